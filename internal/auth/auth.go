@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"log-ingestor/internal/database"
+)
+
+// Role determines which endpoints a principal may use
+type Role string
+
+const (
+	// RoleIngest may only submit logs
+	RoleIngest Role = "ingest"
+
+	// RoleReader may query, tail and aggregate logs
+	RoleReader Role = "reader"
+
+	// RoleAdmin may do everything, including inspecting the server
+	RoleAdmin Role = "admin"
+)
+
+// ErrUnknownKey is returned by stores for keys that do not exist or are disabled
+var ErrUnknownKey = errors.New("unknown API key")
+
+// Principal is the identity an API key authenticates as. Keys assigned a
+// RoleDefinition carry its name and scope; Role is always a built-in role.
+// Keys bound to a tenant can only reach that tenant's logs.
+type Principal struct {
+	Name     string `json:"name"`
+	Role     Role   `json:"role"`
+	RoleName string `json:"roleName,omitempty"`
+	Scope    *Scope `json:"scope,omitempty"`
+	Tenant   string `json:"tenant,omitempty"`
+}
+
+// Store looks up the principal for an API key. Keys are passed as hashes
+// (see HashKey), so stores never hold usable credentials.
+type Store interface {
+	Lookup(ctx context.Context, keyHash string) (*Principal, error)
+}
+
+// KeyRecord is a stored API key. Role is a built-in role or the name of a
+// RoleDefinition. Tenant, when set, binds the key to that tenant's logs.
+type KeyRecord struct {
+	Name     string `json:"name" bson:"name"`
+	KeyHash  string `json:"keyHash" bson:"keyHash"`
+	Role     Role   `json:"role" bson:"role"`
+	Tenant   string `json:"tenant,omitempty" bson:"tenant,omitempty"`
+	Disabled bool   `json:"disabled,omitempty" bson:"disabled,omitempty"`
+}
+
+// HashKey returns the hex-encoded SHA-256 of an API key, as stored
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	return r == RoleIngest || r == RoleReader || r == RoleAdmin
+}
+
+// Allows reports whether the role grants access to endpoints requiring
+// required. Admins are allowed everything; an empty requirement only needs
+// a valid key.
+func (r Role) Allows(required Role) bool {
+	return required == "" || r == RoleAdmin || r == required
+}
+
+// principal validates a record and returns the principal it describes,
+// resolving role definitions with lookupRole
+func (k *KeyRecord) principal(lookupRole func(name string) (*RoleDefinition, error)) (*Principal, error) {
+	if k.Disabled {
+		return nil, ErrUnknownKey
+	}
+	if k.Tenant != database.DefaultTenant && !database.ValidTenant(k.Tenant) {
+		return nil, fmt.Errorf("key %q has invalid tenant %q", k.Name, k.Tenant)
+	}
+	if k.Role.Valid() {
+		return &Principal{Name: k.Name, Role: k.Role, Tenant: k.Tenant}, nil
+	}
+
+	definition, err := lookupRole(string(k.Role))
+	if err != nil {
+		return nil, err
+	}
+	if definition == nil {
+		return nil, fmt.Errorf("key %q has unknown role %q", k.Name, k.Role)
+	}
+
+	principal := definition.principal(k.Name)
+	principal.Tenant = k.Tenant
+	return principal, nil
+}
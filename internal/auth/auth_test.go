@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestHashKey(t *testing.T) {
+	// echo -n secret | sha256sum
+	expected := "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+	if hash := HashKey("secret"); hash != expected {
+		t.Errorf("HashKey() = %s, expected %s", hash, expected)
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	testCases := []struct {
+		role     Role
+		required Role
+		expected bool
+	}{
+		{RoleAdmin, RoleIngest, true},
+		{RoleAdmin, RoleReader, true},
+		{RoleAdmin, RoleAdmin, true},
+		{RoleReader, RoleReader, true},
+		{RoleReader, RoleIngest, false},
+		{RoleReader, RoleAdmin, false},
+		{RoleIngest, RoleIngest, true},
+		{RoleIngest, RoleReader, false},
+		{RoleIngest, "", true},
+	}
+
+	for _, tc := range testCases {
+		if result := tc.role.Allows(tc.required); result != tc.expected {
+			t.Errorf("%s.Allows(%q) = %v, expected %v", tc.role, tc.required, result, tc.expected)
+		}
+	}
+}
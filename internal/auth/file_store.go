@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// keyHashPattern matches hex-encoded SHA-256 hashes
+var keyHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// FileStore is a Store loaded from a JSON file of the form
+//
+//	{
+//	  "roles": [{"name": "payments-reader", "base": "reader", "scope": {...}}],
+//	  "keys": [{"name": "ci", "keyHash": "<sha256 hex>", "role": "ingest"}]
+//	}
+type FileStore struct {
+	principals map[string]*Principal
+}
+
+// Ensure FileStore implements the Store interface
+var _ Store = (*FileStore)(nil)
+
+// NewFileStore loads API keys from a JSON file
+func NewFileStore(path string) (*FileStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Roles []RoleDefinition `json:"roles"`
+		Keys  []KeyRecord      `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return newFileStore(file.Roles, file.Keys)
+}
+
+// newFileStore validates role definitions and key records, and indexes the
+// keys by hash
+func newFileStore(definitions []RoleDefinition, records []KeyRecord) (*FileStore, error) {
+	roles := make(map[string]*RoleDefinition)
+	for i := range definitions {
+		definition := &definitions[i]
+		if err := definition.compile(); err != nil {
+			return nil, err
+		}
+		if _, ok := roles[definition.Name]; ok {
+			return nil, fmt.Errorf("duplicate role %q", definition.Name)
+		}
+		roles[definition.Name] = definition
+	}
+	lookupRole := func(name string) (*RoleDefinition, error) {
+		return roles[name], nil
+	}
+
+	store := &FileStore{principals: make(map[string]*Principal)}
+	for i := range records {
+		record := &records[i]
+		if !keyHashPattern.MatchString(record.KeyHash) {
+			return nil, fmt.Errorf("key %q: keyHash must be a lowercase hex SHA-256", record.Name)
+		}
+		if _, ok := store.principals[record.KeyHash]; ok {
+			return nil, fmt.Errorf("key %q: duplicate keyHash", record.Name)
+		}
+
+		// Disabled keys are skipped, so they are reported as unknown
+		principal, err := record.principal(lookupRole)
+		if err == ErrUnknownKey {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		store.principals[record.KeyHash] = principal
+	}
+	return store, nil
+}
+
+// Lookup returns the principal for a key hash
+func (s *FileStore) Lookup(ctx context.Context, keyHash string) (*Principal, error) {
+	principal, ok := s.principals[keyHash]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return principal, nil
+}
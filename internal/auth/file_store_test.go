@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	contents := `{
+	"roles": [
+		{"name": "payments-reader", "base": "reader", "scope": {"resourceIdPrefixes": ["payments-"], "redactMetadata": ["userId"]}}
+	],
+	"keys": [
+		{"name": "ci", "keyHash": "` + HashKey("ci-key") + `", "role": "ingest"},
+		{"name": "payments", "keyHash": "` + HashKey("payments-key") + `", "role": "payments-reader"},
+		{"name": "oncall", "keyHash": "` + HashKey("oncall-key") + `", "role": "reader"},
+		{"name": "old", "keyHash": "` + HashKey("old-key") + `", "role": "admin", "disabled": true}
+	]}`
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("Failed to write keys file: %v", err)
+	}
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load keys file: %v", err)
+	}
+
+	ctx := context.Background()
+	principal, err := store.Lookup(ctx, HashKey("oncall-key"))
+	if err != nil || principal.Name != "oncall" || principal.Role != RoleReader {
+		t.Errorf("Lookup() = %+v, %v; expected oncall reader", principal, err)
+	}
+
+	principal, err = store.Lookup(ctx, HashKey("payments-key"))
+	if err != nil || principal.Role != RoleReader || principal.RoleName != "payments-reader" || principal.Scope == nil {
+		t.Fatalf("Lookup() = %+v, %v; expected a scoped reader", principal, err)
+	}
+	if !principal.Scope.Redacts("metadata.userId") {
+		t.Error("Expected the role's redactions to apply")
+	}
+
+	for _, key := range []string{"old-key", "unknown", ""} {
+		if _, err := store.Lookup(ctx, HashKey(key)); err != ErrUnknownKey {
+			t.Errorf("Lookup(%q) error = %v, expected ErrUnknownKey", key, err)
+		}
+	}
+}
+
+func TestFileStoreInvalid(t *testing.T) {
+	testCases := map[string][]KeyRecord{
+		"plaintext key":  {{Name: "a", KeyHash: "secret", Role: RoleAdmin}},
+		"invalid role":   {{Name: "a", KeyHash: HashKey("a"), Role: "root"}},
+		"invalid tenant": {{Name: "a", KeyHash: HashKey("a"), Role: RoleReader, Tenant: "Acme Corp"}},
+		"duplicate": {
+			{Name: "a", KeyHash: HashKey("a"), Role: RoleAdmin},
+			{Name: "b", KeyHash: HashKey("a"), Role: RoleReader},
+		},
+	}
+
+	for name, records := range testCases {
+		if _, err := newFileStore(nil, records); err == nil {
+			t.Errorf("Expected error for %s", name)
+		}
+	}
+
+	if _, err := newFileStore(nil, []KeyRecord{{Name: "a", KeyHash: HashKey("a"), Role: "undefined-role"}}); err == nil {
+		t.Error("Expected error for a key with an undefined role")
+	}
+
+	duplicateRoles := []RoleDefinition{{Name: "team", Base: RoleReader}, {Name: "team", Base: RoleAdmin}}
+	if _, err := newFileStore(duplicateRoles, nil); err == nil {
+		t.Error("Expected error for duplicate role definitions")
+	}
+
+	if _, err := NewFileStore(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+}
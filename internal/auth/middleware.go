@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"log-ingestor/internal/database"
+)
+
+// principalContextKey is the gin context key holding the request's principal
+const principalContextKey = "auth.principal"
+
+// tenantContextKey is the gin context key holding the request's tenant
+const tenantContextKey = "auth.tenant"
+
+// accessTokenParam carries the API key for clients that cannot set headers,
+// such as the browser's EventSource
+const accessTokenParam = "access_token"
+
+// TenantHeader selects the tenant of a request made with a key that is not
+// bound to one. The tenant query parameter serves clients that cannot set
+// headers.
+const (
+	TenantHeader = "X-Tenant-ID"
+	tenantParam  = "tenant"
+)
+
+var (
+	// errInvalidTenant is returned for malformed tenant IDs
+	errInvalidTenant = errors.New("invalid tenant ID")
+
+	// errTenantForbidden is returned when a key may not use the requested tenant
+	errTenantForbidden = errors.New("API key may not access this tenant")
+)
+
+// accessTokenPattern finds the access token in a logged request path
+var accessTokenPattern = regexp.MustCompile(`([?&]` + accessTokenParam + `=)[^&]*`)
+
+// anonymous is the principal of every request when authentication is disabled
+var anonymous = &Principal{Name: "anonymous", Role: RoleAdmin}
+
+// Authenticator resolves API keys to principals and enforces roles
+type Authenticator struct {
+	store Store
+}
+
+// NewAuthenticator creates an authenticator backed by store. A nil store
+// disables authentication: every request is treated as an anonymous admin.
+func NewAuthenticator(store Store) *Authenticator {
+	return &Authenticator{store: store}
+}
+
+// Enabled reports whether requests must carry an API key
+func (a *Authenticator) Enabled() bool {
+	return a.store != nil
+}
+
+// Require returns middleware that rejects requests without a valid API key
+// (401) or whose role does not allow required (403)
+func (a *Authenticator) Require(required Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.store == nil {
+			if setTenant(c, anonymous) {
+				c.Set(principalContextKey, anonymous)
+				c.Next()
+			}
+			return
+		}
+
+		key := credential(c.Request)
+		if key == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="log-ingestor"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing API key"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		principal, err := a.store.Lookup(ctx, HashKey(key))
+		if errors.Is(err, ErrUnknownKey) {
+			c.Header("WWW-Authenticate", `Bearer realm="log-ingestor", error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid API key"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error looking up API key: %v", err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Authentication unavailable"})
+			return
+		}
+
+		if !principal.Role.Allows(required) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "role " + string(principal.Role) + " may not access this endpoint"})
+			return
+		}
+
+		if !setTenant(c, principal) {
+			return
+		}
+
+		c.Set(principalContextKey, principal)
+		c.Next()
+	}
+}
+
+// setTenant resolves the request's tenant for a principal, aborting the
+// request and returning false if the principal may not use it
+func setTenant(c *gin.Context, principal *Principal) bool {
+	tenant, err := resolveTenant(c.Request, principal)
+	switch {
+	case errors.Is(err, errInvalidTenant):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return false
+	}
+
+	c.Set(tenantContextKey, tenant)
+	return true
+}
+
+// resolveTenant returns the tenant a request acts on. Keys bound to a
+// tenant always act on it; only admins may pick another tenant, and
+// requests naming none act on the default tenant.
+func resolveTenant(r *http.Request, principal *Principal) (string, error) {
+	requested := r.Header.Get(TenantHeader)
+	if requested == "" {
+		requested = r.URL.Query().Get(tenantParam)
+	}
+
+	if principal.Tenant != database.DefaultTenant {
+		if requested != "" && requested != principal.Tenant {
+			return "", fmt.Errorf("%w: %s", errTenantForbidden, requested)
+		}
+		return principal.Tenant, nil
+	}
+
+	if requested == "" {
+		return database.DefaultTenant, nil
+	}
+	if !database.ValidTenant(requested) {
+		return "", fmt.Errorf("%w: %q", errInvalidTenant, requested)
+	}
+	if principal.Role != RoleAdmin {
+		return "", fmt.Errorf("%w: %s", errTenantForbidden, requested)
+	}
+	return requested, nil
+}
+
+// HandleWhoAmI reports the principal of the request's API key
+func (a *Authenticator) HandleWhoAmI(c *gin.Context) {
+	principal := FromContext(c)
+	if principal == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing API key"})
+		return
+	}
+
+	response := gin.H{
+		"name":        principal.Name,
+		"role":        principal.Role,
+		"authEnabled": a.Enabled(),
+	}
+	if principal.RoleName != "" {
+		response["roleName"] = principal.RoleName
+		response["scope"] = principal.Scope
+	}
+	if tenant := TenantOf(c); tenant != database.DefaultTenant {
+		response["tenant"] = tenant
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// FromContext returns the principal set by Require, or nil
+func FromContext(c *gin.Context) *Principal {
+	value, ok := c.Get(principalContextKey)
+	if !ok {
+		return nil
+	}
+	principal, _ := value.(*Principal)
+	return principal
+}
+
+// TenantOf returns the tenant resolved by Require, or the default tenant
+func TenantOf(c *gin.Context) string {
+	return c.GetString(tenantContextKey)
+}
+
+// credential extracts the API key from the Authorization bearer token, the
+// X-API-Key header or the access_token query parameter, in that order
+func credential(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if scheme, token, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	return r.URL.Query().Get(accessTokenParam)
+}
+
+// RedactPath hides the access token in a request path so it is not logged
+func RedactPath(path string) string {
+	if !strings.Contains(path, accessTokenParam) {
+		return path
+	}
+	return accessTokenPattern.ReplaceAllString(path, "${1}"+url.QueryEscape("[REDACTED]"))
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// failingStore is a Store whose lookups always fail
+type failingStore struct{}
+
+func (failingStore) Lookup(ctx context.Context, keyHash string) (*Principal, error) {
+	return nil, errors.New("store unavailable")
+}
+
+func setupAuthRouter(store Store) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	authenticator := NewAuthenticator(store)
+
+	router := gin.New()
+	router.POST("/", authenticator.Require(RoleIngest), func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/logs", authenticator.Require(RoleReader), func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/auth/whoami", authenticator.Require(""), authenticator.HandleWhoAmI)
+
+	return router
+}
+
+func testStore(t *testing.T) Store {
+	store, err := newFileStore(nil, []KeyRecord{
+		{Name: "ci", KeyHash: HashKey("ci-key"), Role: RoleIngest},
+		{Name: "oncall", KeyHash: HashKey("oncall-key"), Role: RoleReader},
+		{Name: "ops", KeyHash: HashKey("ops-key"), Role: RoleAdmin},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	return store
+}
+
+func TestRequire(t *testing.T) {
+	router := setupAuthRouter(testStore(t))
+
+	testCases := []struct {
+		name           string
+		method         string
+		url            string
+		header         string
+		value          string
+		expectedStatus int
+	}{
+		{"Missing key", "GET", "/logs", "", "", http.StatusUnauthorized},
+		{"Unknown key", "GET", "/logs", "Authorization", "Bearer nope", http.StatusUnauthorized},
+		{"Reader bearer", "GET", "/logs", "Authorization", "Bearer oncall-key", http.StatusOK},
+		{"Reader header", "GET", "/logs", "X-API-Key", "oncall-key", http.StatusOK},
+		{"Reader query", "GET", "/logs?access_token=oncall-key", "", "", http.StatusOK},
+		{"Reader ingesting", "POST", "/", "X-API-Key", "oncall-key", http.StatusForbidden},
+		{"Ingest reading", "GET", "/logs", "X-API-Key", "ci-key", http.StatusForbidden},
+		{"Ingest ingesting", "POST", "/", "X-API-Key", "ci-key", http.StatusOK},
+		{"Admin reading", "GET", "/logs", "Authorization", "bearer ops-key", http.StatusOK},
+		{"Admin ingesting", "POST", "/", "Authorization", "Bearer ops-key", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tc.method, tc.url, nil)
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatus {
+				t.Errorf("Expected status code %d, got %d", tc.expectedStatus, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestRequireStoreError(t *testing.T) {
+	router := setupAuthRouter(failingStore{})
+
+	req, _ := http.NewRequest("GET", "/logs", nil)
+	req.Header.Set("X-API-Key", "any")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestRequireTenant(t *testing.T) {
+	store, err := newFileStore(nil, []KeyRecord{
+		{Name: "acme-reader", KeyHash: HashKey("acme-key"), Role: RoleReader, Tenant: "acme"},
+		{Name: "oncall", KeyHash: HashKey("oncall-key"), Role: RoleReader},
+		{Name: "ops", KeyHash: HashKey("ops-key"), Role: RoleAdmin},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	testCases := []struct {
+		name           string
+		store          Store
+		key            string
+		url            string
+		tenant         string
+		expectedStatus int
+		expectedTenant string
+	}{
+		{"Bound key", store, "acme-key", "/logs", "", http.StatusOK, "acme"},
+		{"Bound key naming its tenant", store, "acme-key", "/logs", "acme", http.StatusOK, "acme"},
+		{"Bound key naming another tenant", store, "acme-key", "/logs", "globex", http.StatusForbidden, ""},
+		{"Unbound reader", store, "oncall-key", "/logs", "", http.StatusOK, ""},
+		{"Unbound reader naming a tenant", store, "oncall-key", "/logs", "acme", http.StatusForbidden, ""},
+		{"Admin naming a tenant", store, "ops-key", "/logs", "globex", http.StatusOK, "globex"},
+		{"Admin tenant parameter", store, "ops-key", "/logs?tenant=globex", "", http.StatusOK, "globex"},
+		{"Invalid tenant", store, "ops-key", "/logs", "Not/Valid", http.StatusBadRequest, ""},
+		{"Authentication disabled", nil, "", "/logs", "acme", http.StatusOK, "acme"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			authenticator := NewAuthenticator(tc.store)
+
+			router := gin.New()
+			router.GET("/logs", authenticator.Require(RoleReader), func(c *gin.Context) {
+				c.String(http.StatusOK, TenantOf(c))
+			})
+
+			req, _ := http.NewRequest("GET", tc.url, nil)
+			req.Header.Set("X-API-Key", tc.key)
+			if tc.tenant != "" {
+				req.Header.Set(TenantHeader, tc.tenant)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatus {
+				t.Fatalf("Expected status code %d, got %d: %s", tc.expectedStatus, w.Code, w.Body.String())
+			}
+			if w.Code == http.StatusOK && w.Body.String() != tc.expectedTenant {
+				t.Errorf("Expected tenant %q, got %q", tc.expectedTenant, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWhoAmI(t *testing.T) {
+	testCases := []struct {
+		name         string
+		store        Store
+		key          string
+		expectedName string
+		expectedRole Role
+	}{
+		{"Authenticated", testStore(t), "ci-key", "ci", RoleIngest},
+		{"Disabled", nil, "", "anonymous", RoleAdmin},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setupAuthRouter(tc.store)
+
+			req, _ := http.NewRequest("GET", "/auth/whoami", nil)
+			req.Header.Set("X-API-Key", tc.key)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			var response struct {
+				Name        string `json:"name"`
+				Role        Role   `json:"role"`
+				AuthEnabled bool   `json:"authEnabled"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if response.Name != tc.expectedName || response.Role != tc.expectedRole || response.AuthEnabled != (tc.store != nil) {
+				t.Errorf("Unexpected whoami response %+v", response)
+			}
+		})
+	}
+}
+
+func TestRedactPath(t *testing.T) {
+	testCases := map[string]string{
+		"/logs/tail?access_token=secret&level=error": "/logs/tail?access_token=%5BREDACTED%5D&level=error",
+		"/logs?level=error&access_token=secret":      "/logs?level=error&access_token=%5BREDACTED%5D",
+		"/logs?level=error":                          "/logs?level=error",
+	}
+
+	for path, expected := range testCases {
+		if result := RedactPath(path); result != expected {
+			t.Errorf("RedactPath(%q) = %q, expected %q", path, result, expected)
+		}
+	}
+}
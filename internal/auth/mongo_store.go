@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore is a Store backed by MongoDB collections of KeyRecords and
+// RoleDefinitions
+type MongoStore struct {
+	keys  *mongo.Collection
+	roles *mongo.Collection
+}
+
+// Ensure MongoStore implements the Store interface
+var _ Store = (*MongoStore)(nil)
+
+// NewMongoStore creates a store on the key and role collections, ensuring
+// key hashes and role names are unique
+func NewMongoStore(ctx context.Context, keys, roles *mongo.Collection) (*MongoStore, error) {
+	_, err := keys.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "keyHash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = roles.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoStore{keys: keys, roles: roles}, nil
+}
+
+// Lookup returns the principal for a key hash
+func (s *MongoStore) Lookup(ctx context.Context, keyHash string) (*Principal, error) {
+	var record KeyRecord
+	err := s.keys.FindOne(ctx, bson.M{"keyHash": keyHash}).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUnknownKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return record.principal(func(name string) (*RoleDefinition, error) {
+		return s.lookupRole(ctx, name)
+	})
+}
+
+// lookupRole loads and compiles a role definition, returning nil when it
+// does not exist
+func (s *MongoStore) lookupRole(ctx context.Context, name string) (*RoleDefinition, error) {
+	var definition RoleDefinition
+	err := s.roles.FindOne(ctx, bson.M{"name": name}).Decode(&definition)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := definition.compile(); err != nil {
+		return nil, err
+	}
+	return &definition, nil
+}
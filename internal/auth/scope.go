@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+)
+
+// ErrRedactedField is returned for queries that filter or group on a
+// metadata key the caller's scope redacts
+var ErrRedactedField = errors.New("field is not visible to this API key")
+
+// RoleDefinition is a named role that grants a built-in role's access,
+// restricted to a scope, e.g. a reader limited to one team's resources
+type RoleDefinition struct {
+	Name  string `json:"name" bson:"name"`
+	Base  Role   `json:"base" bson:"base"`
+	Scope Scope  `json:"scope" bson:"scope"`
+}
+
+// Scope restricts which logs a principal can read and which metadata it
+// can see. A log is in scope when it matches every configured constraint.
+type Scope struct {
+	// ResourceIDPrefixes limits logs to resources starting with one of the prefixes
+	ResourceIDPrefixes []string `json:"resourceIdPrefixes,omitempty" bson:"resourceIdPrefixes,omitempty"`
+
+	// Metadata limits logs to those whose metadata key has one of the values
+	Metadata map[string][]string `json:"metadata,omitempty" bson:"metadata,omitempty"`
+
+	// Query is a query language expression logs must match
+	Query string `json:"query,omitempty" bson:"query,omitempty"`
+
+	// RedactMetadata lists metadata keys removed from results
+	RedactMetadata []string `json:"redactMetadata,omitempty" bson:"redactMetadata,omitempty"`
+
+	// filter is the compiled form of the constraints above
+	filter querylang.Expr
+}
+
+// compile validates a role definition and builds its scope's filter
+func (d *RoleDefinition) compile() error {
+	if d.Name == "" || Role(d.Name).Valid() {
+		return fmt.Errorf("role definition needs a name other than the built-in roles, got %q", d.Name)
+	}
+	if !d.Base.Valid() {
+		return fmt.Errorf("role %q has invalid base role %q", d.Name, d.Base)
+	}
+	if err := d.Scope.compile(); err != nil {
+		return fmt.Errorf("role %q: %w", d.Name, err)
+	}
+	return nil
+}
+
+// principal returns the principal a key with this role authenticates as
+func (d *RoleDefinition) principal(name string) *Principal {
+	return &Principal{Name: name, Role: d.Base, RoleName: d.Name, Scope: &d.Scope}
+}
+
+// compile validates the scope and builds its filter expression
+func (s *Scope) compile() error {
+	var constraints []querylang.Expr
+
+	// Any of the resource prefixes
+	var prefixes []querylang.Expr
+	for _, prefix := range s.ResourceIDPrefixes {
+		if prefix == "" || strings.Contains(prefix, "*") {
+			return fmt.Errorf("invalid resourceId prefix %q", prefix)
+		}
+		prefixes = append(prefixes, &querylang.TermExpr{Field: "resourceId", Value: prefix + "*", Wildcard: true})
+	}
+	if len(s.ResourceIDPrefixes) > 0 {
+		constraints = append(constraints, querylang.Or(prefixes...))
+	}
+
+	// Any of the values for each metadata key
+	for key, values := range s.Metadata {
+		field, ok := querylang.CanonicalField("metadata." + key)
+		if !ok || len(values) == 0 {
+			return fmt.Errorf("invalid metadata scope %q", key)
+		}
+
+		var terms []querylang.Expr
+		for _, value := range values {
+			terms = append(terms, &querylang.TermExpr{Field: field, Value: value})
+		}
+		constraints = append(constraints, querylang.Or(terms...))
+	}
+
+	// An arbitrary expression
+	if s.Query != "" {
+		expr, err := querylang.Parse(s.Query)
+		if err != nil {
+			return fmt.Errorf("invalid scope query: %w", err)
+		}
+		constraints = append(constraints, expr)
+	}
+
+	for _, key := range s.RedactMetadata {
+		if _, ok := querylang.CanonicalField("metadata." + key); !ok {
+			return fmt.Errorf("invalid redacted metadata key %q", key)
+		}
+	}
+
+	s.filter = querylang.And(constraints...)
+	return nil
+}
+
+// Apply restricts a query to the scope, rejecting queries that reference
+// redacted metadata
+func (s *Scope) Apply(query *models.LogQuery) error {
+	if s == nil {
+		return nil
+	}
+
+	// Filtering on a redacted key would reveal its values one guess at a time
+	if len(s.RedactMetadata) > 0 {
+		fields := []string{}
+		if query.ParentResourceID != "" || !query.FieldFilter("parentResourceId").IsEmpty() {
+			fields = append(fields, "parentResourceId")
+		}
+		for _, filter := range query.MetadataFilters {
+			fields = append(fields, "metadata."+filter.Key)
+		}
+		if expr, err := querylang.Parse(query.Query); err == nil {
+			fields = append(fields, querylang.Fields(expr)...)
+		}
+		// Sorting by one would reveal their order
+		if keys, err := query.SortKeys(); err == nil {
+			for _, key := range keys {
+				fields = append(fields, key.Field)
+			}
+		}
+
+		for _, field := range fields {
+			if s.Redacts(field) {
+				return fmt.Errorf("%w: %s", ErrRedactedField, field)
+			}
+		}
+	}
+
+	query.Scope = querylang.And(query.Scope, s.filter)
+	return nil
+}
+
+// Redacts reports whether the scope hides a field, given in its canonical
+// form such as metadata.userId or parentResourceId
+func (s *Scope) Redacts(field string) bool {
+	if s == nil {
+		return false
+	}
+
+	key, ok := querylang.MetadataKey(field)
+	if field == "parentResourceId" {
+		key, ok = field, true
+	}
+	if !ok {
+		return false
+	}
+
+	for _, redacted := range s.RedactMetadata {
+		if redacted == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns the log with redacted metadata removed, copying it only
+// when something is removed so shared logs are never modified
+func (s *Scope) Redact(log *models.Log) *models.Log {
+	if s == nil || len(s.RedactMetadata) == 0 {
+		return log
+	}
+
+	var metadata map[string]string
+	for _, key := range s.RedactMetadata {
+		if _, ok := log.Metadata[key]; !ok {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string, len(log.Metadata))
+			for k, v := range log.Metadata {
+				metadata[k] = v
+			}
+		}
+		delete(metadata, key)
+	}
+
+	if metadata == nil {
+		return log
+	}
+
+	redacted := *log
+	redacted.Metadata = metadata
+	return &redacted
+}
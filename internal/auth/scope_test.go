@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+)
+
+func compiledScope(t *testing.T, scope Scope) *Scope {
+	t.Helper()
+	definition := RoleDefinition{Name: "scoped", Base: RoleReader, Scope: scope}
+	if err := definition.compile(); err != nil {
+		t.Fatalf("Failed to compile scope: %v", err)
+	}
+	return &definition.Scope
+}
+
+func TestScopeApply(t *testing.T) {
+	scope := compiledScope(t, Scope{
+		ResourceIDPrefixes: []string{"payments-", "billing-"},
+		Metadata:           map[string][]string{"tenant": {"acme"}},
+		Query:              "NOT level:debug",
+	})
+
+	query := &models.LogQuery{Query: "level:error"}
+	if err := scope.Apply(query); err != nil {
+		t.Fatalf("Failed to apply scope: %v", err)
+	}
+
+	testCases := []struct {
+		log      models.Log
+		expected bool
+	}{
+		{models.Log{Level: "error", ResourceID: "payments-api", Metadata: map[string]string{"tenant": "acme"}}, true},
+		{models.Log{Level: "error", ResourceID: "billing-1", Metadata: map[string]string{"tenant": "acme"}}, true},
+		{models.Log{Level: "error", ResourceID: "search-api", Metadata: map[string]string{"tenant": "acme"}}, false},
+		{models.Log{Level: "error", ResourceID: "payments-api", Metadata: map[string]string{"tenant": "globex"}}, false},
+		{models.Log{Level: "debug", ResourceID: "payments-api", Metadata: map[string]string{"tenant": "acme"}}, false},
+	}
+
+	for _, tc := range testCases {
+		if result := querylang.Match(query.Scope, &tc.log); result != tc.expected {
+			t.Errorf("Scope match for %+v = %v, expected %v", tc.log, result, tc.expected)
+		}
+	}
+
+	// A nil scope leaves the query unrestricted
+	unscoped := &models.LogQuery{}
+	if err := (*Scope)(nil).Apply(unscoped); err != nil || unscoped.Scope != nil {
+		t.Errorf("Expected nil scope to leave the query alone, got %v, %v", unscoped.Scope, err)
+	}
+}
+
+func TestScopeRejectsRedactedFields(t *testing.T) {
+	scope := compiledScope(t, Scope{RedactMetadata: []string{"userId", "parentResourceId"}})
+
+	testCases := []struct {
+		name  string
+		query models.LogQuery
+	}{
+		{"Metadata filter", models.LogQuery{MetadataFilters: []models.MetadataFilter{{Key: "userId", Op: models.MetadataExists}}}},
+		{"Query language", models.LogQuery{Query: "level:error AND NOT metadata.userId:u-1"}},
+		{"Parent resource", models.LogQuery{ParentResourceID: "server-1"}},
+		{"Negated parent resource", models.LogQuery{Filters: map[string]models.FieldFilter{"parentResourceId": {Exclude: []string{"x"}}}}},
+	}
+
+	for _, tc := range testCases {
+		if err := scope.Apply(&tc.query); !errors.Is(err, ErrRedactedField) {
+			t.Errorf("%s: expected ErrRedactedField, got %v", tc.name, err)
+		}
+	}
+
+	if err := scope.Apply(&models.LogQuery{Query: "metadata.region:us"}); err != nil {
+		t.Errorf("Expected unredacted fields to be allowed, got %v", err)
+	}
+}
+
+func TestScopeRedact(t *testing.T) {
+	scope := compiledScope(t, Scope{RedactMetadata: []string{"userId"}})
+
+	log := &models.Log{Message: "login", Metadata: map[string]string{"userId": "u-1", "region": "us"}}
+	redacted := scope.Redact(log)
+
+	if _, ok := redacted.Metadata["userId"]; ok || redacted.Metadata["region"] != "us" {
+		t.Errorf("Unexpected redacted metadata %v", redacted.Metadata)
+	}
+	if log.Metadata["userId"] != "u-1" {
+		t.Error("Expected the original log to be left unchanged")
+	}
+
+	clean := &models.Log{Message: "no metadata"}
+	if scope.Redact(clean) != clean {
+		t.Error("Expected logs without redacted keys not to be copied")
+	}
+	if !scope.Redacts("metadata.userId") || scope.Redacts("metadata.region") || scope.Redacts("resourceId") {
+		t.Error("Unexpected Redacts results")
+	}
+}
+
+func TestRoleDefinitionInvalid(t *testing.T) {
+	for name, definition := range map[string]RoleDefinition{
+		"built-in name":   {Name: "reader", Base: RoleReader},
+		"invalid base":    {Name: "team", Base: "root"},
+		"wildcard prefix": {Name: "team", Base: RoleReader, Scope: Scope{ResourceIDPrefixes: []string{"a*"}}},
+		"metadata key":    {Name: "team", Base: RoleReader, Scope: Scope{Metadata: map[string][]string{"a.b": {"x"}}}},
+		"no values":       {Name: "team", Base: RoleReader, Scope: Scope{Metadata: map[string][]string{"tenant": {}}}},
+		"query":           {Name: "team", Base: RoleReader, Scope: Scope{Query: "level:"}},
+		"redacted key":    {Name: "team", Base: RoleReader, Scope: Scope{RedactMetadata: []string{"$where"}}},
+	} {
+		if err := definition.compile(); err == nil {
+			t.Errorf("Expected error for %s", name)
+		}
+	}
+}
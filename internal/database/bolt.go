@@ -0,0 +1,682 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+)
+
+const (
+	// defaultBoltPath is the database file used when BOLT_PATH is unset
+	defaultBoltPath = "logs.db"
+
+	// defaultTenantBucket names the default tenant's bucket, which cannot be
+	// empty; it is not a valid tenant ID so it cannot clash with one
+	defaultTenantBucket = "_default"
+
+	// logsBucket holds a tenant's logs keyed by logKey
+	logsBucket = "logs"
+
+	// countKey holds the number of logs in a tenant's bucket
+	countKey = "count"
+
+	// expireBatchSize bounds the logs deleted in one write transaction, so
+	// expiry does not block ingestion for long
+	expireBatchSize = 10000
+)
+
+// BoltDB stores logs in a local bbolt file, for single-node deployments
+// without MongoDB. Each tenant has a bucket holding its logs in time order,
+// a secondary index per filterable field and an inverted index of message
+// terms. Queries use the indexes to narrow the logs they read and check
+// each one in memory with the same semantics as the other backends.
+type BoltDB struct {
+	db *bolt.DB
+
+	// tenantConfig holds the tenants' quotas and retention
+	tenantConfig *TenantConfig
+
+	// expirer deletes logs past their tenant's retention
+	expirer *expirer
+}
+
+// Ensure BoltDB implements the DB, QuotaChecker, TenantLister and
+// LogStreamer interfaces
+var (
+	_ DB           = (*BoltDB)(nil)
+	_ QuotaChecker = (*BoltDB)(nil)
+	_ TenantLister = (*BoltDB)(nil)
+	_ LogStreamer  = (*BoltDB)(nil)
+)
+
+// NewBoltDB opens the bbolt database at BOLT_PATH, creating it if needed,
+// and starts expiring logs past their tenant's retention
+func NewBoltDB() (*BoltDB, error) {
+	path := os.Getenv("BOLT_PATH")
+	if path == "" {
+		path = defaultBoltPath
+	}
+
+	tenantConfig, err := tenantConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := OpenBoltDB(path, tenantConfig)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Opened embedded database %s", path)
+
+	b.expirer.start(b.Expire)
+	return b, nil
+}
+
+// OpenBoltDB opens the bbolt database at path without starting expiry
+func OpenBoltDB(path string, tenantConfig *TenantConfig) (*BoltDB, error) {
+	// A timeout reports a file held by another process instead of hanging
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+
+	return &BoltDB{
+		db:           db,
+		tenantConfig: tenantConfig,
+		expirer:      newExpirer(),
+	}, nil
+}
+
+// Close stops expiry and closes the database file
+func (b *BoltDB) Close() error {
+	b.expirer.close()
+	return b.db.Close()
+}
+
+// tenantBucketName returns the name of a tenant's bucket
+func tenantBucketName(tenant string) []byte {
+	if tenant == DefaultTenant {
+		return []byte(defaultTenantBucket)
+	}
+	return []byte(tenant)
+}
+
+// tenantBucket returns a tenant's bucket, or nil when it has stored nothing
+func tenantBucket(tx *bolt.Tx, tenant string) *bolt.Bucket {
+	return tx.Bucket(tenantBucketName(tenant))
+}
+
+// createTenantBucket returns a tenant's bucket, creating it and its
+// sub-buckets on first use
+func createTenantBucket(tx *bolt.Tx, tenant string) (*bolt.Bucket, error) {
+	if tenant != DefaultTenant && !ValidTenant(tenant) {
+		return nil, fmt.Errorf("invalid tenant ID %q", tenant)
+	}
+
+	bucket, err := tx.CreateBucketIfNotExists(tenantBucketName(tenant))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range append([]string{logsBucket, termsBucket}, boltIndexedFields...) {
+		if _, err := bucket.CreateBucketIfNotExists([]byte(name)); err != nil {
+			return nil, err
+		}
+	}
+	return bucket, nil
+}
+
+// storedCount returns the number of logs in a tenant's bucket
+func storedCount(tenant *bolt.Bucket) int64 {
+	if tenant == nil {
+		return 0
+	}
+	if value := tenant.Get([]byte(countKey)); len(value) == 8 {
+		return int64(binary.BigEndian.Uint64(value))
+	}
+	return 0
+}
+
+// addCount adjusts the number of logs in a tenant's bucket
+func addCount(tenant *bolt.Bucket, delta int64) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(storedCount(tenant)+delta))
+	return tenant.Put([]byte(countKey), value)
+}
+
+// checkQuota returns ErrQuotaExceeded if n more logs would take a tenant
+// past its quota
+func (b *BoltDB) checkQuota(bucket *bolt.Bucket, tenant string, n int) error {
+	limits := b.tenantConfig.Limits(tenant)
+	if limits.MaxLogs > 0 && storedCount(bucket)+int64(n) > limits.MaxLogs {
+		return quotaError(tenant, limits.MaxLogs)
+	}
+	return nil
+}
+
+// CheckQuota reports whether the context's tenant may store n more logs
+func (b *BoltDB) CheckQuota(ctx context.Context, n int) error {
+	tenant := TenantFromContext(ctx)
+	return b.db.View(func(tx *bolt.Tx) error {
+		return b.checkQuota(tenantBucket(tx, tenant), tenant, n)
+	})
+}
+
+// InsertLog inserts a log into the context tenant's bucket
+func (b *BoltDB) InsertLog(ctx context.Context, logEntry *models.Log) error {
+	return b.InsertLogs(ctx, []*models.Log{logEntry})
+}
+
+// InsertLogs inserts a batch of logs and their index entries in a single
+// transaction, so either all of them are stored or none are. Logs keep an
+// ID assigned elsewhere when it is a valid ObjectID, so replaying a batch
+// overwrites the logs it already stored instead of duplicating them.
+func (b *BoltDB) InsertLogs(ctx context.Context, logEntries []*models.Log) error {
+	if len(logEntries) == 0 {
+		return nil
+	}
+
+	tenant := TenantFromContext(ctx)
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := createTenantBucket(tx, tenant)
+		if err != nil {
+			return err
+		}
+		if err := b.checkQuota(bucket, tenant, len(logEntries)); err != nil {
+			return err
+		}
+
+		logs := bucket.Bucket([]byte(logsBucket))
+		var added int64
+		for _, logEntry := range logEntries {
+			if _, err := primitive.ObjectIDFromHex(logEntry.ID); err != nil {
+				logEntry.ID = NewLogID()
+			}
+
+			data, err := json.Marshal(logEntry)
+			if err != nil {
+				return err
+			}
+
+			key := logKey(logEntry.Timestamp, logEntry.ID)
+			if logs.Get(key) == nil {
+				added++
+			}
+			if err := logs.Put(key, data); err != nil {
+				return err
+			}
+
+			var indexErr error
+			indexEntries(logEntry, func(index, value string) {
+				if indexErr == nil {
+					indexErr = bucket.Bucket([]byte(index)).Put(indexKey(value, key), nil)
+				}
+			})
+			if indexErr != nil {
+				return indexErr
+			}
+		}
+		return addCount(bucket, added)
+	})
+
+	// Values too long to index are refused however often they are retried
+	if errors.Is(err, bolt.ErrKeyTooLarge) || errors.Is(err, bolt.ErrValueTooLarge) {
+		return fmt.Errorf("%w: %w", ErrRejected, err)
+	}
+	return err
+}
+
+// boltMatcher checks logs against every part of a query, in memory
+type boltMatcher struct {
+	query  *models.LogQuery
+	expr   querylang.Expr
+	cursor *models.Cursor
+	regex  *regexp.Regexp
+	search *searchQuery
+}
+
+// newBoltMatcher parses the parts of a query checked in memory
+func newBoltMatcher(query *models.LogQuery) (*boltMatcher, error) {
+	expr, err := querylang.Parse(query.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	matcher := &boltMatcher{query: query, expr: expr}
+	if query.Cursor != "" {
+		if matcher.cursor, err = models.DecodeCursor(query.Cursor); err != nil {
+			return nil, err
+		}
+	}
+	regex, err := query.Regex()
+	if err != nil {
+		return nil, err
+	}
+	if regex != nil {
+		matcher.regex = regex.Compile()
+	}
+	if query.FullTextSearch != "" {
+		matcher.search = parseSearch(query.FullTextSearch)
+	}
+	return matcher, nil
+}
+
+// matches reports whether a log satisfies the query
+func (m *boltMatcher) matches(log *models.Log) bool {
+	if m.cursor != nil && !m.cursor.After(log) {
+		return false
+	}
+	if m.regex != nil && !m.regex.MatchString(log.Message) {
+		return false
+	}
+	if m.search != nil && !m.search.matches(log.Message) {
+		return false
+	}
+	return MatchesQuery(log, m.query, m.expr)
+}
+
+// timeBounds returns the range of log keys that can match, from the first
+// key to just past the last
+func (m *boltMatcher) timeBounds() (lower, upper []byte) {
+	if !m.query.StartTime.IsZero() {
+		lower = timeKey(m.query.StartTime)
+	}
+
+	end := m.query.EndTime
+	if m.cursor != nil && (end.IsZero() || m.cursor.Timestamp.Before(end)) {
+		end = m.cursor.Timestamp
+	}
+	if !end.IsZero() {
+		upper = timeKey(end.Add(time.Nanosecond))
+	}
+	return lower, upper
+}
+
+// each calls fn with the key of every log of a tenant matching the query,
+// newest first, until fn returns false
+func each(ctx context.Context, tenant *bolt.Bucket, matcher *boltMatcher, fn func(key []byte, log *models.Log) bool) error {
+	if tenant == nil {
+		return nil
+	}
+	logs := tenant.Bucket([]byte(logsBucket))
+	lower, upper := matcher.timeBounds()
+
+	visit := func(i int, key, data []byte) (bool, error) {
+		// Long scans give up when the request does
+		if i%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+		}
+
+		var entry models.Log
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return false, fmt.Errorf("decoding log %x: %w", key, err)
+		}
+		if !matcher.matches(&entry) {
+			return true, nil
+		}
+		return fn(key, &entry), nil
+	}
+
+	// Read the logs the indexes selected
+	if keys := candidates(tenant, matcher.query, matcher.search); keys != nil {
+		for i, key := range keys.sorted() {
+			if (upper != nil && bytes.Compare(key, upper) >= 0) || (lower != nil && bytes.Compare(key, lower) < 0) {
+				continue
+			}
+			data := logs.Get(key)
+			if data == nil {
+				continue
+			}
+			if more, err := visit(i, key, data); !more || err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Otherwise scan the time range newest first
+	cursor := logs.Cursor()
+	var key, data []byte
+	if upper == nil {
+		key, data = cursor.Last()
+	} else if key, data = cursor.Seek(upper); key == nil {
+		key, data = cursor.Last()
+	} else {
+		key, data = cursor.Prev()
+	}
+
+	for i := 0; key != nil; i++ {
+		if lower != nil && bytes.Compare(key, lower) < 0 {
+			break
+		}
+		if more, err := visit(i, key, data); !more || err != nil {
+			return err
+		}
+		key, data = cursor.Prev()
+	}
+	return nil
+}
+
+// view runs fn in a read transaction on the context tenant's bucket, which
+// is nil when the tenant has stored nothing
+func (b *BoltDB) view(ctx context.Context, fn func(tenant *bolt.Bucket) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return fn(tenantBucket(tx, TenantFromContext(ctx)))
+	})
+}
+
+// QueryLogs queries logs from the embedded database based on the provided filters
+func (b *BoltDB) QueryLogs(ctx context.Context, query *models.LogQuery) ([]*models.Log, error) {
+	// Set default pagination values if not provided
+	if query.Page <= 0 {
+		query.Page = 1
+	}
+	if query.Limit <= 0 {
+		query.Limit = 10
+	}
+
+	matcher, err := newBoltMatcher(query)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := query.SortKeys()
+	if err != nil {
+		return nil, err
+	}
+	projection, err := query.Projection()
+	if err != nil {
+		return nil, err
+	}
+
+	// A cursor replaces the page offset
+	skip := (query.Page - 1) * query.Limit
+	if matcher.cursor != nil {
+		skip = 0
+	}
+
+	var logs []*models.Log
+	switch {
+	case query.ByRelevance():
+		logs, err = b.rankLogs(ctx, matcher, skip, query.Limit)
+	case keys != nil:
+		logs, err = b.sortedLogs(ctx, matcher, keys, skip, query.Limit)
+	default:
+		logs = []*models.Log{}
+		err = b.view(ctx, func(tenant *bolt.Bucket) error {
+			return each(ctx, tenant, matcher, func(key []byte, log *models.Log) bool {
+				if skip > 0 {
+					skip--
+					return true
+				}
+				logs = append(logs, log)
+				return len(logs) < query.Limit
+			})
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return projectLogs(logs, projection), nil
+}
+
+// sortedLogs reads a page of logs ordered by sort keys. Logs are stored in
+// time order, so every match is read, keeping only the first skip+limit
+// in memory.
+func (b *BoltDB) sortedLogs(ctx context.Context, matcher *boltMatcher, keys []models.SortKey, skip, limit int) ([]*models.Log, error) {
+	logs := []*models.Log{}
+	keep := skip + limit
+	err := b.view(ctx, func(tenant *bolt.Bucket) error {
+		return each(ctx, tenant, matcher, func(key []byte, log *models.Log) bool {
+			logs = append(logs, log)
+			if len(logs) >= 2*keep {
+				sortLogsBy(logs, keys)
+				logs = logs[:keep]
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortLogsBy(logs, keys)
+	return pageLogs(logs, skip, limit), nil
+}
+
+// StreamLogs calls fn with each log matching the query, newest first. Logs
+// are read a batch at a time, each in its own transaction continuing from
+// the last, so a slow reader does not hold a transaction open and block
+// the file from growing.
+func (b *BoltDB) StreamLogs(ctx context.Context, query *models.LogQuery, fn func(*models.Log) error) error {
+	return pageLogsThrough(ctx, b, query, fn)
+}
+
+// rankLogs reads a page of the newest maxRankedLogs matches ordered by
+// relevance, weighing terms by how many of the tenant's logs the term
+// index finds them in
+func (b *BoltDB) rankLogs(ctx context.Context, matcher *boltMatcher, skip, limit int) ([]*models.Log, error) {
+	logs := []*models.Log{}
+	err := b.view(ctx, func(tenant *bolt.Bucket) error {
+		err := each(ctx, tenant, matcher, func(key []byte, log *models.Log) bool {
+			logs = append(logs, log)
+			return len(logs) < maxRankedLogs
+		})
+		if err != nil || len(logs) == 0 {
+			return err
+		}
+
+		docs := int(storedCount(tenant))
+		terms := tenant.Bucket([]byte(termsBucket))
+		rankLogs(logs, matcher.search, func(term searchTerm) float64 {
+			// Terms too common to look up weigh as if in every log
+			docFreq := docs
+			if keys, ok := lookupTerms(terms, []searchTerm{term}); ok {
+				docFreq = len(keys)
+			}
+			return bm25IDF(docFreq, docs)
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pageLogs(logs, skip, limit), nil
+}
+
+// CountLogs counts the logs in the embedded database matching the query's filters
+func (b *BoltDB) CountLogs(ctx context.Context, query *models.LogQuery, limit int64) (int64, error) {
+	// The total covers every match, not just those after the cursor
+	countQuery := *query
+	countQuery.Cursor = ""
+
+	matcher, err := newBoltMatcher(&countQuery)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err = b.view(ctx, func(tenant *bolt.Bucket) error {
+		return each(ctx, tenant, matcher, func(key []byte, log *models.Log) bool {
+			count++
+			return limit <= 0 || count < limit
+		})
+	})
+	return count, err
+}
+
+// AggregateLogs computes stats over the logs in the embedded database
+// matching the query's filters
+func (b *BoltDB) AggregateLogs(ctx context.Context, query *models.LogQuery, request *models.StatsRequest) (*models.StatsResult, error) {
+	statsQuery := *query
+	statsQuery.Cursor = ""
+
+	matcher, err := newBoltMatcher(&statsQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregator := newStatsAggregator(request)
+	err = b.view(ctx, func(tenant *bolt.Bucket) error {
+		return each(ctx, tenant, matcher, func(key []byte, log *models.Log) bool {
+			aggregator.add(log)
+			return true
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return aggregator.result(&statsQuery), nil
+}
+
+// DeleteLogs deletes the logs in the embedded database matching the query's
+// filters, along with their index entries
+func (b *BoltDB) DeleteLogs(ctx context.Context, query *models.LogQuery) (int64, error) {
+	deleteQuery := *query
+	deleteQuery.Cursor = ""
+
+	matcher, err := newBoltMatcher(&deleteQuery)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		tenant := tenantBucket(tx, TenantFromContext(ctx))
+		if tenant == nil {
+			return nil
+		}
+
+		// Collect first, as a bucket must not change while it is iterated
+		var matched []*models.Log
+		if err := each(ctx, tenant, matcher, func(key []byte, log *models.Log) bool {
+			matched = append(matched, log)
+			return true
+		}); err != nil {
+			return err
+		}
+
+		if err := deleteLogs(tenant, matched); err != nil {
+			return err
+		}
+		deleted = int64(len(matched))
+		return nil
+	})
+	return deleted, err
+}
+
+// deleteLogs removes logs and their index entries from a tenant's bucket
+func deleteLogs(tenant *bolt.Bucket, logs []*models.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	stored := tenant.Bucket([]byte(logsBucket))
+	for _, entry := range logs {
+		key := logKey(entry.Timestamp, entry.ID)
+		if err := stored.Delete(key); err != nil {
+			return err
+		}
+
+		var indexErr error
+		indexEntries(entry, func(index, value string) {
+			if indexErr == nil {
+				indexErr = tenant.Bucket([]byte(index)).Delete(indexKey(value, key))
+			}
+		})
+		if indexErr != nil {
+			return indexErr
+		}
+	}
+	return addCount(tenant, -int64(len(logs)))
+}
+
+// Expire deletes every tenant's logs timestamped before now minus the
+// tenant's retention and returns how many were deleted
+func (b *BoltDB) Expire(now time.Time) (int64, error) {
+	tenants, err := b.Tenants(context.Background())
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	var errs []error
+	for _, tenant := range tenants {
+		retention := b.tenantConfig.Limits(tenant).Retention
+		if retention <= 0 {
+			continue
+		}
+
+		cutoff := timeKey(now.Add(-retention))
+		for {
+			n, err := b.expireBatch(tenant, cutoff)
+			deleted += n
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if err != nil || n < expireBatchSize {
+				break
+			}
+		}
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// expireBatch deletes up to expireBatchSize of a tenant's oldest logs
+// whose keys sort before cutoff
+func (b *BoltDB) expireBatch(tenant string, cutoff []byte) (int64, error) {
+	var deleted int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tenantBucket(tx, tenant)
+		if bucket == nil {
+			return nil
+		}
+
+		var expired []*models.Log
+		cursor := bucket.Bucket([]byte(logsBucket)).Cursor()
+		for key, data := cursor.First(); key != nil && bytes.Compare(key, cutoff) < 0 && len(expired) < expireBatchSize; key, data = cursor.Next() {
+			var entry models.Log
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return fmt.Errorf("decoding log %x: %w", key, err)
+			}
+			expired = append(expired, &entry)
+		}
+
+		deleted = int64(len(expired))
+		return deleteLogs(bucket, expired)
+	})
+	return deleted, err
+}
+
+// Tenants returns the default tenant and every tenant with a bucket
+func (b *BoltDB) Tenants(ctx context.Context) ([]string, error) {
+	tenants := []string{DefaultTenant}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if tenant := string(name); ValidTenant(tenant) {
+				tenants = append(tenants, tenant)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(tenants[1:])
+	return tenants, nil
+}
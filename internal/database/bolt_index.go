@@ -0,0 +1,170 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"log-ingestor/internal/models"
+)
+
+// boltIndexedFields are the fields with a secondary index. message is
+// searched through the term index instead.
+var boltIndexedFields = []string{"level", "resourceId", "traceId", "spanId", "commit", "parentResourceId"}
+
+// termsBucket is the index bucket mapping message terms to logs
+const termsBucket = "terms"
+
+// maxCandidates bounds how many logs an index lookup may select before the
+// lookup is abandoned in favour of scanning logs in time order, which
+// stops as soon as a page is full
+const maxCandidates = 100000
+
+// logKey returns the key a log is stored under: its timestamp, ordered so
+// that byte order is time order, followed by its ID. Iterating keys in
+// reverse yields logs newest first, as cursors expect.
+func logKey(timestamp time.Time, id string) []byte {
+	key := timeKey(timestamp)
+	return append(key, id...)
+}
+
+// timeKey returns the key prefix of logs timestamped t
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8, 8+24)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano())^(1<<63))
+	return key
+}
+
+// indexKey returns the entry for a log under an index value. The value is
+// separated from the log key by a zero byte so prefix scans find exact values.
+func indexKey(value string, key []byte) []byte {
+	entry := make([]byte, 0, len(value)+1+len(key))
+	entry = append(entry, value...)
+	entry = append(entry, 0)
+	return append(entry, key...)
+}
+
+// indexEntries calls fn with the index bucket and value of every index
+// entry of a log
+func indexEntries(log *models.Log, fn func(bucket, value string)) {
+	for _, field := range boltIndexedFields {
+		if value, ok := log.Field(field); ok && value != "" {
+			fn(field, value)
+		}
+	}
+	for _, term := range tokenize(log.Message) {
+		fn(termsBucket, term)
+	}
+}
+
+// keySet is a set of log keys
+type keySet map[string]struct{}
+
+// intersect returns the keys in both sets; a nil set stands for all keys
+func (s keySet) intersect(other keySet) keySet {
+	if s == nil {
+		return other
+	}
+	for key := range s {
+		if _, ok := other[key]; !ok {
+			delete(s, key)
+		}
+	}
+	return s
+}
+
+// sorted returns the keys newest first
+func (s keySet) sorted() [][]byte {
+	keys := make([][]byte, 0, len(s))
+	for key := range s {
+		keys = append(keys, []byte(key))
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) > 0 })
+	return keys
+}
+
+// lookup collects the keys of the logs indexed under any of values. It
+// returns false when more than maxCandidates logs match.
+func lookup(index *bolt.Bucket, values []string) (keySet, bool) {
+	keys := keySet{}
+	if index == nil {
+		return keys, true
+	}
+
+	cursor := index.Cursor()
+	for _, value := range values {
+		prefix := indexKey(value, nil)
+		for entry, _ := cursor.Seek(prefix); entry != nil && bytes.HasPrefix(entry, prefix); entry, _ = cursor.Next() {
+			if len(keys) >= maxCandidates {
+				return nil, false
+			}
+			keys[string(entry[len(prefix):])] = struct{}{}
+		}
+	}
+	return keys, true
+}
+
+// lookupTerms collects the keys of the logs whose message has a word
+// matching any of terms. It returns false when more than maxCandidates
+// logs match.
+func lookupTerms(index *bolt.Bucket, terms []searchTerm) (keySet, bool) {
+	keys := keySet{}
+	if index == nil {
+		return keys, true
+	}
+
+	cursor := index.Cursor()
+	for _, term := range terms {
+		// A prefix term scans every word it starts
+		prefix := indexKey(term.text, nil)
+		if term.prefix {
+			prefix = []byte(term.text)
+		}
+		for entry, _ := cursor.Seek(prefix); entry != nil && bytes.HasPrefix(entry, prefix); entry, _ = cursor.Next() {
+			if len(keys) >= maxCandidates {
+				return nil, false
+			}
+			// Words hold no zero byte, so the log key follows the first one
+			keys[string(entry[bytes.IndexByte(entry, 0)+1:])] = struct{}{}
+		}
+	}
+	return keys, true
+}
+
+// candidates uses the indexes to find the logs that may match a query. It
+// returns nil when no index narrows the query, and the logs must be scanned.
+// Candidates are only a superset of the matches; every log is still checked.
+func candidates(tenant *bolt.Bucket, query *models.LogQuery, search *searchQuery) keySet {
+	var result keySet
+
+	for _, field := range boltIndexedFields {
+		filter := query.FieldFilter(field)
+		if len(filter.Include) == 0 {
+			continue
+		}
+		if keys, ok := lookup(tenant.Bucket([]byte(field)), filter.Include); ok {
+			result = result.intersect(keys)
+		}
+	}
+
+	if search != nil {
+		terms := tenant.Bucket([]byte(termsBucket))
+		if len(search.phrases) > 0 {
+			// Every term of every phrase must be present
+			for _, phrase := range search.phrases {
+				for _, term := range searchTerms(phrase) {
+					if keys, ok := lookupTerms(terms, []searchTerm{term}); ok {
+						result = result.intersect(keys)
+					}
+				}
+			}
+		} else if keys, ok := lookupTerms(terms, search.terms); ok {
+			result = result.intersect(keys)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,35 @@
+package database
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestLogKeyOrder(t *testing.T) {
+	times := []time.Time{
+		time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 9, 15, 8, 0, 0, 0, time.UTC),
+		time.Date(2023, 9, 15, 8, 0, 0, 1, time.UTC),
+	}
+
+	for i := 1; i < len(times); i++ {
+		if bytes.Compare(logKey(times[i-1], "b"), logKey(times[i], "a")) >= 0 {
+			t.Errorf("Expected %v to sort before %v", times[i-1], times[i])
+		}
+	}
+	if bytes.Compare(logKey(times[1], "a"), logKey(times[1], "b")) >= 0 {
+		t.Error("Expected logs at the same time to sort by ID")
+	}
+}
+
+func TestKeySetIntersect(t *testing.T) {
+	var all keySet
+	a := all.intersect(keySet{"1": {}, "2": {}, "3": {}})
+	b := a.intersect(keySet{"2": {}, "3": {}, "4": {}})
+
+	sorted := b.sorted()
+	if len(sorted) != 2 || string(sorted[0]) != "3" || string(sorted[1]) != "2" {
+		t.Errorf("Expected keys 3 and 2, got %q", sorted)
+	}
+}
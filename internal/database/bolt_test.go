@@ -0,0 +1,385 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+)
+
+// openTestBoltDB opens a BoltDB in a temporary directory
+func openTestBoltDB(t *testing.T, config *TenantConfig) *BoltDB {
+	t.Helper()
+	db, err := OpenBoltDB(filepath.Join(t.TempDir(), "logs.db"), config)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// testLogs returns logs spread over an hour with varied fields
+func testLogs() []*models.Log {
+	base := time.Date(2023, 9, 15, 8, 0, 0, 0, time.UTC)
+	levels := []string{"error", "info", "warning", "debug"}
+	messages := []string{"Failed to connect to DB", "User authentication successful", "Disk usage high", "Cache miss for user profile"}
+
+	var logs []*models.Log
+	for i := 0; i < 40; i++ {
+		logs = append(logs, &models.Log{
+			ID:         fmt.Sprintf("650400000000000000%06d", i),
+			Level:      levels[i%4],
+			Message:    messages[(i/2)%4],
+			ResourceID: fmt.Sprintf("server-%d", i%5),
+			Timestamp:  base.Add(time.Duration(i/3) * time.Minute),
+			TraceID:    fmt.Sprintf("trace-%d", i%7),
+			SpanID:     fmt.Sprintf("span-%d", i),
+			Commit:     "5e5342f",
+			Metadata:   map[string]string{"parentResourceId": fmt.Sprintf("server-%d", i%3)},
+		})
+	}
+	return logs
+}
+
+func TestBoltDBMatchesMockDB(t *testing.T) {
+	ctx := context.Background()
+	boltDB := openTestBoltDB(t, nil)
+	mockDB := NewMockDB()
+	if err := boltDB.InsertLogs(ctx, testLogs()); err != nil {
+		t.Fatalf("Failed to insert logs: %v", err)
+	}
+	mockDB.InsertLogs(ctx, testLogs())
+
+	base := time.Date(2023, 9, 15, 8, 0, 0, 0, time.UTC)
+	queries := map[string]*models.LogQuery{
+		"all":            {Limit: 100},
+		"second page":    {Page: 2, Limit: 7},
+		"level":          {Level: "error", Limit: 100},
+		"levels":         {Filters: map[string]models.FieldFilter{"level": {Include: []string{"error", "debug"}}}, Limit: 100},
+		"excluded level": {Filters: map[string]models.FieldFilter{"level": {Exclude: []string{"info"}}}, Limit: 100},
+		"two indexes":    {Level: "info", ResourceID: "server-1", Limit: 100},
+		"parent":         {ParentResourceID: "server-2", Limit: 100},
+		"message":        {Message: "disk", Limit: 100},
+		"time range":     {StartTime: base.Add(3 * time.Minute), EndTime: base.Add(7 * time.Minute), Limit: 100},
+		"indexed range":  {Level: "warning", StartTime: base.Add(2 * time.Minute), EndTime: base.Add(9 * time.Minute), Limit: 100},
+		"query language": {Query: `level:error OR traceId:trace-3`, Limit: 100},
+		"scope":          {Scope: &querylang.TermExpr{Field: "resourceId", Value: "server-4"}, Limit: 100},
+		"no match":       {Level: "fatal", Limit: 100},
+		"search":         {FullTextSearch: "disk cache -miss", Limit: 100},
+		"search prefix":  {FullTextSearch: "auth* prof*", Limit: 100},
+		"search phrase":  {FullTextSearch: `"usage high"`, Limit: 100},
+		"relevance":      {FullTextSearch: "user profile", Sort: models.SortRelevance, Limit: 100},
+		"relevance page": {FullTextSearch: "user profile", Sort: models.SortRelevance, Page: 2, Limit: 7},
+		"sorted":         {Sort: "resourceId:asc,level:desc", Limit: 100},
+		"sorted page":    {Sort: "parentResourceId:desc", Page: 3, Limit: 4},
+		"oldest first":   {Level: "info", Sort: "timestamp:asc", Page: 2, Limit: 3},
+	}
+
+	for name, query := range queries {
+		t.Run(name, func(t *testing.T) {
+			mockQuery, boltQuery := *query, *query
+			expected, err := mockDB.QueryLogs(ctx, &mockQuery)
+			if err != nil {
+				t.Fatalf("Mock query failed: %v", err)
+			}
+			logs, err := boltDB.QueryLogs(ctx, &boltQuery)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if logIDs(logs) != logIDs(expected) {
+				t.Errorf("Expected %s, got %s", logIDs(expected), logIDs(logs))
+			}
+
+			expectedCount, _ := mockDB.CountLogs(ctx, query, 0)
+			if count, err := boltDB.CountLogs(ctx, query, 0); err != nil || count != expectedCount {
+				t.Errorf("Expected count %d, got %d (%v)", expectedCount, count, err)
+			}
+		})
+	}
+}
+
+func TestBoltDBCursorPagination(t *testing.T) {
+	ctx := context.Background()
+	db := openTestBoltDB(t, nil)
+	if err := db.InsertLogs(ctx, testLogs()); err != nil {
+		t.Fatalf("Failed to insert logs: %v", err)
+	}
+
+	all, _ := db.QueryLogs(ctx, &models.LogQuery{Level: "info", Limit: 100})
+
+	var paged []*models.Log
+	query := &models.LogQuery{Level: "info", Limit: 3}
+	for {
+		page, err := db.QueryLogs(ctx, query)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		paged = append(paged, page...)
+		if len(page) < query.Limit {
+			break
+		}
+		query.Cursor = models.EncodeCursor(page[len(page)-1])
+	}
+
+	if logIDs(paged) != logIDs(all) {
+		t.Errorf("Expected cursor pages to cover %s, got %s", logIDs(all), logIDs(paged))
+	}
+}
+
+func TestBoltDBStreamLogs(t *testing.T) {
+	ctx := context.Background()
+	db := openTestBoltDB(t, nil)
+	if err := db.InsertLogs(ctx, testLogs()); err != nil {
+		t.Fatalf("Failed to insert logs: %v", err)
+	}
+
+	all, _ := db.QueryLogs(ctx, &models.LogQuery{Level: "info", Limit: 100})
+
+	var streamed []*models.Log
+	query := &models.LogQuery{Level: "info", Cursor: models.EncodeCursor(all[1]), Limit: 5}
+	err := db.StreamLogs(ctx, query, func(log *models.Log) error {
+		streamed = append(streamed, log)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	if logIDs(streamed) != logIDs(all[2:7]) {
+		t.Errorf("Expected stream of %s, got %s", logIDs(all[2:7]), logIDs(streamed))
+	}
+}
+
+func TestBoltDBSearch(t *testing.T) {
+	ctx := context.Background()
+	db := openTestBoltDB(t, nil)
+	if err := db.InsertLogs(ctx, testLogs()); err != nil {
+		t.Fatalf("Failed to insert logs: %v", err)
+	}
+
+	tests := []struct {
+		query    models.LogQuery
+		expected int64
+	}{
+		{models.LogQuery{FullTextSearch: "disk"}, 10},
+		{models.LogQuery{FullTextSearch: "disk cache"}, 20},
+		{models.LogQuery{FullTextSearch: "user -profile"}, 10},
+		{models.LogQuery{FullTextSearch: `"user profile"`}, 10},
+		{models.LogQuery{FullTextSearch: "disk", Level: "error"}, 5},
+		{models.LogQuery{FullTextSearch: "-disk"}, 0},
+		{models.LogQuery{FullTextSearch: "us*"}, 30},
+		{models.LogQuery{FullTextSearch: "user -prof*"}, 10},
+		{models.LogQuery{RegexPattern: "^FAILED"}, 10},
+		{models.LogQuery{RegexPattern: "failed"}, 10},
+		{models.LogQuery{RegexPattern: "failed", RegexFlags: "c"}, 0},
+	}
+
+	for _, tt := range tests {
+		count, err := db.CountLogs(ctx, &tt.query, 0)
+		if err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+		if count != tt.expected {
+			t.Errorf("search %q regex %q: expected %d logs, got %d", tt.query.FullTextSearch, tt.query.RegexPattern, tt.expected, count)
+		}
+	}
+
+	if _, err := db.CountLogs(ctx, &models.LogQuery{RegexPattern: "[invalid"}, 0); !errors.Is(err, models.ErrInvalidRegex) {
+		t.Errorf("Expected an invalid regex error, got %v", err)
+	}
+}
+
+func TestBoltDBSearchByRelevance(t *testing.T) {
+	ctx := context.Background()
+	db := openTestBoltDB(t, nil)
+	if err := db.InsertLogs(ctx, testLogs()); err != nil {
+		t.Fatalf("Failed to insert logs: %v", err)
+	}
+
+	logs, err := db.QueryLogs(ctx, &models.LogQuery{FullTextSearch: "user profile", Sort: models.SortRelevance, Limit: 15})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(logs) != 15 {
+		t.Fatalf("Expected 15 logs, got %d", len(logs))
+	}
+
+	// Logs with both terms come first, each group newest first
+	for i, log := range logs {
+		if both := i < 10; both != (log.Message == "Cache miss for user profile") {
+			t.Errorf("Unexpected log %d: %q with score %v", i, log.Message, log.Score)
+		}
+		if i > 0 && (log.Score > logs[i-1].Score || log.Score == logs[i-1].Score && log.Timestamp.After(logs[i-1].Timestamp)) {
+			t.Errorf("Log %d is out of order", i)
+		}
+	}
+
+	// Scores are not stored
+	stored, _ := db.QueryLogs(ctx, &models.LogQuery{FullTextSearch: "user profile", Limit: 1})
+	if stored[0].Score != 0 {
+		t.Errorf("Expected no score when sorting by time, got %v", stored[0].Score)
+	}
+}
+
+func TestBoltDBDeleteLogs(t *testing.T) {
+	ctx := context.Background()
+	db := openTestBoltDB(t, nil)
+	if err := db.InsertLogs(ctx, testLogs()); err != nil {
+		t.Fatalf("Failed to insert logs: %v", err)
+	}
+
+	deleted, err := db.DeleteLogs(ctx, &models.LogQuery{Level: "error"})
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if deleted != 10 {
+		t.Errorf("Expected 10 deleted logs, got %d", deleted)
+	}
+
+	if count, _ := db.CountLogs(ctx, &models.LogQuery{}, 0); count != 30 {
+		t.Errorf("Expected 30 logs left, got %d", count)
+	}
+
+	// Deleted logs leave no index entries behind
+	db.db.View(func(tx *bolt.Tx) error {
+		tenant := tenantBucket(tx, DefaultTenant)
+		if keys, _ := lookup(tenant.Bucket([]byte("level")), []string{"error"}); len(keys) != 0 {
+			t.Errorf("Expected no level index entries for deleted logs, got %d", len(keys))
+		}
+		if count := storedCount(tenant); count != 30 {
+			t.Errorf("Expected a stored count of 30, got %d", count)
+		}
+		return nil
+	})
+}
+
+func TestBoltDBAggregateLogs(t *testing.T) {
+	ctx := context.Background()
+	db := openTestBoltDB(t, nil)
+	if err := db.InsertLogs(ctx, testLogs()); err != nil {
+		t.Fatalf("Failed to insert logs: %v", err)
+	}
+
+	result, err := db.AggregateLogs(ctx, &models.LogQuery{}, &models.StatsRequest{Interval: 5 * time.Minute, GroupBy: "level", Top: 10})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if result.Total != 40 {
+		t.Errorf("Expected a total of 40, got %d", result.Total)
+	}
+	if len(result.Groups) != 4 || result.Groups[0].Count != 10 {
+		t.Errorf("Expected 4 levels of 10 logs, got %+v", result.Groups)
+	}
+}
+
+func TestBoltDBTenantsAndQuota(t *testing.T) {
+	db := openTestBoltDB(t, &TenantConfig{Tenants: map[string]TenantLimits{"acme": {MaxLogs: 2}}})
+	acme := WithTenant(context.Background(), "acme")
+
+	if err := db.InsertLogs(acme, []*models.Log{{Level: "info", Message: "one"}, {Level: "info", Message: "two"}}); err != nil {
+		t.Fatalf("Failed to insert logs: %v", err)
+	}
+	if err := db.InsertLog(acme, &models.Log{Level: "info", Message: "three"}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+	if err := db.CheckQuota(acme, 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded from CheckQuota, got %v", err)
+	}
+
+	// The default tenant sees none of acme's logs
+	if count, _ := db.CountLogs(context.Background(), &models.LogQuery{}, 0); count != 0 {
+		t.Errorf("Expected no logs for the default tenant, got %d", count)
+	}
+
+	tenants, err := db.Tenants(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list tenants: %v", err)
+	}
+	if len(tenants) != 2 || tenants[0] != DefaultTenant || tenants[1] != "acme" {
+		t.Errorf("Expected the default tenant and acme, got %q", tenants)
+	}
+}
+
+func TestBoltDBExpire(t *testing.T) {
+	db := openTestBoltDB(t, &TenantConfig{Default: TenantLimits{Retention: time.Hour}})
+	ctx := context.Background()
+	now := time.Date(2023, 9, 15, 12, 0, 0, 0, time.UTC)
+
+	db.InsertLogs(ctx, []*models.Log{
+		{Level: "info", Message: "old", Timestamp: now.Add(-2 * time.Hour)},
+		{Level: "info", Message: "recent", Timestamp: now.Add(-30 * time.Minute)},
+	})
+
+	deleted, err := db.Expire(now)
+	if err != nil {
+		t.Fatalf("Expire failed: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 expired log, got %d", deleted)
+	}
+
+	logs, _ := db.QueryLogs(ctx, &models.LogQuery{})
+	if len(logs) != 1 || logs[0].Message != "recent" {
+		t.Errorf("Expected only the recent log to remain, got %v", logs)
+	}
+}
+
+func TestBoltDBRejectsOversizedValues(t *testing.T) {
+	db := openTestBoltDB(t, nil)
+	ctx := context.Background()
+
+	// A resource ID too long for an index key can never be stored
+	err := db.InsertLogs(ctx, []*models.Log{
+		{Level: "info", Message: "fine", Timestamp: time.Now()},
+		{Level: "info", Message: "long", ResourceID: strings.Repeat("x", bolt.MaxKeySize), Timestamp: time.Now()},
+	})
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("Expected ErrRejected, got %v", err)
+	}
+
+	if logs, _ := db.QueryLogs(ctx, &models.LogQuery{}); len(logs) != 0 {
+		t.Errorf("Expected the batch to be rolled back, got %v", logs)
+	}
+}
+
+func TestBoltDBPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.db")
+	ctx := context.Background()
+
+	db, err := OpenBoltDB(path, nil)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	entry := &models.Log{Level: "info", Message: "kept", Timestamp: time.Now()}
+	if err := db.InsertLog(ctx, entry); err != nil {
+		t.Fatalf("Failed to insert log: %v", err)
+	}
+
+	// Inserting the same log again replaces it
+	if err := db.InsertLog(ctx, entry); err != nil {
+		t.Fatalf("Failed to insert log: %v", err)
+	}
+	db.Close()
+
+	db, err = OpenBoltDB(path, nil)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	logs, err := db.QueryLogs(ctx, &models.LogQuery{Message: "kept"})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(logs) != 1 || logs[0].ID != entry.ID {
+		t.Errorf("Expected the stored log once after reopening, got %v", logs)
+	}
+}
@@ -0,0 +1,640 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"log-ingestor/internal/models"
+)
+
+const (
+	// defaultClickHouseURL is the HTTP interface used when CLICKHOUSE_URL is unset
+	defaultClickHouseURL = "http://localhost:8123"
+
+	// defaultClickHouseTable is the logs table used when CLICKHOUSE_TABLE is unset
+	defaultClickHouseTable = "logs"
+
+	// chTimestampLayout formats timestamps for DateTime64(9) columns
+	chTimestampLayout = "2006-01-02 15:04:05.000000000"
+
+	// chLogColumns are the columns read into a chLogRow
+	chLogColumns = "id, toUnixTimestamp64Nano(timestamp) AS ts, level, message, resource_id, trace_id, span_id, commit, metadata"
+)
+
+// chIdentifierPattern restricts database and table names to identifiers
+// that need no quoting
+var chIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// chSchema creates the logs table; {table} is replaced with its name. The
+// MergeTree is ordered by resource and time within each tenant, the usual
+// shape of both filters and aggregations, with skip indexes for trace and
+// span lookups and message tokens. Retried insert blocks are deduplicated.
+const chSchema = `CREATE TABLE IF NOT EXISTS {table} (
+	tenant      LowCardinality(String),
+	id          String,
+	timestamp   DateTime64(9, 'UTC'),
+	level       LowCardinality(String),
+	message     String,
+	resource_id String,
+	trace_id    String,
+	span_id     String,
+	commit      String,
+	metadata    Map(String, String),
+	INDEX trace_id_idx trace_id TYPE bloom_filter GRANULARITY 4,
+	INDEX span_id_idx span_id TYPE bloom_filter GRANULARITY 4,
+	INDEX message_tokens_idx message TYPE tokenbf_v1(32768, 3, 0) GRANULARITY 4
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY (tenant, resource_id, timestamp)
+SETTINGS non_replicated_deduplication_window = 1000`
+
+// ClickHouseConfig configures the ClickHouse backend
+type ClickHouseConfig struct {
+	// URL is the HTTP interface, e.g. http://localhost:8123
+	URL string
+
+	// Database and Table name the logs table
+	Database string
+	Table    string
+
+	// User and Password authenticate requests when User is set
+	User     string
+	Password string
+
+	// TenantConfig holds the tenants' quotas and retention
+	TenantConfig *TenantConfig
+
+	// Client sends requests; http.DefaultClient when nil
+	Client *http.Client
+}
+
+// ClickHouseDB stores logs in a ClickHouse MergeTree table through the
+// HTTP interface, for analytics over more logs than MongoDB aggregates
+// comfortably. Tenants share the table, keyed by a tenant column that
+// leads its sort order. Every value is passed as a typed query parameter.
+type ClickHouseDB struct {
+	client       *http.Client
+	url          string
+	database     string
+	table        string
+	user         string
+	password     string
+	tenantConfig *TenantConfig
+
+	// quotas caches each tenant's quota counter
+	mutex  sync.Mutex
+	quotas map[string]*quotaCounter
+
+	// expirer deletes logs past their tenant's retention
+	expirer *expirer
+}
+
+// Ensure ClickHouseDB implements the DB, QuotaChecker, TenantLister and
+// LogStreamer interfaces
+var (
+	_ DB           = (*ClickHouseDB)(nil)
+	_ QuotaChecker = (*ClickHouseDB)(nil)
+	_ TenantLister = (*ClickHouseDB)(nil)
+	_ LogStreamer  = (*ClickHouseDB)(nil)
+)
+
+// NewClickHouseDB connects to the ClickHouse server at CLICKHOUSE_URL,
+// creates the logs table if needed and starts expiring logs past their
+// tenant's retention
+func NewClickHouseDB() (*ClickHouseDB, error) {
+	tenantConfig, err := tenantConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	c, err := OpenClickHouseDB(ctx, ClickHouseConfig{
+		URL:          os.Getenv("CLICKHOUSE_URL"),
+		Database:     os.Getenv("CLICKHOUSE_DATABASE"),
+		Table:        os.Getenv("CLICKHOUSE_TABLE"),
+		User:         os.Getenv("CLICKHOUSE_USER"),
+		Password:     os.Getenv("CLICKHOUSE_PASSWORD"),
+		TenantConfig: tenantConfig,
+		Client:       &http.Client{Timeout: 5 * time.Minute},
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Connected to ClickHouse!")
+
+	c.expirer.start(c.Expire)
+	return c, nil
+}
+
+// OpenClickHouseDB connects to ClickHouse and creates the logs table,
+// without starting expiry
+func OpenClickHouseDB(ctx context.Context, config ClickHouseConfig) (*ClickHouseDB, error) {
+	if config.URL == "" {
+		config.URL = defaultClickHouseURL
+	}
+	if config.Table == "" {
+		config.Table = defaultClickHouseTable
+	}
+	if !chIdentifierPattern.MatchString(config.Table) {
+		return nil, fmt.Errorf("invalid ClickHouse table name %q", config.Table)
+	}
+	if config.Database != "" && !chIdentifierPattern.MatchString(config.Database) {
+		return nil, fmt.Errorf("invalid ClickHouse database name %q", config.Database)
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+
+	c := &ClickHouseDB{
+		client:       config.Client,
+		url:          strings.TrimSuffix(config.URL, "/") + "/",
+		database:     config.Database,
+		table:        config.Table,
+		user:         config.User,
+		password:     config.Password,
+		tenantConfig: config.TenantConfig,
+		quotas:       make(map[string]*quotaCounter),
+		expirer:      newExpirer(),
+	}
+
+	if err := c.exec(ctx, strings.ReplaceAll(chSchema, "{table}", c.table), nil); err != nil {
+		return nil, fmt.Errorf("creating ClickHouse table: %w", err)
+	}
+	return c, nil
+}
+
+// Close stops expiry
+func (c *ClickHouseDB) Close() error {
+	c.expirer.close()
+	return nil
+}
+
+// request sends a statement to the HTTP interface and returns the response
+// body, which the caller must close. The statement goes in the body unless
+// data is given, in which case it is sent as the query's input.
+func (c *ClickHouseDB) request(ctx context.Context, statement string, params url.Values, data io.Reader) (io.ReadCloser, error) {
+	values := url.Values{}
+	for name, value := range params {
+		values[name] = value
+	}
+	if c.database != "" {
+		values.Set("database", c.database)
+	}
+	// 64-bit integers are read as JSON numbers rather than strings
+	values.Set("output_format_json_quote_64bit_integers", "0")
+	// The server stops queries once the caller's time budget runs out
+	if budget, ok := timeBudget(ctx); ok {
+		values.Set("max_execution_time", strconv.Itoa(int(math.Ceil(budget.Seconds()))))
+	}
+
+	body := data
+	if body == nil {
+		body = strings.NewReader(statement)
+	} else {
+		values.Set("query", statement)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"?"+values.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.user != "" {
+		req.Header.Set("X-ClickHouse-User", c.user)
+		req.Header.Set("X-ClickHouse-Key", c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if bytes.Contains(message, []byte("TIMEOUT_EXCEEDED")) {
+			return nil, fmt.Errorf("clickhouse: %w: %s", ErrQueryTimeout, strings.TrimSpace(string(message)))
+		}
+		return nil, fmt.Errorf("clickhouse: %s", strings.TrimSpace(string(message)))
+	}
+	return resp.Body, nil
+}
+
+// exec runs a statement that returns no rows
+func (c *ClickHouseDB) exec(ctx context.Context, statement string, params url.Values) error {
+	body, err := c.request(ctx, statement, params, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(io.Discard, body)
+	return err
+}
+
+// selectRows runs a query and decodes each row of its JSONEachRow output
+// into a value made by newRow, passing it to fn
+func (c *ClickHouseDB) selectRows(ctx context.Context, statement string, params url.Values, newRow func() interface{}, fn func(row interface{})) error {
+	return c.streamRows(ctx, statement, params, newRow, func(row interface{}) error {
+		fn(row)
+		return nil
+	})
+}
+
+// streamRows is selectRows for callers that may stop early: reading stops
+// at the first error fn returns, which streamRows returns
+func (c *ClickHouseDB) streamRows(ctx context.Context, statement string, params url.Values, newRow func() interface{}, fn func(row interface{}) error) error {
+	body, err := c.request(ctx, statement+" FORMAT JSONEachRow", params, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(body))
+	for {
+		row := newRow()
+		if err := decoder.Decode(row); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("decoding ClickHouse response: %w", err)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+// count runs a query returning a single count column
+func (c *ClickHouseDB) count(ctx context.Context, statement string, params url.Values) (int64, error) {
+	var count int64
+	err := c.selectRows(ctx, statement, params, func() interface{} { return &chCountRow{} }, func(row interface{}) {
+		count = row.(*chCountRow).Count
+	})
+	return count, err
+}
+
+// chCountRow is a row of a count query
+type chCountRow struct {
+	Count int64 `json:"count"`
+}
+
+// chLogRow is a log as written to and read from the logs table
+type chLogRow struct {
+	Tenant     string            `json:"tenant,omitempty"`
+	ID         string            `json:"id"`
+	Timestamp  string            `json:"timestamp,omitempty"`
+	Nanos      int64             `json:"ts,omitempty"`
+	Level      string            `json:"level"`
+	Message    string            `json:"message"`
+	ResourceID string            `json:"resource_id"`
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	Commit     string            `json:"commit"`
+	Metadata   map[string]string `json:"metadata"`
+}
+
+// log converts a row read from the table into a log
+func (r *chLogRow) log() *models.Log {
+	entry := &models.Log{
+		ID:         r.ID,
+		Timestamp:  time.Unix(0, r.Nanos).UTC(),
+		Level:      r.Level,
+		Message:    r.Message,
+		ResourceID: r.ResourceID,
+		TraceID:    r.TraceID,
+		SpanID:     r.SpanID,
+		Commit:     r.Commit,
+	}
+	// Maps cannot be null, so logs without metadata read back an empty one
+	if len(r.Metadata) > 0 {
+		entry.Metadata = r.Metadata
+	}
+	return entry
+}
+
+// quota returns a tenant's quota counter
+func (c *ClickHouseDB) quota(tenant string) *quotaCounter {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if quota, ok := c.quotas[tenant]; ok {
+		return quota
+	}
+
+	quota := newQuotaCounter(tenant, c.tenantConfig.Limits(tenant), func(ctx context.Context) (int64, error) {
+		f := newChFilter()
+		return c.count(ctx, "SELECT count() AS count FROM "+c.table+" WHERE tenant = "+f.str(tenant), f.params)
+	})
+	c.quotas[tenant] = quota
+	return quota
+}
+
+// CheckQuota reports whether the context's tenant may store n more logs
+func (c *ClickHouseDB) CheckQuota(ctx context.Context, n int) error {
+	return c.quota(TenantFromContext(ctx)).checkQuota(ctx, n)
+}
+
+// InsertLog inserts a log into ClickHouse
+func (c *ClickHouseDB) InsertLog(ctx context.Context, logEntry *models.Log) error {
+	return c.InsertLogs(ctx, []*models.Log{logEntry})
+}
+
+// InsertLogs inserts a batch of logs in a single INSERT, which ClickHouse
+// writes as one part. Logs keep an ID assigned elsewhere when it is a valid
+// ObjectID, so a retried batch forms the same block and is deduplicated.
+func (c *ClickHouseDB) InsertLogs(ctx context.Context, logEntries []*models.Log) error {
+	if len(logEntries) == 0 {
+		return nil
+	}
+
+	tenant := TenantFromContext(ctx)
+	if tenant != DefaultTenant && !ValidTenant(tenant) {
+		return fmt.Errorf("invalid tenant ID %q", tenant)
+	}
+
+	quota := c.quota(tenant)
+	if err := quota.checkQuota(ctx, len(logEntries)); err != nil {
+		return err
+	}
+
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	for _, logEntry := range logEntries {
+		if _, err := primitive.ObjectIDFromHex(logEntry.ID); err != nil {
+			logEntry.ID = NewLogID()
+		}
+
+		metadata := logEntry.Metadata
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		if err := encoder.Encode(&chLogRow{
+			Tenant:     tenant,
+			ID:         logEntry.ID,
+			Timestamp:  logEntry.Timestamp.UTC().Format(chTimestampLayout),
+			Level:      logEntry.Level,
+			Message:    logEntry.Message,
+			ResourceID: logEntry.ResourceID,
+			TraceID:    logEntry.TraceID,
+			SpanID:     logEntry.SpanID,
+			Commit:     logEntry.Commit,
+			Metadata:   metadata,
+		}); err != nil {
+			return err
+		}
+	}
+
+	body, err := c.request(ctx, "INSERT INTO "+c.table+" (tenant, id, timestamp, level, message, resource_id, trace_id, span_id, commit, metadata) FORMAT JSONEachRow", nil, &data)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return err
+	}
+
+	quota.inserted(len(logEntries))
+	return nil
+}
+
+// QueryLogs queries logs from ClickHouse based on the provided filters
+func (c *ClickHouseDB) QueryLogs(ctx context.Context, query *models.LogQuery) ([]*models.Log, error) {
+	filter, err := buildChFilter(TenantFromContext(ctx), query)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := query.SortKeys()
+	if err != nil {
+		return nil, err
+	}
+	projection, err := query.Projection()
+	if err != nil {
+		return nil, err
+	}
+
+	// Set default pagination values if not provided
+	if query.Page <= 0 {
+		query.Page = 1
+	}
+	if query.Limit <= 0 {
+		query.Limit = 10
+	}
+
+	// Calculate skip value for pagination; a cursor replaces the page offset
+	skip := (query.Page - 1) * query.Limit
+	if query.Cursor != "" {
+		skip = 0
+	}
+
+	// Relevance is ranked in memory over the newest matches
+	limit, offset := query.Limit, skip
+	if query.ByRelevance() {
+		limit, offset = maxRankedLogs, 0
+	}
+
+	order := "timestamp DESC, id DESC"
+	if keys != nil {
+		order = filter.order(keys)
+	}
+
+	statement := "SELECT " + chLogColumns + " FROM " + c.table + " WHERE " + filter.where() +
+		" ORDER BY " + order + " LIMIT " + filter.arg(limit, "UInt64") + " OFFSET " + filter.arg(offset, "UInt64")
+
+	logs := []*models.Log{}
+	err = c.selectRows(ctx, statement, filter.params, func() interface{} { return &chLogRow{} }, func(row interface{}) {
+		logs = append(logs, row.(*chLogRow).log())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if query.ByRelevance() {
+		rankLogs(logs, parseSearch(query.FullTextSearch), nil)
+		logs = pageLogs(logs, skip, query.Limit)
+	}
+	return projectLogs(logs, projection), nil
+}
+
+// StreamLogs calls fn with each log in ClickHouse matching the query,
+// newest first, decoding rows as the response arrives
+func (c *ClickHouseDB) StreamLogs(ctx context.Context, query *models.LogQuery, fn func(*models.Log) error) error {
+	filter, err := buildChFilter(TenantFromContext(ctx), query)
+	if err != nil {
+		return err
+	}
+
+	statement := "SELECT " + chLogColumns + " FROM " + c.table + " WHERE " + filter.where() + " ORDER BY timestamp DESC, id DESC"
+	if query.Limit > 0 {
+		statement += " LIMIT " + filter.arg(query.Limit, "UInt64")
+	}
+	return c.streamRows(ctx, statement, filter.params, func() interface{} { return &chLogRow{} }, func(row interface{}) error {
+		return fn(row.(*chLogRow).log())
+	})
+}
+
+// CountLogs counts the logs in ClickHouse matching the query's filters
+func (c *ClickHouseDB) CountLogs(ctx context.Context, query *models.LogQuery, limit int64) (int64, error) {
+	// The total covers every match, not just those after the cursor
+	countQuery := *query
+	countQuery.Cursor = ""
+
+	filter, err := buildChFilter(TenantFromContext(ctx), &countQuery)
+	if err != nil {
+		return 0, err
+	}
+
+	// A limit stops counting early by counting a bounded subquery
+	statement := "SELECT count() AS count FROM " + c.table + " WHERE " + filter.where()
+	if limit > 0 {
+		statement = "SELECT count() AS count FROM (SELECT 1 FROM " + c.table + " WHERE " + filter.where() + " LIMIT " + filter.arg(limit, "UInt64") + ")"
+	}
+	return c.count(ctx, statement, filter.params)
+}
+
+// chBucketRow is a row of the histogram query
+type chBucketRow struct {
+	Start int64 `json:"start"`
+	Count int64 `json:"count"`
+}
+
+// chGroupRow is a row of the top values query
+type chGroupRow struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// AggregateLogs computes stats over the logs in ClickHouse matching the
+// query's filters. The total, histogram and groups are separate queries,
+// so logs inserted meanwhile may be counted by some and not others.
+func (c *ClickHouseDB) AggregateLogs(ctx context.Context, query *models.LogQuery, request *models.StatsRequest) (*models.StatsResult, error) {
+	statsQuery := *query
+	statsQuery.Cursor = ""
+
+	filter, err := buildChFilter(TenantFromContext(ctx), &statsQuery)
+	if err != nil {
+		return nil, err
+	}
+	where := filter.where()
+
+	total, err := c.count(ctx, "SELECT count() AS count FROM "+c.table+" WHERE "+where, filter.params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Buckets start at multiples of the interval since the Unix epoch
+	step := filter.arg(request.Interval.Milliseconds(), "Int64")
+	buckets := make(map[int64]int64)
+	err = c.selectRows(ctx, "SELECT intDiv(toUnixTimestamp64Milli(timestamp), "+step+") * "+step+" AS start, count() AS count FROM "+c.table+
+		" WHERE "+where+" GROUP BY start ORDER BY start DESC LIMIT "+filter.arg(models.MaxStatsBuckets, "UInt64"),
+		filter.params, func() interface{} { return &chBucketRow{} }, func(row interface{}) {
+			bucket := row.(*chBucketRow)
+			buckets[bucket.Start] = bucket.Count
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// Logs without a value for the grouped field are not grouped
+	groups := make(map[string]int64)
+	if request.GroupBy != "" {
+		column := filter.column(request.GroupBy)
+		err = c.selectRows(ctx, "SELECT "+column+" AS value, count() AS count FROM "+c.table+
+			" WHERE "+where+" AND "+column+" != '' GROUP BY value ORDER BY count DESC, value LIMIT "+filter.arg(request.Top, "UInt64"),
+			filter.params, func() interface{} { return &chGroupRow{} }, func(row interface{}) {
+				group := row.(*chGroupRow)
+				groups[group.Value] = group.Count
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buildStatsResult(&statsQuery, request, total, buckets, groups), nil
+}
+
+// DeleteLogs deletes the logs in ClickHouse matching the query's filters.
+// ClickHouse does not report how many rows a delete removed, so they are
+// counted first.
+func (c *ClickHouseDB) DeleteLogs(ctx context.Context, query *models.LogQuery) (int64, error) {
+	deleteQuery := *query
+	deleteQuery.Cursor = ""
+
+	filter, err := buildChFilter(TenantFromContext(ctx), &deleteQuery)
+	if err != nil {
+		return 0, err
+	}
+	return c.delete(ctx, filter)
+}
+
+// delete counts and then deletes the logs matching a filter
+func (c *ClickHouseDB) delete(ctx context.Context, filter *chFilter) (int64, error) {
+	count, err := c.count(ctx, "SELECT count() AS count FROM "+c.table+" WHERE "+filter.where(), filter.params)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	if err := c.exec(ctx, "DELETE FROM "+c.table+" WHERE "+filter.where(), filter.params); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Tenants returns the default tenant and every tenant that has stored logs
+func (c *ClickHouseDB) Tenants(ctx context.Context) ([]string, error) {
+	tenants := []string{DefaultTenant}
+	err := c.selectRows(ctx, "SELECT DISTINCT tenant AS value FROM "+c.table+" WHERE tenant != '' ORDER BY value", nil,
+		func() interface{} { return &chGroupRow{} }, func(row interface{}) {
+			tenants = append(tenants, row.(*chGroupRow).Value)
+		})
+	if err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}
+
+// Expire deletes every tenant's logs timestamped before now minus the
+// tenant's retention and returns how many were deleted
+func (c *ClickHouseDB) Expire(now time.Time) (int64, error) {
+	ctx := context.Background()
+
+	tenants, err := c.Tenants(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	var errs []error
+	for _, tenant := range tenants {
+		retention := c.tenantConfig.Limits(tenant).Retention
+		if retention <= 0 {
+			continue
+		}
+
+		filter := newChFilter()
+		filter.add("tenant = " + filter.str(tenant))
+		filter.add("timestamp < " + filter.timestamp(now.Add(-retention)))
+		count, err := c.delete(ctx, filter)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted += count
+	}
+	return deleted, errors.Join(errs...)
+}
@@ -0,0 +1,307 @@
+package database
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+)
+
+// chFilter accumulates the conditions of a WHERE clause and the query
+// parameters they refer to. Values are always sent as parameters, which
+// ClickHouse substitutes with their declared type, never spliced into SQL.
+type chFilter struct {
+	conditions []string
+	params     url.Values
+}
+
+// newChFilter returns an empty filter
+func newChFilter() *chFilter {
+	return &chFilter{params: url.Values{}}
+}
+
+// chParamEscaper escapes parameter values, which ClickHouse reads in its
+// escaped text format
+var chParamEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// arg adds a parameter of the given ClickHouse type and returns its placeholder
+func (f *chFilter) arg(value interface{}, typ string) string {
+	name := fmt.Sprintf("p%d", len(f.params)+1)
+	f.params.Set("param_"+name, chParamEscaper.Replace(fmt.Sprint(value)))
+	return "{" + name + ":" + typ + "}"
+}
+
+// str adds a String parameter and returns its placeholder
+func (f *chFilter) str(value string) string {
+	return f.arg(value, "String")
+}
+
+// add appends a condition
+func (f *chFilter) add(condition string) {
+	f.conditions = append(f.conditions, condition)
+}
+
+// where renders the conditions ANDed together
+func (f *chFilter) where() string {
+	if len(f.conditions) == 0 {
+		return "1"
+	}
+	return strings.Join(f.conditions, " AND ")
+}
+
+// column maps a query field to its column, or the map lookup for
+// metadata.<key> fields. Missing metadata keys read as empty strings.
+func (f *chFilter) column(field string) string {
+	switch field {
+	case "level", "message", "commit":
+		return field
+	case "resourceId":
+		return "resource_id"
+	case "traceId":
+		return "trace_id"
+	case "spanId":
+		return "span_id"
+	case "parentResourceId":
+		return "metadata['parentResourceId']"
+	}
+	if key, ok := querylang.MetadataKey(field); ok {
+		return "metadata[" + f.str(key) + "]"
+	}
+	return "''"
+}
+
+// order renders sort keys as an ORDER BY list, breaking ties newest first
+func (f *chFilter) order(keys []models.SortKey) string {
+	var terms []string
+	for _, key := range keys {
+		term := "timestamp"
+		if key.Field != "timestamp" {
+			term = f.column(key.Field)
+		}
+		if key.Descending {
+			term += " DESC"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(append(terms, "timestamp DESC", "id DESC"), ", ")
+}
+
+// timestamp returns a DateTime64 placeholder for a time
+func (f *chFilter) timestamp(t time.Time) string {
+	return "fromUnixTimestamp64Nano(" + f.arg(t.UnixNano(), "Int64") + ", 'UTC')"
+}
+
+// matchAny matches a column against any of several case-insensitive
+// regular expressions
+func (f *chFilter) matchAny(column string, patterns []string) string {
+	matches := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		matches[i] = "match(" + column + ", " + f.str("(?i)"+pattern) + ")"
+	}
+	return "(" + strings.Join(matches, " OR ") + ")"
+}
+
+// in matches a column against a list of values
+func (f *chFilter) in(column string, values []string) string {
+	placeholders := make([]string, len(values))
+	for i, value := range values {
+		placeholders[i] = f.str(value)
+	}
+	return column + " IN (" + strings.Join(placeholders, ", ") + ")"
+}
+
+// buildChFilter translates a tenant's log query into a WHERE clause and its
+// parameters, with the same semantics as buildFilter for MongoDB
+func buildChFilter(tenant string, query *models.LogQuery) (*chFilter, error) {
+	f := newChFilter()
+	f.add("tenant = " + f.str(tenant))
+
+	// Field filters, including repeated and negated values. As in MongoDB,
+	// message values are case-insensitive substrings.
+	for _, field := range models.FilterFields {
+		filter := query.FieldFilter(field)
+		if filter.IsEmpty() {
+			continue
+		}
+
+		column := f.column(field)
+		if field == "message" {
+			if len(filter.Include) > 0 {
+				f.add(f.matchAny(column, quoteMetas(filter.Include)))
+			}
+			if len(filter.Exclude) > 0 {
+				f.add("NOT " + f.matchAny(column, quoteMetas(filter.Exclude)))
+			}
+			continue
+		}
+
+		if len(filter.Include) > 0 {
+			f.add(f.in(column, filter.Include))
+		}
+		if len(filter.Exclude) > 0 {
+			f.add("NOT " + f.in(column, filter.Exclude))
+		}
+	}
+
+	// Metadata filters
+	for _, metadataFilter := range query.MetadataFilters {
+		f.add(f.metadataCondition(metadataFilter))
+	}
+
+	// Date range filter
+	if !query.StartTime.IsZero() {
+		f.add("timestamp >= " + f.timestamp(query.StartTime))
+	}
+	if !query.EndTime.IsZero() {
+		f.add("timestamp <= " + f.timestamp(query.EndTime))
+	}
+
+	// Regex pattern search. ClickHouse uses RE2, as Go does, so the
+	// compiled pattern carries the flags.
+	regex, err := query.Regex()
+	if err != nil {
+		return nil, err
+	}
+	if regex != nil {
+		f.add("match(message, " + f.str(regex.Compile().String()) + ")")
+	}
+
+	// Full-text search
+	if query.FullTextSearch != "" {
+		f.add(f.searchCondition(parseSearch(query.FullTextSearch)))
+	}
+
+	// Structured query language expression
+	if query.Query != "" {
+		expr, err := querylang.Parse(query.Query)
+		if err != nil {
+			return nil, err
+		}
+		f.add(f.exprCondition(expr))
+	}
+
+	// Restrict results to the caller's scope
+	if query.Scope != nil {
+		f.add(f.exprCondition(query.Scope))
+	}
+
+	// Continue after the position encoded in the cursor
+	if query.Cursor != "" {
+		cursor, err := models.DecodeCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		f.add("(timestamp, id) < (" + f.timestamp(cursor.Timestamp) + ", " + f.str(cursor.ID) + ")")
+	}
+
+	return f, nil
+}
+
+// metadataCondition translates a metadata filter
+func (f *chFilter) metadataCondition(metadataFilter models.MetadataFilter) string {
+	var condition string
+	switch metadataFilter.Op {
+	case models.MetadataPrefix:
+		key := f.str(metadataFilter.Key)
+		prefixes := make([]string, len(metadataFilter.Values))
+		for i, value := range metadataFilter.Values {
+			prefixes[i] = "startsWith(metadata[" + key + "], " + f.str(value) + ")"
+		}
+		condition = "(mapContains(metadata, " + key + ") AND (" + strings.Join(prefixes, " OR ") + "))"
+	case models.MetadataExists:
+		condition = "mapContains(metadata, " + f.str(metadataFilter.Key) + ")"
+	case models.MetadataMissing:
+		condition = "NOT mapContains(metadata, " + f.str(metadataFilter.Key) + ")"
+	default:
+		condition = f.in("metadata["+f.str(metadataFilter.Key)+"]", metadataFilter.Values)
+	}
+
+	// Negated filters match logs the condition does not
+	if metadataFilter.Negate {
+		return "NOT (" + condition + ")"
+	}
+	return condition
+}
+
+// searchCondition translates a full-text search. As in MongoDB, a log
+// matches when it contains every phrase, or any term when there is no
+// phrase, and none of the negated terms, all ignoring case.
+func (f *chFilter) searchCondition(search *searchQuery) string {
+	var conditions []string
+	if len(search.phrases) > 0 {
+		for _, phrase := range search.phrases {
+			conditions = append(conditions, "match(message, "+f.str("(?i)"+phrasePattern(phrase))+")")
+		}
+	} else if len(search.terms) > 0 {
+		terms := make([]string, len(search.terms))
+		for i, term := range search.terms {
+			terms[i] = f.searchTermCondition(term)
+		}
+		conditions = append(conditions, "("+strings.Join(terms, " OR ")+")")
+	} else {
+		// A search of only negated terms matches nothing
+		conditions = append(conditions, "0")
+	}
+
+	for _, term := range search.negated {
+		conditions = append(conditions, "NOT "+f.searchTermCondition(term))
+	}
+	return "(" + strings.Join(conditions, " AND ") + ")"
+}
+
+// searchTermCondition matches a term of a full-text search. Whole words
+// use the token index; prefixes are matched by regular expression.
+func (f *chFilter) searchTermCondition(term searchTerm) string {
+	if term.prefix {
+		return "match(message, " + f.str("(?i)"+termPattern(term)) + ")"
+	}
+	return "hasTokenCaseInsensitive(message, " + f.str(term.text) + ")"
+}
+
+// exprCondition translates a query language expression
+func (f *chFilter) exprCondition(e querylang.Expr) string {
+	switch e := e.(type) {
+	case *querylang.AndExpr:
+		return f.operandsCondition(e.Operands, " AND ")
+	case *querylang.OrExpr:
+		return f.operandsCondition(e.Operands, " OR ")
+	case *querylang.NotExpr:
+		return "NOT " + f.exprCondition(e.Operand)
+	case *querylang.TermExpr:
+		return f.termCondition(e)
+	default:
+		return "1"
+	}
+}
+
+// operandsCondition joins the conditions of a boolean expression's operands
+func (f *chFilter) operandsCondition(operands []querylang.Expr, operator string) string {
+	conditions := make([]string, len(operands))
+	for i, operand := range operands {
+		conditions[i] = f.exprCondition(operand)
+	}
+	return "(" + strings.Join(conditions, operator) + ")"
+}
+
+// termCondition translates a single term, matching the message as a
+// case-insensitive substring and other fields exactly
+func (f *chFilter) termCondition(term *querylang.TermExpr) string {
+	column := f.column(term.Field)
+
+	if term.Field == querylang.DefaultField {
+		pattern := regexp.QuoteMeta(term.Value)
+		if term.Wildcard {
+			pattern = querylang.WildcardPattern(term.Value, false)
+		}
+		return f.matchAny(column, []string{pattern})
+	}
+
+	if term.Wildcard {
+		return "match(" + column + ", " + f.str(querylang.WildcardPattern(term.Value, true)) + ")"
+	}
+	return "(" + column + " = " + f.str(term.Value) + ")"
+}
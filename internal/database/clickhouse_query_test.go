@@ -0,0 +1,133 @@
+package database
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+)
+
+func TestBuildChFilter(t *testing.T) {
+	base := time.Date(2023, 9, 15, 8, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name   string
+		query  *models.LogQuery
+		where  string
+		params map[string]string
+	}{
+		{
+			name:   "empty",
+			query:  &models.LogQuery{},
+			where:  "tenant = {p1:String}",
+			params: map[string]string{"p1": "acme"},
+		},
+		{
+			name:   "fields",
+			query:  &models.LogQuery{Level: "error", Message: "disk"},
+			where:  "tenant = {p1:String} AND level IN ({p2:String}) AND (match(message, {p3:String}))",
+			params: map[string]string{"p1": "acme", "p2": "error", "p3": "(?i)disk"},
+		},
+		{
+			name:   "excluded values",
+			query:  &models.LogQuery{Filters: map[string]models.FieldFilter{"resourceId": {Exclude: []string{"server-1", "server-2"}}}},
+			where:  "tenant = {p1:String} AND NOT resource_id IN ({p2:String}, {p3:String})",
+			params: map[string]string{"p1": "acme", "p2": "server-1", "p3": "server-2"},
+		},
+		{
+			name: "metadata",
+			query: &models.LogQuery{MetadataFilters: []models.MetadataFilter{
+				{Key: "region", Op: models.MetadataPrefix, Values: []string{"us"}},
+				{Key: "zone", Op: models.MetadataMissing, Negate: true},
+			}},
+			where:  "tenant = {p1:String} AND (mapContains(metadata, {p2:String}) AND (startsWith(metadata[{p2:String}], {p3:String}))) AND NOT (NOT mapContains(metadata, {p4:String}))",
+			params: map[string]string{"p1": "acme", "p2": "region", "p3": "us", "p4": "zone"},
+		},
+		{
+			name:   "time range",
+			query:  &models.LogQuery{StartTime: base, EndTime: base.Add(time.Second)},
+			where:  "tenant = {p1:String} AND timestamp >= fromUnixTimestamp64Nano({p2:Int64}, 'UTC') AND timestamp <= fromUnixTimestamp64Nano({p3:Int64}, 'UTC')",
+			params: map[string]string{"p1": "acme", "p2": "1694764800000000000", "p3": "1694764801000000000"},
+		},
+		{
+			name:   "regex is escaped for the parameter format",
+			query:  &models.LogQuery{RegexPattern: `\d+`},
+			where:  "tenant = {p1:String} AND match(message, {p2:String})",
+			params: map[string]string{"p1": "acme", "p2": `(?i)\\d+`},
+		},
+		{
+			name:   "case-sensitive anchored regex",
+			query:  &models.LogQuery{RegexPattern: `GET|POST`, RegexFlags: "ca"},
+			where:  "tenant = {p1:String} AND match(message, {p2:String})",
+			params: map[string]string{"p1": "acme", "p2": `^(?:GET|POST)$`},
+		},
+		{
+			name:   "message is a literal substring",
+			query:  &models.LogQuery{Message: "a.b("},
+			where:  "tenant = {p1:String} AND (match(message, {p2:String}))",
+			params: map[string]string{"p1": "acme", "p2": `(?i)a\\.b\\(`},
+		},
+		{
+			name:   "search",
+			query:  &models.LogQuery{FullTextSearch: "disk cache -profile"},
+			where:  "tenant = {p1:String} AND ((hasTokenCaseInsensitive(message, {p2:String}) OR hasTokenCaseInsensitive(message, {p3:String})) AND NOT hasTokenCaseInsensitive(message, {p4:String}))",
+			params: map[string]string{"p1": "acme", "p2": "disk", "p3": "cache", "p4": "profile"},
+		},
+		{
+			name:   "search prefixes",
+			query:  &models.LogQuery{FullTextSearch: "disk prof* -debug*"},
+			where:  "tenant = {p1:String} AND ((hasTokenCaseInsensitive(message, {p2:String}) OR match(message, {p3:String})) AND NOT match(message, {p4:String}))",
+			params: map[string]string{"p1": "acme", "p2": "disk", "p3": `(?i)(^|[^\\p{L}\\p{N}])prof`, "p4": `(?i)(^|[^\\p{L}\\p{N}])debug`},
+		},
+		{
+			name:   "search phrase",
+			query:  &models.LogQuery{FullTextSearch: `"user profile"`},
+			where:  "tenant = {p1:String} AND (match(message, {p2:String}))",
+			params: map[string]string{"p1": "acme", "p2": `(?i)(^|[^\\p{L}\\p{N}])user[^\\p{L}\\p{N}]+profile($|[^\\p{L}\\p{N}])`},
+		},
+		{
+			name:   "query language",
+			query:  &models.LogQuery{Query: `level:error AND (resourceId:server-1* OR NOT metadata.region:eu)`},
+			where:  "tenant = {p1:String} AND ((level = {p2:String}) AND (match(resource_id, {p3:String}) OR NOT (metadata[{p4:String}] = {p5:String})))",
+			params: map[string]string{"p1": "acme", "p2": "error", "p3": "^server-1.*$", "p4": "region", "p5": "eu"},
+		},
+		{
+			name:   "scope",
+			query:  &models.LogQuery{Scope: &querylang.TermExpr{Field: "parentResourceId", Value: "server-0987"}},
+			where:  "tenant = {p1:String} AND (metadata['parentResourceId'] = {p2:String})",
+			params: map[string]string{"p1": "acme", "p2": "server-0987"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := buildChFilter("acme", tc.query)
+			if err != nil {
+				t.Fatalf("Failed to build filter: %v", err)
+			}
+			if filter.where() != tc.where {
+				t.Errorf("where() = %q, expected %q", filter.where(), tc.where)
+			}
+
+			expected := url.Values{}
+			for name, value := range tc.params {
+				expected.Set("param_"+name, value)
+			}
+			if !reflect.DeepEqual(filter.params, expected) {
+				t.Errorf("params = %v, expected %v", filter.params, expected)
+			}
+		})
+	}
+}
+
+func TestBuildChFilterErrors(t *testing.T) {
+	if _, err := buildChFilter("", &models.LogQuery{Query: `level:(`}); err == nil {
+		t.Error("Expected an error for an invalid query")
+	}
+	if _, err := buildChFilter("", &models.LogQuery{Cursor: "not a cursor"}); err == nil {
+		t.Error("Expected an error for an invalid cursor")
+	}
+}
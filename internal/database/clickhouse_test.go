@@ -0,0 +1,417 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"log-ingestor/internal/models"
+)
+
+// chRequest is a request received by fakeClickHouse
+type chRequest struct {
+	statement string
+	params    url.Values
+	data      string
+	user, key string
+}
+
+// fakeClickHouse speaks the ClickHouse HTTP protocol: statements arrive in
+// the body, or in the query parameter with the body as their input, and
+// errors are reported with a non-200 status and a plain text message. It
+// records every request and answers with respond, or an empty body.
+type fakeClickHouse struct {
+	server  *httptest.Server
+	respond func(statement string, params url.Values) (int, string)
+
+	mutex    sync.Mutex
+	requests []chRequest
+}
+
+// newFakeClickHouse starts a fake ClickHouse server
+func newFakeClickHouse(t *testing.T) *fakeClickHouse {
+	t.Helper()
+	fake := &fakeClickHouse{}
+	fake.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		request := chRequest{
+			statement: string(body),
+			params:    r.URL.Query(),
+			user:      r.Header.Get("X-ClickHouse-User"),
+			key:       r.Header.Get("X-ClickHouse-Key"),
+		}
+		if statement := r.URL.Query().Get("query"); statement != "" {
+			request.statement, request.data = statement, string(body)
+		}
+
+		fake.mutex.Lock()
+		fake.requests = append(fake.requests, request)
+		respond := fake.respond
+		fake.mutex.Unlock()
+
+		if respond == nil {
+			return
+		}
+		status, response := respond(request.statement, request.params)
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+// since returns the requests received after the first skip
+func (f *fakeClickHouse) since(skip int) []chRequest {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]chRequest(nil), f.requests[skip:]...)
+}
+
+// openTestClickHouseDB opens a ClickHouseDB against a fake server
+func openTestClickHouseDB(t *testing.T, config ClickHouseConfig) (*ClickHouseDB, *fakeClickHouse) {
+	t.Helper()
+	fake := newFakeClickHouse(t)
+	config.URL = fake.server.URL
+	db, err := OpenClickHouseDB(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, fake
+}
+
+func TestClickHouseDBCreatesTable(t *testing.T) {
+	_, fake := openTestClickHouseDB(t, ClickHouseConfig{Database: "observability", Table: "app_logs", User: "ingestor", Password: "secret"})
+
+	requests := fake.since(0)
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(requests))
+	}
+	request := requests[0]
+	if !strings.HasPrefix(request.statement, "CREATE TABLE IF NOT EXISTS app_logs (") ||
+		!strings.Contains(request.statement, "ENGINE = MergeTree") ||
+		!strings.Contains(request.statement, "ORDER BY (tenant, resource_id, timestamp)") {
+		t.Errorf("Unexpected schema statement: %s", request.statement)
+	}
+	if request.params.Get("database") != "observability" {
+		t.Errorf("Expected the database parameter, got %v", request.params)
+	}
+	if request.user != "ingestor" || request.key != "secret" {
+		t.Errorf("Expected credentials in headers, got %q and %q", request.user, request.key)
+	}
+}
+
+func TestOpenClickHouseDBErrors(t *testing.T) {
+	fake := newFakeClickHouse(t)
+	fake.respond = func(string, url.Values) (int, string) {
+		return http.StatusForbidden, "Code: 497. DB::Exception: ingestor: Not enough privileges.\n"
+	}
+
+	_, err := OpenClickHouseDB(context.Background(), ClickHouseConfig{URL: fake.server.URL})
+	if err == nil || !strings.Contains(err.Error(), "Not enough privileges") {
+		t.Errorf("Expected the server's error, got %v", err)
+	}
+
+	if _, err := OpenClickHouseDB(context.Background(), ClickHouseConfig{URL: fake.server.URL, Table: "logs; DROP TABLE logs"}); err == nil {
+		t.Error("Expected an error for an invalid table name")
+	}
+}
+
+func TestClickHouseDBInsertLogs(t *testing.T) {
+	db, fake := openTestClickHouseDB(t, ClickHouseConfig{})
+	timestamp := time.Date(2023, 9, 15, 8, 0, 0, 123456789, time.UTC)
+	logs := []*models.Log{
+		{Level: "error", Message: "Failed to connect", ResourceID: "server-1", Timestamp: timestamp, Metadata: map[string]string{"parentResourceId": "server-0"}},
+		{ID: "650400000000000000000002", Level: "info", Message: "ok", Timestamp: timestamp},
+	}
+
+	if err := db.InsertLogs(WithTenant(context.Background(), "acme"), logs); err != nil {
+		t.Fatalf("Failed to insert logs: %v", err)
+	}
+
+	requests := fake.since(1)
+	if len(requests) != 1 {
+		t.Fatalf("Expected a single insert request, got %d", len(requests))
+	}
+	if !strings.HasPrefix(requests[0].statement, "INSERT INTO logs (") || !strings.HasSuffix(requests[0].statement, "FORMAT JSONEachRow") {
+		t.Errorf("Unexpected insert statement: %s", requests[0].statement)
+	}
+
+	var rows []map[string]interface{}
+	scanner := bufio.NewScanner(strings.NewReader(requests[0].data))
+	for scanner.Scan() {
+		var row map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("Invalid JSONEachRow line %q: %v", scanner.Text(), err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(rows))
+	}
+	if rows[0]["tenant"] != "acme" || rows[0]["timestamp"] != "2023-09-15 08:00:00.123456789" || rows[0]["resource_id"] != "server-1" {
+		t.Errorf("Unexpected row: %v", rows[0])
+	}
+	if rows[0]["id"] != logs[0].ID || len(logs[0].ID) != 24 {
+		t.Errorf("Expected a generated ObjectID, got %v", rows[0]["id"])
+	}
+	if rows[1]["id"] != "650400000000000000000002" {
+		t.Errorf("Expected the log's own ID to be kept, got %v", rows[1]["id"])
+	}
+	if metadata, ok := rows[1]["metadata"].(map[string]interface{}); !ok || len(metadata) != 0 {
+		t.Errorf("Expected an empty metadata map, got %v", rows[1]["metadata"])
+	}
+}
+
+func TestClickHouseDBQueryLogs(t *testing.T) {
+	db, fake := openTestClickHouseDB(t, ClickHouseConfig{})
+	fake.respond = func(statement string, params url.Values) (int, string) {
+		return http.StatusOK, `{"id":"650400000000000000000002","ts":1694764800123456789,"level":"error","message":"Failed","resource_id":"server-1","trace_id":"","span_id":"","commit":"5e5342f","metadata":{"parentResourceId":"server-0"}}
+{"id":"650400000000000000000001","ts":1694764799000000000,"level":"info","message":"ok","resource_id":"","trace_id":"","span_id":"","commit":"","metadata":{}}
+`
+	}
+
+	query := &models.LogQuery{Level: "error", Page: 3, Limit: 5}
+	logs, err := db.QueryLogs(WithTenant(context.Background(), "acme"), query)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	if len(logs) != 2 {
+		t.Fatalf("Expected 2 logs, got %d", len(logs))
+	}
+	expected := time.Date(2023, 9, 15, 8, 0, 0, 123456789, time.UTC)
+	if !logs[0].Timestamp.Equal(expected) || logs[0].Metadata["parentResourceId"] != "server-0" || logs[0].Commit != "5e5342f" {
+		t.Errorf("Unexpected log: %+v", logs[0])
+	}
+	if logs[1].Metadata != nil {
+		t.Errorf("Expected no metadata, got %v", logs[1].Metadata)
+	}
+
+	request := fake.since(1)[0]
+	if !strings.Contains(request.statement, "WHERE tenant = {p1:String} AND level IN ({p2:String}) ORDER BY timestamp DESC, id DESC LIMIT {p3:UInt64} OFFSET {p4:UInt64} FORMAT JSONEachRow") {
+		t.Errorf("Unexpected statement: %s", request.statement)
+	}
+	if request.params.Get("param_p1") != "acme" || request.params.Get("param_p3") != "5" || request.params.Get("param_p4") != "10" {
+		t.Errorf("Unexpected parameters: %v", request.params)
+	}
+}
+
+func TestClickHouseDBQueryLogsSorted(t *testing.T) {
+	db, fake := openTestClickHouseDB(t, ClickHouseConfig{})
+	fake.respond = func(statement string, params url.Values) (int, string) {
+		return http.StatusOK, `{"id":"650400000000000000000002","ts":1694764800123456789,"level":"error","message":"Failed","resource_id":"server-1","trace_id":"","span_id":"","commit":"","metadata":{"region":"eu"}}
+`
+	}
+
+	query := &models.LogQuery{Sort: "metadata.region:desc,level", Fields: "message", Page: 2, Limit: 5}
+	logs, err := db.QueryLogs(context.Background(), query)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(logs) != 1 || logs[0].Message != "Failed" || logs[0].Level != "" || logs[0].Metadata != nil {
+		t.Errorf("Expected a projected log, got %+v", logs)
+	}
+
+	request := fake.since(1)[0]
+	if !strings.Contains(request.statement, "ORDER BY metadata[{p2:String}] DESC, level, timestamp DESC, id DESC LIMIT {p3:UInt64} OFFSET {p4:UInt64}") {
+		t.Errorf("Unexpected statement: %s", request.statement)
+	}
+	if request.params.Get("param_p2") != "region" || request.params.Get("param_p4") != "5" {
+		t.Errorf("Unexpected parameters: %v", request.params)
+	}
+}
+
+func TestClickHouseDBStreamLogs(t *testing.T) {
+	db, fake := openTestClickHouseDB(t, ClickHouseConfig{})
+	fake.respond = func(statement string, params url.Values) (int, string) {
+		return http.StatusOK, `{"id":"650400000000000000000002","ts":1694764800123456789,"level":"error","message":"Failed","resource_id":"server-1","trace_id":"","span_id":"","commit":"","metadata":{}}
+{"id":"650400000000000000000001","ts":1694764799000000000,"level":"error","message":"Failed again","resource_id":"","trace_id":"","span_id":"","commit":"","metadata":{}}
+`
+	}
+
+	var ids []string
+	query := &models.LogQuery{Level: "error", Page: 3, Limit: 5}
+	err := db.StreamLogs(WithTenant(context.Background(), "acme"), query, func(log *models.Log) error {
+		ids = append(ids, log.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != "650400000000000000000002" {
+		t.Errorf("Unexpected logs %v", ids)
+	}
+
+	// Streams have no offset, whatever the page
+	request := fake.since(1)[0]
+	if !strings.Contains(request.statement, "WHERE tenant = {p1:String} AND level IN ({p2:String}) ORDER BY timestamp DESC, id DESC LIMIT {p3:UInt64} FORMAT JSONEachRow") {
+		t.Errorf("Unexpected statement: %s", request.statement)
+	}
+	if request.params.Get("param_p3") != "5" {
+		t.Errorf("Unexpected parameters: %v", request.params)
+	}
+
+	// Streaming stops at the callback's first error
+	stop := errors.New("stop")
+	calls := 0
+	err = db.StreamLogs(context.Background(), &models.LogQuery{}, func(*models.Log) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("Expected to stop after the first log, got %d calls and %v", calls, err)
+	}
+}
+
+func TestClickHouseDBQueryLogsByRelevance(t *testing.T) {
+	db, fake := openTestClickHouseDB(t, ClickHouseConfig{})
+	fake.respond = func(statement string, params url.Values) (int, string) {
+		return http.StatusOK, `{"id":"3","ts":1694764802000000000,"level":"error","message":"disk full","resource_id":"","trace_id":"","span_id":"","commit":"","metadata":{}}
+{"id":"2","ts":1694764801000000000,"level":"error","message":"disk cache full","resource_id":"","trace_id":"","span_id":"","commit":"","metadata":{}}
+{"id":"1","ts":1694764800000000000,"level":"error","message":"cache cleared","resource_id":"","trace_id":"","span_id":"","commit":"","metadata":{}}
+`
+	}
+
+	query := &models.LogQuery{FullTextSearch: "disk cache", Sort: models.SortRelevance, Page: 1, Limit: 2}
+	logs, err := db.QueryLogs(context.Background(), query)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	// The newest matches are fetched, then ranked and paged in memory
+	if ids := logIDs(logs); ids != "2,3" || logs[0].Score <= logs[1].Score {
+		t.Errorf("Expected the log with both terms first, got %s", ids)
+	}
+	request := fake.since(1)[0]
+	if request.params.Get("param_p4") != strconv.Itoa(maxRankedLogs) || request.params.Get("param_p5") != "0" {
+		t.Errorf("Expected the newest %d matches requested, got %v", maxRankedLogs, request.params)
+	}
+}
+
+func TestClickHouseDBAggregateLogs(t *testing.T) {
+	db, fake := openTestClickHouseDB(t, ClickHouseConfig{})
+	fake.respond = func(statement string, params url.Values) (int, string) {
+		switch {
+		case strings.Contains(statement, "AS start"):
+			return http.StatusOK, "{\"start\":1694764800000,\"count\":3}\n{\"start\":1694765100000,\"count\":4}\n"
+		case strings.Contains(statement, "AS value"):
+			return http.StatusOK, "{\"value\":\"error\",\"count\":5}\n{\"value\":\"info\",\"count\":2}\n"
+		default:
+			return http.StatusOK, "{\"count\":7}\n"
+		}
+	}
+
+	query := &models.LogQuery{}
+	result, err := db.AggregateLogs(context.Background(), query, &models.StatsRequest{Interval: 5 * time.Minute, GroupBy: "level", Top: 10})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+
+	if result.Total != 7 {
+		t.Errorf("Expected a total of 7, got %d", result.Total)
+	}
+	if len(result.Buckets) != 2 || result.Buckets[0].Count != 3 || result.Buckets[1].Count != 4 {
+		t.Errorf("Unexpected buckets: %+v", result.Buckets)
+	}
+	if len(result.Groups) != 2 || result.Groups[0].Value != "error" || result.Groups[0].Count != 5 {
+		t.Errorf("Unexpected groups: %+v", result.Groups)
+	}
+
+	requests := fake.since(1)
+	if len(requests) != 3 {
+		t.Fatalf("Expected 3 queries, got %d", len(requests))
+	}
+	if step := requests[1].params.Get("param_p2"); step != "300000" {
+		t.Errorf("Expected a 300000ms step, got %q", step)
+	}
+	if !strings.Contains(requests[2].statement, "SELECT level AS value") || !strings.Contains(requests[2].statement, "level != ''") {
+		t.Errorf("Unexpected groups statement: %s", requests[2].statement)
+	}
+}
+
+func TestClickHouseDBDeleteLogs(t *testing.T) {
+	db, fake := openTestClickHouseDB(t, ClickHouseConfig{})
+	fake.respond = func(statement string, params url.Values) (int, string) {
+		if strings.HasPrefix(statement, "SELECT count()") {
+			return http.StatusOK, "{\"count\":12}\n"
+		}
+		return http.StatusOK, ""
+	}
+
+	deleted, err := db.DeleteLogs(context.Background(), &models.LogQuery{Level: "debug"})
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if deleted != 12 {
+		t.Errorf("Expected 12 deleted logs, got %d", deleted)
+	}
+
+	requests := fake.since(1)
+	if len(requests) != 2 || requests[1].statement != "DELETE FROM logs WHERE tenant = {p1:String} AND level IN ({p2:String})" {
+		t.Errorf("Expected a count then a delete, got %+v", requests)
+	}
+}
+
+func TestClickHouseDBTenantsAndQuota(t *testing.T) {
+	db, fake := openTestClickHouseDB(t, ClickHouseConfig{TenantConfig: &TenantConfig{
+		Default: TenantLimits{Retention: time.Hour},
+		Tenants: map[string]TenantLimits{"acme": {MaxLogs: 10}},
+	}})
+	fake.respond = func(statement string, params url.Values) (int, string) {
+		switch {
+		case strings.Contains(statement, "DISTINCT tenant"):
+			return http.StatusOK, "{\"value\":\"acme\"}\n"
+		case strings.HasPrefix(statement, "SELECT count()"):
+			return http.StatusOK, "{\"count\":9}\n"
+		case strings.HasPrefix(statement, "INSERT"):
+			return http.StatusInternalServerError, "Code: 241. DB::Exception: Memory limit exceeded.\n"
+		}
+		return http.StatusOK, ""
+	}
+	acme := WithTenant(context.Background(), "acme")
+
+	if err := db.CheckQuota(acme, 2); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+	if err := db.InsertLog(acme, &models.Log{Level: "info", Message: "one"}); err == nil || !strings.Contains(err.Error(), "Memory limit exceeded") {
+		t.Errorf("Expected the server's error, got %v", err)
+	}
+
+	tenants, err := db.Tenants(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to list tenants: %v", err)
+	}
+	if len(tenants) != 2 || tenants[0] != DefaultTenant || tenants[1] != "acme" {
+		t.Errorf("Expected the default tenant and acme, got %q", tenants)
+	}
+
+	// Both tenants have a retention, so each gets a count and a delete
+	skip := len(fake.since(0))
+	deleted, err := db.Expire(time.Now())
+	if err != nil {
+		t.Fatalf("Expire failed: %v", err)
+	}
+	if deleted != 18 {
+		t.Errorf("Expected 18 expired logs, got %d", deleted)
+	}
+	deletes := 0
+	for _, request := range fake.since(skip) {
+		if strings.HasPrefix(request.statement, "DELETE FROM logs WHERE tenant = {p1:String} AND timestamp < ") {
+			deletes++
+		}
+	}
+	if deletes != 2 {
+		t.Errorf("Expected 2 deletes, got %d", deletes)
+	}
+}
@@ -2,7 +2,13 @@ package database
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
 	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
 )
 
 // DB is an interface for database operations
@@ -13,6 +19,116 @@ type DB interface {
 	// InsertLog inserts a log into the database
 	InsertLog(ctx context.Context, logEntry *models.Log) error
 
+	// InsertLogs inserts a batch of logs into the database. When only some
+	// of the logs could be stored it returns an *InsertError.
+	InsertLogs(ctx context.Context, logEntries []*models.Log) error
+
 	// QueryLogs queries logs from the database based on the provided filters
 	QueryLogs(ctx context.Context, query *models.LogQuery) ([]*models.Log, error)
+
+	// CountLogs counts the logs matching the query's filters, ignoring
+	// pagination. Counting stops at limit when limit is positive.
+	CountLogs(ctx context.Context, query *models.LogQuery, limit int64) (int64, error)
+
+	// AggregateLogs computes a histogram and top group values over the logs
+	// matching the query's filters, ignoring pagination
+	AggregateLogs(ctx context.Context, query *models.LogQuery, request *models.StatsRequest) (*models.StatsResult, error)
+
+	// DeleteLogs deletes the logs matching the query's filters, ignoring
+	// pagination, and returns how many were deleted
+	DeleteLogs(ctx context.Context, query *models.LogQuery) (int64, error)
+}
+
+// ErrRejected is wrapped by insert errors for logs a backend refuses to
+// store as they are, such as text it cannot encode. Retrying cannot succeed.
+var ErrRejected = errors.New("logs rejected by the database")
+
+// InsertError reports a batch insert that stored some of its logs but not
+// others. Failed maps the index in the batch of each log that was not
+// stored to the reason.
+type InsertError struct {
+	Failed map[int]error
+}
+
+// Error summarizes the failures
+func (e *InsertError) Error() string {
+	for _, err := range e.Failed {
+		return fmt.Sprintf("%d logs of the batch were not stored, e.g.: %v", len(e.Failed), err)
+	}
+	return "logs of the batch were not stored"
+}
+
+// Stored returns the logs of the batch that were stored
+func (e *InsertError) Stored(logEntries []*models.Log) []*models.Log {
+	stored := make([]*models.Log, 0, len(logEntries)-len(e.Failed))
+	for i, logEntry := range logEntries {
+		if _, failed := e.Failed[i]; !failed {
+			stored = append(stored, logEntry)
+		}
+	}
+	return stored
+}
+
+// Refused returns the logs of the batch that were not stored
+func (e *InsertError) Refused(logEntries []*models.Log) []*models.Log {
+	refused := make([]*models.Log, 0, len(e.Failed))
+	for i, logEntry := range logEntries {
+		if _, failed := e.Failed[i]; failed {
+			refused = append(refused, logEntry)
+		}
+	}
+	return refused
+}
+
+// TenantLister is implemented by backends that can enumerate the tenants
+// holding logs, for maintenance that runs across all of them
+type TenantLister interface {
+	// Tenants returns the tenants with stored logs, including the default tenant
+	Tenants(ctx context.Context) ([]string, error)
+}
+
+// MetadataIndexer is implemented by backends that index metadata keys, so
+// that results may be sorted by them
+type MetadataIndexer interface {
+	// IndexedMetadataKeys returns the metadata keys with an index of their
+	// own, besides parentResourceId
+	IndexedMetadataKeys() []string
+}
+
+// IndexedMetadataKeys returns the metadata keys a backend indexes, none
+// for backends that are not MetadataIndexers
+func IndexedMetadataKeys(db DB) []string {
+	if indexer, ok := db.(MetadataIndexer); ok {
+		return indexer.IndexedMetadataKeys()
+	}
+	return nil
+}
+
+// parseMetadataIndexKeys parses a comma-separated list of metadata keys to
+// index, skipping parentResourceId, which is always indexed, and keys that
+// are not valid field names
+func parseMetadataIndexKeys(keys string) []string {
+	var parsed []string
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" || key == "parentResourceId" {
+			continue
+		}
+		if _, ok := querylang.CanonicalField("metadata." + key); !ok {
+			log.Printf("Skipping index on invalid metadata key %q", key)
+			continue
+		}
+		parsed = append(parsed, key)
+	}
+	return parsed
+}
+
+// LogStreamer is implemented by backends that can read every log matching a
+// query through a database cursor, without holding the results in memory
+type LogStreamer interface {
+	// StreamLogs calls fn with each log matching the query's filters,
+	// newest first and after the cursor if one is set. Page, Sort and
+	// Fields are ignored and a positive Limit caps the logs read. Streaming
+	// stops at the first error fn returns, which StreamLogs returns.
+	StreamLogs(ctx context.Context, query *models.LogQuery, fn func(*models.Log) error) error
 }
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// expireInterval is how often backends without a native TTL delete logs
+// past their tenant's retention, matching MongoDB's TTL monitor
+const expireInterval = time.Minute
+
+// expirer runs a backend's expiry in the background every expireInterval
+type expirer struct {
+	stop    chan struct{}
+	done    chan struct{}
+	started atomic.Bool
+	once    sync.Once
+}
+
+// newExpirer creates an expirer; call start to run it
+func newExpirer() *expirer {
+	return &expirer{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// start calls expire every expireInterval until close is called
+func (e *expirer) start(expire func(now time.Time) (int64, error)) {
+	if !e.started.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer close(e.done)
+
+		ticker := time.NewTicker(expireInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := expire(time.Now()); err != nil {
+					log.Printf("Error expiring logs: %v", err)
+				}
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+}
+
+// close stops the expirer and waits for a run in progress to finish
+func (e *expirer) close() {
+	e.once.Do(func() {
+		close(e.stop)
+	})
+
+	if e.started.Load() {
+		<-e.done
+	}
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+)
+
+// maxHighlights bounds the highlights returned for a message
+const maxHighlights = 100
+
+// Highlights returns the parts of a message matching a query's message
+// filters, regex, full-text search and the message terms of its query
+// language expression, in order with overlapping parts merged. Negated
+// filters and terms match no part of a result, so they are not highlighted.
+func Highlights(message string, query *models.LogQuery) []models.Highlight {
+	var highlights []models.Highlight
+
+	// Message filter values are literal substrings
+	for _, value := range query.FieldFilter("message").Include {
+		highlights = append(highlights, patternHighlights(message, regexp.QuoteMeta(value))...)
+	}
+
+	if regex, err := query.Regex(); err == nil && regex != nil {
+		highlights = append(highlights, regexHighlights(message, regex.Compile())...)
+	}
+
+	if query.FullTextSearch != "" {
+		highlights = append(highlights, parseSearch(query.FullTextSearch).highlights(message)...)
+	}
+
+	if query.Query != "" {
+		if expr, err := querylang.Parse(query.Query); err == nil {
+			highlights = append(highlights, exprHighlights(message, expr)...)
+		}
+	}
+
+	return mergeHighlights(highlights)
+}
+
+// patternHighlights returns the matches of a valid case-insensitive pattern
+func patternHighlights(message, pattern string) []models.Highlight {
+	return regexHighlights(message, regexp.MustCompile("(?i)"+pattern))
+}
+
+// regexHighlights returns the non-empty matches of a regular expression
+func regexHighlights(message string, re *regexp.Regexp) []models.Highlight {
+	var highlights []models.Highlight
+	for _, match := range re.FindAllStringIndex(message, maxHighlights) {
+		if match[1] > match[0] {
+			highlights = append(highlights, models.Highlight{Start: match[0], End: match[1]})
+		}
+	}
+	return highlights
+}
+
+// exprHighlights returns the matches of the message terms of an
+// expression, skipping negated subexpressions
+func exprHighlights(message string, e querylang.Expr) []models.Highlight {
+	switch e := e.(type) {
+	case *querylang.AndExpr:
+		return operandHighlights(message, e.Operands)
+	case *querylang.OrExpr:
+		return operandHighlights(message, e.Operands)
+	case *querylang.TermExpr:
+		if e.Field != querylang.DefaultField {
+			return nil
+		}
+		if e.Wildcard {
+			return patternHighlights(message, querylang.WildcardPattern(e.Value, false))
+		}
+		return patternHighlights(message, regexp.QuoteMeta(e.Value))
+	default:
+		return nil
+	}
+}
+
+// operandHighlights returns the matches of a boolean expression's operands
+func operandHighlights(message string, operands []querylang.Expr) []models.Highlight {
+	var highlights []models.Highlight
+	for _, operand := range operands {
+		highlights = append(highlights, exprHighlights(message, operand)...)
+	}
+	return highlights
+}
+
+// wordSpan is a word of a text, lowercase, with its byte offsets
+type wordSpan struct {
+	word       string
+	start, end int
+}
+
+// wordSpans splits text into words as words does, keeping their offsets
+func wordSpans(text string) []wordSpan {
+	var spans []wordSpan
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			spans = append(spans, wordSpan{word: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, wordSpan{word: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return spans
+}
+
+// highlights returns the words of a message matching the search's terms
+// and the runs of words matching its phrases
+func (q *searchQuery) highlights(message string) []models.Highlight {
+	spans := wordSpans(message)
+
+	var highlights []models.Highlight
+	for i, span := range spans {
+		for _, term := range q.terms {
+			if term.matches(span.word) {
+				highlights = append(highlights, models.Highlight{Start: span.start, End: span.end})
+				break
+			}
+		}
+
+		for _, phrase := range q.phrases {
+			sequence := strings.Fields(phrase)
+			if i+len(sequence) > len(spans) {
+				continue
+			}
+			matched := true
+			for j, word := range sequence {
+				if spans[i+j].word != word {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				highlights = append(highlights, models.Highlight{Start: span.start, End: spans[i+len(sequence)-1].end})
+			}
+		}
+	}
+	return highlights
+}
+
+// mergeHighlights orders highlights and merges those that overlap or
+// touch, keeping at most maxHighlights
+func mergeHighlights(highlights []models.Highlight) []models.Highlight {
+	sort.Slice(highlights, func(i, j int) bool { return highlights[i].Start < highlights[j].Start })
+
+	merged := []models.Highlight{}
+	for _, highlight := range highlights {
+		if last := len(merged) - 1; last >= 0 && highlight.Start <= merged[last].End {
+			if highlight.End > merged[last].End {
+				merged[last].End = highlight.End
+			}
+			continue
+		}
+		if len(merged) == maxHighlights {
+			break
+		}
+		merged = append(merged, highlight)
+	}
+	return merged
+}
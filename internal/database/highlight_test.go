@@ -0,0 +1,124 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"log-ingestor/internal/models"
+)
+
+// highlighted returns the highlighted parts of a message
+func highlighted(message string, highlights []models.Highlight) []string {
+	parts := []string{}
+	for _, highlight := range highlights {
+		parts = append(parts, message[highlight.Start:highlight.End])
+	}
+	return parts
+}
+
+func TestHighlights(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		query    *models.LogQuery
+		expected []string
+	}{
+		{
+			name:     "none",
+			message:  "Disk usage high",
+			query:    &models.LogQuery{Level: "error"},
+			expected: []string{},
+		},
+		{
+			name:     "message filter",
+			message:  "Failed to connect, failed again",
+			query:    &models.LogQuery{Message: "failed"},
+			expected: []string{"Failed", "failed"},
+		},
+		{
+			name:     "message filter is literal",
+			message:  "Retry [attempt 2] at a.b(",
+			query:    &models.LogQuery{Message: "a.b("},
+			expected: []string{"a.b("},
+		},
+		{
+			name:     "regex",
+			message:  "GET /api/users/42 took 350ms",
+			query:    &models.LogQuery{RegexPattern: `\d+ms`},
+			expected: []string{"350ms"},
+		},
+		{
+			name:     "case-sensitive anchored regex",
+			message:  "timeout",
+			query:    &models.LogQuery{RegexPattern: `time\w+`, RegexFlags: "ca"},
+			expected: []string{"timeout"},
+		},
+		{
+			name:     "case-sensitive regex",
+			message:  "Timeout",
+			query:    &models.LogQuery{RegexPattern: `time`, RegexFlags: "c"},
+			expected: []string{},
+		},
+		{
+			name:     "invalid regex",
+			message:  "Retry [attempt 2]",
+			query:    &models.LogQuery{RegexPattern: "[attempt"},
+			expected: []string{},
+		},
+		{
+			name:     "search terms and prefixes",
+			message:  "Cache miss for user profile; profiles reloaded",
+			query:    &models.LogQuery{FullTextSearch: "cache prof* -user"},
+			expected: []string{"Cache", "profile", "profiles"},
+		},
+		{
+			name:     "search phrase",
+			message:  "User profile, user  PROFILE and user",
+			query:    &models.LogQuery{FullTextSearch: `"user profile"`},
+			expected: []string{"User profile", "user  PROFILE"},
+		},
+		{
+			name:     "unicode offsets",
+			message:  "Überlauf im Ärger-Puffer",
+			query:    &models.LogQuery{FullTextSearch: "ärger puffer"},
+			expected: []string{"Ärger", "Puffer"},
+		},
+		{
+			name:     "query language",
+			message:  "API request timed out after retry",
+			query:    &models.LogQuery{Query: `level:error AND ("timed out" OR ret*y) AND NOT request`},
+			expected: []string{"timed out", "retry"},
+		},
+		{
+			name:     "overlapping matches merge",
+			message:  "connection reset by peer",
+			query:    &models.LogQuery{Message: "connection re", FullTextSearch: "reset"},
+			expected: []string{"connection reset"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := highlighted(tt.message, Highlights(tt.message, tt.query))
+			if !reflect.DeepEqual(parts, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, parts)
+			}
+		})
+	}
+}
+
+func TestMergeHighlights(t *testing.T) {
+	highlights := mergeHighlights([]models.Highlight{{Start: 10, End: 12}, {Start: 0, End: 4}, {Start: 2, End: 6}, {Start: 6, End: 8}})
+	expected := []models.Highlight{{Start: 0, End: 8}, {Start: 10, End: 12}}
+	if !reflect.DeepEqual(highlights, expected) {
+		t.Errorf("Expected %v, got %v", expected, highlights)
+	}
+
+	many := make([]models.Highlight, 0, 2*maxHighlights)
+	for i := 0; i < 2*maxHighlights; i++ {
+		many = append(many, models.Highlight{Start: 2 * i, End: 2*i + 1})
+	}
+	if highlights := mergeHighlights(many); len(highlights) != maxHighlights {
+		t.Errorf("Expected %d highlights, got %d", maxHighlights, len(highlights))
+	}
+}
@@ -4,28 +4,58 @@ import (
 	"context"
 	"errors"
 	"log-ingestor/internal/models"
-	"strings"
+	"log-ingestor/internal/querylang"
+	"regexp"
+	"sort"
 	"sync"
+	"time"
 )
 
-// MockDB is a mock implementation of the DB interface for testing
+// MockDB is a mock implementation of the DB interface for testing. Each
+// tenant's logs are kept apart, as in separate MongoDB collections, with an
+// inverted index of their messages for full-text search.
 type MockDB struct {
-	logs          []*models.Log
+	logs          map[string][]*models.Log
+	indexes       map[string]*textIndex
 	mutex         sync.RWMutex
 	SimulateError bool
+
+	// TenantConfig sets the tenants' quotas; retention is only modelled
+	// through partition drops
+	TenantConfig *TenantConfig
+
+	// Partitioning groups logs into time partitions that can be dropped
+	Partitioning Partitioning
+
+	// MetadataIndexKeys are the metadata keys reported as indexed
+	MetadataIndexKeys []string
 }
 
-// Ensure MockDB implements the DB interface
-var _ DB = (*MockDB)(nil)
+// Ensure MockDB implements the DB, QuotaChecker, TenantLister, Partitioner,
+// LogStreamer and MetadataIndexer interfaces
+var (
+	_ DB              = (*MockDB)(nil)
+	_ QuotaChecker    = (*MockDB)(nil)
+	_ TenantLister    = (*MockDB)(nil)
+	_ Partitioner     = (*MockDB)(nil)
+	_ LogStreamer     = (*MockDB)(nil)
+	_ MetadataIndexer = (*MockDB)(nil)
+)
 
 // NewMockDB creates a new mock database
 func NewMockDB() *MockDB {
 	return &MockDB{
-		logs:          make([]*models.Log, 0),
+		logs:          make(map[string][]*models.Log),
+		indexes:       make(map[string]*textIndex),
 		SimulateError: false,
 	}
 }
 
+// IndexedMetadataKeys returns MetadataIndexKeys
+func (m *MockDB) IndexedMetadataKeys() []string {
+	return m.MetadataIndexKeys
+}
+
 // Close is a no-op for the mock database
 func (m *MockDB) Close() error {
 	// No-op
@@ -41,7 +71,137 @@ func (m *MockDB) InsertLog(ctx context.Context, logEntry *models.Log) error {
 		return errors.New("simulated error")
 	}
 
-	m.logs = append(m.logs, logEntry)
+	tenant := TenantFromContext(ctx)
+	if err := m.checkQuota(tenant, 1); err != nil {
+		return err
+	}
+
+	if logEntry.ID == "" {
+		logEntry.ID = NewLogID()
+	}
+	m.store(tenant, []*models.Log{logEntry})
+	return nil
+}
+
+// InsertLogs inserts a batch of logs into the mock database
+func (m *MockDB) InsertLogs(ctx context.Context, logEntries []*models.Log) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.SimulateError {
+		return errors.New("simulated error")
+	}
+
+	tenant := TenantFromContext(ctx)
+	if err := m.checkQuota(tenant, len(logEntries)); err != nil {
+		return err
+	}
+
+	for _, logEntry := range logEntries {
+		if logEntry.ID == "" {
+			logEntry.ID = NewLogID()
+		}
+	}
+	m.store(tenant, logEntries)
+	return nil
+}
+
+// store appends logs to a tenant's logs and indexes their messages. The
+// caller must hold the mutex.
+func (m *MockDB) store(tenant string, logEntries []*models.Log) {
+	index := m.indexes[tenant]
+	if index == nil {
+		index = newTextIndex()
+		m.indexes[tenant] = index
+	}
+	for _, logEntry := range logEntries {
+		index.add(logEntry)
+	}
+	m.logs[tenant] = append(m.logs[tenant], logEntries...)
+}
+
+// removeLogs removes a tenant's logs for which remove returns true and
+// returns how many it removed. The caller must hold the mutex.
+func (m *MockDB) removeLogs(tenant string, remove func(log *models.Log) bool) int {
+	// Keep the logs that are not removed, reusing the slice's storage
+	logs := m.logs[tenant]
+	kept := logs[:0]
+	for _, log := range logs {
+		if remove(log) {
+			m.indexes[tenant].remove(log)
+			continue
+		}
+		kept = append(kept, log)
+	}
+	removed := len(logs) - len(kept)
+	m.logs[tenant] = kept
+	return removed
+}
+
+// matching returns the context tenant's logs matching the query in the
+// order they were stored, ignoring the cursor, and the parsed full-text
+// search if there is one. Searches read their candidates from the index.
+// The caller must hold the mutex.
+func (m *MockDB) matching(ctx context.Context, query *models.LogQuery) ([]*models.Log, *searchQuery, error) {
+	// Give up once the time budget has run out, as the servers do
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	expr, err := querylang.Parse(query.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	regex, err := query.Regex()
+	if err != nil {
+		return nil, nil, err
+	}
+	var pattern *regexp.Regexp
+	if regex != nil {
+		pattern = regex.Compile()
+	}
+
+	tenant := TenantFromContext(ctx)
+	var search *searchQuery
+	var candidates map[*models.Log]bool
+	if query.FullTextSearch != "" {
+		search = parseSearch(query.FullTextSearch)
+		if index := m.indexes[tenant]; index != nil {
+			candidates = index.candidates(search)
+		}
+	}
+
+	var logs []*models.Log
+	for _, log := range m.logs[tenant] {
+		if search != nil && (!candidates[log] || !search.matches(log.Message)) {
+			continue
+		}
+		if pattern != nil && !pattern.MatchString(log.Message) {
+			continue
+		}
+		if MatchesQuery(log, query, expr) {
+			logs = append(logs, log)
+		}
+	}
+	return logs, search, nil
+}
+
+// CheckQuota reports whether the context's tenant may store n more logs
+func (m *MockDB) CheckQuota(ctx context.Context, n int) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.checkQuota(TenantFromContext(ctx), n)
+}
+
+// checkQuota compares a tenant's stored logs against its quota. The caller
+// must hold the mutex.
+func (m *MockDB) checkQuota(tenant string, n int) error {
+	limits := m.TenantConfig.Limits(tenant)
+	if limits.MaxLogs > 0 && int64(len(m.logs[tenant])+n) > limits.MaxLogs {
+		return quotaError(tenant, limits.MaxLogs)
+	}
 	return nil
 }
 
@@ -62,85 +222,192 @@ func (m *MockDB) QueryLogs(ctx context.Context, query *models.LogQuery) ([]*mode
 		query.Limit = 10
 	}
 
-	// Filter logs based on query parameters
+	var cursor *models.Cursor
+	if query.Cursor != "" {
+		var err error
+		if cursor, err = models.DecodeCursor(query.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	keys, err := query.SortKeys()
+	if err != nil {
+		return nil, err
+	}
+	projection, err := query.Projection()
+	if err != nil {
+		return nil, err
+	}
+
+	matched, search, err := m.matching(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply pagination; a cursor replaces the page offset
+	skip := (query.Page - 1) * query.Limit
+	if cursor != nil {
+		skip = 0
+	}
+
+	// Rank copies of the logs, leaving the stored logs unscored
+	if query.ByRelevance() {
+		ranked := make([]*models.Log, len(matched))
+		for i, log := range matched {
+			entry := *log
+			ranked[i] = &entry
+		}
+		rankLogs(ranked, search, m.indexes[TenantFromContext(ctx)].idf)
+		return projectLogs(pageLogs(ranked, skip, query.Limit), projection), nil
+	}
+
+	// Filter by cursor, then order logs newest first or by the sort keys,
+	// as MongoDB does
 	var filteredLogs []*models.Log
-	for _, log := range m.logs {
-		if matchesQuery(log, query) {
+	for _, log := range matched {
+		if cursor == nil || cursor.After(log) {
 			filteredLogs = append(filteredLogs, log)
 		}
 	}
+	if keys != nil {
+		sortLogsBy(filteredLogs, keys)
+	} else {
+		sortLogs(filteredLogs)
+	}
 
-	// Apply pagination
-	start := (query.Page - 1) * query.Limit
-	end := start + query.Limit
+	return projectLogs(pageLogs(filteredLogs, skip, query.Limit), projection), nil
+}
 
-	if start >= len(filteredLogs) {
-		return []*models.Log{}, nil
+// StreamLogs calls fn with each log in the mock database matching the
+// query, newest first. The matches are collected under the lock and
+// streamed after releasing it.
+func (m *MockDB) StreamLogs(ctx context.Context, query *models.LogQuery, fn func(*models.Log) error) error {
+	m.mutex.RLock()
+	if m.SimulateError {
+		m.mutex.RUnlock()
+		return errors.New("simulated error")
 	}
-
-	if end > len(filteredLogs) {
-		end = len(filteredLogs)
+	matched, _, err := m.matching(ctx, query)
+	m.mutex.RUnlock()
+	if err != nil {
+		return err
 	}
 
-	return filteredLogs[start:end], nil
+	sortLogs(matched)
+	return streamLogs(matched, query, fn)
 }
 
-// matchesQuery checks if a log matches the query parameters
-func matchesQuery(log *models.Log, query *models.LogQuery) bool {
-	// Level filter
-	if query.Level != "" && log.Level != query.Level {
-		return false
+// CountLogs counts the logs in the mock database matching the query's filters
+func (m *MockDB) CountLogs(ctx context.Context, query *models.LogQuery, limit int64) (int64, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if m.SimulateError {
+		return 0, errors.New("simulated error")
 	}
 
-	// ResourceID filter
-	if query.ResourceID != "" && log.ResourceID != query.ResourceID {
-		return false
+	matched, _, err := m.matching(ctx, query)
+	if err != nil {
+		return 0, err
 	}
 
-	// TraceID filter
-	if query.TraceID != "" && log.TraceID != query.TraceID {
-		return false
+	count := int64(len(matched))
+	if limit > 0 && count > limit {
+		count = limit
 	}
+	return count, nil
+}
 
-	// SpanID filter
-	if query.SpanID != "" && log.SpanID != query.SpanID {
-		return false
+// AggregateLogs computes stats over the logs in the mock database matching
+// the query's filters
+func (m *MockDB) AggregateLogs(ctx context.Context, query *models.LogQuery, request *models.StatsRequest) (*models.StatsResult, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if m.SimulateError {
+		return nil, errors.New("simulated error")
 	}
 
-	// Commit filter
-	if query.Commit != "" && log.Commit != query.Commit {
-		return false
+	matched, _, err := m.matching(ctx, query)
+	if err != nil {
+		return nil, err
 	}
 
-	// ParentResourceID filter
-	if query.ParentResourceID != "" && log.Metadata["parentResourceId"] != query.ParentResourceID {
-		return false
+	aggregator := newStatsAggregator(request)
+	for _, log := range matched {
+		aggregator.add(log)
 	}
 
-	// Date range filter
-	if !query.StartTime.IsZero() && log.Timestamp.Before(query.StartTime) {
-		return false
+	return aggregator.result(query), nil
+}
+
+// DeleteLogs removes the logs in the mock database matching the query's filters
+func (m *MockDB) DeleteLogs(ctx context.Context, query *models.LogQuery) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.SimulateError {
+		return 0, errors.New("simulated error")
 	}
-	if !query.EndTime.IsZero() && log.Timestamp.After(query.EndTime) {
-		return false
+
+	matched, _, err := m.matching(ctx, query)
+	if err != nil {
+		return 0, err
 	}
 
-	// Simple message contains filter (not regex for simplicity)
-	if query.Message != "" {
-		// Simple contains check for testing
-		if !contains(log.Message, query.Message) {
-			return false
-		}
+	deleted := make(map[*models.Log]bool, len(matched))
+	for _, log := range matched {
+		deleted[log] = true
 	}
+	removed := m.removeLogs(TenantFromContext(ctx), func(log *models.Log) bool { return deleted[log] })
+	return int64(removed), nil
+}
 
-	return true
+// Tenants returns the tenants with logs in the mock database, sorted
+func (m *MockDB) Tenants(ctx context.Context) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	tenants := []string{DefaultTenant}
+	for tenant, logs := range m.logs {
+		if tenant != DefaultTenant && len(logs) > 0 {
+			tenants = append(tenants, tenant)
+		}
+	}
+	sort.Strings(tenants[1:])
+	return tenants, nil
 }
 
-// contains checks if a string contains a substring (case-sensitive)
-func contains(s, substr string) bool {
-	if substr == "" {
-		return true // Empty substring is always contained
+// DropPartitionsBefore removes the context tenant's logs in partitions that
+// expired before cutoff and returns how many partitions held them
+func (m *MockDB) DropPartitionsBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.SimulateError {
+		return 0, errors.New("simulated error")
+	}
+	if !m.Partitioning.Enabled() {
+		return 0, nil
 	}
 
-	return strings.Contains(s, substr)
+	dropped := make(map[time.Time]bool)
+	m.removeLogs(TenantFromContext(ctx), func(log *models.Log) bool {
+		start := m.Partitioning.Start(log.Timestamp)
+		if m.Partitioning.Expired(start, cutoff) {
+			dropped[start] = true
+			return true
+		}
+		return false
+	})
+	return len(dropped), nil
+}
+
+// PartitionRetention returns the context tenant's retention when logs are
+// partitioned
+func (m *MockDB) PartitionRetention(ctx context.Context) time.Duration {
+	if !m.Partitioning.Enabled() {
+		return 0
+	}
+	return m.TenantConfig.Limits(TenantFromContext(ctx)).Retention
 }
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"log-ingestor/internal/models"
 	"testing"
 	"time"
@@ -217,3 +218,436 @@ func TestContains(t *testing.T) {
 		})
 	}
 }
+
+func TestMockDBInsertLogs(t *testing.T) {
+	mockDB := NewMockDB()
+	ctx := context.Background()
+
+	timestamp, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	batch := []*models.Log{
+		{Level: "error", Message: "Failed to connect to DB", ResourceID: "server-1234", Timestamp: timestamp},
+		{Level: "info", Message: "User authentication successful", ResourceID: "server-5678", Timestamp: timestamp},
+		{Level: "error", Message: "API request timed out", ResourceID: "server-1234", Timestamp: timestamp},
+	}
+
+	if err := mockDB.InsertLogs(ctx, batch); err != nil {
+		t.Fatalf("Failed to insert batch: %v", err)
+	}
+
+	logs, err := mockDB.QueryLogs(ctx, &models.LogQuery{ResourceID: "server-1234"})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 2 {
+		t.Errorf("Expected 2 logs for server-1234, got %d", len(logs))
+	}
+
+	// Simulated errors reject the whole batch
+	mockDB.SimulateError = true
+	if err := mockDB.InsertLogs(ctx, batch); err == nil {
+		t.Error("Expected error when SimulateError is set")
+	}
+}
+
+func TestMockDBCursorPagination(t *testing.T) {
+	mockDB := NewMockDB()
+	ctx := context.Background()
+
+	// Insert logs sharing timestamps so the ID tiebreaker matters
+	base, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	for i := 0; i < 5; i++ {
+		logEntry := &models.Log{Level: "info", Message: "entry", Timestamp: base.Add(time.Duration(i/2) * time.Minute)}
+		if err := mockDB.InsertLog(ctx, logEntry); err != nil {
+			t.Fatalf("Failed to insert log: %v", err)
+		}
+	}
+
+	// First page is ordered newest first
+	logs, err := mockDB.QueryLogs(ctx, &models.LogQuery{Limit: 2})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 2 || logs[0].Timestamp.Before(logs[1].Timestamp) {
+		t.Fatalf("Expected 2 logs ordered newest first, got %d", len(logs))
+	}
+
+	seen := map[string]bool{logs[0].ID: true, logs[1].ID: true}
+	cursor := models.EncodeCursor(logs[1])
+
+	// A newer log ingested between pages must not shift the next page
+	if err := mockDB.InsertLog(ctx, &models.Log{Level: "info", Message: "late", Timestamp: base.Add(time.Hour)}); err != nil {
+		t.Fatalf("Failed to insert log: %v", err)
+	}
+
+	for cursor != "" {
+		logs, err = mockDB.QueryLogs(ctx, &models.LogQuery{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("Failed to query logs with cursor: %v", err)
+		}
+
+		cursor = ""
+		for _, logEntry := range logs {
+			if seen[logEntry.ID] {
+				t.Errorf("Log %s returned twice", logEntry.ID)
+			}
+			if logEntry.Message == "late" {
+				t.Error("Log ingested after the first page appeared on a later page")
+			}
+			seen[logEntry.ID] = true
+		}
+		if len(logs) == 2 {
+			cursor = models.EncodeCursor(logs[1])
+		}
+	}
+
+	if len(seen) != 5 {
+		t.Errorf("Expected to page through 5 logs, got %d", len(seen))
+	}
+
+	// Invalid cursors are rejected
+	if _, err := mockDB.QueryLogs(ctx, &models.LogQuery{Cursor: "garbage"}); err == nil {
+		t.Error("Expected error for invalid cursor")
+	}
+}
+
+func TestMockDBCountLogs(t *testing.T) {
+	mockDB := NewMockDB()
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		level := "info"
+		if i < 3 {
+			level = "error"
+		}
+		mockDB.InsertLog(ctx, &models.Log{Level: level, Message: "entry", Timestamp: time.Now()})
+	}
+
+	testCases := []struct {
+		name     string
+		query    *models.LogQuery
+		limit    int64
+		expected int64
+	}{
+		{"All logs", &models.LogQuery{}, 0, 4},
+		{"Filtered", &models.LogQuery{Level: "error"}, 0, 3},
+		{"Capped", &models.LogQuery{}, 2, 2},
+		{"Pagination ignored", &models.LogQuery{Page: 3, Limit: 1}, 0, 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			count, err := mockDB.CountLogs(ctx, tc.query, tc.limit)
+			if err != nil {
+				t.Fatalf("Failed to count logs: %v", err)
+			}
+			if count != tc.expected {
+				t.Errorf("Expected %d, got %d", tc.expected, count)
+			}
+		})
+	}
+}
+
+func TestMockDBQueryLanguage(t *testing.T) {
+	mockDB := NewMockDB()
+	ctx := context.Background()
+
+	timestamp, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	mockDB.InsertLogs(ctx, []*models.Log{
+		{Level: "error", Message: "API request timed out", ResourceID: "server-1234", Commit: "5e5342f", Timestamp: timestamp},
+		{Level: "error", Message: "Failed to connect to DB", ResourceID: "server-1999", Commit: "a1b2c3d", Timestamp: timestamp},
+		{Level: "error", Message: "Request timed out", ResourceID: "api-gateway-1", Commit: "a1b2c3d", Timestamp: timestamp},
+		{Level: "info", Message: "User authentication successful", ResourceID: "server-1234", Commit: "a1b2c3d", Timestamp: timestamp},
+	})
+
+	query := &models.LogQuery{
+		Query: `level:error AND (resourceId:server-1* OR message:"timed out") AND NOT commit:5e5342f`,
+	}
+
+	logs, err := mockDB.QueryLogs(ctx, query)
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	if len(logs) != 2 {
+		t.Fatalf("Expected 2 logs, got %d", len(logs))
+	}
+
+	count, err := mockDB.CountLogs(ctx, query, 0)
+	if err != nil || count != 2 {
+		t.Errorf("Expected count 2, got %d (%v)", count, err)
+	}
+
+	// The expression is ANDed with the regular filters
+	query.ResourceID = "api-gateway-1"
+	logs, _ = mockDB.QueryLogs(ctx, query)
+	if len(logs) != 1 || logs[0].ResourceID != "api-gateway-1" {
+		t.Errorf("Expected only the api-gateway-1 log, got %d logs", len(logs))
+	}
+
+	if _, err := mockDB.QueryLogs(ctx, &models.LogQuery{Query: "level:error AND"}); err == nil {
+		t.Error("Expected error for malformed query")
+	}
+}
+
+func TestMockDBMetadataFilters(t *testing.T) {
+	mockDB := NewMockDB()
+	ctx := context.Background()
+
+	timestamp, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	mockDB.InsertLogs(ctx, []*models.Log{
+		{Level: "info", Message: "a", Timestamp: timestamp, Metadata: map[string]string{"region": "us-east-1", "tenant": "acme"}},
+		{Level: "info", Message: "b", Timestamp: timestamp, Metadata: map[string]string{"region": "us-west-2"}},
+		{Level: "info", Message: "c", Timestamp: timestamp, Metadata: map[string]string{"region": "eu-west-1", "tenant": "globex"}},
+	})
+
+	testCases := []struct {
+		name     string
+		filters  []models.MetadataFilter
+		expected int
+	}{
+		{"Equals", []models.MetadataFilter{{Key: "region", Op: models.MetadataEquals, Values: []string{"us-west-2"}}}, 1},
+		{"Prefix", []models.MetadataFilter{{Key: "region", Op: models.MetadataPrefix, Values: []string{"us-"}}}, 2},
+		{"Exists", []models.MetadataFilter{{Key: "tenant", Op: models.MetadataExists}}, 2},
+		{"Missing", []models.MetadataFilter{{Key: "tenant", Op: models.MetadataMissing}}, 1},
+		{"Combined", []models.MetadataFilter{
+			{Key: "region", Op: models.MetadataPrefix, Values: []string{"us-"}},
+			{Key: "tenant", Op: models.MetadataExists},
+		}, 1},
+		{"AnyOf", []models.MetadataFilter{{Key: "region", Op: models.MetadataEquals, Values: []string{"us-west-2", "eu-west-1"}}}, 2},
+		{"Negated", []models.MetadataFilter{{Key: "tenant", Op: models.MetadataEquals, Values: []string{"acme"}, Negate: true}}, 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs, err := mockDB.QueryLogs(ctx, &models.LogQuery{MetadataFilters: tc.filters})
+			if err != nil {
+				t.Fatalf("Failed to query logs: %v", err)
+			}
+			if len(logs) != tc.expected {
+				t.Errorf("Expected %d logs, got %d", tc.expected, len(logs))
+			}
+		})
+	}
+}
+
+func TestMockDBFieldFilters(t *testing.T) {
+	mockDB := NewMockDB()
+	ctx := context.Background()
+
+	timestamp, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	mockDB.InsertLogs(ctx, []*models.Log{
+		{Level: "error", Message: "Connection Timeout", ResourceID: "server-1", Timestamp: timestamp},
+		{Level: "warning", Message: "Slow response", ResourceID: "server-2", Timestamp: timestamp},
+		{Level: "info", Message: "Health check ok", ResourceID: "health-check", Timestamp: timestamp},
+		{Level: "debug", Message: "timeout retry", ResourceID: "server-1", Timestamp: timestamp},
+	})
+
+	testCases := []struct {
+		name     string
+		query    *models.LogQuery
+		expected int
+	}{
+		{"AnyLevel", &models.LogQuery{Filters: map[string]models.FieldFilter{
+			"level": {Include: []string{"error", "warning"}},
+		}}, 2},
+		{"MergedWithSingleValue", &models.LogQuery{Level: "info", Filters: map[string]models.FieldFilter{
+			"level": {Include: []string{"debug"}},
+		}}, 2},
+		{"ExcludeResource", &models.LogQuery{Filters: map[string]models.FieldFilter{
+			"resourceId": {Exclude: []string{"health-check"}},
+		}}, 3},
+		{"MessageCaseInsensitive", &models.LogQuery{Message: "TIMEOUT"}, 2},
+		{"ExcludeMessage", &models.LogQuery{Message: "timeout", Filters: map[string]models.FieldFilter{
+			"message": {Exclude: []string{"retry"}},
+		}}, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			count, err := mockDB.CountLogs(ctx, tc.query, 0)
+			if err != nil {
+				t.Fatalf("Failed to count logs: %v", err)
+			}
+			if count != int64(tc.expected) {
+				t.Errorf("Expected %d logs, got %d", tc.expected, count)
+			}
+		})
+	}
+}
+
+func TestMockDBDeleteLogs(t *testing.T) {
+	mockDB := NewMockDB()
+	acme := WithTenant(context.Background(), "acme")
+	now := time.Now()
+
+	mockDB.InsertLogs(acme, []*models.Log{
+		{Level: "debug", Message: "old debug", Timestamp: now.Add(-48 * time.Hour)},
+		{Level: "debug", Message: "new debug", Timestamp: now},
+		{Level: "error", Message: "old error", Timestamp: now.Add(-48 * time.Hour)},
+	})
+	mockDB.InsertLog(context.Background(), &models.Log{Level: "debug", Message: "default debug", Timestamp: now.Add(-48 * time.Hour)})
+
+	// Only the tenant's matching logs are deleted
+	deleted, err := mockDB.DeleteLogs(acme, &models.LogQuery{Query: "level:debug", EndTime: now.Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("Failed to delete logs: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 deleted log, got %d", deleted)
+	}
+
+	logs, _ := mockDB.QueryLogs(acme, &models.LogQuery{})
+	if len(logs) != 2 || logs[0].Message != "new debug" || logs[1].Message != "old error" {
+		t.Errorf("Unexpected logs after delete: %+v", logs)
+	}
+	if count, _ := mockDB.CountLogs(context.Background(), &models.LogQuery{}, 0); count != 1 {
+		t.Errorf("Expected the default tenant's log to remain, got %d logs", count)
+	}
+
+	tenants, _ := mockDB.Tenants(context.Background())
+	if len(tenants) != 2 || tenants[0] != DefaultTenant || tenants[1] != "acme" {
+		t.Errorf("Expected the default tenant and acme, got %q", tenants)
+	}
+}
+
+func TestMockDBFullTextSearch(t *testing.T) {
+	mockDB := NewMockDB()
+	acme := WithTenant(context.Background(), "acme")
+	now := time.Now()
+
+	mockDB.InsertLogs(acme, []*models.Log{
+		{Level: "error", Message: "Disk full on /var", Timestamp: now.Add(-2 * time.Minute)},
+		{Level: "info", Message: "Cache miss for user profile", Timestamp: now.Add(-time.Minute)},
+		{Level: "info", Message: "User profiles synced", Timestamp: now},
+	})
+	mockDB.InsertLog(context.Background(), &models.Log{Level: "error", Message: "Disk full", Timestamp: now})
+
+	tests := []struct {
+		search   string
+		expected int64
+	}{
+		{"disk", 1},
+		{"profile", 1},
+		{"profile*", 2},
+		{"user -cache", 1},
+		{`"user profile"`, 1},
+		{`"profile user"`, 0},
+		{"network", 0},
+	}
+	for _, tt := range tests {
+		if count, _ := mockDB.CountLogs(acme, &models.LogQuery{FullTextSearch: tt.search}, 0); count != tt.expected {
+			t.Errorf("search %q: expected %d logs, got %d", tt.search, tt.expected, count)
+		}
+	}
+
+	// Ranked results are scored copies of the stored logs
+	logs, _ := mockDB.QueryLogs(acme, &models.LogQuery{FullTextSearch: "user profile*", Sort: models.SortRelevance})
+	if len(logs) != 2 || logs[0].Score <= 0 || logs[1].Score > logs[0].Score {
+		t.Fatalf("Unexpected ranked logs: %+v", logs)
+	}
+	if stored, _ := mockDB.QueryLogs(acme, &models.LogQuery{FullTextSearch: "user"}); stored[0].Score != 0 {
+		t.Errorf("Expected stored logs to stay unscored, got %v", stored[0].Score)
+	}
+
+	// Deleted logs leave the index
+	mockDB.DeleteLogs(acme, &models.LogQuery{Level: "error"})
+	if count, _ := mockDB.CountLogs(acme, &models.LogQuery{FullTextSearch: "disk"}, 0); count != 0 {
+		t.Errorf("Expected deleted logs to no longer match, got %d", count)
+	}
+}
+
+func TestMockDBRegex(t *testing.T) {
+	mockDB := NewMockDB()
+	ctx := context.Background()
+	now := time.Now()
+
+	mockDB.InsertLogs(ctx, []*models.Log{
+		{Level: "info", Message: "GET /api/users took 350ms", Timestamp: now},
+		{Level: "info", Message: "get /health took 2ms", Timestamp: now},
+		{Level: "error", Message: "Parse error near a.b(", Timestamp: now},
+		{Level: "error", Message: "Parse error near axb(", Timestamp: now},
+	})
+
+	tests := []struct {
+		query    models.LogQuery
+		expected int64
+	}{
+		{models.LogQuery{RegexPattern: `^get /\w+`}, 2},
+		{models.LogQuery{RegexPattern: `^GET /\w+`, RegexFlags: "c"}, 1},
+		{models.LogQuery{RegexPattern: `\d+ms`}, 2},
+		{models.LogQuery{RegexPattern: `\d+ms`, RegexFlags: "a"}, 0},
+		{models.LogQuery{RegexPattern: `.*\d+ms`, RegexFlags: "a"}, 2},
+		{models.LogQuery{Message: "a.b("}, 1},
+	}
+	for _, tt := range tests {
+		count, err := mockDB.CountLogs(ctx, &tt.query, 0)
+		if err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+		if count != tt.expected {
+			t.Errorf("regex %q flags %q message %q: expected %d logs, got %d", tt.query.RegexPattern, tt.query.RegexFlags, tt.query.Message, tt.expected, count)
+		}
+	}
+
+	if _, err := mockDB.QueryLogs(ctx, &models.LogQuery{RegexPattern: "a.b("}); !errors.Is(err, models.ErrInvalidRegex) {
+		t.Errorf("Expected an invalid regex error, got %v", err)
+	}
+}
+
+func TestMockDBSortAndProjection(t *testing.T) {
+	mockDB := NewMockDB()
+	ctx := context.Background()
+	base := time.Date(2023, 9, 15, 8, 0, 0, 0, time.UTC)
+
+	mockDB.InsertLogs(ctx, []*models.Log{
+		{ID: "1", Level: "info", Message: "a", ResourceID: "server-2", Timestamp: base, Metadata: map[string]string{"region": "us"}},
+		{ID: "2", Level: "error", Message: "b", ResourceID: "server-1", Timestamp: base.Add(time.Minute), Metadata: map[string]string{"region": "eu"}},
+		{ID: "3", Level: "error", Message: "c", ResourceID: "server-2", Timestamp: base.Add(2 * time.Minute)},
+		{ID: "4", Level: "debug", Message: "d", ResourceID: "server-1", Timestamp: base.Add(2 * time.Minute)},
+	})
+
+	tests := []struct {
+		sort     string
+		expected string
+	}{
+		{"", "4,3,2,1"},
+		{"timestamp:asc", "1,2,4,3"},
+		{"level", "4,3,2,1"},
+		{"level:desc", "1,3,2,4"},
+		{"resourceId,level:desc", "2,4,1,3"},
+		{"metadata.region", "4,3,2,1"},
+		{"metadata.region:desc", "1,2,4,3"},
+	}
+	for _, tt := range tests {
+		logs, err := mockDB.QueryLogs(ctx, &models.LogQuery{Sort: tt.sort, Limit: 10})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if ids := logIDs(logs); ids != tt.expected {
+			t.Errorf("sort %q: expected %s, got %s", tt.sort, tt.expected, ids)
+		}
+	}
+
+	// Pages follow the sort
+	logs, _ := mockDB.QueryLogs(ctx, &models.LogQuery{Sort: "level:desc", Page: 2, Limit: 3})
+	if logIDs(logs) != "4" {
+		t.Errorf("Expected the last log on the second page, got %s", logIDs(logs))
+	}
+
+	// Projections keep only the requested fields, leaving stored logs whole
+	logs, err := mockDB.QueryLogs(ctx, &models.LogQuery{Level: "error", Fields: "message,metadata.region", Limit: 10})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(logs) != 2 || logs[0].Message != "c" || logs[0].Level != "" || logs[0].Metadata != nil || logs[1].Metadata["region"] != "eu" || logs[1].Timestamp.IsZero() {
+		t.Errorf("Unexpected projected logs %+v, %+v", logs[0], logs[1])
+	}
+	if stored, _ := mockDB.QueryLogs(ctx, &models.LogQuery{Level: "error", Limit: 10}); stored[0].Level != "error" {
+		t.Errorf("Expected stored logs to be unchanged, got %+v", stored[0])
+	}
+
+	for _, query := range []*models.LogQuery{{Sort: "message"}, {Sort: "level", Cursor: models.EncodeCursor(logs[0])}, {Fields: "score"}} {
+		if _, err := mockDB.QueryLogs(ctx, query); err == nil {
+			t.Errorf("Expected error for sort %q, fields %q", query.Sort, query.Fields)
+		}
+	}
+}
@@ -2,9 +2,11 @@ package database
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
-	"regexp"
+	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,14 +17,68 @@ import (
 	"log-ingestor/internal/models"
 )
 
-// MongoDB represents the MongoDB client and collection
+// MongoDB represents the MongoDB client and collection. The default
+// tenant's logs are stored in the configured collection and every other
+// tenant's in a collection or database of its own, created on first use.
 type MongoDB struct {
 	client     *mongo.Client
 	collection *mongo.Collection
+
+	// indexModels are the indexes created on every tenant's collection,
+	// including one per key of metadataKeys
+	indexModels  []mongo.IndexModel
+	metadataKeys []string
+
+	// isolation is how tenants are separated, by collection or by database
+	isolation string
+
+	// tenantConfig holds the tenants' quotas and retention
+	tenantConfig *TenantConfig
+
+	// partitioning splits each tenant's logs into time partitions
+	partitioning Partitioning
+
+	mutex   sync.Mutex
+	tenants map[string]*tenantCollection
 }
 
-// Ensure MongoDB implements the DB interface
-var _ DB = (*MongoDB)(nil)
+// Ensure MongoDB implements the DB, QuotaChecker, TenantLister, Partitioner,
+// LogStreamer and MetadataIndexer interfaces
+var (
+	_ DB              = (*MongoDB)(nil)
+	_ QuotaChecker    = (*MongoDB)(nil)
+	_ TenantLister    = (*MongoDB)(nil)
+	_ Partitioner     = (*MongoDB)(nil)
+	_ LogStreamer     = (*MongoDB)(nil)
+	_ MetadataIndexer = (*MongoDB)(nil)
+)
+
+const (
+	// IsolateByCollection stores each tenant's logs in a collection named
+	// after the logs collection and the tenant, e.g. logs_acme
+	IsolateByCollection = "collection"
+
+	// IsolateByDatabase stores each tenant's logs in a database named after
+	// the configured database and the tenant, e.g. logs_db_acme
+	IsolateByDatabase = "database"
+)
+
+// mongoLog is the stored form of a log, carrying MongoDB's ObjectID
+type mongoLog struct {
+	ObjectID   primitive.ObjectID `bson:"_id,omitempty"`
+	models.Log `bson:",inline"`
+}
+
+// newMongoLog prepares a log for insertion, reusing an ID assigned elsewhere
+// when it is a valid ObjectID and generating a new one otherwise
+func newMongoLog(logEntry *models.Log) *mongoLog {
+	oid, err := primitive.ObjectIDFromHex(logEntry.ID)
+	if err != nil {
+		oid = primitive.NewObjectID()
+		logEntry.ID = oid.Hex()
+	}
+	return &mongoLog{ObjectID: oid, Log: *logEntry}
+}
 
 // NewMongoDB creates a new MongoDB connection
 func NewMongoDB() (*MongoDB, error) {
@@ -31,6 +87,26 @@ func NewMongoDB() (*MongoDB, error) {
 	dbName := os.Getenv("DB_NAME")
 	collectionName := os.Getenv("COLLECTION_NAME")
 
+	// Tenant isolation, quotas and retention
+	isolation := os.Getenv("TENANT_ISOLATION")
+	if isolation == "" {
+		isolation = IsolateByCollection
+	}
+	if isolation != IsolateByCollection && isolation != IsolateByDatabase {
+		return nil, fmt.Errorf("invalid TENANT_ISOLATION %q: expected %s or %s", isolation, IsolateByCollection, IsolateByDatabase)
+	}
+
+	tenantConfig, err := tenantConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	// PARTITION_BY stores logs in daily or hourly partition collections
+	partitioning, err := ParsePartitioning(os.Getenv("PARTITION_BY"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PARTITION_BY: %w", err)
+	}
+
 	// Set client options
 	clientOptions := options.Client().ApplyURI(uri)
 
@@ -54,6 +130,29 @@ func NewMongoDB() (*MongoDB, error) {
 	// Get collection
 	collection := client.Database(dbName).Collection(collectionName)
 
+	metadataKeys := parseMetadataIndexKeys(os.Getenv("METADATA_INDEX_KEYS"))
+	m := &MongoDB{
+		client:       client,
+		collection:   collection,
+		indexModels:  logIndexModels(metadataKeys),
+		metadataKeys: metadataKeys,
+		isolation:    isolation,
+		tenantConfig: tenantConfig,
+		partitioning: partitioning,
+		tenants:      make(map[string]*tenantCollection),
+	}
+
+	// The default tenant's collection is prepared up front; others on first use
+	if _, err := m.tenant(ctx, DefaultTenant); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// logIndexModels returns the indexes created on every logs collection,
+// including those on the configured metadata keys
+func logIndexModels(metadataKeys []string) []mongo.IndexModel {
 	// Create indexes for better query performance
 	indexModels := []mongo.IndexModel{
 		{
@@ -77,17 +176,52 @@ func NewMongoDB() (*MongoDB, error) {
 				{Key: "metadata.parentResourceId", Value: 1},
 			},
 		},
+		{
+			Keys: bson.D{
+				{Key: "timestamp", Value: -1},
+				{Key: "_id", Value: -1},
+			},
+		},
+		// Sorting by a field needs an index led by it; level leads the
+		// compound index above
+		{
+			Keys: bson.D{{Key: "resourceId", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "traceId", Value: 1}},
+		},
 	}
 
-	_, err = collection.Indexes().CreateMany(ctx, indexModels)
-	if err != nil {
-		log.Printf("Error creating indexes: %v", err)
+	// Index the metadata keys configured for filtering
+	return append(indexModels, metadataIndexModels(metadataKeys)...)
+}
+
+// metadataIndexModels builds single-field indexes for metadata keys
+func metadataIndexModels(keys []string) []mongo.IndexModel {
+	indexModels := make([]mongo.IndexModel, len(keys))
+	for i, key := range keys {
+		indexModels[i] = mongo.IndexModel{
+			Keys: bson.D{{Key: "metadata." + key, Value: 1}},
+		}
 	}
+	return indexModels
+}
+
+// IndexedMetadataKeys returns the metadata keys indexed from
+// METADATA_INDEX_KEYS
+func (m *MongoDB) IndexedMetadataKeys() []string {
+	return m.metadataKeys
+}
+
+// Partitioning returns how logs are split into time partitions
+func (m *MongoDB) Partitioning() Partitioning {
+	return m.partitioning
+}
 
-	return &MongoDB{
-		client:     client,
-		collection: collection,
-	}, nil
+// Database returns the database holding the logs collection, for other
+// subsystems that store their data alongside the logs
+func (m *MongoDB) Database() *mongo.Database {
+	return m.collection.Database()
 }
 
 // Close closes the MongoDB connection
@@ -102,69 +236,113 @@ func (m *MongoDB) Close() error {
 	return nil
 }
 
-// InsertLog inserts a log into MongoDB
+// InsertLog inserts a log into the context tenant's collection
 func (m *MongoDB) InsertLog(ctx context.Context, logEntry *models.Log) error {
-	_, err := m.collection.InsertOne(ctx, logEntry)
-	return err
-}
-
-// QueryLogs queries logs from MongoDB based on the provided filters
-func (m *MongoDB) QueryLogs(ctx context.Context, query *models.LogQuery) ([]*models.Log, error) {
-	filter := bson.M{}
-
-	// Apply filters if provided
-	if query.Level != "" {
-		filter["level"] = query.Level
+	tenant, err := m.tenant(ctx, TenantFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	if err := tenant.checkQuota(ctx, 1); err != nil {
+		return err
 	}
 
-	if query.Message != "" {
-		filter["message"] = bson.M{"$regex": primitive.Regex{Pattern: query.Message, Options: "i"}}
+	collection := tenant.collection
+	if m.partitioning.Enabled() {
+		collection = m.partition(ctx, tenant, m.partitioning.Start(logEntry.Timestamp))
 	}
 
-	if query.ResourceID != "" {
-		filter["resourceId"] = query.ResourceID
+	if _, err := collection.InsertOne(ctx, newMongoLog(logEntry)); err != nil {
+		return err
 	}
+	tenant.inserted(1)
+	return nil
+}
 
-	if query.TraceID != "" {
-		filter["traceId"] = query.TraceID
+// InsertLogs inserts a batch of logs into MongoDB, split across the
+// partitions covering their timestamps when logs are partitioned. Logs
+// already stored, as when a timed out batch is retried, are skipped; any
+// other logs MongoDB refuses are reported in an *InsertError.
+func (m *MongoDB) InsertLogs(ctx context.Context, logEntries []*models.Log) error {
+	if len(logEntries) == 0 {
+		return nil
 	}
 
-	if query.SpanID != "" {
-		filter["spanId"] = query.SpanID
+	tenant, err := m.tenant(ctx, TenantFromContext(ctx))
+	if err != nil {
+		return err
 	}
+	if err := tenant.checkQuota(ctx, len(logEntries)); err != nil {
+		return err
+	}
+
+	failed := make(map[int]error)
+	for _, batch := range m.writeBatches(ctx, tenant, logEntries) {
+		documents := make([]interface{}, len(batch.entries))
+		for i, logEntry := range batch.entries {
+			documents[i] = newMongoLog(logEntry)
+		}
 
-	if query.Commit != "" {
-		filter["commit"] = query.Commit
+		// Unordered inserts let MongoDB continue past a failing document
+		_, err := batch.collection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+		rejected, err := insertFailures(err, batch.indexes, failed)
+		if err != nil {
+			return err
+		}
+		tenant.inserted(len(documents) - rejected)
 	}
 
-	if query.ParentResourceID != "" {
-		filter["metadata.parentResourceId"] = query.ParentResourceID
+	if len(failed) > 0 {
+		return &InsertError{Failed: failed}
 	}
+	return nil
+}
 
-	// Date range filter
-	timeFilter := bson.M{}
-	if !query.StartTime.IsZero() {
-		timeFilter["$gte"] = query.StartTime
+// insertFailures unpacks the per-document errors of an unordered insert
+// into failed, by the logs' positions in the batch given by indexes, and
+// returns how many documents were not inserted. Duplicate keys are logs
+// stored by an earlier attempt and are not failures. Errors that do not
+// name documents, such as a lost connection, are returned.
+func insertFailures(err error, indexes []int, failed map[int]error) (int, error) {
+	if err == nil {
+		return 0, nil
 	}
-	if !query.EndTime.IsZero() {
-		timeFilter["$lte"] = query.EndTime
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return 0, err
 	}
-	if len(timeFilter) > 0 {
-		filter["timestamp"] = timeFilter
+	for _, writeErr := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(writeErr.WriteError) {
+			failed[indexes[writeErr.Index]] = errors.New(writeErr.Message)
+		}
 	}
+	return len(bulkErr.WriteErrors), nil
+}
 
-	// Regex pattern search
-	if query.RegexPattern != "" {
-		// Validate regex pattern
-		_, err := regexp.Compile(query.RegexPattern)
-		if err == nil {
-			filter["message"] = bson.M{"$regex": primitive.Regex{Pattern: query.RegexPattern, Options: "i"}}
-		}
+// CheckQuota reports whether the context's tenant may store n more logs
+func (m *MongoDB) CheckQuota(ctx context.Context, n int) error {
+	tenant, err := m.tenant(ctx, TenantFromContext(ctx))
+	if err != nil {
+		return err
 	}
+	return tenant.checkQuota(ctx, n)
+}
 
-	// Full-text search
-	if query.FullTextSearch != "" {
-		filter["$text"] = bson.M{"$search": query.FullTextSearch}
+// QueryLogs queries logs from MongoDB based on the provided filters. With
+// partitioning, only the partitions overlapping the query's time range are
+// read, newest first.
+func (m *MongoDB) QueryLogs(ctx context.Context, query *models.LogQuery) ([]*models.Log, error) {
+	filter, err := buildFilter(query)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := query.SortKeys()
+	if err != nil {
+		return nil, err
+	}
+	projection, err := query.Projection()
+	if err != nil {
+		return nil, err
 	}
 
 	// Set default pagination values if not provided
@@ -175,27 +353,281 @@ func (m *MongoDB) QueryLogs(ctx context.Context, query *models.LogQuery) ([]*mod
 		query.Limit = 10
 	}
 
-	// Calculate skip value for pagination
+	// Calculate skip value for pagination; a cursor replaces the page offset
 	skip := (query.Page - 1) * query.Limit
+	if query.Cursor != "" {
+		skip = 0
+	}
 
-	// Set options
-	findOptions := options.Find().
-		SetSkip(int64(skip)).
-		SetLimit(int64(query.Limit)).
-		SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	tenant, err := m.tenant(ctx, TenantFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	collections, err := m.readCollections(ctx, tenant, query)
+	if err != nil {
+		return nil, err
+	}
 
-	// Execute query
-	cursor, err := m.collection.Find(ctx, filter, findOptions)
+	var logs []*models.Log
+	switch {
+	case query.ByRelevance():
+		logs, err = queryByRelevance(ctx, collections, filter, query, skip)
+	case keys != nil:
+		logs, err = querySorted(ctx, collections, filter, keys, mongoProjection(projection, keys), skip, query.Limit)
+	default:
+		logs, err = pagePartitions(len(collections), skip, query.Limit,
+			func(i int) (int64, error) {
+				return collections[i].CountDocuments(ctx, filter, countOptions(ctx))
+			},
+			func(i, skip, limit int) ([]*models.Log, error) {
+				return findLogs(ctx, collections[i], filter, timeOrder, mongoProjection(projection, nil), skip, limit)
+			},
+		)
+	}
 	if err != nil {
 		return nil, err
 	}
+	return projectLogs(logs, projection), nil
+}
+
+// querySorted reads a page of logs ordered by sort keys. Partitions hold
+// time ranges, not ranges of the sort keys, so the first skip+limit logs
+// of each are merged.
+func querySorted(ctx context.Context, collections []*mongo.Collection, filter bson.M, keys []models.SortKey, projection bson.M, skip, limit int) ([]*models.Log, error) {
+	if len(collections) == 1 {
+		return findLogs(ctx, collections[0], filter, mongoSort(keys), projection, skip, limit)
+	}
+
+	var logs []*models.Log
+	for _, collection := range collections {
+		sorted, err := findLogs(ctx, collection, filter, mongoSort(keys), projection, 0, skip+limit)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, sorted...)
+	}
+	sortLogsBy(logs, keys)
+	return pageLogs(logs, skip, limit), nil
+}
+
+// StreamLogs calls fn with each log in MongoDB matching the query, newest
+// first, reading the partitions in turn through a cursor that fetches
+// streamBatchSize logs at a time
+func (m *MongoDB) StreamLogs(ctx context.Context, query *models.LogQuery, fn func(*models.Log) error) error {
+	filter, err := buildFilter(query)
+	if err != nil {
+		return err
+	}
+
+	tenant, err := m.tenant(ctx, TenantFromContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	collections, err := m.readCollections(ctx, tenant, query)
+	if err != nil {
+		return err
+	}
+
+	remaining := int64(query.Limit)
+	for _, collection := range collections {
+		findOptions := options.Find().
+			SetBatchSize(streamBatchSize).
+			SetSort(timeOrder)
+		if remaining > 0 {
+			findOptions.SetLimit(remaining)
+		}
+		if budget, ok := timeBudget(ctx); ok {
+			findOptions.SetMaxTime(budget)
+		}
+
+		streamed, err := streamCursor(ctx, collection, filter, findOptions, fn)
+		if err != nil {
+			return err
+		}
+		if remaining > 0 {
+			if remaining -= streamed; remaining <= 0 {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// streamCursor runs a find and calls fn with each log as the cursor reads
+// it, returning how many were read
+func streamCursor(ctx context.Context, collection *mongo.Collection, filter bson.M, findOptions *options.FindOptions, fn func(*models.Log) error) (int64, error) {
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return 0, err
+	}
 	defer cursor.Close(ctx)
 
-	// Decode results
+	var streamed int64
+	for cursor.Next(ctx) {
+		var document mongoLog
+		if err := cursor.Decode(&document); err != nil {
+			return streamed, err
+		}
+		document.Log.ID = document.ObjectID.Hex()
+		if err := fn(&document.Log); err != nil {
+			return streamed, err
+		}
+		streamed++
+	}
+	return streamed, cursor.Err()
+}
+
+// queryByRelevance reads a page of logs ordered by relevance. $text
+// searches are ordered by MongoDB's textScore, merging the best skip+limit
+// matches of each partition. Searches with prefix terms cannot use the
+// text index, so the newest maxRankedLogs matches are ranked in memory.
+func queryByRelevance(ctx context.Context, collections []*mongo.Collection, filter bson.M, query *models.LogQuery, skip int) ([]*models.Log, error) {
+	if search := parseSearch(query.FullTextSearch); search.hasPrefixes() {
+		logs, err := pagePartitions(len(collections), 0, maxRankedLogs,
+			func(i int) (int64, error) {
+				return collections[i].CountDocuments(ctx, filter, countOptions(ctx))
+			},
+			func(i, skip, limit int) ([]*models.Log, error) {
+				return findLogs(ctx, collections[i], filter, timeOrder, nil, skip, limit)
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		rankLogs(logs, search, nil)
+		return pageLogs(logs, skip, query.Limit), nil
+	}
+
 	var logs []*models.Log
-	if err := cursor.All(ctx, &logs); err != nil {
+	for _, collection := range collections {
+		ranked, err := findRankedLogs(ctx, collection, filter, skip+query.Limit)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, ranked...)
+	}
+	sortByScore(logs)
+	return pageLogs(logs, skip, query.Limit), nil
+}
+
+// CountLogs counts the logs in MongoDB matching the query's filters
+func (m *MongoDB) CountLogs(ctx context.Context, query *models.LogQuery, limit int64) (int64, error) {
+	// The total covers every match, not just those after the cursor
+	countQuery := *query
+	countQuery.Cursor = ""
+
+	filter, err := buildFilter(&countQuery)
+	if err != nil {
+		return 0, err
+	}
+
+	tenant, err := m.tenant(ctx, TenantFromContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+
+	collections, err := m.readCollections(ctx, tenant, &countQuery)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, collection := range collections {
+		limitOptions := countOptions(ctx)
+		if limit > 0 {
+			limitOptions.SetLimit(limit - total)
+		}
+
+		count, err := collection.CountDocuments(ctx, filter, limitOptions)
+		if err != nil {
+			return 0, err
+		}
+		total += count
+		if limit > 0 && total >= limit {
+			break
+		}
+	}
+	return total, nil
+}
+
+// AggregateLogs computes stats over the logs in MongoDB matching the
+// query's filters in a single aggregation, which unions the partitions
+// overlapping the query when logs are partitioned
+func (m *MongoDB) AggregateLogs(ctx context.Context, query *models.LogQuery, request *models.StatsRequest) (*models.StatsResult, error) {
+	statsQuery := *query
+	statsQuery.Cursor = ""
+
+	filter, err := buildFilter(&statsQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant, err := m.tenant(ctx, TenantFromContext(ctx))
+	if err != nil {
 		return nil, err
 	}
 
-	return logs, nil
+	collections, err := m.readCollections(ctx, tenant, &statsQuery)
+	if err != nil {
+		return nil, err
+	}
+	if len(collections) == 0 {
+		return facetsToStats(&statsQuery, request, nil), nil
+	}
+
+	// The other partitions are unioned into the first
+	var others []string
+	for _, collection := range collections[1:] {
+		others = append(others, collection.Name())
+	}
+
+	aggregateOptions := options.Aggregate()
+	if budget, ok := timeBudget(ctx); ok {
+		aggregateOptions.SetMaxTime(budget)
+	}
+	cursor, err := collections[0].Aggregate(ctx, partitionedStatsPipeline(others, filter, request), aggregateOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var facets []statsFacets
+	if err := cursor.All(ctx, &facets); err != nil {
+		return nil, err
+	}
+
+	return facetsToStats(&statsQuery, request, facets), nil
+}
+
+// DeleteLogs deletes the logs in MongoDB matching the query's filters
+func (m *MongoDB) DeleteLogs(ctx context.Context, query *models.LogQuery) (int64, error) {
+	deleteQuery := *query
+	deleteQuery.Cursor = ""
+
+	filter, err := buildFilter(&deleteQuery)
+	if err != nil {
+		return 0, err
+	}
+
+	tenant, err := m.tenant(ctx, TenantFromContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+
+	collections, err := m.readCollections(ctx, tenant, &deleteQuery)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, collection := range collections {
+		result, err := collection.DeleteMany(ctx, filter)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += result.DeletedCount
+	}
+	return deleted, nil
 }
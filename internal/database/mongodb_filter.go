@@ -0,0 +1,325 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+)
+
+// buildFilter translates a log query into a MongoDB filter
+func buildFilter(query *models.LogQuery) (bson.M, error) {
+	filter := bson.M{}
+
+	// Apply filters if provided, including repeated and negated values
+	for _, field := range models.FilterFields {
+		if fieldFilter := query.FieldFilter(field); !fieldFilter.IsEmpty() {
+			filter[fieldPath(field)] = compileFieldFilter(field, fieldFilter)
+		}
+	}
+
+	// Metadata filters may repeat a key, so each gets its own clause
+	var conditions bson.A
+	for _, metadataFilter := range query.MetadataFilters {
+		conditions = append(conditions, compileMetadataFilter(metadataFilter))
+	}
+
+	// Date range filter
+	timeFilter := bson.M{}
+	if !query.StartTime.IsZero() {
+		timeFilter["$gte"] = query.StartTime
+	}
+	if !query.EndTime.IsZero() {
+		timeFilter["$lte"] = query.EndTime
+	}
+	if len(timeFilter) > 0 {
+		filter["timestamp"] = timeFilter
+	}
+
+	// Regex pattern search, in its own clause so it combines with message
+	// filters
+	regex, err := query.Regex()
+	if err != nil {
+		return nil, err
+	}
+	if regex != nil {
+		options := "i"
+		if regex.CaseSensitive {
+			options = ""
+		}
+		conditions = append(conditions, bson.M{"message": bson.M{"$regex": primitive.Regex{Pattern: regex.Expr(), Options: options}}})
+	}
+
+	// Full-text search. $text has no prefix terms, so searches with them
+	// match words by regular expression instead, without the text index.
+	if query.FullTextSearch != "" {
+		if search := parseSearch(query.FullTextSearch); search.hasPrefixes() {
+			conditions = append(conditions, compileSearch(search))
+		} else {
+			filter["$text"] = bson.M{"$search": query.FullTextSearch}
+		}
+	}
+
+	// Structured query language expression
+	if query.Query != "" {
+		expr, err := querylang.Parse(query.Query)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, compileExpr(expr))
+	}
+
+	// Restrict results to the caller's scope
+	if query.Scope != nil {
+		conditions = append(conditions, compileExpr(query.Scope))
+	}
+
+	if len(conditions) > 0 {
+		filter = bson.M{"$and": append(bson.A{filter}, conditions...)}
+	}
+
+	// Continue after the position encoded in the cursor
+	if query.Cursor != "" {
+		cursor, err := models.DecodeCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		oid, err := primitive.ObjectIDFromHex(cursor.ID)
+		if err != nil {
+			return nil, models.ErrInvalidCursor
+		}
+
+		filter = bson.M{"$and": bson.A{filter, bson.M{"$or": bson.A{
+			bson.M{"timestamp": bson.M{"$lt": cursor.Timestamp}},
+			bson.M{"timestamp": cursor.Timestamp, "_id": bson.M{"$lt": oid}},
+		}}}}
+	}
+
+	return filter, nil
+}
+
+// compileFieldFilter translates a field filter into a MongoDB condition. A
+// single value stays a plain equality so existing indexes are used as
+// before; message values are case-insensitive substrings.
+func compileFieldFilter(field string, fieldFilter models.FieldFilter) interface{} {
+	if field == "message" {
+		if len(fieldFilter.Include) == 1 && len(fieldFilter.Exclude) == 0 {
+			return bson.M{"$regex": messageRegex(regexp.QuoteMeta(fieldFilter.Include[0]))}
+		}
+
+		condition := bson.M{}
+		if len(fieldFilter.Include) > 0 {
+			condition["$in"] = substringRegexes(fieldFilter.Include)
+		}
+		if len(fieldFilter.Exclude) > 0 {
+			condition["$nin"] = substringRegexes(fieldFilter.Exclude)
+		}
+		return condition
+	}
+
+	if len(fieldFilter.Include) == 1 && len(fieldFilter.Exclude) == 0 {
+		return fieldFilter.Include[0]
+	}
+
+	condition := bson.M{}
+	if len(fieldFilter.Include) > 0 {
+		condition["$in"] = fieldFilter.Include
+	}
+	if len(fieldFilter.Exclude) > 0 {
+		condition["$nin"] = fieldFilter.Exclude
+	}
+	return condition
+}
+
+// messageRegex builds a case-insensitive pattern on messages
+func messageRegex(pattern string) primitive.Regex {
+	return primitive.Regex{Pattern: pattern, Options: "i"}
+}
+
+// substringRegexes builds a pattern matching each value as a literal
+// substring, ignoring case
+func substringRegexes(values []string) bson.A {
+	regexes := make(bson.A, len(values))
+	for i, value := range values {
+		regexes[i] = messageRegex(regexp.QuoteMeta(value))
+	}
+	return regexes
+}
+
+// compileMetadataFilter translates a metadata filter into a MongoDB filter
+func compileMetadataFilter(metadataFilter models.MetadataFilter) bson.M {
+	path := "metadata." + metadataFilter.Key
+
+	var condition bson.M
+	switch metadataFilter.Op {
+	case models.MetadataPrefix:
+		prefixes := make(bson.A, len(metadataFilter.Values))
+		for i, value := range metadataFilter.Values {
+			prefixes[i] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(value)}
+		}
+		if len(prefixes) == 1 {
+			condition = bson.M{path: bson.M{"$regex": prefixes[0]}}
+		} else {
+			condition = bson.M{path: bson.M{"$in": prefixes}}
+		}
+	case models.MetadataExists:
+		condition = bson.M{path: bson.M{"$exists": true}}
+	case models.MetadataMissing:
+		condition = bson.M{path: bson.M{"$exists": false}}
+	default:
+		if len(metadataFilter.Values) == 1 {
+			condition = bson.M{path: metadataFilter.Values[0]}
+		} else {
+			condition = bson.M{path: bson.M{"$in": metadataFilter.Values}}
+		}
+	}
+
+	// Negated filters match documents the condition does not
+	if metadataFilter.Negate {
+		return bson.M{"$nor": bson.A{condition}}
+	}
+	return condition
+}
+
+// compileExpr translates a query language expression into a MongoDB filter
+func compileExpr(e querylang.Expr) bson.M {
+	switch e := e.(type) {
+	case *querylang.AndExpr:
+		return bson.M{"$and": compileOperands(e.Operands)}
+	case *querylang.OrExpr:
+		return bson.M{"$or": compileOperands(e.Operands)}
+	case *querylang.NotExpr:
+		return bson.M{"$nor": bson.A{compileExpr(e.Operand)}}
+	case *querylang.TermExpr:
+		return compileTerm(e)
+	default:
+		return bson.M{}
+	}
+}
+
+// compileOperands translates each operand of a boolean expression
+func compileOperands(operands []querylang.Expr) bson.A {
+	filters := make(bson.A, len(operands))
+	for i, operand := range operands {
+		filters[i] = compileExpr(operand)
+	}
+	return filters
+}
+
+// compileTerm translates a single term, matching the message as a
+// case-insensitive substring and other fields exactly
+func compileTerm(term *querylang.TermExpr) bson.M {
+	path := fieldPath(term.Field)
+
+	if term.Field == querylang.DefaultField {
+		pattern := regexp.QuoteMeta(term.Value)
+		if term.Wildcard {
+			pattern = querylang.WildcardPattern(term.Value, false)
+		}
+		return bson.M{path: bson.M{"$regex": primitive.Regex{Pattern: pattern, Options: "i"}}}
+	}
+
+	if term.Wildcard {
+		return bson.M{path: bson.M{"$regex": primitive.Regex{Pattern: querylang.WildcardPattern(term.Value, true)}}}
+	}
+	return bson.M{path: term.Value}
+}
+
+// fieldPath maps a query field to its document path
+func fieldPath(field string) string {
+	if field == "parentResourceId" {
+		return "metadata.parentResourceId"
+	}
+	return field
+}
+
+// compileSearch translates a full-text search into regular expressions on
+// the message, with the semantics of $text: a log matches when it contains
+// every phrase, or any term when there is no phrase, and none of the
+// negated terms
+func compileSearch(search *searchQuery) bson.M {
+	var conditions bson.A
+	if len(search.phrases) > 0 {
+		for _, phrase := range search.phrases {
+			conditions = append(conditions, bson.M{"message": messageRegex(phrasePattern(phrase))})
+		}
+	} else if len(search.terms) > 0 {
+		terms := make(bson.A, len(search.terms))
+		for i, term := range search.terms {
+			terms[i] = bson.M{"message": messageRegex(termPattern(term))}
+		}
+		conditions = append(conditions, bson.M{"$or": terms})
+	} else {
+		// A search of only negated terms matches nothing
+		conditions = append(conditions, bson.M{"_id": bson.M{"$exists": false}})
+	}
+
+	for _, term := range search.negated {
+		conditions = append(conditions, bson.M{"message": bson.M{"$not": messageRegex(termPattern(term))}})
+	}
+	return bson.M{"$and": conditions}
+}
+
+// timeOrder sorts logs newest first, the order cursors are defined over
+var timeOrder = bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}
+
+// mongoSort translates sort keys into a sort document, breaking ties
+// newest first as sortLogsBy does
+func mongoSort(keys []models.SortKey) bson.D {
+	var sort bson.D
+	byTimestamp := false
+	for _, key := range keys {
+		field := key.Field
+		if field == "parentResourceId" {
+			field = "metadata.parentResourceId"
+		}
+		byTimestamp = byTimestamp || field == "timestamp"
+
+		direction := 1
+		if key.Descending {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: field, Value: direction})
+	}
+
+	if !byTimestamp {
+		sort = append(sort, bson.E{Key: "timestamp", Value: -1})
+	}
+	return append(sort, bson.E{Key: "_id", Value: -1})
+}
+
+// mongoProjection translates projected fields into a projection document,
+// or nil for every field. The timestamp and the sort keys' fields are
+// included as cursors and merging partitions need them.
+func mongoProjection(fields []string, keys []models.SortKey) bson.M {
+	if fields == nil {
+		return nil
+	}
+
+	projection := bson.M{"timestamp": 1}
+	for _, field := range fields {
+		projection[field] = 1
+	}
+	for _, key := range keys {
+		field := key.Field
+		if field == "parentResourceId" {
+			field = "metadata.parentResourceId"
+		}
+		projection[field] = 1
+	}
+
+	// Including metadata and one of its keys is a path collision
+	if _, ok := projection[models.MetadataField]; ok {
+		for field := range projection {
+			if strings.HasPrefix(field, models.MetadataField+".") {
+				delete(projection, field)
+			}
+		}
+	}
+	return projection
+}
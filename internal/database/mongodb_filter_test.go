@@ -0,0 +1,237 @@
+package database
+
+import (
+	"errors"
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCompileExpr(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected bson.M
+	}{
+		{
+			query:    `level:error`,
+			expected: bson.M{"level": "error"},
+		},
+		{
+			query:    `"a.b("`,
+			expected: bson.M{"message": bson.M{"$regex": primitive.Regex{Pattern: `a\.b\(`, Options: "i"}}},
+		},
+		{
+			query:    `resourceId:server-1*`,
+			expected: bson.M{"resourceId": bson.M{"$regex": primitive.Regex{Pattern: `^server-1.*$`}}},
+		},
+		{
+			query:    `parentResourceId:server-0987`,
+			expected: bson.M{"metadata.parentResourceId": "server-0987"},
+		},
+		{
+			query: `level:error AND (resourceId:a OR NOT commit:b)`,
+			expected: bson.M{"$and": bson.A{
+				bson.M{"level": "error"},
+				bson.M{"$or": bson.A{
+					bson.M{"resourceId": "a"},
+					bson.M{"$nor": bson.A{bson.M{"commit": "b"}}},
+				}},
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			expr, err := querylang.Parse(tc.query)
+			if err != nil {
+				t.Fatalf("Failed to parse %q: %v", tc.query, err)
+			}
+			if result := compileExpr(expr); !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("compileExpr(%q) = %v, expected %v", tc.query, result, tc.expected)
+			}
+		})
+	}
+}
+
+func TestBuildFilterMetadata(t *testing.T) {
+	query := &models.LogQuery{
+		Level: "error",
+		MetadataFilters: []models.MetadataFilter{
+			{Key: "region", Op: models.MetadataPrefix, Values: []string{"us."}},
+			{Key: "tenant", Op: models.MetadataMissing},
+		},
+	}
+
+	filter, err := buildFilter(query)
+	if err != nil {
+		t.Fatalf("Failed to build filter: %v", err)
+	}
+
+	expected := bson.M{"$and": bson.A{
+		bson.M{"level": "error"},
+		bson.M{"metadata.region": bson.M{"$regex": primitive.Regex{Pattern: `^us\.`}}},
+		bson.M{"metadata.tenant": bson.M{"$exists": false}},
+	}}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("buildFilter() = %v, expected %v", filter, expected)
+	}
+}
+
+func TestBuildFilterFieldFilters(t *testing.T) {
+	query := &models.LogQuery{
+		Level:   "error",
+		Message: "timeout",
+		Filters: map[string]models.FieldFilter{
+			"level":            {Include: []string{"warning"}},
+			"resourceId":       {Exclude: []string{"health-check"}},
+			"parentResourceId": {Include: []string{"a", "b"}, Exclude: []string{"c"}},
+			"message":          {Exclude: []string{"debug"}},
+		},
+		MetadataFilters: []models.MetadataFilter{
+			{Key: "region", Op: models.MetadataEquals, Values: []string{"eu", "us"}},
+			{Key: "team", Op: models.MetadataEquals, Values: []string{"infra"}, Negate: true},
+		},
+	}
+
+	filter, err := buildFilter(query)
+	if err != nil {
+		t.Fatalf("Failed to build filter: %v", err)
+	}
+
+	expected := bson.M{"$and": bson.A{
+		bson.M{
+			"level":      bson.M{"$in": []string{"error", "warning"}},
+			"resourceId": bson.M{"$nin": []string{"health-check"}},
+			"metadata.parentResourceId": bson.M{
+				"$in":  []string{"a", "b"},
+				"$nin": []string{"c"},
+			},
+			"message": bson.M{
+				"$in":  bson.A{primitive.Regex{Pattern: "timeout", Options: "i"}},
+				"$nin": bson.A{primitive.Regex{Pattern: "debug", Options: "i"}},
+			},
+		},
+		bson.M{"metadata.region": bson.M{"$in": []string{"eu", "us"}}},
+		bson.M{"$nor": bson.A{bson.M{"metadata.team": "infra"}}},
+	}}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("buildFilter() = %v, expected %v", filter, expected)
+	}
+}
+
+func TestBuildFilterRegex(t *testing.T) {
+	query := &models.LogQuery{Message: "a.b(", RegexPattern: `^GET|POST`, RegexFlags: "ca"}
+
+	filter, err := buildFilter(query)
+	if err != nil {
+		t.Fatalf("Failed to build filter: %v", err)
+	}
+
+	expected := bson.M{"$and": bson.A{
+		bson.M{"message": bson.M{"$regex": primitive.Regex{Pattern: `a\.b\(`, Options: "i"}}},
+		bson.M{"message": bson.M{"$regex": primitive.Regex{Pattern: `^(?:^GET|POST)$`}}},
+	}}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("buildFilter() = %v, expected %v", filter, expected)
+	}
+
+	if _, err := buildFilter(&models.LogQuery{RegexPattern: "a.b("}); !errors.Is(err, models.ErrInvalidRegex) {
+		t.Errorf("Expected an invalid regex error, got %v", err)
+	}
+}
+
+func TestBuildFilterScope(t *testing.T) {
+	query := &models.LogQuery{
+		Level: "error",
+		Scope: &querylang.TermExpr{Field: "resourceId", Value: "payments-*", Wildcard: true},
+	}
+
+	filter, err := buildFilter(query)
+	if err != nil {
+		t.Fatalf("Failed to build filter: %v", err)
+	}
+
+	expected := bson.M{"$and": bson.A{
+		bson.M{"level": "error"},
+		bson.M{"resourceId": bson.M{"$regex": primitive.Regex{Pattern: `^payments-.*$`}}},
+	}}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("buildFilter() = %v, expected %v", filter, expected)
+	}
+}
+
+func TestMetadataIndexModels(t *testing.T) {
+	indexModels := metadataIndexModels(parseMetadataIndexKeys("region, userId,,parentResourceId,bad.key"))
+	if len(indexModels) != 2 {
+		t.Fatalf("Expected 2 index models, got %d", len(indexModels))
+	}
+	if keys := indexModels[1].Keys.(bson.D); keys[0].Key != "metadata.userId" {
+		t.Errorf("Expected index on metadata.userId, got %v", keys)
+	}
+}
+
+func TestMongoSort(t *testing.T) {
+	sort := mongoSort([]models.SortKey{{Field: "level"}, {Field: "parentResourceId", Descending: true}})
+	expected := bson.D{{Key: "level", Value: 1}, {Key: "metadata.parentResourceId", Value: -1}, {Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}
+	if !reflect.DeepEqual(sort, expected) {
+		t.Errorf("mongoSort() = %v, expected %v", sort, expected)
+	}
+
+	// Sorting by timestamp replaces the tiebreaker on it
+	sort = mongoSort([]models.SortKey{{Field: "timestamp"}})
+	expected = bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: -1}}
+	if !reflect.DeepEqual(sort, expected) {
+		t.Errorf("mongoSort() = %v, expected %v", sort, expected)
+	}
+}
+
+func TestMongoProjection(t *testing.T) {
+	if projection := mongoProjection(nil, nil); projection != nil {
+		t.Errorf("Expected no projection, got %v", projection)
+	}
+
+	projection := mongoProjection([]string{"message", "metadata.region"}, []models.SortKey{{Field: "parentResourceId"}})
+	expected := bson.M{"timestamp": 1, "message": 1, "metadata.region": 1, "metadata.parentResourceId": 1}
+	if !reflect.DeepEqual(projection, expected) {
+		t.Errorf("mongoProjection() = %v, expected %v", projection, expected)
+	}
+
+	// Single metadata keys are covered by all metadata
+	projection = mongoProjection([]string{"metadata.region", "metadata"}, []models.SortKey{{Field: "metadata.userId"}})
+	if expected := (bson.M{"timestamp": 1, "metadata": 1}); !reflect.DeepEqual(projection, expected) {
+		t.Errorf("mongoProjection() = %v, expected %v", projection, expected)
+	}
+}
+
+func TestBuildFilterSearch(t *testing.T) {
+	filter, err := buildFilter(&models.LogQuery{FullTextSearch: `disk "cache miss"`})
+	if err != nil {
+		t.Fatalf("Failed to build filter: %v", err)
+	}
+	if expected := (bson.M{"$text": bson.M{"$search": `disk "cache miss"`}}); !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Expected a $text filter, got %v", filter)
+	}
+
+	// $text has no prefix terms, so these match words by regex
+	filter, err = buildFilter(&models.LogQuery{FullTextSearch: "disk prof* -debug"})
+	if err != nil {
+		t.Fatalf("Failed to build filter: %v", err)
+	}
+	expected := bson.M{"$and": bson.A{
+		bson.M{},
+		bson.M{"$and": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"message": primitive.Regex{Pattern: `(^|[^\p{L}\p{N}])disk($|[^\p{L}\p{N}])`, Options: "i"}},
+				bson.M{"message": primitive.Regex{Pattern: `(^|[^\p{L}\p{N}])prof`, Options: "i"}},
+			}},
+			bson.M{"message": bson.M{"$not": primitive.Regex{Pattern: `(^|[^\p{L}\p{N}])debug($|[^\p{L}\p{N}])`, Options: "i"}}},
+		}},
+	}}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("buildFilter() = %v, expected %v", filter, expected)
+	}
+}
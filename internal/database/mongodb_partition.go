@@ -0,0 +1,266 @@
+package database
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"log-ingestor/internal/models"
+)
+
+// logBatch is a group of logs written to the same collection
+type logBatch struct {
+	collection *mongo.Collection
+	entries    []*models.Log
+
+	// indexes are the positions of the entries in the logs written
+	indexes []int
+}
+
+// writeBatches groups logs by the collection they are written to: the
+// tenant's collection, or the partition covering each log's timestamp
+func (m *MongoDB) writeBatches(ctx context.Context, tenant *tenantCollection, entries []*models.Log) []logBatch {
+	if !m.partitioning.Enabled() {
+		indexes := make([]int, len(entries))
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return []logBatch{{collection: tenant.collection, entries: entries, indexes: indexes}}
+	}
+
+	var batches []logBatch
+	index := make(map[time.Time]int)
+	for n, entry := range entries {
+		start := m.partitioning.Start(entry.Timestamp)
+		i, ok := index[start]
+		if !ok {
+			i = len(batches)
+			index[start] = i
+			batches = append(batches, logBatch{collection: m.partition(ctx, tenant, start)})
+		}
+		batches[i].entries = append(batches[i].entries, entry)
+		batches[i].indexes = append(batches[i].indexes, n)
+	}
+	return batches
+}
+
+// partition returns the collection of a tenant's partition, creating its
+// indexes the first time it is written to
+func (m *MongoDB) partition(ctx context.Context, tenant *tenantCollection, start time.Time) *mongo.Collection {
+	collection := m.partitionCollection(tenant, start)
+
+	tenant.indexMutex.Lock()
+	defer tenant.indexMutex.Unlock()
+
+	if !tenant.indexed[collection.Name()] {
+		if _, err := collection.Indexes().CreateMany(ctx, m.indexModels); err != nil {
+			log.Printf("Error creating indexes for partition %s: %v", collection.Name(), err)
+		}
+		tenant.indexed[collection.Name()] = true
+	}
+	return collection
+}
+
+// partitionCollection returns the collection of a tenant's partition
+func (m *MongoDB) partitionCollection(tenant *tenantCollection, start time.Time) *mongo.Collection {
+	return tenant.collection.Database().Collection(tenant.collection.Name() + "_" + m.partitioning.Suffix(start))
+}
+
+// readCollections returns the collections that may hold a tenant's logs
+// matching query, newest first: the partitions overlapping the query's time
+// range and cursor, or the tenant's collection when logs are not partitioned
+func (m *MongoDB) readCollections(ctx context.Context, tenant *tenantCollection, query *models.LogQuery) ([]*mongo.Collection, error) {
+	if !m.partitioning.Enabled() {
+		return []*mongo.Collection{tenant.collection}, nil
+	}
+
+	// Logs after the cursor are older than it
+	to := query.EndTime
+	if query.Cursor != "" {
+		cursor, err := models.DecodeCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if to.IsZero() || cursor.Timestamp.Before(to) {
+			to = cursor.Timestamp
+		}
+	}
+
+	starts, err := m.listPartitions(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	var collections []*mongo.Collection
+	for _, start := range m.partitioning.Overlapping(starts, query.StartTime, to) {
+		collections = append(collections, m.partitionCollection(tenant, start))
+	}
+	return collections, nil
+}
+
+// listPartitions returns the start of each of a tenant's partitions
+func (m *MongoDB) listPartitions(ctx context.Context, tenant *tenantCollection) ([]time.Time, error) {
+	prefix := tenant.collection.Name() + "_"
+	pattern := "^" + regexp.QuoteMeta(prefix) + m.partitioning.suffixPattern() + "$"
+
+	names, err := tenant.collection.Database().ListCollectionNames(ctx, bson.D{{Key: "name", Value: bson.D{{Key: "$regex", Value: pattern}}}})
+	if err != nil {
+		return nil, err
+	}
+
+	var starts []time.Time
+	for _, name := range names {
+		if start, ok := m.partitioning.ParseSuffix(strings.TrimPrefix(name, prefix)); ok {
+			starts = append(starts, start)
+		}
+	}
+	return starts, nil
+}
+
+// estimatePartitions returns the number of logs in a tenant's partitions,
+// estimated from collection metadata
+func (m *MongoDB) estimatePartitions(ctx context.Context, tenant *tenantCollection) (int64, error) {
+	starts, err := m.listPartitions(ctx, tenant)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, start := range starts {
+		count, err := m.partitionCollection(tenant, start).EstimatedDocumentCount(ctx)
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// partitionedStatsPipeline returns the stats pipeline run on the first of
+// several collections, with the logs matching filter in the others joining
+// it after its $match stage
+func partitionedStatsPipeline(others []string, filter bson.M, request *models.StatsRequest) mongo.Pipeline {
+	pipeline := buildStatsPipeline(filter, request)
+
+	stages := mongo.Pipeline{pipeline[0]}
+	for _, name := range others {
+		stages = append(stages, bson.D{{Key: "$unionWith", Value: bson.M{
+			"coll":     name,
+			"pipeline": bson.A{bson.M{"$match": filter}},
+		}}})
+	}
+	return append(stages, pipeline[1:]...)
+}
+
+// findLogs reads a page of logs matching filter from a collection in the
+// order of sort, such as timeOrder, with only the fields of projection
+// when it is not nil
+func findLogs(ctx context.Context, collection *mongo.Collection, filter bson.M, sort bson.D, projection bson.M, skip, limit int) ([]*models.Log, error) {
+	findOptions := options.Find().
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit)).
+		SetSort(sort)
+	if projection != nil {
+		findOptions.SetProjection(projection)
+	}
+	return find(ctx, collection, filter, findOptions)
+}
+
+// findRankedLogs reads the limit logs of a collection matching a $text
+// filter with the highest text scores, newest first among equal scores,
+// with each log's score projected
+func findRankedLogs(ctx context.Context, collection *mongo.Collection, filter bson.M, limit int) ([]*models.Log, error) {
+	score := bson.M{"$meta": "textScore"}
+	return find(ctx, collection, filter, options.Find().
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}))
+}
+
+// find runs a find on a collection and decodes the logs
+func find(ctx context.Context, collection *mongo.Collection, filter bson.M, findOptions *options.FindOptions) ([]*models.Log, error) {
+	// Execute query, stopping on the server when the budget runs out
+	if budget, ok := timeBudget(ctx); ok {
+		findOptions.SetMaxTime(budget)
+	}
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	// Decode results
+	var documents []*mongoLog
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+
+	logs := make([]*models.Log, len(documents))
+	for i, document := range documents {
+		document.Log.ID = document.ObjectID.Hex()
+		logs[i] = &document.Log
+	}
+
+	return logs, nil
+}
+
+// countOptions returns the options for counting documents within the
+// context's time budget
+func countOptions(ctx context.Context) *options.CountOptions {
+	countOptions := options.Count()
+	if budget, ok := timeBudget(ctx); ok {
+		countOptions.SetMaxTime(budget)
+	}
+	return countOptions
+}
+
+// DropPartitionsBefore drops the context tenant's partitions whose period
+// ends at or before cutoff. It drops nothing when logs are not partitioned.
+func (m *MongoDB) DropPartitionsBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	if !m.partitioning.Enabled() {
+		return 0, nil
+	}
+
+	tenant, err := m.tenant(ctx, TenantFromContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+
+	starts, err := m.listPartitions(ctx, tenant)
+	if err != nil {
+		return 0, err
+	}
+
+	dropped := 0
+	for _, start := range starts {
+		if !m.partitioning.Expired(start, cutoff) {
+			continue
+		}
+
+		collection := m.partitionCollection(tenant, start)
+		if err := collection.Drop(ctx); err != nil {
+			return dropped, err
+		}
+		dropped++
+
+		tenant.indexMutex.Lock()
+		delete(tenant.indexed, collection.Name())
+		tenant.indexMutex.Unlock()
+	}
+	return dropped, nil
+}
+
+// PartitionRetention returns the context tenant's retention when logs are
+// partitioned, as it is then enforced by dropping partitions
+func (m *MongoDB) PartitionRetention(ctx context.Context) time.Duration {
+	if !m.partitioning.Enabled() {
+		return 0
+	}
+	return m.tenantConfig.Limits(TenantFromContext(ctx)).Retention
+}
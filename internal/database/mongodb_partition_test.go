@@ -0,0 +1,43 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"log-ingestor/internal/models"
+)
+
+func TestPartitionedStatsPipeline(t *testing.T) {
+	filter := bson.M{"level": "error"}
+	request := &models.StatsRequest{Interval: time.Hour, Top: 5}
+
+	pipeline := partitionedStatsPipeline([]string{"logs_2026_10_16", "logs_2026_10_15"}, filter, request)
+	if len(pipeline) != 4 {
+		t.Fatalf("Expected 4 stages, got %d", len(pipeline))
+	}
+	if pipeline[0][0].Key != "$match" || pipeline[3][0].Key != "$facet" {
+		t.Fatalf("Expected the unions between $match and $facet, got %v", pipeline)
+	}
+
+	for i, name := range []string{"logs_2026_10_16", "logs_2026_10_15"} {
+		stage := pipeline[i+1]
+		if stage[0].Key != "$unionWith" {
+			t.Fatalf("Expected a $unionWith stage, got %s", stage[0].Key)
+		}
+		union := stage[0].Value.(bson.M)
+		if union["coll"] != name {
+			t.Errorf("Expected union with %s, got %v", name, union["coll"])
+		}
+		match := union["pipeline"].(bson.A)[0].(bson.M)["$match"].(bson.M)
+		if match["level"] != "error" {
+			t.Errorf("Expected the union to match the filter, got %v", match)
+		}
+	}
+
+	// A single collection gets the plain stats pipeline
+	if pipeline := partitionedStatsPipeline(nil, filter, request); len(pipeline) != 2 {
+		t.Errorf("Expected 2 stages without unions, got %d", len(pipeline))
+	}
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"log-ingestor/internal/models"
+)
+
+// statsFacets is the document produced by the stats pipeline
+type statsFacets struct {
+	Total []struct {
+		Count int64 `bson:"count"`
+	} `bson:"total"`
+	Buckets []struct {
+		Start time.Time `bson:"_id"`
+		Count int64     `bson:"count"`
+	} `bson:"buckets"`
+	Groups []struct {
+		Value string `bson:"_id"`
+		Count int64  `bson:"count"`
+	} `bson:"groups"`
+}
+
+// buildStatsPipeline builds an aggregation computing the total, the
+// histogram and the top group values of the logs matching filter in one
+// pass. Buckets are aligned to the Unix epoch, as models.BucketStart does.
+func buildStatsPipeline(filter bson.M, request *models.StatsRequest) mongo.Pipeline {
+	millis := bson.M{"$toLong": "$timestamp"}
+	bucketStart := bson.M{"$toDate": bson.M{"$subtract": bson.A{
+		millis,
+		bson.M{"$mod": bson.A{millis, request.Interval.Milliseconds()}},
+	}}}
+
+	facets := bson.M{
+		"total": bson.A{
+			bson.M{"$count": "count"},
+		},
+		"buckets": bson.A{
+			bson.M{"$group": bson.M{"_id": bucketStart, "count": bson.M{"$sum": 1}}},
+			bson.M{"$sort": bson.M{"_id": -1}},
+			bson.M{"$limit": models.MaxStatsBuckets},
+		},
+	}
+
+	// Logs without a value for the grouped field are not grouped
+	if request.GroupBy != "" {
+		path := fieldPath(request.GroupBy)
+		facets["groups"] = bson.A{
+			bson.M{"$match": bson.M{path: bson.M{"$nin": bson.A{nil, ""}}}},
+			bson.M{"$group": bson.M{"_id": "$" + path, "count": bson.M{"$sum": 1}}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": request.Top},
+		}
+	}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$facet", Value: facets}},
+	}
+}
+
+// facetsToStats converts the stats pipeline's output into a StatsResult
+func facetsToStats(query *models.LogQuery, request *models.StatsRequest, facets []statsFacets) *models.StatsResult {
+	var total int64
+	buckets := make(map[int64]int64)
+	groups := make(map[string]int64)
+
+	for _, facet := range facets {
+		for _, t := range facet.Total {
+			total += t.Count
+		}
+		for _, bucket := range facet.Buckets {
+			buckets[bucket.Start.UnixMilli()] += bucket.Count
+		}
+		for _, group := range facet.Groups {
+			groups[group.Value] += group.Count
+		}
+	}
+
+	return buildStatsResult(query, request, total, buckets, groups)
+}
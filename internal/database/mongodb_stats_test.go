@@ -0,0 +1,66 @@
+package database
+
+import (
+	"log-ingestor/internal/models"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestBuildStatsPipeline(t *testing.T) {
+	request := &models.StatsRequest{Interval: time.Minute, GroupBy: "parentResourceId", Top: 5}
+	pipeline := buildStatsPipeline(bson.M{"level": "error"}, request)
+
+	if len(pipeline) != 2 || pipeline[0][0].Key != "$match" || pipeline[1][0].Key != "$facet" {
+		t.Fatalf("Expected $match followed by $facet, got %v", pipeline)
+	}
+
+	facets := pipeline[1][0].Value.(bson.M)
+	groups, ok := facets["groups"].(bson.A)
+	if !ok {
+		t.Fatalf("Expected a groups facet, got %v", facets)
+	}
+
+	expectedGroup := bson.M{"$group": bson.M{"_id": "$metadata.parentResourceId", "count": bson.M{"$sum": 1}}}
+	if !reflect.DeepEqual(groups[1], expectedGroup) {
+		t.Errorf("Unexpected group stage %v", groups[1])
+	}
+	if !reflect.DeepEqual(groups[3], bson.M{"$limit": 5}) {
+		t.Errorf("Unexpected limit stage %v", groups[3])
+	}
+
+	// Without groupBy only the total and histogram are computed
+	pipeline = buildStatsPipeline(bson.M{}, &models.StatsRequest{Interval: time.Minute, Top: 5})
+	if _, ok := pipeline[1][0].Value.(bson.M)["groups"]; ok {
+		t.Error("Expected no groups facet without groupBy")
+	}
+}
+
+func TestFacetsToStats(t *testing.T) {
+	base, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+
+	var facets statsFacets
+	raw, _ := bson.Marshal(bson.M{
+		"total": bson.A{bson.M{"count": int64(3)}},
+		"buckets": bson.A{
+			bson.M{"_id": base.Add(2 * time.Minute), "count": int64(1)},
+			bson.M{"_id": base, "count": int64(2)},
+		},
+		"groups": bson.A{bson.M{"_id": "server-1", "count": int64(3)}},
+	})
+	if err := bson.Unmarshal(raw, &facets); err != nil {
+		t.Fatalf("Failed to decode facets: %v", err)
+	}
+
+	request := &models.StatsRequest{Interval: time.Minute, GroupBy: "resourceId", Top: 10}
+	result := facetsToStats(&models.LogQuery{}, request, []statsFacets{facets})
+
+	if result.Total != 3 || len(result.Buckets) != 3 || result.Buckets[0].Count != 2 || result.Buckets[1].Count != 0 {
+		t.Errorf("Unexpected stats %+v", result)
+	}
+	if len(result.Groups) != 1 || result.Groups[0].Value != "server-1" {
+		t.Errorf("Unexpected groups %+v", result.Groups)
+	}
+}
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// retentionIndexName is the name of the TTL index enforcing retention
+const retentionIndexName = "timestamp_ttl"
+
+// tenantCollection is a tenant's logs collection along with its limits and
+// the document count its quota is checked against. When logs are
+// partitioned, collection names the partitions instead of holding logs.
+type tenantCollection struct {
+	*quotaCounter
+
+	name       string
+	collection *mongo.Collection
+	limits     TenantLimits
+
+	// indexed records the partitions whose indexes have been created
+	indexMutex sync.Mutex
+	indexed    map[string]bool
+}
+
+// tenant returns the collection of a tenant, creating its indexes the first
+// time the tenant is used
+func (m *MongoDB) tenant(ctx context.Context, name string) (*tenantCollection, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if tenant, ok := m.tenants[name]; ok {
+		return tenant, nil
+	}
+
+	if name != DefaultTenant && !ValidTenant(name) {
+		return nil, fmt.Errorf("invalid tenant ID %q", name)
+	}
+
+	dbName, collectionName := tenantNamespace(m.isolation, m.collection.Database().Name(), m.collection.Name(), name)
+	tenant := &tenantCollection{
+		name:       name,
+		collection: m.client.Database(dbName).Collection(collectionName),
+		limits:     m.tenantConfig.Limits(name),
+		indexed:    make(map[string]bool),
+	}
+
+	// Counts are estimated from collection metadata
+	tenant.quotaCounter = newQuotaCounter(name, tenant.limits, func(ctx context.Context) (int64, error) {
+		if m.partitioning.Enabled() {
+			return m.estimatePartitions(ctx, tenant)
+		}
+		return tenant.collection.EstimatedDocumentCount(ctx)
+	})
+
+	// Partitions get their indexes when first written to, and retention
+	// drops whole partitions instead of relying on a TTL index
+	if m.partitioning.Enabled() {
+		m.tenants[name] = tenant
+		return tenant, nil
+	}
+
+	// Index failures are logged rather than failing every request for the tenant
+	if _, err := tenant.collection.Indexes().CreateMany(ctx, m.indexModels); err != nil {
+		log.Printf("Error creating indexes for %s.%s: %v", dbName, collectionName, err)
+	}
+	if err := ensureRetention(ctx, tenant.collection, tenant.limits.Retention); err != nil {
+		log.Printf("Error applying retention to %s.%s: %v", dbName, collectionName, err)
+	}
+
+	m.tenants[name] = tenant
+	return tenant, nil
+}
+
+// tenantNamespace returns the database and collection holding a tenant's
+// logs. The default tenant uses the configured names.
+func tenantNamespace(isolation, dbName, collectionName, tenant string) (string, string) {
+	switch {
+	case tenant == DefaultTenant:
+		return dbName, collectionName
+	case isolation == IsolateByDatabase:
+		return dbName + "_" + tenant, collectionName
+	default:
+		return dbName, collectionName + "_" + tenant
+	}
+}
+
+// Tenants returns the default tenant and every tenant with a collection, or
+// a database when isolating by database, named after the configured ones
+func (m *MongoDB) Tenants(ctx context.Context) ([]string, error) {
+	dbName, collectionName := m.collection.Database().Name(), m.collection.Name()
+
+	var names []string
+	var prefix string
+	var err error
+	if m.isolation == IsolateByDatabase {
+		prefix = dbName + "_"
+		names, err = m.client.ListDatabaseNames(ctx, bson.D{{Key: "name", Value: bson.D{{Key: "$regex", Value: "^" + regexp.QuoteMeta(prefix)}}}})
+	} else {
+		prefix = collectionName + "_"
+		names, err = m.collection.Database().ListCollectionNames(ctx, bson.D{{Key: "name", Value: bson.D{{Key: "$regex", Value: "^" + regexp.QuoteMeta(prefix)}}}})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Database names never carry a partition suffix
+	partitioning := m.partitioning
+	if m.isolation == IsolateByDatabase {
+		partitioning = PartitionNone
+	}
+	return tenantsFromNames(prefix, names, partitioning), nil
+}
+
+// tenantsFromNames extracts tenant IDs from collection or database names
+// starting with prefix, ignoring names that are not valid tenant IDs. With
+// partitioning, names must end in a partition suffix, which is removed.
+func tenantsFromNames(prefix string, names []string, partitioning Partitioning) []string {
+	var suffix *regexp.Regexp
+	if partitioning.Enabled() {
+		suffix = regexp.MustCompile(`_` + partitioning.suffixPattern() + `$`)
+	}
+
+	tenants := []string{DefaultTenant}
+	seen := map[string]bool{DefaultTenant: true}
+	for _, name := range names {
+		if suffix != nil {
+			if !suffix.MatchString(name) {
+				continue
+			}
+			name = suffix.ReplaceAllString(name, "")
+		}
+
+		tenant := strings.TrimPrefix(name, prefix)
+		if tenant == name || seen[tenant] || !ValidTenant(tenant) {
+			continue
+		}
+		seen[tenant] = true
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants[1:])
+	return tenants
+}
+
+// retentionIndexModel returns a TTL index expiring logs retention after
+// their timestamp
+func retentionIndexModel(retention time.Duration) mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys:    bson.D{{Key: "timestamp", Value: 1}},
+		Options: options.Index().SetName(retentionIndexName).SetExpireAfterSeconds(int32(retention / time.Second)),
+	}
+}
+
+// ensureRetention creates the TTL index for a retention period, updating
+// its expiry when the index already exists with another
+func ensureRetention(ctx context.Context, collection *mongo.Collection, retention time.Duration) error {
+	if retention <= 0 {
+		return nil
+	}
+
+	model := retentionIndexModel(retention)
+	_, err := collection.Indexes().CreateOne(ctx, model)
+
+	// IndexOptionsConflict and IndexKeySpecsConflict mean the expiry changed
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == 85 || cmdErr.Code == 86) {
+		return collection.Database().RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collection.Name()},
+			{Key: "index", Value: bson.D{
+				{Key: "name", Value: retentionIndexName},
+				{Key: "expireAfterSeconds", Value: int32(retention / time.Second)},
+			}},
+		}).Err()
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTenantNamespace(t *testing.T) {
+	tests := []struct {
+		isolation  string
+		tenant     string
+		database   string
+		collection string
+	}{
+		{IsolateByCollection, DefaultTenant, "logs_db", "logs"},
+		{IsolateByCollection, "acme", "logs_db", "logs_acme"},
+		{IsolateByDatabase, DefaultTenant, "logs_db", "logs"},
+		{IsolateByDatabase, "acme", "logs_db_acme", "logs"},
+	}
+
+	for _, test := range tests {
+		database, collection := tenantNamespace(test.isolation, "logs_db", "logs", test.tenant)
+		if database != test.database || collection != test.collection {
+			t.Errorf("Expected %s.%s for tenant %q isolated by %s, got %s.%s",
+				test.database, test.collection, test.tenant, test.isolation, database, collection)
+		}
+	}
+}
+
+func TestRetentionIndexModel(t *testing.T) {
+	model := retentionIndexModel(7 * 24 * time.Hour)
+
+	keys, ok := model.Keys.(bson.D)
+	if !ok || len(keys) != 1 || keys[0].Key != "timestamp" {
+		t.Fatalf("Expected a single-field timestamp index, got %v", model.Keys)
+	}
+	if model.Options.Name == nil || *model.Options.Name != retentionIndexName {
+		t.Errorf("Expected index name %s, got %v", retentionIndexName, model.Options.Name)
+	}
+	if model.Options.ExpireAfterSeconds == nil || *model.Options.ExpireAfterSeconds != 604800 {
+		t.Errorf("Expected expiry of 604800 seconds, got %v", model.Options.ExpireAfterSeconds)
+	}
+}
+
+func TestTenantsFromNames(t *testing.T) {
+	names := []string{"logs_globex", "logs_acme", "logs_Not Valid", "logs_", "audit_acme"}
+
+	tenants := tenantsFromNames("logs_", names, PartitionNone)
+	expected := []string{DefaultTenant, "acme", "globex"}
+	if len(tenants) != len(expected) {
+		t.Fatalf("Expected tenants %q, got %q", expected, tenants)
+	}
+	for i := range expected {
+		if tenants[i] != expected[i] {
+			t.Errorf("Expected tenants %q, got %q", expected, tenants)
+			break
+		}
+	}
+}
+
+func TestTenantsFromPartitionNames(t *testing.T) {
+	names := []string{"logs_2026_10_17", "logs_acme_2026_10_16", "logs_acme_2026_10_17", "logs_globex", "logs_globex_2026_10_17_08"}
+
+	tenants := tenantsFromNames("logs_", names, PartitionDaily)
+	expected := []string{DefaultTenant, "acme"}
+	if len(tenants) != len(expected) {
+		t.Fatalf("Expected tenants %q, got %q", expected, tenants)
+	}
+	for i := range expected {
+		if tenants[i] != expected[i] {
+			t.Errorf("Expected tenants %q, got %q", expected, tenants)
+			break
+		}
+	}
+}
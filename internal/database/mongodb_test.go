@@ -0,0 +1,41 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestInsertFailures(t *testing.T) {
+	failed := make(map[int]error)
+
+	// Errors that name no documents fail the whole batch
+	unavailable := errors.New("connection refused")
+	if _, err := insertFailures(unavailable, []int{0, 1}, failed); err != unavailable {
+		t.Errorf("Expected the error to be returned, got %v", err)
+	}
+	if n, err := insertFailures(nil, []int{0, 1}, failed); n != 0 || err != nil {
+		t.Errorf("Expected no failures, got %d and %v", n, err)
+	}
+
+	// Positions in the partition's batch map back to the logs written,
+	// and duplicate keys were stored by an earlier attempt
+	bulkErr := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+		{WriteError: mongo.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"}},
+		{WriteError: mongo.WriteError{Index: 2, Code: 10334, Message: "document too large"}},
+	}}
+	n, err := insertFailures(bulkErr, []int{1, 3, 5}, failed)
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 documents not inserted, got %d and %v", n, err)
+	}
+	if len(failed) != 1 || failed[5] == nil || failed[5].Error() != "document too large" {
+		t.Errorf("Expected log 5 to have failed, got %v", failed)
+	}
+
+	// Write concern errors leave it unknown which documents were stored
+	bulkErr.WriteConcernError = &mongo.WriteConcernError{Code: 64, Message: "waiting for replication timed out"}
+	if _, err := insertFailures(bulkErr, []int{1, 3, 5}, failed); err == nil {
+		t.Error("Expected a write concern error to be returned")
+	}
+}
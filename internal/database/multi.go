@@ -0,0 +1,567 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"log-ingestor/internal/models"
+)
+
+// WriteMode is how MultiDB treats failed writes to its secondary backends
+type WriteMode string
+
+const (
+	// WriteAll fails a write unless every backend stores it. It is meant
+	// for buffered ingestion: the primary may have stored a failed write,
+	// so it should be retried with the logs' IDs unchanged.
+	WriteAll WriteMode = "all"
+
+	// WritePrimary fails a write only when the primary cannot store it;
+	// logs a secondary fails to store are queued and retried
+	WritePrimary WriteMode = "primary"
+)
+
+// ParseWriteMode parses a write mode: all, or primary (or empty)
+func ParseWriteMode(value string) (WriteMode, error) {
+	switch mode := WriteMode(value); mode {
+	case "":
+		return WritePrimary, nil
+	case WriteAll, WritePrimary:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown write mode %q: expected all or primary", value)
+	}
+}
+
+// Backend is a named storage backend
+type Backend struct {
+	Name string
+	DB   DB
+}
+
+// MultiConfig configures a MultiDB
+type MultiConfig struct {
+	// Mode is how failed writes to secondaries are treated
+	Mode WriteMode
+
+	// RetryQueueSize bounds the logs queued for each secondary in
+	// WritePrimary mode; logs beyond it are dropped
+	RetryQueueSize int
+
+	// RetryInterval is how long a secondary waits between retries
+	RetryInterval time.Duration
+
+	// RetryLimit is the number of times a queued batch is retried before
+	// it is dropped, so a batch a secondary never accepts does not block
+	// the batches behind it
+	RetryLimit int
+
+	// RetryTimeout bounds a single retry
+	RetryTimeout time.Duration
+
+	// CompareRate is the fraction of queries, between 0 and 1, also run
+	// against every secondary in the background to detect mismatches
+	CompareRate float64
+}
+
+// DefaultMultiConfig returns the default MultiDB configuration
+func DefaultMultiConfig() MultiConfig {
+	return MultiConfig{
+		Mode:           WritePrimary,
+		RetryQueueSize: 100000,
+		RetryInterval:  5 * time.Second,
+		RetryLimit:     720,
+		RetryTimeout:   30 * time.Second,
+	}
+}
+
+// MultiDB fans writes out to several backends and reads from the first,
+// the primary, for migrating between stores. The primary is always written
+// first: when it fails nothing else is written. Log IDs are assigned before
+// the fan-out, so every backend stores a log under the same ID.
+type MultiDB struct {
+	primary     Backend
+	secondaries []*secondary
+	config      MultiConfig
+
+	// stop ends the retry loops; wg waits for them and for comparisons
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Ensure MultiDB implements the DB, QuotaChecker, TenantLister, Partitioner,
+// LogStreamer and MetadataIndexer interfaces
+var (
+	_ DB              = (*MultiDB)(nil)
+	_ QuotaChecker    = (*MultiDB)(nil)
+	_ TenantLister    = (*MultiDB)(nil)
+	_ Partitioner     = (*MultiDB)(nil)
+	_ LogStreamer     = (*MultiDB)(nil)
+	_ MetadataIndexer = (*MultiDB)(nil)
+)
+
+// pendingBatch is a batch of logs waiting to be retried on a secondary
+type pendingBatch struct {
+	tenant   string
+	logs     []*models.Log
+	attempts int
+}
+
+// secondary is a secondary backend, its retry queue and its counters
+type secondary struct {
+	Backend
+
+	mutex  sync.Mutex
+	queue  []pendingBatch
+	queued int
+	wake   chan struct{}
+
+	writes           atomic.Int64
+	failedWrites     atomic.Int64
+	retried          atomic.Int64
+	dropped          atomic.Int64
+	failedDeletes    atomic.Int64
+	deleteMismatches atomic.Int64
+	readComparisons  atomic.Int64
+	readMismatches   atomic.Int64
+}
+
+// MultiStats is a snapshot of a MultiDB's counters
+type MultiStats struct {
+	Mode        WriteMode        `json:"mode"`
+	Primary     string           `json:"primary"`
+	Secondaries []SecondaryStats `json:"secondaries"`
+}
+
+// SecondaryStats is a snapshot of a secondary backend's counters
+type SecondaryStats struct {
+	Name             string `json:"name"`
+	Writes           int64  `json:"writes"`
+	FailedWrites     int64  `json:"failedWrites"`
+	RetryQueueDepth  int    `json:"retryQueueDepth"`
+	Retried          int64  `json:"retried"`
+	Dropped          int64  `json:"dropped"`
+	FailedDeletes    int64  `json:"failedDeletes"`
+	DeleteMismatches int64  `json:"deleteMismatches"`
+	ReadComparisons  int64  `json:"readComparisons"`
+	ReadMismatches   int64  `json:"readMismatches"`
+}
+
+// NewMultiDB wraps a primary and secondary backends and, in WritePrimary
+// mode, starts retrying writes the secondaries failed
+func NewMultiDB(primary Backend, secondaries []Backend, config MultiConfig) *MultiDB {
+	m := &MultiDB{
+		primary: primary,
+		config:  config,
+		stop:    make(chan struct{}),
+	}
+
+	for _, backend := range secondaries {
+		s := &secondary{Backend: backend, wake: make(chan struct{}, 1)}
+		m.secondaries = append(m.secondaries, s)
+		if config.Mode == WritePrimary {
+			m.wg.Add(1)
+			go m.retry(s)
+		}
+	}
+	return m
+}
+
+// Primary returns the backend reads are served from
+func (m *MultiDB) Primary() Backend {
+	return m.primary
+}
+
+// Mode returns how failed writes to the secondaries are treated
+func (m *MultiDB) Mode() WriteMode {
+	return m.config.Mode
+}
+
+// Backends returns the primary followed by the secondaries
+func (m *MultiDB) Backends() []Backend {
+	backends := []Backend{m.primary}
+	for _, s := range m.secondaries {
+		backends = append(backends, s.Backend)
+	}
+	return backends
+}
+
+// Close stops retrying, waits for comparisons in progress and closes every
+// backend. Logs still queued for a secondary are lost.
+func (m *MultiDB) Close() error {
+	close(m.stop)
+	m.wg.Wait()
+
+	for _, s := range m.secondaries {
+		s.mutex.Lock()
+		if s.queued > 0 {
+			log.Printf("Closing storage backend %s with %d logs not written", s.Name, s.queued)
+		}
+		s.mutex.Unlock()
+	}
+
+	var errs []error
+	for _, backend := range m.Backends() {
+		if err := backend.DB.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", backend.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Stats returns a snapshot of the write, retry and mismatch counters
+func (m *MultiDB) Stats() MultiStats {
+	stats := MultiStats{Mode: m.config.Mode, Primary: m.primary.Name, Secondaries: []SecondaryStats{}}
+	for _, s := range m.secondaries {
+		s.mutex.Lock()
+		depth := s.queued
+		s.mutex.Unlock()
+
+		stats.Secondaries = append(stats.Secondaries, SecondaryStats{
+			Name:             s.Name,
+			Writes:           s.writes.Load(),
+			FailedWrites:     s.failedWrites.Load(),
+			RetryQueueDepth:  depth,
+			Retried:          s.retried.Load(),
+			Dropped:          s.dropped.Load(),
+			FailedDeletes:    s.failedDeletes.Load(),
+			DeleteMismatches: s.deleteMismatches.Load(),
+			ReadComparisons:  s.readComparisons.Load(),
+			ReadMismatches:   s.readMismatches.Load(),
+		})
+	}
+	return stats
+}
+
+// InsertLog inserts a log into every backend
+func (m *MultiDB) InsertLog(ctx context.Context, logEntry *models.Log) error {
+	return m.InsertLogs(ctx, []*models.Log{logEntry})
+}
+
+// InsertLogs inserts a batch of logs into the primary and then into each
+// secondary. In WriteAll mode any failure fails the batch; retrying it is
+// safe, as backends skip or replace the logs they already hold. Logs the
+// primary refuses in an *InsertError are not written to the secondaries.
+func (m *MultiDB) InsertLogs(ctx context.Context, logEntries []*models.Log) error {
+	if len(logEntries) == 0 {
+		return nil
+	}
+
+	for _, logEntry := range logEntries {
+		if _, err := primitive.ObjectIDFromHex(logEntry.ID); err != nil {
+			logEntry.ID = NewLogID()
+		}
+	}
+
+	var insertErr *InsertError
+	err := m.primary.DB.InsertLogs(ctx, logEntries)
+	switch {
+	case err == nil:
+	case errors.As(err, &insertErr):
+		logEntries = insertErr.Stored(logEntries)
+	default:
+		return err
+	}
+
+	var errs []error
+	for _, s := range m.secondaries {
+		if err := m.insertSecondary(ctx, s, logEntries); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+		}
+	}
+
+	// A failed secondary fails the whole batch, which is then retried
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if insertErr != nil {
+		return insertErr
+	}
+	return nil
+}
+
+// insertSecondary writes logs to a secondary. In WritePrimary mode a
+// failure queues the logs for retry, and while logs are queued new ones
+// join the queue without waiting on a backend known to be failing.
+func (m *MultiDB) insertSecondary(ctx context.Context, s *secondary, logEntries []*models.Log) error {
+	if m.config.Mode == WritePrimary {
+		s.mutex.Lock()
+		backlogged := s.queued > 0
+		s.mutex.Unlock()
+		if backlogged {
+			m.enqueue(s, TenantFromContext(ctx), logEntries)
+			return nil
+		}
+	}
+
+	err := s.DB.InsertLogs(ctx, logEntries)
+	if err == nil {
+		s.writes.Add(1)
+		return nil
+	}
+
+	s.failedWrites.Add(1)
+	if m.config.Mode == WriteAll {
+		return err
+	}
+
+	// Only the logs the secondary refused need retrying
+	var insertErr *InsertError
+	if errors.As(err, &insertErr) {
+		logEntries = insertErr.Refused(logEntries)
+	}
+
+	log.Printf("Error writing %d logs to storage backend %s, queueing for retry: %v", len(logEntries), s.Name, err)
+	m.enqueue(s, TenantFromContext(ctx), logEntries)
+	return nil
+}
+
+// enqueue queues copies of logs for retry on a secondary, dropping them
+// when the queue is full
+func (m *MultiDB) enqueue(s *secondary, tenant string, logEntries []*models.Log) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.queued+len(logEntries) > m.config.RetryQueueSize {
+		s.dropped.Add(int64(len(logEntries)))
+		return
+	}
+
+	// Copies keep later changes to the caller's logs out of the retry
+	copies := make([]*models.Log, len(logEntries))
+	for i, logEntry := range logEntries {
+		entry := *logEntry
+		copies[i] = &entry
+	}
+	s.queue = append(s.queue, pendingBatch{tenant: tenant, logs: copies})
+	s.queued += len(copies)
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// retry writes a secondary's queued batches in order, waiting
+// RetryInterval after each failure, until Close is called. A batch failing
+// RetryLimit retries is dropped, and of a batch the secondary stored in
+// part only the logs it refused are retried.
+func (m *MultiDB) retry(s *secondary) {
+	defer m.wg.Done()
+
+	for {
+		s.mutex.Lock()
+		var batch pendingBatch
+		backlogged := len(s.queue) > 0
+		if backlogged {
+			batch = s.queue[0]
+		}
+		s.mutex.Unlock()
+
+		if !backlogged {
+			select {
+			case <-s.wake:
+				continue
+			case <-m.stop:
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(WithTenant(context.Background(), batch.tenant), m.config.RetryTimeout)
+		err := s.DB.InsertLogs(ctx, batch.logs)
+		cancel()
+
+		if err == nil {
+			s.mutex.Lock()
+			s.queue = s.queue[1:]
+			s.queued -= len(batch.logs)
+			s.mutex.Unlock()
+			s.writes.Add(1)
+			s.retried.Add(1)
+			continue
+		}
+
+		s.mutex.Lock()
+		head := &s.queue[0]
+		head.attempts++
+		var insertErr *InsertError
+		if errors.As(err, &insertErr) {
+			head.logs = insertErr.Refused(batch.logs)
+			s.queued -= len(batch.logs) - len(head.logs)
+		}
+		remaining, attempts := len(head.logs), head.attempts
+		abandoned := attempts > m.config.RetryLimit
+		if abandoned {
+			s.queue = s.queue[1:]
+			s.queued -= remaining
+			s.dropped.Add(int64(remaining))
+		}
+		s.mutex.Unlock()
+
+		if abandoned {
+			log.Printf("Dropping %d logs after %d retries on storage backend %s: %v", remaining, attempts, s.Name, err)
+			continue
+		}
+		log.Printf("Error retrying %d logs on storage backend %s: %v", len(batch.logs), s.Name, err)
+		select {
+		case <-time.After(m.config.RetryInterval):
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// QueryLogs queries logs from the primary. A sample of queries is also run
+// against the secondaries in the background to count mismatched results.
+func (m *MultiDB) QueryLogs(ctx context.Context, query *models.LogQuery) ([]*models.Log, error) {
+	logs, err := m.primary.DB.QueryLogs(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each backend scores relevance its own way, so only time-ordered
+	// reads are compared
+	if len(m.secondaries) > 0 && m.config.CompareRate > 0 && !query.ByRelevance() && rand.Float64() < m.config.CompareRate {
+		m.compare(TenantFromContext(ctx), *query, logIDs(logs))
+	}
+	return logs, nil
+}
+
+// compare runs a query against each secondary in the background and
+// counts those returning different logs than the primary did
+func (m *MultiDB) compare(tenant string, query models.LogQuery, expected string) {
+	for _, s := range m.secondaries {
+		m.wg.Add(1)
+		go func(s *secondary, query models.LogQuery) {
+			defer m.wg.Done()
+
+			ctx, cancel := context.WithTimeout(WithTenant(context.Background(), tenant), m.config.RetryTimeout)
+			defer cancel()
+
+			s.readComparisons.Add(1)
+			logs, err := s.DB.QueryLogs(ctx, &query)
+			if err != nil {
+				s.readMismatches.Add(1)
+				log.Printf("Error comparing query on storage backend %s: %v", s.Name, err)
+				return
+			}
+			if logIDs(logs) != expected {
+				s.readMismatches.Add(1)
+			}
+		}(s, query)
+	}
+}
+
+// CountLogs counts the matching logs in the primary
+func (m *MultiDB) CountLogs(ctx context.Context, query *models.LogQuery, limit int64) (int64, error) {
+	return m.primary.DB.CountLogs(ctx, query, limit)
+}
+
+// StreamLogs streams the matching logs from the primary
+func (m *MultiDB) StreamLogs(ctx context.Context, query *models.LogQuery, fn func(*models.Log) error) error {
+	return StreamLogs(ctx, m.primary.DB, query, fn)
+}
+
+// IndexedMetadataKeys returns the metadata keys the primary indexes, as
+// queries are served from it
+func (m *MultiDB) IndexedMetadataKeys() []string {
+	return IndexedMetadataKeys(m.primary.DB)
+}
+
+// AggregateLogs computes stats over the matching logs in the primary
+func (m *MultiDB) AggregateLogs(ctx context.Context, query *models.LogQuery, request *models.StatsRequest) (*models.StatsResult, error) {
+	return m.primary.DB.AggregateLogs(ctx, query, request)
+}
+
+// DeleteLogs deletes the matching logs from every backend and returns how
+// many the primary deleted. Secondaries deleting a different number are
+// counted as mismatches. In WritePrimary mode failed deletes on secondaries
+// are only counted, as retention deletes again on its next run.
+func (m *MultiDB) DeleteLogs(ctx context.Context, query *models.LogQuery) (int64, error) {
+	deleted, err := m.primary.DB.DeleteLogs(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	var errs []error
+	for _, s := range m.secondaries {
+		count, err := s.DB.DeleteLogs(ctx, query)
+		if err != nil {
+			s.failedDeletes.Add(1)
+			if m.config.Mode == WriteAll {
+				errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+			} else {
+				log.Printf("Error deleting logs from storage backend %s: %v", s.Name, err)
+			}
+			continue
+		}
+		if count != deleted {
+			s.deleteMismatches.Add(1)
+		}
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// CheckQuota checks the context tenant's quota in the primary
+func (m *MultiDB) CheckQuota(ctx context.Context, n int) error {
+	if checker, ok := m.primary.DB.(QuotaChecker); ok {
+		return checker.CheckQuota(ctx, n)
+	}
+	return nil
+}
+
+// Tenants returns the tenants with logs in the primary, or only the
+// default tenant when the primary cannot list them
+func (m *MultiDB) Tenants(ctx context.Context) ([]string, error) {
+	if lister, ok := m.primary.DB.(TenantLister); ok {
+		return lister.Tenants(ctx)
+	}
+	return []string{DefaultTenant}, nil
+}
+
+// DropPartitionsBefore drops expired partitions in every backend that
+// partitions logs and returns how many the primary dropped
+func (m *MultiDB) DropPartitionsBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	dropped := 0
+	var errs []error
+	for i, backend := range m.Backends() {
+		partitioner, ok := backend.DB.(Partitioner)
+		if !ok {
+			continue
+		}
+		count, err := partitioner.DropPartitionsBefore(ctx, cutoff)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", backend.Name, err))
+		}
+		if i == 0 {
+			dropped = count
+		}
+	}
+	return dropped, errors.Join(errs...)
+}
+
+// PartitionRetention returns the primary's partition retention
+func (m *MultiDB) PartitionRetention(ctx context.Context) time.Duration {
+	if partitioner, ok := m.primary.DB.(Partitioner); ok {
+		return partitioner.PartitionRetention(ctx)
+	}
+	return 0
+}
+
+// logIDs joins the IDs of logs, to compare result sets
+func logIDs(logs []*models.Log) string {
+	result := make([]string, len(logs))
+	for i, log := range logs {
+		result[i] = log.ID
+	}
+	return strings.Join(result, ",")
+}
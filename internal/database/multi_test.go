@@ -0,0 +1,246 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"log-ingestor/internal/models"
+)
+
+// flakyDB is a MockDB whose writes fail while failing is set
+type flakyDB struct {
+	*MockDB
+	failing atomic.Bool
+}
+
+func (f *flakyDB) InsertLogs(ctx context.Context, logEntries []*models.Log) error {
+	if f.failing.Load() {
+		return errors.New("backend unavailable")
+	}
+	return f.MockDB.InsertLogs(ctx, logEntries)
+}
+
+func (f *flakyDB) DeleteLogs(ctx context.Context, query *models.LogQuery) (int64, error) {
+	if f.failing.Load() {
+		return 0, errors.New("backend unavailable")
+	}
+	return f.MockDB.DeleteLogs(ctx, query)
+}
+
+// newTestMultiDB wraps a MockDB primary and a flaky secondary
+func newTestMultiDB(t *testing.T, config MultiConfig) (*MultiDB, *MockDB, *flakyDB) {
+	t.Helper()
+	primary := NewMockDB()
+	secondaryDB := &flakyDB{MockDB: NewMockDB()}
+	db := NewMultiDB(Backend{Name: "mongo", DB: primary}, []Backend{{Name: "postgres", DB: secondaryDB}}, config)
+	t.Cleanup(func() { db.Close() })
+	return db, primary, secondaryDB
+}
+
+// eventually waits up to a second for condition to hold
+func eventually(t *testing.T, condition func() bool) bool {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestParseWriteMode(t *testing.T) {
+	for value, expected := range map[string]WriteMode{"": WritePrimary, "primary": WritePrimary, "all": WriteAll} {
+		if mode, err := ParseWriteMode(value); err != nil || mode != expected {
+			t.Errorf("ParseWriteMode(%q) = %q, %v; expected %q", value, mode, err, expected)
+		}
+	}
+	if _, err := ParseWriteMode("quorum"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
+
+func TestMultiDBWritesAllBackends(t *testing.T) {
+	config := DefaultMultiConfig()
+	config.Mode = WriteAll
+	db, primary, secondaryDB := newTestMultiDB(t, config)
+	ctx := WithTenant(context.Background(), "acme")
+
+	if err := db.InsertLogs(ctx, []*models.Log{{Level: "info", Message: "one"}, {Level: "error", Message: "two"}}); err != nil {
+		t.Fatalf("Failed to insert logs: %v", err)
+	}
+
+	expected, _ := primary.QueryLogs(ctx, &models.LogQuery{})
+	logs, _ := secondaryDB.QueryLogs(ctx, &models.LogQuery{})
+	if len(expected) != 2 || logIDs(logs) != logIDs(expected) {
+		t.Errorf("Expected both backends to hold the same logs, got %s and %s", logIDs(expected), logIDs(logs))
+	}
+
+	// Any failure fails the write in WriteAll mode
+	secondaryDB.failing.Store(true)
+	if err := db.InsertLog(ctx, &models.Log{Level: "info", Message: "three"}); err == nil {
+		t.Error("Expected an error when a secondary fails")
+	}
+	if stats := db.Stats(); stats.Secondaries[0].Writes != 1 || stats.Secondaries[0].FailedWrites != 1 || stats.Secondaries[0].RetryQueueDepth != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestMultiDBPrimaryFailure(t *testing.T) {
+	db, primary, secondaryDB := newTestMultiDB(t, DefaultMultiConfig())
+	primary.SimulateError = true
+
+	if err := db.InsertLog(context.Background(), &models.Log{Level: "info", Message: "lost"}); err == nil {
+		t.Error("Expected an error when the primary fails")
+	}
+	if count, _ := secondaryDB.CountLogs(context.Background(), &models.LogQuery{}, 0); count != 0 {
+		t.Errorf("Expected nothing written to the secondary, got %d logs", count)
+	}
+}
+
+// partialDB is a MockDB that refuses the logs with a message of "bad"
+type partialDB struct {
+	*MockDB
+}
+
+func (p *partialDB) InsertLogs(ctx context.Context, logEntries []*models.Log) error {
+	insertErr := &InsertError{Failed: make(map[int]error)}
+	for i, logEntry := range logEntries {
+		if logEntry.Message == "bad" {
+			insertErr.Failed[i] = errors.New("document refused")
+		}
+	}
+	if err := p.MockDB.InsertLogs(ctx, insertErr.Stored(logEntries)); err != nil {
+		return err
+	}
+	if len(insertErr.Failed) > 0 {
+		return insertErr
+	}
+	return nil
+}
+
+func TestMultiDBPrimaryPartialFailure(t *testing.T) {
+	primary := &partialDB{MockDB: NewMockDB()}
+	secondaryDB := NewMockDB()
+	db := NewMultiDB(Backend{Name: "mongo", DB: primary}, []Backend{{Name: "postgres", DB: secondaryDB}}, DefaultMultiConfig())
+	defer db.Close()
+
+	// Only the logs the primary stored reach the secondaries
+	err := db.InsertLogs(context.Background(), []*models.Log{{Level: "info", Message: "good"}, {Level: "info", Message: "bad"}})
+	var insertErr *InsertError
+	if !errors.As(err, &insertErr) || len(insertErr.Failed) != 1 || insertErr.Failed[1] == nil {
+		t.Fatalf("Expected the second log to be reported as failed, got %v", err)
+	}
+	logs, _ := secondaryDB.QueryLogs(context.Background(), &models.LogQuery{})
+	if len(logs) != 1 || logs[0].Message != "good" {
+		t.Errorf("Expected only the stored log on the secondary, got %+v", logs)
+	}
+}
+
+func TestMultiDBRetriesSecondaries(t *testing.T) {
+	config := DefaultMultiConfig()
+	config.RetryInterval = 10 * time.Millisecond
+	db, _, secondaryDB := newTestMultiDB(t, config)
+	ctx := WithTenant(context.Background(), "acme")
+
+	secondaryDB.failing.Store(true)
+	for i := 0; i < 3; i++ {
+		if err := db.InsertLog(ctx, &models.Log{Level: "info", Message: "queued"}); err != nil {
+			t.Fatalf("Expected writes to succeed while the primary does, got %v", err)
+		}
+	}
+	if stats := db.Stats().Secondaries[0]; stats.FailedWrites != 1 || stats.RetryQueueDepth != 3 {
+		t.Errorf("Expected one failed write and 3 queued logs, got %+v", stats)
+	}
+
+	secondaryDB.failing.Store(false)
+	if !eventually(t, func() bool { return db.Stats().Secondaries[0].RetryQueueDepth == 0 }) {
+		t.Fatalf("Expected the queue to drain, got %+v", db.Stats())
+	}
+
+	// Retried logs keep their tenant
+	if count, _ := secondaryDB.CountLogs(ctx, &models.LogQuery{}, 0); count != 3 {
+		t.Errorf("Expected 3 retried logs for acme, got %d", count)
+	}
+	if stats := db.Stats().Secondaries[0]; stats.Retried != 3 {
+		t.Errorf("Expected 3 retried batches, got %+v", stats)
+	}
+}
+
+func TestMultiDBDropsBatchesPastRetryLimit(t *testing.T) {
+	config := DefaultMultiConfig()
+	config.RetryInterval = 10 * time.Millisecond
+	config.RetryLimit = 2
+	secondaryDB := &partialDB{MockDB: NewMockDB()}
+	db := NewMultiDB(Backend{Name: "mongo", DB: NewMockDB()}, []Backend{{Name: "postgres", DB: secondaryDB}}, config)
+	defer db.Close()
+
+	// Only the refused log is queued, and it is dropped once the retries
+	// run out instead of holding up later writes
+	if err := db.InsertLogs(context.Background(), []*models.Log{{Level: "info", Message: "good"}, {Level: "info", Message: "bad"}}); err != nil {
+		t.Fatalf("Expected the write to succeed on the primary, got %v", err)
+	}
+	if !eventually(t, func() bool { return db.Stats().Secondaries[0].Dropped == 1 }) {
+		t.Fatalf("Expected the refused log to be dropped, got %+v", db.Stats())
+	}
+	if stats := db.Stats().Secondaries[0]; stats.RetryQueueDepth != 0 || stats.Retried != 0 {
+		t.Errorf("Expected an empty queue and no successful retries, got %+v", stats)
+	}
+
+	db.InsertLog(context.Background(), &models.Log{Level: "info", Message: "later"})
+	if count, _ := secondaryDB.CountLogs(context.Background(), &models.LogQuery{}, 0); count != 2 {
+		t.Errorf("Expected the good and later logs on the secondary, got %d", count)
+	}
+}
+
+func TestMultiDBDropsWhenQueueIsFull(t *testing.T) {
+	config := DefaultMultiConfig()
+	config.RetryQueueSize = 2
+	config.RetryInterval = time.Hour
+	db, _, secondaryDB := newTestMultiDB(t, config)
+
+	secondaryDB.failing.Store(true)
+	db.InsertLogs(context.Background(), []*models.Log{{Message: "one"}, {Message: "two"}})
+	db.InsertLog(context.Background(), &models.Log{Message: "three"})
+
+	if stats := db.Stats().Secondaries[0]; stats.RetryQueueDepth != 2 || stats.Dropped != 1 {
+		t.Errorf("Expected 2 queued and 1 dropped log, got %+v", stats)
+	}
+}
+
+func TestMultiDBReadsPrimaryAndComparesSecondaries(t *testing.T) {
+	config := DefaultMultiConfig()
+	config.CompareRate = 1
+	db, primary, secondaryDB := newTestMultiDB(t, config)
+	ctx := context.Background()
+
+	primary.InsertLogs(ctx, []*models.Log{{Level: "info", Message: "primary only", Timestamp: time.Now()}})
+	db.InsertLog(ctx, &models.Log{Level: "info", Message: "both", Timestamp: time.Now()})
+
+	logs, err := db.QueryLogs(ctx, &models.LogQuery{})
+	if err != nil || len(logs) != 2 {
+		t.Fatalf("Expected 2 logs from the primary, got %d (%v)", len(logs), err)
+	}
+	db.QueryLogs(ctx, &models.LogQuery{Message: "both"})
+
+	if !eventually(t, func() bool { return db.Stats().Secondaries[0].ReadComparisons == 2 }) {
+		t.Fatalf("Expected 2 comparisons, got %+v", db.Stats())
+	}
+	if stats := db.Stats().Secondaries[0]; stats.ReadMismatches != 1 {
+		t.Errorf("Expected 1 mismatch, got %+v", stats)
+	}
+
+	// Deletes reach every backend, counting differing results
+	deleted, err := db.DeleteLogs(ctx, &models.LogQuery{Level: "info"})
+	if err != nil || deleted != 2 {
+		t.Errorf("Expected 2 logs deleted from the primary, got %d (%v)", deleted, err)
+	}
+	if count, _ := secondaryDB.CountLogs(ctx, &models.LogQuery{}, 0); count != 0 {
+		t.Errorf("Expected the secondary's logs deleted, got %d", count)
+	}
+	if stats := db.Stats().Secondaries[0]; stats.DeleteMismatches != 1 {
+		t.Errorf("Expected 1 delete mismatch, got %+v", stats)
+	}
+}
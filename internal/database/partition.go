@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"log-ingestor/internal/models"
+)
+
+// Partitioning is how logs are split into time partitions, each stored
+// separately and holding the logs whose timestamps fall in its period
+type Partitioning string
+
+const (
+	// PartitionNone stores all of a tenant's logs together
+	PartitionNone Partitioning = ""
+
+	// PartitionDaily stores each UTC day's logs in a partition such as logs_2026_10_17
+	PartitionDaily Partitioning = "day"
+
+	// PartitionHourly stores each UTC hour's logs in a partition such as logs_2026_10_17_08
+	PartitionHourly Partitioning = "hour"
+)
+
+// Partitioner is implemented by backends that can partition logs by time,
+// so retention can drop whole partitions instead of deleting logs one by one
+type Partitioner interface {
+	// DropPartitionsBefore drops the context tenant's partitions whose
+	// period ends at or before cutoff and returns how many were dropped
+	DropPartitionsBefore(ctx context.Context, cutoff time.Time) (int, error)
+
+	// PartitionRetention returns how long the context tenant's logs are
+	// kept when that is enforced by dropping partitions, or zero
+	PartitionRetention(ctx context.Context) time.Duration
+}
+
+// ParsePartitioning parses a partitioning mode: none (or empty), day or hour
+func ParsePartitioning(value string) (Partitioning, error) {
+	switch value {
+	case "", "none":
+		return PartitionNone, nil
+	case string(PartitionDaily), string(PartitionHourly):
+		return Partitioning(value), nil
+	default:
+		return "", fmt.Errorf("invalid partitioning %q: expected none, day or hour", value)
+	}
+}
+
+// Enabled reports whether logs are partitioned
+func (p Partitioning) Enabled() bool {
+	return p != PartitionNone
+}
+
+// period returns the length of a partition
+func (p Partitioning) period() time.Duration {
+	if p == PartitionHourly {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// layout returns the time layout of partition name suffixes
+func (p Partitioning) layout() string {
+	if p == PartitionHourly {
+		return "2006_01_02_15"
+	}
+	return "2006_01_02"
+}
+
+// suffixPattern returns a regular expression matching partition name suffixes
+func (p Partitioning) suffixPattern() string {
+	if p == PartitionHourly {
+		return `\d{4}_\d{2}_\d{2}_\d{2}`
+	}
+	return `\d{4}_\d{2}_\d{2}`
+}
+
+// Start returns the start of the partition holding logs timestamped t.
+// Partitions are aligned to UTC.
+func (p Partitioning) Start(t time.Time) time.Time {
+	return t.UTC().Truncate(p.period())
+}
+
+// Suffix returns the name suffix of the partition starting at start
+func (p Partitioning) Suffix(start time.Time) string {
+	return start.UTC().Format(p.layout())
+}
+
+// ParseSuffix returns the start of the partition with a name suffix
+func (p Partitioning) ParseSuffix(suffix string) (time.Time, bool) {
+	start, err := time.Parse(p.layout(), suffix)
+	if err != nil || p.Suffix(start) != suffix {
+		return time.Time{}, false
+	}
+	return start, true
+}
+
+// Overlapping returns the partitions, given by start, that may hold logs
+// timestamped between from and to inclusive, newest first. Zero bounds are
+// open.
+func (p Partitioning) Overlapping(starts []time.Time, from, to time.Time) []time.Time {
+	var overlapping []time.Time
+	for _, start := range starts {
+		if !to.IsZero() && start.After(to) {
+			continue
+		}
+		if !from.IsZero() && !start.Add(p.period()).After(from) {
+			continue
+		}
+		overlapping = append(overlapping, start)
+	}
+
+	sort.Slice(overlapping, func(i, j int) bool { return overlapping[i].After(overlapping[j]) })
+	return overlapping
+}
+
+// Expired reports whether every log the partition starting at start can
+// hold is timestamped before cutoff
+func (p Partitioning) Expired(start, cutoff time.Time) bool {
+	return !start.Add(p.period()).After(cutoff)
+}
+
+// pagePartitions reads a page of logs from n partitions ordered newest
+// first. Partitions hold disjoint time ranges, so concatenating each one's
+// sorted results keeps the overall order. Whole partitions are skipped by
+// counting their matches, and the page is read from where it starts.
+func pagePartitions(n, skip, limit int, count func(i int) (int64, error), find func(i, skip, limit int) ([]*models.Log, error)) ([]*models.Log, error) {
+	// A single partition skips directly without counting
+	if n == 1 {
+		return find(0, skip, limit)
+	}
+
+	logs := []*models.Log{}
+	for i := 0; i < n && len(logs) < limit; i++ {
+		if skip > 0 {
+			matches, err := count(i)
+			if err != nil {
+				return nil, err
+			}
+			if matches <= int64(skip) {
+				skip -= int(matches)
+				continue
+			}
+		}
+
+		page, err := find(i, skip, limit-len(logs))
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, page...)
+		skip = 0
+	}
+	return logs, nil
+}
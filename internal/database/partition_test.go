@@ -0,0 +1,210 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"log-ingestor/internal/models"
+)
+
+func TestParsePartitioning(t *testing.T) {
+	tests := map[string]Partitioning{
+		"":     PartitionNone,
+		"none": PartitionNone,
+		"day":  PartitionDaily,
+		"hour": PartitionHourly,
+	}
+	for value, expected := range tests {
+		partitioning, err := ParsePartitioning(value)
+		if err != nil {
+			t.Errorf("Expected %q to parse, got %v", value, err)
+		}
+		if partitioning != expected {
+			t.Errorf("Expected %q to parse as %q, got %q", value, expected, partitioning)
+		}
+	}
+
+	if _, err := ParsePartitioning("week"); err == nil {
+		t.Error("Expected an error for an unknown partitioning")
+	}
+}
+
+func TestPartitionSuffix(t *testing.T) {
+	timestamp := time.Date(2026, 10, 17, 8, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	tests := []struct {
+		partitioning Partitioning
+		start        time.Time
+		suffix       string
+	}{
+		{PartitionDaily, time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC), "2026_10_17"},
+		{PartitionHourly, time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC), "2026_10_17_06"},
+	}
+
+	for _, tt := range tests {
+		start := tt.partitioning.Start(timestamp)
+		if !start.Equal(tt.start) {
+			t.Errorf("%s: expected start %v, got %v", tt.partitioning, tt.start, start)
+		}
+		if suffix := tt.partitioning.Suffix(start); suffix != tt.suffix {
+			t.Errorf("%s: expected suffix %s, got %s", tt.partitioning, tt.suffix, suffix)
+		}
+
+		parsed, ok := tt.partitioning.ParseSuffix(tt.suffix)
+		if !ok || !parsed.Equal(tt.start) {
+			t.Errorf("%s: expected %s to parse as %v, got %v", tt.partitioning, tt.suffix, tt.start, parsed)
+		}
+	}
+
+	for _, suffix := range []string{"2026_10_17_06", "2026_1_17", "acme", "2026_13_01"} {
+		if _, ok := PartitionDaily.ParseSuffix(suffix); ok {
+			t.Errorf("Expected %q not to parse as a daily suffix", suffix)
+		}
+	}
+}
+
+func TestOverlappingPartitions(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 0, 0, 0, 0, time.UTC) }
+	starts := []time.Time{day(15), day(17), day(16), day(14)}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		expected []time.Time
+	}{
+		{"open", time.Time{}, time.Time{}, []time.Time{day(17), day(16), day(15), day(14)}},
+		{"from", day(16).Add(time.Hour), time.Time{}, []time.Time{day(17), day(16)}},
+		{"to", time.Time{}, day(15).Add(time.Hour), []time.Time{day(15), day(14)}},
+		{"boundaries", day(15), day(16), []time.Time{day(16), day(15)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			overlapping := PartitionDaily.Overlapping(starts, tt.from, tt.to)
+			if len(overlapping) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, overlapping)
+			}
+			for i := range tt.expected {
+				if !overlapping[i].Equal(tt.expected[i]) {
+					t.Fatalf("Expected %v, got %v", tt.expected, overlapping)
+				}
+			}
+		})
+	}
+}
+
+func TestPartitionExpired(t *testing.T) {
+	start := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+
+	if !PartitionDaily.Expired(start, start.Add(24*time.Hour)) {
+		t.Error("Expected a partition to expire once its period has passed the cutoff")
+	}
+	if PartitionDaily.Expired(start, start.Add(23*time.Hour)) {
+		t.Error("Expected a partition holding logs after the cutoff not to expire")
+	}
+}
+
+func TestPagePartitions(t *testing.T) {
+	// Three partitions holding 3, 2 and 4 logs, newest first
+	partitions := [][]*models.Log{}
+	id := 0
+	for _, size := range []int{3, 2, 4} {
+		var logs []*models.Log
+		for i := 0; i < size; i++ {
+			id++
+			logs = append(logs, &models.Log{ID: string(rune('a' + id - 1))})
+		}
+		partitions = append(partitions, logs)
+	}
+
+	counted := 0
+	count := func(i int) (int64, error) {
+		counted++
+		return int64(len(partitions[i])), nil
+	}
+	find := func(i, skip, limit int) ([]*models.Log, error) {
+		logs := partitions[i]
+		if skip >= len(logs) {
+			return nil, nil
+		}
+		logs = logs[skip:]
+		if limit < len(logs) {
+			logs = logs[:limit]
+		}
+		return logs, nil
+	}
+
+	tests := []struct {
+		skip, limit int
+		expected    string
+	}{
+		{0, 4, "abcd"},
+		{2, 3, "cde"},
+		{4, 4, "efgh"},
+		{6, 10, "ghi"},
+		{9, 5, ""},
+	}
+
+	for _, tt := range tests {
+		logs, err := pagePartitions(len(partitions), tt.skip, tt.limit, count, find)
+		if err != nil {
+			t.Fatalf("Failed to page partitions: %v", err)
+		}
+		if logs == nil {
+			t.Fatal("Expected an empty page rather than nil")
+		}
+
+		ids := ""
+		for _, log := range logs {
+			ids += log.ID
+		}
+		if ids != tt.expected {
+			t.Errorf("skip %d, limit %d: expected %q, got %q", tt.skip, tt.limit, tt.expected, ids)
+		}
+	}
+
+	// A single partition is read without counting
+	counted = 0
+	if _, err := pagePartitions(1, 2, 1, count, find); err != nil {
+		t.Fatalf("Failed to page partitions: %v", err)
+	}
+	if counted != 0 {
+		t.Errorf("Expected a single partition not to be counted, counted %d times", counted)
+	}
+}
+
+func TestMockDBDropPartitions(t *testing.T) {
+	db := NewMockDB()
+	db.Partitioning = PartitionDaily
+	db.TenantConfig = &TenantConfig{Default: TenantLimits{Retention: 48 * time.Hour}}
+
+	ctx := context.Background()
+	for _, timestamp := range []time.Time{
+		time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 15, 1, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 15, 23, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC),
+	} {
+		if err := db.InsertLog(ctx, &models.Log{Level: "info", Message: "test", Timestamp: timestamp}); err != nil {
+			t.Fatalf("Failed to insert log: %v", err)
+		}
+	}
+
+	if retention := db.PartitionRetention(ctx); retention != 48*time.Hour {
+		t.Errorf("Expected partition retention of 48h, got %v", retention)
+	}
+
+	dropped, err := db.DropPartitionsBefore(ctx, time.Date(2026, 10, 16, 6, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Failed to drop partitions: %v", err)
+	}
+	if dropped != 2 {
+		t.Errorf("Expected 2 partitions dropped, got %d", dropped)
+	}
+
+	count, _ := db.CountLogs(ctx, &models.LogQuery{}, 0)
+	if count != 1 {
+		t.Errorf("Expected 1 log left, got %d", count)
+	}
+}
@@ -0,0 +1,296 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+)
+
+// textSearchConfig is the Postgres text search configuration of the
+// message_tsv column; queries must use the same one to match it
+const textSearchConfig = "english"
+
+// pgFilter accumulates the conditions of a WHERE clause and their arguments
+type pgFilter struct {
+	conditions []string
+	args       []interface{}
+
+	// rank scores rows by relevance to the full-text search, if any
+	rank string
+}
+
+// arg adds an argument and returns its placeholder
+func (f *pgFilter) arg(value interface{}) string {
+	f.args = append(f.args, value)
+	return fmt.Sprintf("$%d", len(f.args))
+}
+
+// add appends a condition
+func (f *pgFilter) add(condition string) {
+	f.conditions = append(f.conditions, condition)
+}
+
+// where renders the conditions ANDed together
+func (f *pgFilter) where() string {
+	if len(f.conditions) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(f.conditions, " AND ")
+}
+
+// not negates a condition. A comparison with a missing metadata key is
+// NULL, which must count as not matching so its negation matches, as
+// MongoDB's $nor does.
+func not(condition string) string {
+	return "NOT COALESCE(" + condition + ", FALSE)"
+}
+
+// pgColumn maps a query field to its column, or the metadata lookup for
+// metadata.<key> fields
+func (f *pgFilter) column(field string) string {
+	switch field {
+	case "level", "message", "commit":
+		return field
+	case "resourceId":
+		return "resource_id"
+	case "traceId":
+		return "trace_id"
+	case "spanId":
+		return "span_id"
+	case "parentResourceId":
+		return "metadata->>'parentResourceId'"
+	}
+	if key, ok := querylang.MetadataKey(field); ok {
+		return "metadata->>" + f.arg(key)
+	}
+	return "NULL"
+}
+
+// order renders sort keys as an ORDER BY list, breaking ties newest first.
+// Text is ordered by the database's collation and missing metadata last
+// when ascending, so the indexes on the sort fields can be used.
+func (f *pgFilter) order(keys []models.SortKey) string {
+	var terms []string
+	for _, key := range keys {
+		term := "timestamp"
+		if key.Field != "timestamp" {
+			term = f.column(key.Field)
+		}
+		if key.Descending {
+			term += " DESC"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(append(terms, "timestamp DESC", "id DESC"), ", ")
+}
+
+// buildPgFilter translates a tenant's log query into a WHERE clause and its
+// arguments, with the same semantics as buildFilter for MongoDB
+func buildPgFilter(tenant string, query *models.LogQuery) (*pgFilter, error) {
+	f := &pgFilter{}
+	f.add("tenant = " + f.arg(tenant))
+
+	// Field filters, including repeated and negated values. As in MongoDB,
+	// message values are case-insensitive substrings.
+	for _, field := range models.FilterFields {
+		filter := query.FieldFilter(field)
+		if filter.IsEmpty() {
+			continue
+		}
+
+		column := f.column(field)
+		if field == "message" {
+			if len(filter.Include) > 0 {
+				f.add(column + " ~* ANY(" + f.arg(quoteMetas(filter.Include)) + ")")
+			}
+			if len(filter.Exclude) > 0 {
+				f.add(not(column + " ~* ANY(" + f.arg(quoteMetas(filter.Exclude)) + ")"))
+			}
+			continue
+		}
+
+		if len(filter.Include) > 0 {
+			f.add(column + " = ANY(" + f.arg(filter.Include) + ")")
+		}
+		if len(filter.Exclude) > 0 {
+			f.add(not(column + " = ANY(" + f.arg(filter.Exclude) + ")"))
+		}
+	}
+
+	// Metadata filters
+	for _, metadataFilter := range query.MetadataFilters {
+		f.add(f.metadataCondition(metadataFilter))
+	}
+
+	// Date range filter
+	if !query.StartTime.IsZero() {
+		f.add("timestamp >= " + f.arg(query.StartTime))
+	}
+	if !query.EndTime.IsZero() {
+		f.add("timestamp <= " + f.arg(query.EndTime))
+	}
+
+	// Regex pattern search, ignoring case unless asked not to
+	regex, err := query.Regex()
+	if err != nil {
+		return nil, err
+	}
+	if regex != nil {
+		operator := " ~* "
+		if regex.CaseSensitive {
+			operator = " ~ "
+		}
+		f.add("message" + operator + f.arg(regex.Expr()))
+	}
+
+	// Full-text search
+	if query.FullTextSearch != "" {
+		f.add(f.searchCondition(parseSearch(query.FullTextSearch)))
+	}
+
+	// Structured query language expression
+	if query.Query != "" {
+		expr, err := querylang.Parse(query.Query)
+		if err != nil {
+			return nil, err
+		}
+		f.add(f.exprCondition(expr))
+	}
+
+	// Restrict results to the caller's scope
+	if query.Scope != nil {
+		f.add(f.exprCondition(query.Scope))
+	}
+
+	// Continue after the position encoded in the cursor
+	if query.Cursor != "" {
+		cursor, err := models.DecodeCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		f.add("(timestamp, id) < (" + f.arg(cursor.Timestamp) + ", " + f.arg(cursor.ID) + ")")
+	}
+
+	return f, nil
+}
+
+// metadataCondition translates a metadata filter
+func (f *pgFilter) metadataCondition(metadataFilter models.MetadataFilter) string {
+	var condition string
+	switch metadataFilter.Op {
+	case models.MetadataPrefix:
+		prefixes := make([]string, len(metadataFilter.Values))
+		for i, value := range metadataFilter.Values {
+			prefixes[i] = likePrefix(value)
+		}
+		condition = "metadata->>" + f.arg(metadataFilter.Key) + " LIKE ANY(" + f.arg(prefixes) + ")"
+	case models.MetadataExists:
+		condition = "metadata ? " + f.arg(metadataFilter.Key)
+	case models.MetadataMissing:
+		condition = "NOT metadata ? " + f.arg(metadataFilter.Key)
+	default:
+		condition = "metadata->>" + f.arg(metadataFilter.Key) + " = ANY(" + f.arg(metadataFilter.Values) + ")"
+	}
+
+	// Negated filters match logs the condition does not
+	if metadataFilter.Negate {
+		return not(condition)
+	}
+	return condition
+}
+
+// likePrefix returns a LIKE pattern matching values starting with prefix
+func likePrefix(prefix string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	return escaped + "%"
+}
+
+// searchCondition translates a full-text search. As in MongoDB, a log
+// matches when it contains every phrase, or any term when there is no
+// phrase, and none of the negated terms. The phrases or terms also set the
+// filter's rank expression.
+func (f *pgFilter) searchCondition(search *searchQuery) string {
+	var positive []string
+	if len(search.phrases) > 0 {
+		for _, phrase := range search.phrases {
+			positive = append(positive, "phraseto_tsquery('"+textSearchConfig+"', "+f.arg(phrase)+")")
+		}
+	} else if len(search.terms) > 0 {
+		positive = append(positive, f.tsquery(search.terms, " | "))
+	}
+
+	var conditions []string
+	if len(positive) > 0 {
+		query := strings.Join(positive, " && ")
+		conditions = append(conditions, "message_tsv @@ ("+query+")")
+		f.rank = "ts_rank(message_tsv, " + query + ")::float8"
+	} else {
+		// A search of only negated terms matches nothing
+		conditions = append(conditions, "FALSE")
+	}
+
+	for _, term := range search.negated {
+		conditions = append(conditions, "NOT message_tsv @@ "+f.tsquery([]searchTerm{term}, ""))
+	}
+	return "(" + strings.Join(conditions, " AND ") + ")"
+}
+
+// tsquery joins terms into a to_tsquery call, marking prefix terms with
+// :*. Terms are letters and digits only, so they need no quoting.
+func (f *pgFilter) tsquery(terms []searchTerm, operator string) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = term.text
+		if term.prefix {
+			parts[i] += ":*"
+		}
+	}
+	return "to_tsquery('" + textSearchConfig + "', " + f.arg(strings.Join(parts, operator)) + ")"
+}
+
+// exprCondition translates a query language expression
+func (f *pgFilter) exprCondition(e querylang.Expr) string {
+	switch e := e.(type) {
+	case *querylang.AndExpr:
+		return f.operandsCondition(e.Operands, " AND ")
+	case *querylang.OrExpr:
+		return f.operandsCondition(e.Operands, " OR ")
+	case *querylang.NotExpr:
+		return not(f.exprCondition(e.Operand))
+	case *querylang.TermExpr:
+		return f.termCondition(e)
+	default:
+		return "TRUE"
+	}
+}
+
+// operandsCondition joins the conditions of a boolean expression's operands
+func (f *pgFilter) operandsCondition(operands []querylang.Expr, operator string) string {
+	conditions := make([]string, len(operands))
+	for i, operand := range operands {
+		conditions[i] = f.exprCondition(operand)
+	}
+	return "(" + strings.Join(conditions, operator) + ")"
+}
+
+// termCondition translates a single term, matching the message as a
+// case-insensitive substring and other fields exactly
+func (f *pgFilter) termCondition(term *querylang.TermExpr) string {
+	column := f.column(term.Field)
+
+	if term.Field == querylang.DefaultField {
+		pattern := regexp.QuoteMeta(term.Value)
+		if term.Wildcard {
+			pattern = querylang.WildcardPattern(term.Value, false)
+		}
+		return "(" + column + " ~* " + f.arg(pattern) + ")"
+	}
+
+	if term.Wildcard {
+		return "(" + column + " ~ " + f.arg(querylang.WildcardPattern(term.Value, true)) + ")"
+	}
+	return "(" + column + " = " + f.arg(term.Value) + ")"
+}
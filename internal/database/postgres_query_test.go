@@ -0,0 +1,213 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+)
+
+func TestBuildPgFilter(t *testing.T) {
+	base := time.Date(2023, 9, 15, 8, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		query    *models.LogQuery
+		where    string
+		args     []interface{}
+		hasError bool
+	}{
+		{
+			name:  "empty",
+			query: &models.LogQuery{},
+			where: "tenant = $1",
+			args:  []interface{}{"acme"},
+		},
+		{
+			name:  "fields",
+			query: &models.LogQuery{Level: "error", Message: "a.b("},
+			where: "tenant = $1 AND level = ANY($2) AND message ~* ANY($3)",
+			args:  []interface{}{"acme", []string{"error"}, []string{`a\.b\(`}},
+		},
+		{
+			name:  "excluded values",
+			query: &models.LogQuery{Filters: map[string]models.FieldFilter{"resourceId": {Exclude: []string{"server-1"}}}},
+			where: "tenant = $1 AND NOT COALESCE(resource_id = ANY($2), FALSE)",
+			args:  []interface{}{"acme", []string{"server-1"}},
+		},
+		{
+			name: "metadata",
+			query: &models.LogQuery{MetadataFilters: []models.MetadataFilter{
+				{Key: "region", Op: models.MetadataPrefix, Values: []string{"us_"}},
+				{Key: "zone", Op: models.MetadataMissing},
+			}},
+			where: "tenant = $1 AND metadata->>$2 LIKE ANY($3) AND NOT metadata ? $4",
+			args:  []interface{}{"acme", "region", []string{`us\_%`}, "zone"},
+		},
+		{
+			name:  "time range",
+			query: &models.LogQuery{StartTime: base, EndTime: base.Add(time.Hour)},
+			where: "tenant = $1 AND timestamp >= $2 AND timestamp <= $3",
+			args:  []interface{}{"acme", base, base.Add(time.Hour)},
+		},
+		{
+			name:  "regex",
+			query: &models.LogQuery{RegexPattern: `\d+ms`},
+			where: "tenant = $1 AND message ~* $2",
+			args:  []interface{}{"acme", `\d+ms`},
+		},
+		{
+			name:  "case-sensitive anchored regex",
+			query: &models.LogQuery{RegexPattern: `GET|POST`, RegexFlags: "ca"},
+			where: "tenant = $1 AND message ~ $2",
+			args:  []interface{}{"acme", `^(?:GET|POST)$`},
+		},
+		{
+			name:     "invalid regex",
+			query:    &models.LogQuery{RegexPattern: "[invalid"},
+			hasError: true,
+		},
+		{
+			name:  "search terms",
+			query: &models.LogQuery{FullTextSearch: "disk cache -profile"},
+			where: "tenant = $1 AND (message_tsv @@ (to_tsquery('english', $2)) AND NOT message_tsv @@ to_tsquery('english', $3))",
+			args:  []interface{}{"acme", "disk | cache", "profile"},
+		},
+		{
+			name:  "search prefixes",
+			query: &models.LogQuery{FullTextSearch: "disk prof* -debug*"},
+			where: "tenant = $1 AND (message_tsv @@ (to_tsquery('english', $2)) AND NOT message_tsv @@ to_tsquery('english', $3))",
+			args:  []interface{}{"acme", "disk | prof:*", "debug:*"},
+		},
+		{
+			name:  "search phrase",
+			query: &models.LogQuery{FullTextSearch: `"user profile"`},
+			where: "tenant = $1 AND (message_tsv @@ (phraseto_tsquery('english', $2)))",
+			args:  []interface{}{"acme", "user profile"},
+		},
+		{
+			name:  "query language",
+			query: &models.LogQuery{Query: `level:error AND (resourceId:server-1* OR NOT metadata.region:eu)`},
+			where: "tenant = $1 AND ((level = $2) AND ((resource_id ~ $3) OR NOT COALESCE((metadata->>$4 = $5), FALSE)))",
+			args:  []interface{}{"acme", "error", "^server-1.*$", "region", "eu"},
+		},
+		{
+			name:  "scope",
+			query: &models.LogQuery{Scope: &querylang.TermExpr{Field: "message", Value: "a.b"}},
+			where: "tenant = $1 AND (message ~* $2)",
+			args:  []interface{}{"acme", `a\.b`},
+		},
+		{
+			name:     "invalid query",
+			query:    &models.LogQuery{Query: `level:(`},
+			hasError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := buildPgFilter("acme", tc.query)
+			if tc.hasError {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to build filter: %v", err)
+			}
+			if filter.where() != tc.where {
+				t.Errorf("where() = %q, expected %q", filter.where(), tc.where)
+			}
+			if !reflect.DeepEqual(filter.args, tc.args) {
+				t.Errorf("args = %#v, expected %#v", filter.args, tc.args)
+			}
+		})
+	}
+}
+
+func TestBuildPgFilterRank(t *testing.T) {
+	filter, err := buildPgFilter("", &models.LogQuery{FullTextSearch: `"disk full" "cache"`})
+	if err != nil {
+		t.Fatalf("Failed to build filter: %v", err)
+	}
+	if expected := "ts_rank(message_tsv, phraseto_tsquery('english', $2) && phraseto_tsquery('english', $3))::float8"; filter.rank != expected {
+		t.Errorf("rank = %q, expected %q", filter.rank, expected)
+	}
+
+	if filter, _ := buildPgFilter("", &models.LogQuery{FullTextSearch: "-debug"}); filter.rank != "" {
+		t.Errorf("Expected no rank for a negated search, got %q", filter.rank)
+	}
+}
+
+func TestPgFilterOrder(t *testing.T) {
+	filter, err := buildPgFilter("", &models.LogQuery{Level: "error"})
+	if err != nil {
+		t.Fatalf("Failed to build filter: %v", err)
+	}
+
+	order := filter.order([]models.SortKey{{Field: "resourceId"}, {Field: "metadata.region", Descending: true}, {Field: "parentResourceId"}})
+	if expected := "resource_id, metadata->>$3 DESC, metadata->>'parentResourceId', timestamp DESC, id DESC"; order != expected {
+		t.Errorf("order() = %q, expected %q", order, expected)
+	}
+	if filter.args[2] != "region" {
+		t.Errorf("Expected the metadata key as an argument, got %v", filter.args)
+	}
+
+	if order := filter.order([]models.SortKey{{Field: "timestamp"}}); order != "timestamp, timestamp DESC, id DESC" {
+		t.Errorf("order() = %q", order)
+	}
+}
+
+func TestBuildPgFilterCursor(t *testing.T) {
+	log := &models.Log{ID: "650400000000000000000001", Timestamp: time.Date(2023, 9, 15, 8, 0, 0, 0, time.UTC)}
+	filter, err := buildPgFilter("", &models.LogQuery{Cursor: models.EncodeCursor(log)})
+	if err != nil {
+		t.Fatalf("Failed to build filter: %v", err)
+	}
+
+	if expected := "tenant = $1 AND (timestamp, id) < ($2, $3)"; filter.where() != expected {
+		t.Errorf("where() = %q, expected %q", filter.where(), expected)
+	}
+	if filter.args[1] != log.Timestamp || filter.args[2] != log.ID {
+		t.Errorf("Expected the cursor's timestamp and ID as arguments, got %v", filter.args[1:])
+	}
+
+	if _, err := buildPgFilter("", &models.LogQuery{Cursor: "not a cursor"}); err == nil {
+		t.Error("Expected an error for an invalid cursor")
+	}
+}
+
+func TestInsertStatement(t *testing.T) {
+	p := &PostgresDB{table: "logs"}
+	logs := []*models.Log{
+		{ID: "not an ObjectID", Level: "info", Message: "one", Timestamp: time.Date(2023, 9, 15, 8, 0, 0, 1234567, time.UTC)},
+		{ID: "650400000000000000000002", Level: "error", Message: "two", Metadata: map[string]string{"region": "eu"}},
+	}
+
+	statement, args, err := p.insertStatement("acme", logs)
+	if err != nil {
+		t.Fatalf("Failed to build statement: %v", err)
+	}
+
+	expected := "INSERT INTO logs (tenant, id, timestamp, level, message, resource_id, trace_id, span_id, commit, metadata) VALUES " +
+		"($1, $2, $3, $4, $5, $6, $7, $8, $9, $10::jsonb), ($11, $12, $13, $14, $15, $16, $17, $18, $19, $20::jsonb) ON CONFLICT DO NOTHING"
+	if statement != expected {
+		t.Errorf("Expected statement %q, got %q", expected, statement)
+	}
+	if len(args) != 20 {
+		t.Fatalf("Expected 20 arguments, got %d", len(args))
+	}
+
+	if logs[0].ID == "not an ObjectID" || logs[1].ID != "650400000000000000000002" {
+		t.Errorf("Expected only the invalid ID to be replaced, got %q and %q", logs[0].ID, logs[1].ID)
+	}
+	if logs[0].Timestamp.Nanosecond() != 1234000 {
+		t.Errorf("Expected the timestamp truncated to microseconds, got %v", logs[0].Timestamp)
+	}
+	if args[9] != nil || args[19] != `{"region":"eu"}` {
+		t.Errorf("Expected NULL and JSON metadata, got %v and %v", args[9], args[19])
+	}
+}
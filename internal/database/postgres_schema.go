@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// pgTablePattern restricts table names to identifiers that need no quoting
+	pgTablePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,39}$`)
+
+	// pgIndexNameUnsafe matches the characters of a metadata key replaced
+	// in the name of its index
+	pgIndexNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9]+`)
+)
+
+// pgMigrations create and evolve the schema. Each runs once, in order, and
+// is recorded in the <table>_migrations table; {table} is replaced with the
+// logs table name. Applied migrations must never be edited, only followed
+// by new ones.
+var pgMigrations = []string{
+	// 1: the logs table, partitioned by timestamp. Logs outside every time
+	// partition, including all logs when partitioning is off, go to the
+	// default partition.
+	`CREATE TABLE {table} (
+		tenant      text        NOT NULL DEFAULT '',
+		id          text        NOT NULL,
+		timestamp   timestamptz NOT NULL,
+		level       text        NOT NULL,
+		message     text        NOT NULL,
+		resource_id text        NOT NULL DEFAULT '',
+		trace_id    text        NOT NULL DEFAULT '',
+		span_id     text        NOT NULL DEFAULT '',
+		commit      text        NOT NULL DEFAULT '',
+		metadata    jsonb,
+		message_tsv tsvector GENERATED ALWAYS AS (to_tsvector('english', message)) STORED,
+		PRIMARY KEY (tenant, timestamp, id)
+	) PARTITION BY RANGE (timestamp);
+	CREATE TABLE {table}_default PARTITION OF {table} DEFAULT;
+	CREATE INDEX {table}_level_idx ON {table} (tenant, level, timestamp DESC);
+	CREATE INDEX {table}_resource_id_idx ON {table} (tenant, resource_id, timestamp DESC);
+	CREATE INDEX {table}_trace_id_idx ON {table} (tenant, trace_id);
+	CREATE INDEX {table}_span_id_idx ON {table} (tenant, span_id);
+	CREATE INDEX {table}_commit_idx ON {table} (tenant, commit);
+	CREATE INDEX {table}_parent_resource_id_idx ON {table} (tenant, (metadata->>'parentResourceId'));
+	CREATE INDEX {table}_message_tsv_idx ON {table} USING gin (message_tsv);
+	CREATE INDEX {table}_metadata_idx ON {table} USING gin (metadata);`,
+
+	// 2: the tenants that have stored logs, so they can be listed without
+	// scanning the logs
+	`CREATE TABLE {table}_tenants (tenant text PRIMARY KEY);
+	INSERT INTO {table}_tenants SELECT DISTINCT tenant FROM {table} WHERE tenant <> '';`,
+}
+
+// migrate applies the migrations the database has not seen. An advisory
+// lock keeps concurrent instances from migrating at the same time.
+func (p *PostgresDB) migrate(ctx context.Context) error {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	migrations := p.table + "_migrations"
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", migrations); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+migrations+" (version integer PRIMARY KEY, applied_at timestamptz NOT NULL DEFAULT now())"); err != nil {
+		return err
+	}
+
+	var applied int
+	if err := tx.QueryRow(ctx, "SELECT COALESCE(MAX(version), 0) FROM "+migrations).Scan(&applied); err != nil {
+		return err
+	}
+
+	for version := applied + 1; version <= len(pgMigrations); version++ {
+		statements := strings.ReplaceAll(pgMigrations[version-1], "{table}", p.table)
+		if _, err := tx.Exec(ctx, statements); err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO "+migrations+" (version) VALUES ($1)", version); err != nil {
+			return err
+		}
+		log.Printf("Applied Postgres migration %d to %s", version, p.table)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ensureMetadataIndexes creates an expression index for each metadata key
+// in a comma-separated list, like the MongoDB backend's metadata indexes,
+// and returns the keys that are indexed
+func (p *PostgresDB) ensureMetadataIndexes(ctx context.Context, keys string) []string {
+	var indexed []string
+	for _, key := range parseMetadataIndexKeys(keys) {
+		name := p.table + "_meta_" + strings.ToLower(pgIndexNameUnsafe.ReplaceAllString(key, "_")) + "_idx"
+		statement := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (tenant, (metadata->>%s))",
+			pgx.Identifier{name}.Sanitize(), p.table, quoteLiteral(key))
+		if _, err := p.pool.Exec(ctx, statement); err != nil {
+			log.Printf("Error creating index on metadata key %q: %v", key, err)
+			continue
+		}
+		indexed = append(indexed, key)
+	}
+	return indexed
+}
+
+// quoteLiteral quotes a string for use as an SQL literal
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// partitionName returns the name of the time partition starting at start
+func (p *PostgresDB) partitionName(start time.Time) string {
+	return p.table + "_" + p.partitioning.Suffix(start)
+}
+
+// ensurePartitions creates the time partitions covering the given
+// timestamps the first time each is written to. A partition that cannot be
+// created, for example because the default partition already holds logs in
+// its range, is logged and its logs go to the default partition.
+func (p *PostgresDB) ensurePartitions(ctx context.Context, timestamps []time.Time) {
+	if !p.partitioning.Enabled() {
+		return
+	}
+
+	p.partitionMutex.Lock()
+	defer p.partitionMutex.Unlock()
+
+	for _, timestamp := range timestamps {
+		start := p.partitioning.Start(timestamp)
+		name := p.partitionName(start)
+		if p.partitions[name] {
+			continue
+		}
+		p.partitions[name] = true
+
+		statement := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM (%s) TO (%s)",
+			name, p.table,
+			quoteLiteral(start.Format(time.RFC3339)),
+			quoteLiteral(start.Add(p.partitioning.period()).Format(time.RFC3339)))
+		if _, err := p.pool.Exec(ctx, statement); err != nil {
+			log.Printf("Error creating partition %s: %v", name, err)
+		}
+	}
+}
+
+// listPartitions returns the start of each time partition
+func (p *PostgresDB) listPartitions(ctx context.Context) ([]time.Time, error) {
+	rows, err := p.pool.Query(ctx, "SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid WHERE i.inhparent = $1::regclass", p.table)
+	if err != nil {
+		return nil, err
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+
+	var starts []time.Time
+	for _, name := range names {
+		if start, ok := p.partitioning.ParseSuffix(strings.TrimPrefix(name, p.table+"_")); ok {
+			starts = append(starts, start)
+		}
+	}
+	return starts, nil
+}
+
+// dropPartitionsBefore drops the time partitions whose period ends at or
+// before cutoff
+func (p *PostgresDB) dropPartitionsBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	starts, err := p.listPartitions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	dropped := 0
+	for _, start := range starts {
+		if !p.partitioning.Expired(start, cutoff) {
+			continue
+		}
+
+		name := p.partitionName(start)
+		if _, err := p.pool.Exec(ctx, "DROP TABLE IF EXISTS "+name); err != nil {
+			return dropped, err
+		}
+		dropped++
+
+		p.partitionMutex.Lock()
+		delete(p.partitions, name)
+		p.partitionMutex.Unlock()
+	}
+	return dropped, nil
+}
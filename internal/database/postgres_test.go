@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+)
+
+// openTestPostgresDB opens a PostgresDB on a fresh table in the database at
+// POSTGRES_TEST_URL, skipping the test when it is unset, and drops the
+// table and its companions afterwards
+func openTestPostgresDB(t *testing.T, partitioning Partitioning, config *TenantConfig) *PostgresDB {
+	t.Helper()
+	url := os.Getenv("POSTGRES_TEST_URL")
+	if url == "" {
+		t.Skip("POSTGRES_TEST_URL is not set")
+	}
+
+	ctx := context.Background()
+	table := fmt.Sprintf("logs_test_%d", rand.Int63())
+	db, err := OpenPostgresDB(ctx, PostgresConfig{URL: url, Table: table, Partitioning: partitioning, TenantConfig: config})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.pool.Exec(ctx, "DROP TABLE IF EXISTS "+table+", "+table+"_tenants, "+table+"_migrations CASCADE")
+		db.Close()
+	})
+	return db
+}
+
+func TestPostgresDBMatchesMockDB(t *testing.T) {
+	ctx := context.Background()
+	postgresDB := openTestPostgresDB(t, "", nil)
+	mockDB := NewMockDB()
+	if err := postgresDB.InsertLogs(ctx, testLogs()); err != nil {
+		t.Fatalf("Failed to insert logs: %v", err)
+	}
+	mockDB.InsertLogs(ctx, testLogs())
+
+	base := time.Date(2023, 9, 15, 8, 0, 0, 0, time.UTC)
+	queries := map[string]*models.LogQuery{
+		"all":            {Limit: 100},
+		"second page":    {Page: 2, Limit: 7},
+		"levels":         {Filters: map[string]models.FieldFilter{"level": {Include: []string{"error", "debug"}}}, Limit: 100},
+		"excluded level": {Filters: map[string]models.FieldFilter{"level": {Exclude: []string{"info"}}}, Limit: 100},
+		"parent":         {ParentResourceID: "server-2", Limit: 100},
+		"message":        {Message: "disk", Limit: 100},
+		"time range":     {StartTime: base.Add(3 * time.Minute), EndTime: base.Add(7 * time.Minute), Limit: 100},
+		"query language": {Query: `level:error OR traceId:trace-3`, Limit: 100},
+		"negated":        {Query: `NOT metadata.missing:x`, Limit: 100},
+		"scope":          {Scope: &querylang.TermExpr{Field: "resourceId", Value: "server-4"}, Limit: 100},
+	}
+
+	for name, query := range queries {
+		t.Run(name, func(t *testing.T) {
+			mockQuery, postgresQuery := *query, *query
+			expected, err := mockDB.QueryLogs(ctx, &mockQuery)
+			if err != nil {
+				t.Fatalf("Mock query failed: %v", err)
+			}
+			logs, err := postgresDB.QueryLogs(ctx, &postgresQuery)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if logIDs(logs) != logIDs(expected) {
+				t.Errorf("Expected %s, got %s", logIDs(expected), logIDs(logs))
+			}
+
+			expectedCount, _ := mockDB.CountLogs(ctx, query, 0)
+			if count, err := postgresDB.CountLogs(ctx, query, 0); err != nil || count != expectedCount {
+				t.Errorf("Expected count %d, got %d (%v)", expectedCount, count, err)
+			}
+		})
+	}
+}
+
+func TestPostgresDBSearchAndDelete(t *testing.T) {
+	ctx := context.Background()
+	db := openTestPostgresDB(t, "", nil)
+	if err := db.InsertLogs(ctx, testLogs()); err != nil {
+		t.Fatalf("Failed to insert logs: %v", err)
+	}
+
+	if count, _ := db.CountLogs(ctx, &models.LogQuery{FullTextSearch: "disk cache"}, 0); count != 20 {
+		t.Errorf("Expected 20 logs matching disk or cache, got %d", count)
+	}
+	if count, _ := db.CountLogs(ctx, &models.LogQuery{FullTextSearch: `"user profile"`}, 0); count != 10 {
+		t.Errorf("Expected 10 logs matching the phrase, got %d", count)
+	}
+	if count, _ := db.CountLogs(ctx, &models.LogQuery{FullTextSearch: "auth*"}, 0); count != 10 {
+		t.Errorf("Expected 10 logs matching the prefix, got %d", count)
+	}
+
+	// Logs with both terms rank above those with one
+	logs, err := db.QueryLogs(ctx, &models.LogQuery{FullTextSearch: "user profile", Sort: models.SortRelevance, Limit: 10})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	for _, log := range logs {
+		if log.Message != "Cache miss for user profile" || log.Score <= 0 {
+			t.Errorf("Unexpected log %q with score %v", log.Message, log.Score)
+		}
+	}
+
+	deleted, err := db.DeleteLogs(ctx, &models.LogQuery{Level: "error"})
+	if err != nil || deleted != 10 {
+		t.Errorf("Expected 10 deleted logs, got %d (%v)", deleted, err)
+	}
+
+	result, err := db.AggregateLogs(ctx, &models.LogQuery{}, &models.StatsRequest{Interval: 5 * time.Minute, GroupBy: "level", Top: 10})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if result.Total != 30 || len(result.Groups) != 3 {
+		t.Errorf("Expected 30 logs in 3 levels, got %d in %+v", result.Total, result.Groups)
+	}
+}
+
+func TestPostgresDBTenantsQuotaAndExpiry(t *testing.T) {
+	now := time.Now().UTC()
+	db := openTestPostgresDB(t, PartitionDaily, &TenantConfig{
+		Default: TenantLimits{Retention: 24 * time.Hour},
+		Tenants: map[string]TenantLimits{"acme": {MaxLogs: 2}},
+	})
+	acme := WithTenant(context.Background(), "acme")
+
+	if err := db.InsertLogs(acme, []*models.Log{
+		{Level: "info", Message: "old", Timestamp: now.Add(-72 * time.Hour)},
+		{Level: "info", Message: "recent", Timestamp: now},
+	}); err != nil {
+		t.Fatalf("Failed to insert logs: %v", err)
+	}
+	if err := db.InsertLog(acme, &models.Log{Level: "info", Message: "over", Timestamp: now}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+
+	if count, _ := db.CountLogs(context.Background(), &models.LogQuery{}, 0); count != 0 {
+		t.Errorf("Expected no logs for the default tenant, got %d", count)
+	}
+	if tenants, err := db.Tenants(context.Background()); err != nil || len(tenants) != 2 || tenants[1] != "acme" {
+		t.Errorf("Expected the default tenant and acme, got %q (%v)", tenants, err)
+	}
+
+	if _, err := db.Expire(now); err != nil {
+		t.Fatalf("Expire failed: %v", err)
+	}
+	logs, _ := db.QueryLogs(acme, &models.LogQuery{})
+	if len(logs) != 1 || logs[0].Message != "recent" {
+		t.Errorf("Expected only the recent log to remain, got %v", logs)
+	}
+}
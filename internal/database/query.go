@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+)
+
+// ErrQueryTimeout is returned when the server stops a query for running
+// past its time budget
+var ErrQueryTimeout = errors.New("query exceeded its time budget")
+
+// IsQueryTimeout reports whether a query failed for running past its time
+// budget, whether the server stopped it or the context expired first
+func IsQueryTimeout(err error) bool {
+	return errors.Is(err, ErrQueryTimeout) || errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err) || pgconn.Timeout(err)
+}
+
+// timeBudget returns the time left before a context's deadline, which
+// backends pass on to the server so it stops work the caller has given up
+// on. It is at least a millisecond, and ok is false without a deadline.
+func timeBudget(ctx context.Context) (budget time.Duration, ok bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	if budget = time.Until(deadline); budget < time.Millisecond {
+		budget = time.Millisecond
+	}
+	return budget, true
+}
+
+// quoteMetas escapes values for matching as literal text in regular
+// expressions
+func quoteMetas(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = regexp.QuoteMeta(value)
+	}
+	return quoted
+}
+
+// NewLogID returns a unique, time-ordered ID for logs that need one before
+// they are stored, and for backends that do not generate their own
+func NewLogID() string {
+	return primitive.NewObjectID().Hex()
+}
+
+// sortLogs orders logs by timestamp and then ID, both descending, matching
+// the order cursors are defined over
+func sortLogs(logs []*models.Log) {
+	sort.SliceStable(logs, func(i, j int) bool {
+		if !logs[i].Timestamp.Equal(logs[j].Timestamp) {
+			return logs[i].Timestamp.After(logs[j].Timestamp)
+		}
+		return logs[i].ID > logs[j].ID
+	})
+}
+
+// sortLogsBy orders logs by sort keys, breaking ties by timestamp and then
+// ID, both descending, as the MongoDB sort built by mongoSort does
+func sortLogsBy(logs []*models.Log, keys []models.SortKey) {
+	sort.SliceStable(logs, func(i, j int) bool {
+		return lessBy(logs[i], logs[j], keys)
+	})
+}
+
+// lessBy reports whether log a comes before log b in the order of sort keys
+func lessBy(a, b *models.Log, keys []models.SortKey) bool {
+	for _, key := range keys {
+		if c := compareField(a, b, key.Field); c != 0 {
+			return (c < 0) != key.Descending
+		}
+	}
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.After(b.Timestamp)
+	}
+	return a.ID > b.ID
+}
+
+// compareField compares two logs' values of a sort field byte-wise. Logs
+// missing a metadata key come before those with any value, as MongoDB
+// orders missing fields first.
+func compareField(a, b *models.Log, field string) int {
+	if field == "timestamp" {
+		return a.Timestamp.Compare(b.Timestamp)
+	}
+
+	aValue, aOK := a.Field(field)
+	bValue, bOK := b.Field(field)
+	if aOK != bOK {
+		if aOK {
+			return 1
+		}
+		return -1
+	}
+	return strings.Compare(aValue, bValue)
+}
+
+// projectLogs returns copies of logs with only the projected fields set
+func projectLogs(logs []*models.Log, fields []string) []*models.Log {
+	if fields == nil {
+		return logs
+	}
+	projected := make([]*models.Log, len(logs))
+	for i, log := range logs {
+		projected[i] = log.Project(fields)
+	}
+	return projected
+}
+
+// MatchesQuery checks if a log matches the query parameters and the parsed
+// query language expression, with the same semantics as the database
+// backends. Pagination and the cursor are not considered.
+func MatchesQuery(log *models.Log, query *models.LogQuery, expr querylang.Expr) bool {
+	// Field filters, including repeated and negated values
+	for _, field := range models.FilterFields {
+		if !matchesFieldFilter(log, field, query.FieldFilter(field)) {
+			return false
+		}
+	}
+
+	// Metadata filters
+	for _, filter := range query.MetadataFilters {
+		if !filter.Matches(log.Metadata) {
+			return false
+		}
+	}
+
+	// Date range filter
+	if !query.StartTime.IsZero() && log.Timestamp.Before(query.StartTime) {
+		return false
+	}
+	if !query.EndTime.IsZero() && log.Timestamp.After(query.EndTime) {
+		return false
+	}
+
+	// Structured query language expression and the caller's scope
+	return querylang.Match(expr, log) && querylang.Match(query.Scope, log)
+}
+
+// matchesFieldFilter checks a log field against a field filter. message
+// values match case-insensitive substrings, as in MongoDB; other fields
+// must match exactly.
+func matchesFieldFilter(log *models.Log, field string, filter models.FieldFilter) bool {
+	if filter.IsEmpty() {
+		return true
+	}
+
+	value, _ := log.Field(field)
+	matches := func(want string) bool {
+		if field == "message" {
+			return contains(strings.ToLower(value), strings.ToLower(want))
+		}
+		return value == want
+	}
+
+	// At least one include value must match
+	if len(filter.Include) > 0 {
+		matched := false
+		for _, want := range filter.Include {
+			if matches(want) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	// No exclude value may match
+	for _, unwanted := range filter.Exclude {
+		if matches(unwanted) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// contains checks if a string contains a substring (case-sensitive)
+func contains(s, substr string) bool {
+	if substr == "" {
+		return true // Empty substring is always contained
+	}
+
+	return strings.Contains(s, substr)
+}
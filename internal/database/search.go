@@ -0,0 +1,376 @@
+package database
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"log-ingestor/internal/models"
+)
+
+// Ranking parameters: bm25K1 controls how quickly repeated occurrences of
+// a term stop adding to its weight, and each phrase found in a message
+// counts phraseBoost times a single term
+const (
+	bm25K1      = 1.2
+	phraseBoost = 2.0
+)
+
+// nonWord matches the characters words are split at
+const nonWord = `[^\p{L}\p{N}]`
+
+// maxRankedLogs bounds how many matches backends without native ranking
+// score in memory for sort=relevance; the newest matches are ranked
+const maxRankedLogs = 10000
+
+// words splits text into lowercase words of letters and digits, in order
+// and including repeats
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// tokenize splits text into distinct lowercase terms of letters and digits
+func tokenize(text string) []string {
+	words := words(text)
+
+	seen := make(map[string]bool, len(words))
+	terms := words[:0]
+	for _, word := range words {
+		if !seen[word] {
+			seen[word] = true
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// searchTerm is a term of a full-text search. It matches a whole word, or
+// for a prefix term, written term*, any word starting with it.
+type searchTerm struct {
+	text   string
+	prefix bool
+}
+
+// matches reports whether a word satisfies the term
+func (t searchTerm) matches(word string) bool {
+	if t.prefix {
+		return strings.HasPrefix(word, t.text)
+	}
+	return word == t.text
+}
+
+// count returns how many of words the term matches
+func (t searchTerm) count(words []string) int {
+	n := 0
+	for _, word := range words {
+		if t.matches(word) {
+			n++
+		}
+	}
+	return n
+}
+
+// searchQuery is a parsed full-text search. As in MongoDB, a log matches
+// when it contains every phrase, or any term when there is no phrase, and
+// none of the negated terms. A phrase is contained when its words follow
+// one another in the message; phrases are kept as their lowercase words
+// joined by single spaces.
+type searchQuery struct {
+	terms   []searchTerm
+	phrases []string
+	negated []searchTerm
+}
+
+// parseSearch parses a full-text search of terms, prefix* terms, "quoted
+// phrases" and -negated terms
+func parseSearch(search string) *searchQuery {
+	query := &searchQuery{}
+
+	for {
+		start := strings.IndexByte(search, '"')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(search[start+1:], '"')
+		if end < 0 {
+			break
+		}
+		if phrase := strings.Join(words(search[start+1:start+1+end]), " "); phrase != "" {
+			query.phrases = append(query.phrases, phrase)
+		}
+		search = search[:start] + " " + search[start+2+end:]
+	}
+
+	for _, word := range strings.Fields(search) {
+		if negated, ok := strings.CutPrefix(word, "-"); ok {
+			query.negated = append(query.negated, searchTerms(negated)...)
+		} else {
+			query.terms = append(query.terms, searchTerms(word)...)
+		}
+	}
+	return query
+}
+
+// searchTerms splits a word of a search into terms. A trailing * makes its
+// last term a prefix.
+func searchTerms(word string) []searchTerm {
+	tokens := tokenize(word)
+	terms := make([]searchTerm, len(tokens))
+	for i, token := range tokens {
+		terms[i] = searchTerm{text: token}
+	}
+	if len(terms) > 0 && strings.HasSuffix(word, "*") {
+		terms[len(terms)-1].prefix = true
+	}
+	return terms
+}
+
+// hasPrefixes reports whether any term or negated term is a prefix
+func (q *searchQuery) hasPrefixes() bool {
+	for _, terms := range [][]searchTerm{q.terms, q.negated} {
+		for _, term := range terms {
+			if term.prefix {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matches reports whether a message satisfies the search
+func (q *searchQuery) matches(message string) bool {
+	words := words(message)
+
+	for _, term := range q.negated {
+		if term.count(words) > 0 {
+			return false
+		}
+	}
+
+	if len(q.phrases) > 0 {
+		for _, phrase := range q.phrases {
+			if countPhrase(words, phrase) == 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, term := range q.terms {
+		if term.count(words) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// rankedTerms returns the terms a message is scored on: the search's terms
+// and the words of its phrases
+func (q *searchQuery) rankedTerms() []searchTerm {
+	terms := append([]searchTerm(nil), q.terms...)
+	for _, phrase := range q.phrases {
+		terms = append(terms, searchTerms(phrase)...)
+	}
+	return terms
+}
+
+// score rates how well a message matches the search, in the manner of
+// BM25: each term adds its inverse document frequency, scaled by how often
+// it occurs with diminishing returns, and each phrase occurrence adds
+// phraseBoost. idf is nil when document frequencies are unknown, weighing
+// every term equally.
+func (q *searchQuery) score(message string, idf func(searchTerm) float64) float64 {
+	words := words(message)
+
+	var score float64
+	for _, term := range q.rankedTerms() {
+		tf := float64(term.count(words))
+		if tf == 0 {
+			continue
+		}
+		weight := 1.0
+		if idf != nil {
+			weight = idf(term)
+		}
+		score += weight * tf * (bm25K1 + 1) / (tf + bm25K1)
+	}
+
+	for _, phrase := range q.phrases {
+		score += phraseBoost * float64(countPhrase(words, phrase))
+	}
+	return score
+}
+
+// countPhrase returns how often the words of a phrase follow one another
+// in words
+func countPhrase(words []string, phrase string) int {
+	sequence := strings.Fields(phrase)
+	n := 0
+	for i := 0; i+len(sequence) <= len(words); i++ {
+		matched := true
+		for j, word := range sequence {
+			if words[i+j] != word {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			n++
+		}
+	}
+	return n
+}
+
+// termPattern is a regular expression matching a search term as a whole
+// word, or the start of one for a prefix term, for backends that match
+// words by regular expression. Callers make it case-insensitive.
+func termPattern(term searchTerm) string {
+	pattern := "(^|" + nonWord + ")" + regexp.QuoteMeta(term.text)
+	if !term.prefix {
+		pattern += "($|" + nonWord + ")"
+	}
+	return pattern
+}
+
+// phrasePattern is a regular expression matching the words of a phrase
+// following one another. Callers make it case-insensitive.
+func phrasePattern(phrase string) string {
+	sequence := strings.Fields(phrase)
+	for i, word := range sequence {
+		sequence[i] = regexp.QuoteMeta(word)
+	}
+	return "(^|" + nonWord + ")" + strings.Join(sequence, nonWord+"+") + "($|" + nonWord + ")"
+}
+
+// bm25IDF is the inverse document frequency of a term found in docFreq of
+// docs documents; rare terms weigh more
+func bm25IDF(docFreq, docs int) float64 {
+	return math.Log(1 + (float64(docs-docFreq)+0.5)/(float64(docFreq)+0.5))
+}
+
+// rankLogs scores logs against a search and orders them by descending
+// score, newest first among equal scores
+func rankLogs(logs []*models.Log, search *searchQuery, idf func(searchTerm) float64) {
+	for _, log := range logs {
+		log.Score = search.score(log.Message, idf)
+	}
+	sortByScore(logs)
+}
+
+// sortByScore orders scored logs by descending score, newest first among
+// equal scores
+func sortByScore(logs []*models.Log) {
+	sortLogs(logs)
+	sort.SliceStable(logs, func(i, j int) bool { return logs[i].Score > logs[j].Score })
+}
+
+// pageLogs returns the page of at most limit logs starting at skip
+func pageLogs(logs []*models.Log, skip, limit int) []*models.Log {
+	if skip >= len(logs) {
+		return []*models.Log{}
+	}
+	logs = logs[skip:]
+	if len(logs) > limit {
+		logs = logs[:limit]
+	}
+	return logs
+}
+
+// textIndex is an in-memory inverted index of log messages, mapping each
+// word to the logs containing it and how often
+type textIndex struct {
+	postings map[string]map[*models.Log]int
+	docs     int
+}
+
+// newTextIndex creates an empty index
+func newTextIndex() *textIndex {
+	return &textIndex{postings: make(map[string]map[*models.Log]int)}
+}
+
+// add indexes a log's message
+func (x *textIndex) add(log *models.Log) {
+	for _, word := range words(log.Message) {
+		postings := x.postings[word]
+		if postings == nil {
+			postings = make(map[*models.Log]int)
+			x.postings[word] = postings
+		}
+		postings[log]++
+	}
+	x.docs++
+}
+
+// remove drops a log from the index
+func (x *textIndex) remove(log *models.Log) {
+	for _, word := range tokenize(log.Message) {
+		delete(x.postings[word], log)
+		if len(x.postings[word]) == 0 {
+			delete(x.postings, word)
+		}
+	}
+	x.docs--
+}
+
+// lookup returns the logs containing a word matching the term
+func (x *textIndex) lookup(term searchTerm) map[*models.Log]bool {
+	logs := make(map[*models.Log]bool)
+	if !term.prefix {
+		for log := range x.postings[term.text] {
+			logs[log] = true
+		}
+		return logs
+	}
+	for word, postings := range x.postings {
+		if term.matches(word) {
+			for log := range postings {
+				logs[log] = true
+			}
+		}
+	}
+	return logs
+}
+
+// candidates returns the logs that may match a search: those containing
+// every word of every phrase, or any term. Negated terms and the phrases'
+// word order are left to searchQuery.matches.
+func (x *textIndex) candidates(search *searchQuery) map[*models.Log]bool {
+	if len(search.phrases) == 0 {
+		result := make(map[*models.Log]bool)
+		for _, term := range search.terms {
+			for log := range x.lookup(term) {
+				result[log] = true
+			}
+		}
+		return result
+	}
+
+	var result map[*models.Log]bool
+	for _, phrase := range search.phrases {
+		for _, term := range searchTerms(phrase) {
+			logs := x.lookup(term)
+			if result != nil {
+				for log := range result {
+					if !logs[log] {
+						delete(result, log)
+					}
+				}
+			} else {
+				result = logs
+			}
+		}
+	}
+	if result == nil {
+		result = make(map[*models.Log]bool)
+	}
+	return result
+}
+
+// idf returns the inverse document frequency of a term across the index
+func (x *textIndex) idf(term searchTerm) float64 {
+	return bm25IDF(len(x.lookup(term)), x.docs)
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"log-ingestor/internal/models"
+)
+
+func TestTokenize(t *testing.T) {
+	terms := tokenize("Failed to connect: DB timeout, failed again (code 503)")
+	expected := []string{"failed", "to", "connect", "db", "timeout", "again", "code", "503"}
+	if !reflect.DeepEqual(terms, expected) {
+		t.Errorf("Expected %q, got %q", expected, terms)
+	}
+}
+
+func TestParseSearch(t *testing.T) {
+	query := parseSearch(`disk "connection reset" -debug Cache user-prof*`)
+
+	expectedTerms := []searchTerm{{text: "disk"}, {text: "cache"}, {text: "user"}, {text: "prof", prefix: true}}
+	if !reflect.DeepEqual(query.terms, expectedTerms) {
+		t.Errorf("Unexpected terms %v", query.terms)
+	}
+	if !reflect.DeepEqual(query.phrases, []string{"connection reset"}) {
+		t.Errorf("Unexpected phrases %q", query.phrases)
+	}
+	if !reflect.DeepEqual(query.negated, []searchTerm{{text: "debug"}}) {
+		t.Errorf("Unexpected negated terms %v", query.negated)
+	}
+	if !query.hasPrefixes() {
+		t.Error("Expected the search to have prefixes")
+	}
+}
+
+func TestSearchMatches(t *testing.T) {
+	tests := []struct {
+		search  string
+		message string
+		matches bool
+	}{
+		{"disk cache", "Disk usage high", true},
+		{"disk cache", "Memory usage high", false},
+		{"disk -usage", "Disk usage high", false},
+		{`"usage high"`, "Disk usage high", true},
+		{`"high usage"`, "Disk usage high", false},
+		{`"usage high" memory`, "Disk usage high", true},
+		{"-disk", "Memory usage high", false},
+		{"us*", "Disk usage high", true},
+		{"usage*", "Disk usage high", true},
+		{"sage*", "Disk usage high", false},
+		{"disk -us*", "Disk usage high", false},
+		{`"Usage,  HIGH!"`, "Disk usage high", true},
+		{`"usage hi"`, "Disk usage high", false},
+		{`"user profile"`, "User profiles synced", false},
+	}
+
+	for _, tt := range tests {
+		if matches := parseSearch(tt.search).matches(tt.message); matches != tt.matches {
+			t.Errorf("search %q on %q: expected %v, got %v", tt.search, tt.message, tt.matches, matches)
+		}
+	}
+}
+
+func TestSearchScore(t *testing.T) {
+	search := parseSearch("disk cache")
+
+	if score := search.score("Memory usage high", nil); score != 0 {
+		t.Errorf("Expected no score without matching terms, got %v", score)
+	}
+
+	one := search.score("Disk usage high", nil)
+	both := search.score("Disk cache usage high", nil)
+	repeated := search.score("Disk disk disk usage", nil)
+	if !(one > 0 && both > one && repeated > one && repeated < both) {
+		t.Errorf("Expected both terms to outscore a repeated one, got one=%v both=%v repeated=%v", one, both, repeated)
+	}
+
+	// Rare terms weigh more
+	idf := func(term searchTerm) float64 {
+		if term.text == "cache" {
+			return bm25IDF(1, 100)
+		}
+		return bm25IDF(50, 100)
+	}
+	if disk, cache := search.score("Disk full", idf), search.score("Cache full", idf); cache <= disk {
+		t.Errorf("Expected the rarer term to score higher, got disk=%v cache=%v", disk, cache)
+	}
+
+	// Phrases in order score above their words apart
+	phrase := parseSearch(`"cache miss"`)
+	if inOrder, apart := phrase.score("cache miss on read", nil), phrase.score("miss the cache", nil); inOrder <= apart {
+		t.Errorf("Expected the phrase to score higher, got %v and %v", inOrder, apart)
+	}
+}
+
+func TestRankLogs(t *testing.T) {
+	base := time.Date(2023, 9, 15, 8, 0, 0, 0, time.UTC)
+	logs := []*models.Log{
+		{ID: "1", Message: "disk full", Timestamp: base},
+		{ID: "2", Message: "disk cache full", Timestamp: base.Add(-time.Second)},
+		{ID: "3", Message: "disk almost full", Timestamp: base.Add(time.Second)},
+	}
+
+	rankLogs(logs, parseSearch("disk cache"), nil)
+	if ids := logIDs(logs); ids != "2,3,1" {
+		t.Errorf("Expected logs ranked by score then newest first, got %s", ids)
+	}
+	if logs[0].Score <= logs[1].Score || logs[1].Score != logs[2].Score {
+		t.Errorf("Unexpected scores %v, %v, %v", logs[0].Score, logs[1].Score, logs[2].Score)
+	}
+
+	if page := pageLogs(logs, 1, 5); logIDs(page) != "3,1" {
+		t.Errorf("Expected the second and third logs, got %s", logIDs(page))
+	}
+	if page := pageLogs(logs, 3, 5); len(page) != 0 {
+		t.Errorf("Expected an empty page, got %s", logIDs(page))
+	}
+}
+
+func TestTextIndex(t *testing.T) {
+	index := newTextIndex()
+	full := &models.Log{Message: "Disk full, disk failing"}
+	cache := &models.Log{Message: "Cache miss on disk read"}
+	profile := &models.Log{Message: "User profile updated"}
+	for _, log := range []*models.Log{full, cache, profile} {
+		index.add(log)
+	}
+
+	tests := []struct {
+		search   string
+		expected []*models.Log
+	}{
+		{"disk", []*models.Log{full, cache}},
+		{"cache profile", []*models.Log{cache, profile}},
+		{"prof*", []*models.Log{profile}},
+		{`"disk read"`, []*models.Log{cache}},
+		{`"miss on" "user"`, nil},
+		{"network", nil},
+	}
+	for _, tt := range tests {
+		candidates := index.candidates(parseSearch(tt.search))
+		if len(candidates) != len(tt.expected) {
+			t.Errorf("search %q: expected %d candidates, got %d", tt.search, len(tt.expected), len(candidates))
+		}
+		for _, log := range tt.expected {
+			if !candidates[log] {
+				t.Errorf("search %q: expected %q among the candidates", tt.search, log.Message)
+			}
+		}
+	}
+
+	if disk, user := index.idf(searchTerm{text: "disk"}), index.idf(searchTerm{text: "user"}); disk >= user {
+		t.Errorf("Expected the rarer term to have the higher idf, got disk=%v user=%v", disk, user)
+	}
+
+	index.remove(full)
+	if candidates := index.candidates(parseSearch("full")); len(candidates) != 0 {
+		t.Errorf("Expected removed logs to leave the index, got %d candidates", len(candidates))
+	}
+	if index.docs != 2 {
+		t.Errorf("Expected 2 indexed logs, got %d", index.docs)
+	}
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"sort"
+	"time"
+
+	"log-ingestor/internal/models"
+)
+
+// statsAggregator computes a StatsResult in memory, one log at a time
+type statsAggregator struct {
+	request *models.StatsRequest
+	total   int64
+	buckets map[int64]int64
+	groups  map[string]int64
+}
+
+// newStatsAggregator creates an aggregator for a stats request
+func newStatsAggregator(request *models.StatsRequest) *statsAggregator {
+	return &statsAggregator{
+		request: request,
+		buckets: make(map[int64]int64),
+		groups:  make(map[string]int64),
+	}
+}
+
+// add counts a log matching the query
+func (a *statsAggregator) add(log *models.Log) {
+	a.total++
+	a.buckets[models.BucketStart(log.Timestamp, a.request.Interval).UnixMilli()]++
+
+	// Logs without a value for the grouped field are not grouped
+	if a.request.GroupBy != "" {
+		if value, ok := log.Field(a.request.GroupBy); ok && value != "" {
+			a.groups[value]++
+		}
+	}
+}
+
+// result builds the aggregations for the logs added so far
+func (a *statsAggregator) result(query *models.LogQuery) *models.StatsResult {
+	return buildStatsResult(query, a.request, a.total, a.buckets, a.groups)
+}
+
+// buildStatsResult assembles a StatsResult from bucket counts keyed by
+// bucket start in Unix milliseconds and counts per group value
+func buildStatsResult(query *models.LogQuery, request *models.StatsRequest, total int64, buckets map[int64]int64, groups map[string]int64) *models.StatsResult {
+	result := &models.StatsResult{
+		Total:    total,
+		Interval: request.Interval.String(),
+		Buckets:  fillBuckets(query, request.Interval, buckets),
+		GroupBy:  request.GroupBy,
+	}
+
+	if request.GroupBy != "" {
+		result.Groups = topTerms(groups, request.Top)
+	}
+
+	return result
+}
+
+// fillBuckets orders bucket counts by time and adds the empty buckets in
+// between, so the histogram has no gaps. The query's time range, when
+// given, bounds the histogram; only the latest MaxStatsBuckets are kept.
+func fillBuckets(query *models.LogQuery, interval time.Duration, counts map[int64]int64) []models.StatsBucket {
+	step := interval.Milliseconds()
+
+	var first, last int64
+	haveRange := false
+	for start := range counts {
+		if !haveRange || start < first {
+			first = start
+		}
+		if !haveRange || start > last {
+			last = start
+		}
+		haveRange = true
+	}
+
+	// A range starting at StartTime runs to EndTime, or to now when open
+	if !query.StartTime.IsZero() {
+		first = models.BucketStart(query.StartTime, interval).UnixMilli()
+
+		end := query.EndTime
+		if end.IsZero() {
+			end = time.Now()
+		}
+		if endBucket := models.BucketStart(end, interval).UnixMilli(); !haveRange || endBucket > last || !query.EndTime.IsZero() {
+			last = endBucket
+		}
+		haveRange = true
+	} else if !query.EndTime.IsZero() && haveRange {
+		last = models.BucketStart(query.EndTime, interval).UnixMilli()
+	}
+
+	if !haveRange || last < first {
+		return []models.StatsBucket{}
+	}
+
+	if (last-first)/step >= models.MaxStatsBuckets {
+		first = last - (models.MaxStatsBuckets-1)*step
+	}
+
+	buckets := make([]models.StatsBucket, 0, (last-first)/step+1)
+	for start := first; start <= last; start += step {
+		buckets = append(buckets, models.StatsBucket{
+			Start: time.UnixMilli(start).UTC(),
+			Count: counts[start],
+		})
+	}
+	return buckets
+}
+
+// topTerms returns the top values by count, breaking ties by value
+func topTerms(counts map[string]int64, top int) []models.TermCount {
+	terms := make([]models.TermCount, 0, len(counts))
+	for value, count := range counts {
+		terms = append(terms, models.TermCount{Value: value, Count: count})
+	}
+
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Count != terms[j].Count {
+			return terms[i].Count > terms[j].Count
+		}
+		return terms[i].Value < terms[j].Value
+	})
+
+	if len(terms) > top {
+		terms = terms[:top]
+	}
+	return terms
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"log-ingestor/internal/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMockDBAggregateLogs(t *testing.T) {
+	mockDB := NewMockDB()
+	ctx := context.Background()
+
+	base, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	mockDB.InsertLogs(ctx, []*models.Log{
+		{Level: "error", Message: "a", ResourceID: "server-1", Timestamp: base.Add(10 * time.Second)},
+		{Level: "error", Message: "b", ResourceID: "server-1", Timestamp: base.Add(20 * time.Second)},
+		{Level: "error", Message: "c", ResourceID: "server-2", Timestamp: base.Add(3 * time.Minute)},
+		{Level: "info", Message: "d", ResourceID: "server-3", Timestamp: base.Add(time.Minute)},
+		{Level: "error", Message: "e", Timestamp: base.Add(3 * time.Minute)},
+	})
+
+	request := &models.StatsRequest{Interval: time.Minute, GroupBy: "resourceId", Top: 1}
+	result, err := mockDB.AggregateLogs(ctx, &models.LogQuery{Level: "error"}, request)
+	if err != nil {
+		t.Fatalf("Failed to aggregate logs: %v", err)
+	}
+
+	expected := &models.StatsResult{
+		Total:    4,
+		Interval: "1m0s",
+		Buckets: []models.StatsBucket{
+			{Start: base, Count: 2},
+			{Start: base.Add(time.Minute), Count: 0},
+			{Start: base.Add(2 * time.Minute), Count: 0},
+			{Start: base.Add(3 * time.Minute), Count: 2},
+		},
+		GroupBy: "resourceId",
+		Groups:  []models.TermCount{{Value: "server-1", Count: 2}},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("AggregateLogs() = %+v, expected %+v", result, expected)
+	}
+
+	mockDB.SimulateError = true
+	if _, err := mockDB.AggregateLogs(ctx, &models.LogQuery{}, request); err == nil {
+		t.Error("Expected error with SimulateError set")
+	}
+}
+
+func TestFillBucketsTimeRange(t *testing.T) {
+	start, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	query := &models.LogQuery{StartTime: start, EndTime: start.Add(3 * time.Hour)}
+
+	buckets := fillBuckets(query, time.Hour, map[int64]int64{start.Add(time.Hour).UnixMilli(): 5})
+	if len(buckets) != 4 {
+		t.Fatalf("Expected 4 buckets covering the range, got %d", len(buckets))
+	}
+	if buckets[0].Count != 0 || buckets[1].Count != 5 || !buckets[3].Start.Equal(start.Add(3*time.Hour)) {
+		t.Errorf("Unexpected buckets %+v", buckets)
+	}
+
+	if buckets := fillBuckets(&models.LogQuery{}, time.Hour, map[int64]int64{}); len(buckets) != 0 {
+		t.Errorf("Expected no buckets without logs or a range, got %+v", buckets)
+	}
+}
+
+func TestFillBucketsCapsCount(t *testing.T) {
+	counts := map[int64]int64{0: 1, int64(5000 * time.Second / time.Millisecond): 1}
+
+	buckets := fillBuckets(&models.LogQuery{}, time.Second, counts)
+	if len(buckets) != models.MaxStatsBuckets {
+		t.Fatalf("Expected %d buckets, got %d", models.MaxStatsBuckets, len(buckets))
+	}
+	if last := buckets[len(buckets)-1]; last.Count != 1 {
+		t.Errorf("Expected the latest buckets to be kept, got %+v", last)
+	}
+}
+
+func TestTopTerms(t *testing.T) {
+	terms := topTerms(map[string]int64{"b": 2, "a": 2, "c": 5, "d": 1}, 3)
+
+	expected := []models.TermCount{{Value: "c", Count: 5}, {Value: "a", Count: 2}, {Value: "b", Count: 2}}
+	if !reflect.DeepEqual(terms, expected) {
+		t.Errorf("topTerms() = %+v, expected %+v", terms, expected)
+	}
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+
+	"log-ingestor/internal/models"
+)
+
+// streamBatchSize is how many logs are read at a time when streaming,
+// both as the cursor batch size and as the page size of pageLogsThrough
+const streamBatchSize = 1000
+
+// StreamLogs calls fn with each log matching the query, as
+// LogStreamer.StreamLogs does. Backends that are not LogStreamers are
+// paged through with cursors.
+func StreamLogs(ctx context.Context, db DB, query *models.LogQuery, fn func(*models.Log) error) error {
+	if streamer, ok := db.(LogStreamer); ok {
+		return streamer.StreamLogs(ctx, query, fn)
+	}
+	return pageLogsThrough(ctx, db, query, fn)
+}
+
+// pageLogsThrough streams logs by querying successive pages, each
+// continuing from the cursor of the last log of the page before
+func pageLogsThrough(ctx context.Context, db DB, query *models.LogQuery, fn func(*models.Log) error) error {
+	page := *query
+	page.Page = 1
+	page.Sort = models.SortTime
+	page.Fields = ""
+
+	remaining := query.Limit
+	for {
+		page.Limit = streamBatchSize
+		if remaining > 0 && remaining < streamBatchSize {
+			page.Limit = remaining
+		}
+
+		logs, err := db.QueryLogs(ctx, &page)
+		if err != nil {
+			return err
+		}
+		for _, log := range logs {
+			if err := fn(log); err != nil {
+				return err
+			}
+		}
+
+		if remaining > 0 {
+			remaining -= len(logs)
+			if remaining <= 0 {
+				return nil
+			}
+		}
+		if len(logs) < page.Limit {
+			return nil
+		}
+		page.Cursor = models.EncodeCursor(logs[len(logs)-1])
+	}
+}
+
+// streamLogs calls fn with each of a slice of logs sorted newest first,
+// skipping those at or before the query's cursor and stopping at its limit
+func streamLogs(logs []*models.Log, query *models.LogQuery, fn func(*models.Log) error) error {
+	var cursor *models.Cursor
+	if query.Cursor != "" {
+		var err error
+		if cursor, err = models.DecodeCursor(query.Cursor); err != nil {
+			return err
+		}
+	}
+
+	streamed := 0
+	for _, log := range logs {
+		if query.Limit > 0 && streamed == query.Limit {
+			break
+		}
+		if cursor != nil && !cursor.After(log) {
+			continue
+		}
+		if err := fn(log); err != nil {
+			return err
+		}
+		streamed++
+	}
+	return nil
+}
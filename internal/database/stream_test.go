@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"log-ingestor/internal/models"
+)
+
+// pagedDB hides a database's StreamLogs, so StreamLogs pages through it
+type pagedDB struct {
+	DB
+}
+
+// streamIDs returns the IDs of the logs streamed for a query
+func streamIDs(t *testing.T, db DB, query *models.LogQuery) []string {
+	t.Helper()
+	var ids []string
+	err := StreamLogs(context.Background(), db, query, func(log *models.Log) error {
+		ids = append(ids, log.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to stream logs: %v", err)
+	}
+	return ids
+}
+
+func TestStreamLogs(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDB()
+
+	// More logs than a batch, sharing timestamps so the ID tiebreaker matters
+	base := time.Date(2023, 9, 15, 8, 0, 0, 0, time.UTC)
+	var logs []*models.Log
+	for i := 0; i < 2*streamBatchSize+500; i++ {
+		logs = append(logs, &models.Log{
+			ID:        fmt.Sprintf("650400000000000000%06d", i),
+			Level:     []string{"error", "info"}[i%2],
+			Message:   "entry",
+			Timestamp: base.Add(time.Duration(i/3) * time.Second),
+		})
+	}
+	mockDB.InsertLogs(ctx, logs)
+
+	all, err := mockDB.QueryLogs(ctx, &models.LogQuery{Level: "error", Limit: len(logs)})
+	if err != nil {
+		t.Fatalf("Failed to query logs: %v", err)
+	}
+	cursor := models.EncodeCursor(all[99])
+
+	tests := []struct {
+		name  string
+		query models.LogQuery
+		want  []*models.Log
+	}{
+		{"All", models.LogQuery{Level: "error"}, all},
+		{"Limit", models.LogQuery{Level: "error", Limit: 1100}, all[:1100]},
+		{"Cursor", models.LogQuery{Level: "error", Cursor: cursor}, all[100:]},
+		{"Cursor and limit", models.LogQuery{Level: "error", Cursor: cursor, Limit: 10}, all[100:110]},
+		{"Page is ignored", models.LogQuery{Level: "error", Page: 3, Limit: 5}, all[:5]},
+	}
+
+	for _, tt := range tests {
+		for name, db := range map[string]DB{"streamed": mockDB, "paged": pagedDB{mockDB}} {
+			t.Run(tt.name+" "+name, func(t *testing.T) {
+				query := tt.query
+				ids := streamIDs(t, db, &query)
+				if len(ids) != len(tt.want) {
+					t.Fatalf("Expected %d logs, got %d", len(tt.want), len(ids))
+				}
+				for i, log := range tt.want {
+					if ids[i] != log.ID {
+						t.Fatalf("Expected log %s at %d, got %s", log.ID, i, ids[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestStreamLogsStopsOnError(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDB()
+	mockDB.InsertLogs(ctx, testLogs())
+
+	stop := errors.New("stop")
+	for name, db := range map[string]DB{"streamed": mockDB, "paged": pagedDB{mockDB}} {
+		calls := 0
+		err := StreamLogs(ctx, db, &models.LogQuery{}, func(*models.Log) error {
+			calls++
+			if calls == 3 {
+				return stop
+			}
+			return nil
+		})
+		if !errors.Is(err, stop) || calls != 3 {
+			t.Errorf("%s: expected to stop after 3 logs with the callback's error, got %d calls and %v", name, calls, err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultTenant is the tenant of requests that do not name one. Its logs
+// live in the configured collection, as they did before tenants existed.
+const DefaultTenant = ""
+
+// ErrQuotaExceeded is returned when an insert would take a tenant past its quota
+var ErrQuotaExceeded = errors.New("tenant log quota exceeded")
+
+// tenantPattern restricts tenant IDs to names that are safe in MongoDB
+// collection and database names
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// tenantContextKey is the context key holding the tenant of a request
+type tenantContextKey struct{}
+
+// ValidTenant reports whether id may be used as a tenant ID
+func ValidTenant(id string) bool {
+	return tenantPattern.MatchString(id)
+}
+
+// WithTenant returns a context routing database operations to a tenant's logs
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant set by WithTenant, or DefaultTenant
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+// QuotaChecker is implemented by backends that enforce tenant quotas, so
+// logs can be refused before they are accepted for asynchronous insertion
+type QuotaChecker interface {
+	// CheckQuota returns ErrQuotaExceeded if inserting n logs for the
+	// context's tenant would exceed its quota
+	CheckQuota(ctx context.Context, n int) error
+}
+
+// TenantLimits are the quota and retention applied to a tenant's logs. Zero
+// values mean no limit.
+type TenantLimits struct {
+	// MaxLogs caps how many logs the tenant may store
+	MaxLogs int64
+
+	// Retention is how long the tenant's logs are kept, by timestamp
+	Retention time.Duration
+}
+
+// tenantLimitsJSON is the configuration file form of TenantLimits
+type tenantLimitsJSON struct {
+	MaxLogs   int64  `json:"maxLogs,omitempty"`
+	Retention string `json:"retention,omitempty"`
+}
+
+// UnmarshalJSON reads limits with the retention given as a duration string,
+// such as "720h"
+func (l *TenantLimits) UnmarshalJSON(data []byte) error {
+	var raw tenantLimitsJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	limits := TenantLimits{MaxLogs: raw.MaxLogs}
+	if raw.Retention != "" {
+		retention, err := time.ParseDuration(raw.Retention)
+		if err != nil {
+			return fmt.Errorf("invalid retention %q", raw.Retention)
+		}
+		limits.Retention = retention
+	}
+
+	*l = limits
+	return nil
+}
+
+// MarshalJSON writes limits in the configuration file form
+func (l TenantLimits) MarshalJSON() ([]byte, error) {
+	raw := tenantLimitsJSON{MaxLogs: l.MaxLogs}
+	if l.Retention > 0 {
+		raw.Retention = l.Retention.String()
+	}
+	return json.Marshal(raw)
+}
+
+// validate checks that limits are not negative and retention is expressible
+// as a MongoDB TTL
+func (l TenantLimits) validate() error {
+	if l.MaxLogs < 0 {
+		return fmt.Errorf("maxLogs must not be negative")
+	}
+	if l.Retention < 0 || (l.Retention > 0 && l.Retention < time.Second) {
+		return fmt.Errorf("retention must be at least 1s")
+	}
+	return nil
+}
+
+// TenantConfig holds the limits of each tenant. Tenants that are not listed,
+// and limits a listed tenant leaves unset, fall back to Default.
+type TenantConfig struct {
+	Default TenantLimits            `json:"default"`
+	Tenants map[string]TenantLimits `json:"tenants"`
+}
+
+// LoadTenantConfig reads a tenant configuration file. An empty path returns
+// a configuration without limits.
+func LoadTenantConfig(path string) (*TenantConfig, error) {
+	config := &TenantConfig{}
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid tenant config %s: %w", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid tenant config %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// tenantConfigFromEnv loads the tenant config named by TENANT_CONFIG_FILE.
+// RETENTION_TTL sets the default retention unless the config sets its own.
+func tenantConfigFromEnv() (*TenantConfig, error) {
+	config, err := LoadTenantConfig(os.Getenv("TENANT_CONFIG_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	if value := os.Getenv("RETENTION_TTL"); value != "" && config.Default.Retention == 0 {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < time.Second {
+			return nil, fmt.Errorf("invalid RETENTION_TTL %q: expected a duration of at least 1s", value)
+		}
+		config.Default.Retention = ttl
+	}
+	return config, nil
+}
+
+// validate checks tenant IDs and limits
+func (c *TenantConfig) validate() error {
+	if err := c.Default.validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for tenant, limits := range c.Tenants {
+		if !ValidTenant(tenant) {
+			return fmt.Errorf("invalid tenant ID %q", tenant)
+		}
+		if err := limits.validate(); err != nil {
+			return fmt.Errorf("tenant %q: %w", tenant, err)
+		}
+	}
+	return nil
+}
+
+// Limits returns the effective limits of a tenant. A nil config has no limits.
+func (c *TenantConfig) Limits(tenant string) TenantLimits {
+	if c == nil {
+		return TenantLimits{}
+	}
+
+	limits := c.Default
+	if override, ok := c.Tenants[tenant]; ok {
+		if override.MaxLogs > 0 {
+			limits.MaxLogs = override.MaxLogs
+		}
+		if override.Retention > 0 {
+			limits.Retention = override.Retention
+		}
+	}
+	return limits
+}
+
+// quotaError describes a refused insert
+func quotaError(tenant string, limit int64) error {
+	if tenant == DefaultTenant {
+		return fmt.Errorf("%w: the default tenant may store at most %d logs", ErrQuotaExceeded, limit)
+	}
+	return fmt.Errorf("%w: tenant %q may store at most %d logs", ErrQuotaExceeded, tenant, limit)
+}
+
+// quotaRefreshInterval is how long an estimated log count is trusted before
+// it is estimated again
+const quotaRefreshInterval = 10 * time.Second
+
+// quotaCounter checks a tenant's quota against an estimate of its stored
+// logs, for backends where counting them exactly is expensive. The estimate
+// is refreshed periodically and advanced by inserts in between, so the
+// quota is approximate under concurrent writes.
+type quotaCounter struct {
+	tenant   string
+	maxLogs  int64
+	estimate func(ctx context.Context) (int64, error)
+
+	mutex     sync.Mutex
+	count     int64
+	countedAt time.Time
+}
+
+// newQuotaCounter creates a counter for a tenant's quota
+func newQuotaCounter(tenant string, limits TenantLimits, estimate func(ctx context.Context) (int64, error)) *quotaCounter {
+	return &quotaCounter{tenant: tenant, maxLogs: limits.MaxLogs, estimate: estimate}
+}
+
+// checkQuota returns ErrQuotaExceeded if n more logs would take the tenant
+// past its quota
+func (q *quotaCounter) checkQuota(ctx context.Context, n int) error {
+	if q.maxLogs <= 0 {
+		return nil
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if time.Since(q.countedAt) > quotaRefreshInterval {
+		count, err := q.estimate(ctx)
+		if err != nil {
+			return err
+		}
+		q.count, q.countedAt = count, time.Now()
+	}
+
+	if q.count+int64(n) > q.maxLogs {
+		return quotaError(q.tenant, q.maxLogs)
+	}
+	return nil
+}
+
+// inserted adds newly inserted logs to the count until it is next refreshed
+func (q *quotaCounter) inserted(n int) {
+	if q.maxLogs <= 0 {
+		return
+	}
+
+	q.mutex.Lock()
+	q.count += int64(n)
+	q.mutex.Unlock()
+}
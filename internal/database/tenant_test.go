@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"log-ingestor/internal/models"
+)
+
+func TestValidTenant(t *testing.T) {
+	valid := []string{"acme", "team-a", "team_b", "42"}
+	for _, id := range valid {
+		if !ValidTenant(id) {
+			t.Errorf("Expected %q to be a valid tenant ID", id)
+		}
+	}
+
+	invalid := []string{"", "Acme", "-acme", "a.b", "a/b", "a b", "abcdefghijklmnopqrstuvwxyz0123456"}
+	for _, id := range invalid {
+		if ValidTenant(id) {
+			t.Errorf("Expected %q to be an invalid tenant ID", id)
+		}
+	}
+}
+
+func TestTenantContext(t *testing.T) {
+	if tenant := TenantFromContext(context.Background()); tenant != DefaultTenant {
+		t.Errorf("Expected the default tenant, got %q", tenant)
+	}
+
+	ctx := WithTenant(context.Background(), "acme")
+	if tenant := TenantFromContext(ctx); tenant != "acme" {
+		t.Errorf("Expected tenant acme, got %q", tenant)
+	}
+}
+
+func TestLoadTenantConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	data := `{
+		"default": {"maxLogs": 1000, "retention": "720h"},
+		"tenants": {
+			"acme": {"maxLogs": 50},
+			"globex": {"retention": "24h"}
+		}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	config, err := LoadTenantConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Unset limits fall back to the default
+	tests := map[string]TenantLimits{
+		"acme":        {MaxLogs: 50, Retention: 720 * time.Hour},
+		"globex":      {MaxLogs: 1000, Retention: 24 * time.Hour},
+		"initech":     {MaxLogs: 1000, Retention: 720 * time.Hour},
+		DefaultTenant: {MaxLogs: 1000, Retention: 720 * time.Hour},
+	}
+	for tenant, expected := range tests {
+		if limits := config.Limits(tenant); limits != expected {
+			t.Errorf("Expected limits %+v for %q, got %+v", expected, tenant, limits)
+		}
+	}
+
+	// No file means no limits
+	config, err = LoadTenantConfig("")
+	if err != nil || config.Limits("acme") != (TenantLimits{}) {
+		t.Errorf("Expected an empty config, got %+v, %v", config, err)
+	}
+}
+
+func TestLoadTenantConfigInvalid(t *testing.T) {
+	tests := []string{
+		`{"tenants": {"Not Valid": {}}}`,
+		`{"default": {"maxLogs": -1}}`,
+		`{"default": {"retention": "forever"}}`,
+		`{"tenants": {"acme": {"retention": "10ms"}}}`,
+	}
+
+	for _, data := range tests {
+		path := filepath.Join(t.TempDir(), "tenants.json")
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		if _, err := LoadTenantConfig(path); err == nil {
+			t.Errorf("Expected an error for config %s", data)
+		}
+	}
+}
+
+func TestMockDBTenantIsolation(t *testing.T) {
+	mockDB := NewMockDB()
+	acme := WithTenant(context.Background(), "acme")
+	globex := WithTenant(context.Background(), "globex")
+
+	if err := mockDB.InsertLog(acme, &models.Log{Level: "error", Message: "acme failure", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Failed to insert log: %v", err)
+	}
+	if err := mockDB.InsertLogs(globex, []*models.Log{
+		{Level: "error", Message: "globex failure", Timestamp: time.Now()},
+		{Level: "info", Message: "globex started", Timestamp: time.Now()},
+	}); err != nil {
+		t.Fatalf("Failed to insert logs: %v", err)
+	}
+
+	// Each tenant sees only its own logs
+	tests := []struct {
+		ctx      context.Context
+		expected int64
+	}{
+		{acme, 1},
+		{globex, 2},
+		{context.Background(), 0},
+	}
+	for _, test := range tests {
+		query := &models.LogQuery{Level: "error"}
+		logs, err := mockDB.QueryLogs(test.ctx, query)
+		if err != nil {
+			t.Fatalf("Failed to query logs: %v", err)
+		}
+		if len(logs) > 1 {
+			t.Errorf("Expected at most one error log for %q, got %d", TenantFromContext(test.ctx), len(logs))
+		}
+
+		count, err := mockDB.CountLogs(test.ctx, &models.LogQuery{}, 0)
+		if err != nil {
+			t.Fatalf("Failed to count logs: %v", err)
+		}
+		if count != test.expected {
+			t.Errorf("Expected %d logs for %q, got %d", test.expected, TenantFromContext(test.ctx), count)
+		}
+	}
+
+	logs, _ := mockDB.QueryLogs(acme, &models.LogQuery{})
+	if len(logs) != 1 || logs[0].Message != "acme failure" {
+		t.Errorf("Expected only acme's log, got %+v", logs)
+	}
+}
+
+func TestMockDBTenantQuota(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.TenantConfig = &TenantConfig{
+		Tenants: map[string]TenantLimits{"acme": {MaxLogs: 2}},
+	}
+	acme := WithTenant(context.Background(), "acme")
+
+	if err := mockDB.InsertLog(acme, &models.Log{Level: "info", Message: "one", Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Failed to insert log: %v", err)
+	}
+
+	// A batch that does not fit is refused as a whole
+	batch := []*models.Log{
+		{Level: "info", Message: "two", Timestamp: time.Now()},
+		{Level: "info", Message: "three", Timestamp: time.Now()},
+	}
+	if err := mockDB.CheckQuota(acme, len(batch)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded from CheckQuota, got %v", err)
+	}
+	if err := mockDB.InsertLogs(acme, batch); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded from InsertLogs, got %v", err)
+	}
+	if count, _ := mockDB.CountLogs(acme, &models.LogQuery{}, 0); count != 1 {
+		t.Errorf("Expected 1 log after the refused batch, got %d", count)
+	}
+
+	if err := mockDB.InsertLogs(acme, batch[:1]); err != nil {
+		t.Errorf("Expected the last log to fit, got %v", err)
+	}
+
+	// Other tenants are unaffected
+	if err := mockDB.InsertLogs(context.Background(), batch); err != nil {
+		t.Errorf("Expected the default tenant to be unlimited, got %v", err)
+	}
+}
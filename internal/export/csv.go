@@ -0,0 +1,44 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+
+	"log-ingestor/internal/models"
+)
+
+// csvWriter writes logs as CSV rows under a header of Columns. Missing
+// metadata is written as an empty field.
+type csvWriter struct {
+	writer       *csv.Writer
+	metadataKeys []string
+}
+
+// newCSVWriter writes the header and returns a writer for the rows
+func newCSVWriter(w io.Writer, metadataKeys []string) (*csvWriter, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(Columns(metadataKeys)); err != nil {
+		return nil, err
+	}
+	return &csvWriter{writer: writer, metadataKeys: metadataKeys}, nil
+}
+
+// Write writes a log as a row
+func (w *csvWriter) Write(log *models.Log) error {
+	record := fieldValues(log)
+	for _, key := range w.metadataKeys {
+		record = append(record, log.Metadata[key])
+	}
+
+	other, err := otherMetadata(log, w.metadataKeys)
+	if err != nil {
+		return err
+	}
+	return w.writer.Write(append(record, other))
+}
+
+// Close flushes buffered rows
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
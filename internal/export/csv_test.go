@@ -0,0 +1,35 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+)
+
+func TestCSV(t *testing.T) {
+	logs := exportLogs()
+	data := writeAll(t, CSV, []string{"region", "userId"}, logs)
+
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+
+	expected := [][]string{
+		{"id", "timestamp", "level", "message", "resourceId", "traceId", "spanId", "commit", "metadata.region", "metadata.userId", "metadata"},
+		{"650400000000000000000003", "2023-09-15T08:00:00.123456Z", "error", "Failed to connect, retrying", "server-1", "trace-1", "span-1", "5e5342f", "eu", "42", ""},
+		{"650400000000000000000002", "2023-09-15T07:59:00.123456Z", "info", "ok", "", "", "", "", "", "", `{"requestId":"r-1"}`},
+		{"650400000000000000000001", "2023-09-15T07:00:00.123456Z", "debug", "quote \"this\"\nand a newline", "", "", "", "", "", "", ""},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expected records\n%q\ngot\n%q", expected, records)
+	}
+}
+
+func TestCSVWithoutLogs(t *testing.T) {
+	data := writeAll(t, CSV, nil, nil)
+	if string(data) != "id,timestamp,level,message,resourceId,traceId,spanId,commit,metadata\n" {
+		t.Errorf("Expected only a header, got %q", data)
+	}
+}
@@ -0,0 +1,148 @@
+// Package export writes logs in file formats for bulk download: NDJSON,
+// CSV and Parquet.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"log-ingestor/internal/models"
+)
+
+// Export formats
+const (
+	NDJSON  = "ndjson"
+	CSV     = "csv"
+	Parquet = "parquet"
+)
+
+// Formats lists the supported export formats
+var Formats = []string{NDJSON, CSV, Parquet}
+
+// Writer writes logs to an export file. Close must be called to complete
+// the file; it does not close the underlying writer.
+type Writer interface {
+	Write(log *models.Log) error
+	Close() error
+}
+
+// NewWriter returns a writer for the format. CSV and Parquet files have a
+// column per metadata key in metadataKeys; see Columns.
+func NewWriter(format string, w io.Writer, metadataKeys []string) (Writer, error) {
+	switch format {
+	case NDJSON:
+		return &ndjsonWriter{encoder: json.NewEncoder(w)}, nil
+	case CSV:
+		return newCSVWriter(w, metadataKeys)
+	case Parquet:
+		return newParquetWriter(w, metadataKeys), nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q: expected ndjson, csv or parquet", format)
+	}
+}
+
+// Flat reports whether a format lays logs out in columns, and so needs
+// the metadata keys up front
+func Flat(format string) bool {
+	return format == CSV || format == Parquet
+}
+
+// ContentType returns the media type of a format
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case Parquet:
+		return "application/vnd.apache.parquet"
+	default:
+		return "application/x-ndjson"
+	}
+}
+
+// fieldColumns are the columns holding a log's fields, before its metadata
+var fieldColumns = []string{"id", "timestamp", "level", "message", "resourceId", "traceId", "spanId", "commit"}
+
+// MetadataColumn holds, as a JSON object, the metadata of a log whose keys
+// have no column of their own
+const MetadataColumn = "metadata"
+
+// Columns returns the columns of a flat export: the log's fields, a
+// metadata.<key> column for each of metadataKeys, and MetadataColumn
+func Columns(metadataKeys []string) []string {
+	columns := append([]string(nil), fieldColumns...)
+	for _, key := range metadataKeys {
+		columns = append(columns, "metadata."+key)
+	}
+	return append(columns, MetadataColumn)
+}
+
+// MetadataKeys returns the metadata keys of logs in sorted order
+func MetadataKeys(logs []*models.Log) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, log := range logs {
+		for key := range log.Metadata {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fieldValues returns the values of a log's field columns, with the
+// timestamp in RFC 3339 format
+func fieldValues(log *models.Log) []string {
+	return []string{
+		log.ID,
+		log.Timestamp.UTC().Format(time.RFC3339Nano),
+		log.Level,
+		log.Message,
+		log.ResourceID,
+		log.TraceID,
+		log.SpanID,
+		log.Commit,
+	}
+}
+
+// otherMetadata returns the metadata of a log not in metadataKeys as a JSON
+// object, or "" when there is none
+func otherMetadata(log *models.Log, metadataKeys []string) (string, error) {
+	if len(log.Metadata) == 0 {
+		return "", nil
+	}
+
+	other := make(map[string]string)
+	for key, value := range log.Metadata {
+		other[key] = value
+	}
+	for _, key := range metadataKeys {
+		delete(other, key)
+	}
+	if len(other) == 0 {
+		return "", nil
+	}
+
+	data, err := json.Marshal(other)
+	return string(data), err
+}
+
+// ndjsonWriter writes each log as a line of JSON
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+// Write writes a log as a line
+func (w *ndjsonWriter) Write(log *models.Log) error {
+	return w.encoder.Encode(log)
+}
+
+// Close does nothing, as NDJSON has no footer
+func (w *ndjsonWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"log-ingestor/internal/models"
+)
+
+// exportLogs returns logs with differing metadata
+func exportLogs() []*models.Log {
+	base := time.Date(2023, 9, 15, 8, 0, 0, 123456000, time.UTC)
+	return []*models.Log{
+		{ID: "650400000000000000000003", Timestamp: base, Level: "error", Message: "Failed to connect, retrying", ResourceID: "server-1", TraceID: "trace-1", SpanID: "span-1", Commit: "5e5342f", Metadata: map[string]string{"region": "eu", "userId": "42"}},
+		{ID: "650400000000000000000002", Timestamp: base.Add(-time.Minute), Level: "info", Message: "ok", Metadata: map[string]string{"requestId": "r-1"}},
+		{ID: "650400000000000000000001", Timestamp: base.Add(-time.Hour), Level: "debug", Message: "quote \"this\"\nand a newline"},
+	}
+}
+
+// writeAll writes logs in a format and returns the file
+func writeAll(t *testing.T, format string, metadataKeys []string, logs []*models.Log) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer, err := NewWriter(format, &buf, metadataKeys)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	for _, log := range logs {
+		if err := writer.Write(log); err != nil {
+			t.Fatalf("Failed to write log: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestNDJSON(t *testing.T) {
+	logs := exportLogs()
+	data := writeAll(t, NDJSON, nil, logs)
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != len(logs) {
+		t.Fatalf("Expected %d lines, got %d: %s", len(logs), len(lines), data)
+	}
+	for i, line := range lines {
+		var log models.Log
+		if err := json.Unmarshal([]byte(line), &log); err != nil {
+			t.Fatalf("Failed to parse line %d: %v", i, err)
+		}
+		if log.ID != logs[i].ID || log.Message != logs[i].Message || !reflect.DeepEqual(log.Metadata, logs[i].Metadata) {
+			t.Errorf("Line %d: expected %+v, got %+v", i, logs[i], log)
+		}
+	}
+}
+
+func TestMetadataKeys(t *testing.T) {
+	keys := MetadataKeys(exportLogs())
+	if !reflect.DeepEqual(keys, []string{"region", "requestId", "userId"}) {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+	if keys := MetadataKeys(nil); len(keys) != 0 {
+		t.Errorf("Expected no keys, got %v", keys)
+	}
+}
+
+func TestNewWriterUnknownFormat(t *testing.T) {
+	if _, err := NewWriter("xml", &bytes.Buffer{}, nil); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+	if !Flat(CSV) || !Flat(Parquet) || Flat(NDJSON) {
+		t.Error("Expected CSV and Parquet to be flat and NDJSON not")
+	}
+}
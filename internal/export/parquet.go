@@ -0,0 +1,429 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/golang/snappy"
+
+	"log-ingestor/internal/models"
+)
+
+// Values from the Parquet format's Thrift definitions (parquet.thrift)
+const (
+	// Physical types
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	// Repetition types
+	parquetRequired = 0
+	parquetOptional = 1
+
+	// Converted types
+	parquetUTF8            = 0
+	parquetTimestampMicros = 10
+
+	// Encodings
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	// Compression codecs
+	parquetSnappy = 1
+
+	// Page types
+	parquetDataPage = 0
+)
+
+// parquetMagic starts and ends every Parquet file
+const parquetMagic = "PAR1"
+
+// parquetRowGroupSize is how many rows are buffered before being written
+// as a row group
+const parquetRowGroupSize = 10000
+
+// parquetColumn is a column of a Parquet export and the values buffered
+// for the current row group
+type parquetColumn struct {
+	name     string
+	int64    bool
+	optional bool
+
+	// values holds the PLAIN encoding of the non-null values, and levels
+	// the definition level of each row of an optional column: 1 for a
+	// value, 0 for null
+	values bytes.Buffer
+	levels []byte
+}
+
+// parquetChunk describes a column's data written for a row group
+type parquetChunk struct {
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+// parquetRowGroup describes a written row group
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	rows   int64
+}
+
+// parquetWriter writes logs as a Parquet file with one data page per column
+// per row group, compressed with Snappy. Timestamps are stored in
+// microseconds and other fields as UTF-8 strings; metadata columns and
+// MetadataColumn are optional, the rest required.
+type parquetWriter struct {
+	w            io.Writer
+	offset       int64
+	metadataKeys []string
+	columns      []*parquetColumn
+	rows         int
+	rowGroups    []parquetRowGroup
+}
+
+// newParquetWriter returns a writer for logs with the columns of Columns
+func newParquetWriter(w io.Writer, metadataKeys []string) *parquetWriter {
+	writer := &parquetWriter{w: w, metadataKeys: metadataKeys}
+	for i, name := range Columns(metadataKeys) {
+		writer.columns = append(writer.columns, &parquetColumn{
+			name:     name,
+			int64:    name == "timestamp",
+			optional: i >= len(fieldColumns),
+		})
+	}
+	return writer
+}
+
+// Write buffers a log as a row, writing a row group when enough are buffered
+func (w *parquetWriter) Write(log *models.Log) error {
+	for i, value := range fieldValues(log) {
+		if w.columns[i].int64 {
+			w.columns[i].appendInt64(log.Timestamp.UnixMicro())
+			continue
+		}
+		w.columns[i].appendString(value)
+	}
+
+	for i, key := range w.metadataKeys {
+		value, ok := log.Metadata[key]
+		w.columns[len(fieldColumns)+i].appendOptional(value, ok)
+	}
+
+	other, err := otherMetadata(log, w.metadataKeys)
+	if err != nil {
+		return err
+	}
+	w.columns[len(w.columns)-1].appendOptional(other, other != "")
+
+	if w.rows++; w.rows == parquetRowGroupSize {
+		return w.writeRowGroup()
+	}
+	return nil
+}
+
+// Close writes the buffered rows and the file footer
+func (w *parquetWriter) Close() error {
+	if w.rows > 0 {
+		if err := w.writeRowGroup(); err != nil {
+			return err
+		}
+	}
+	if err := w.start(); err != nil {
+		return err
+	}
+
+	footer := w.footer()
+	trailer := binary.LittleEndian.AppendUint32(nil, uint32(len(footer)))
+	return w.write(footer, trailer, []byte(parquetMagic))
+}
+
+// start writes the leading magic number before anything else
+func (w *parquetWriter) start() error {
+	if w.offset > 0 {
+		return nil
+	}
+	return w.write([]byte(parquetMagic))
+}
+
+// write writes data, keeping track of the offset in the file
+func (w *parquetWriter) write(data ...[]byte) error {
+	for _, part := range data {
+		n, err := w.w.Write(part)
+		w.offset += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRowGroup writes each column's buffered values as a data page
+func (w *parquetWriter) writeRowGroup() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+
+	group := parquetRowGroup{rows: int64(w.rows)}
+	for _, column := range w.columns {
+		var page []byte
+		if column.optional {
+			levels := rleLevels(column.levels)
+			page = binary.LittleEndian.AppendUint32(page, uint32(len(levels)))
+			page = append(page, levels...)
+		}
+		page = append(page, column.values.Bytes()...)
+		compressed := snappy.Encode(nil, page)
+
+		header := pageHeader(w.rows, len(page), len(compressed))
+		chunk := parquetChunk{
+			offset:           w.offset,
+			uncompressedSize: int64(len(header) + len(page)),
+			compressedSize:   int64(len(header) + len(compressed)),
+		}
+		if err := w.write(header, compressed); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+
+		column.values.Reset()
+		column.levels = column.levels[:0]
+	}
+
+	w.rowGroups = append(w.rowGroups, group)
+	w.rows = 0
+	return nil
+}
+
+// appendString adds a required string value
+func (c *parquetColumn) appendString(value string) {
+	c.values.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(value))))
+	c.values.WriteString(value)
+}
+
+// appendInt64 adds a required integer value
+func (c *parquetColumn) appendInt64(value int64) {
+	c.values.Write(binary.LittleEndian.AppendUint64(nil, uint64(value)))
+}
+
+// appendOptional adds an optional string value, which is null unless ok
+func (c *parquetColumn) appendOptional(value string, ok bool) {
+	if !ok {
+		c.levels = append(c.levels, 0)
+		return
+	}
+	c.levels = append(c.levels, 1)
+	c.appendString(value)
+}
+
+// rleLevels encodes definition levels of bit width 1 as runs of the
+// RLE/bit-packing hybrid encoding
+func rleLevels(levels []byte) []byte {
+	var encoded []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		encoded = binary.AppendUvarint(encoded, uint64(j-i)<<1)
+		encoded = append(encoded, levels[i])
+		i = j
+	}
+	return encoded
+}
+
+// pageHeader encodes the header of a data page of PLAIN values
+func pageHeader(rows, uncompressedSize, compressedSize int) []byte {
+	t := &thriftWriter{}
+	t.begin()
+	t.i32(1, parquetDataPage)
+	t.i32(2, int32(uncompressedSize))
+	t.i32(3, int32(compressedSize))
+	t.structField(5)
+	t.i32(1, int32(rows))
+	t.i32(2, parquetPlain)
+	t.i32(3, parquetRLE)
+	t.i32(4, parquetRLE)
+	t.end()
+	t.end()
+	return t.buf.Bytes()
+}
+
+// footer encodes the file metadata: the schema and the row groups written
+func (w *parquetWriter) footer() []byte {
+	var rows int64
+	for _, group := range w.rowGroups {
+		rows += group.rows
+	}
+
+	t := &thriftWriter{}
+	t.begin()
+	t.i32(1, 1)
+
+	// The schema is a root with a child per column
+	t.list(2, thriftStruct, len(w.columns)+1)
+	t.begin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(w.columns)))
+	t.end()
+	for _, column := range w.columns {
+		t.begin()
+		if column.int64 {
+			t.i32(1, parquetInt64)
+		} else {
+			t.i32(1, parquetByteArray)
+		}
+		if column.optional {
+			t.i32(3, parquetOptional)
+		} else {
+			t.i32(3, parquetRequired)
+		}
+		t.binary(4, column.name)
+		if column.int64 {
+			t.i32(6, parquetTimestampMicros)
+		} else {
+			t.i32(6, parquetUTF8)
+		}
+		t.end()
+	}
+
+	t.i64(3, rows)
+
+	t.list(4, thriftStruct, len(w.rowGroups))
+	for _, group := range w.rowGroups {
+		t.begin()
+		t.list(1, thriftStruct, len(group.chunks))
+		var size int64
+		for i, chunk := range group.chunks {
+			column := w.columns[i]
+			size += chunk.uncompressedSize
+
+			t.begin()
+			t.i64(2, chunk.offset)
+			t.structField(3)
+			if column.int64 {
+				t.i32(1, parquetInt64)
+			} else {
+				t.i32(1, parquetByteArray)
+			}
+			t.list(2, thriftI32, 2)
+			t.listI32(parquetPlain)
+			t.listI32(parquetRLE)
+			t.list(3, thriftBinary, 1)
+			t.listBinary(column.name)
+			t.i32(4, parquetSnappy)
+			t.i64(5, group.rows)
+			t.i64(6, chunk.uncompressedSize)
+			t.i64(7, chunk.compressedSize)
+			t.i64(9, chunk.offset)
+			t.end()
+			t.end()
+		}
+		t.i64(2, size)
+		t.i64(3, group.rows)
+		t.end()
+	}
+
+	t.binary(6, "log-ingestor")
+	t.end()
+	return t.buf.Bytes()
+}
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs in the Thrift compact protocol, which
+// Parquet uses for its page headers and footer. Fields must be written in
+// ascending order of ID within each struct.
+type thriftWriter struct {
+	buf    bytes.Buffer
+	lastID int16
+	stack  []int16
+}
+
+// begin starts a struct
+func (t *thriftWriter) begin() {
+	t.stack = append(t.stack, t.lastID)
+	t.lastID = 0
+}
+
+// end ends the current struct
+func (t *thriftWriter) end() {
+	t.buf.WriteByte(0)
+	t.lastID = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+// field writes a field header, as a delta from the previous field's ID
+// when it is small
+func (t *thriftWriter) field(id int16, fieldType byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		t.buf.WriteByte(fieldType)
+		t.varint(zigzag(int64(id)))
+	}
+	t.lastID = id
+}
+
+// varint writes an unsigned variable-length integer
+func (t *thriftWriter) varint(v uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+// zigzag maps signed integers to unsigned ones with small magnitudes kept small
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+// i32 writes a 32-bit integer field
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+// i64 writes a 64-bit integer field
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+// binary writes a string field
+func (t *thriftWriter) binary(id int16, v string) {
+	t.field(id, thriftBinary)
+	t.listBinary(v)
+}
+
+// structField starts a struct field, ended with end
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+// list writes the header of a list field of size elements, which follow
+func (t *thriftWriter) list(id int16, elementType byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elementType)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elementType)
+	t.varint(uint64(size))
+}
+
+// listI32 writes a 32-bit integer list element
+func (t *thriftWriter) listI32(v int32) {
+	t.varint(zigzag(int64(v)))
+}
+
+// listBinary writes a string list element
+func (t *thriftWriter) listBinary(v string) {
+	t.varint(uint64(len(v)))
+	t.buf.WriteString(v)
+}
@@ -0,0 +1,235 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/golang/snappy"
+
+	"log-ingestor/internal/models"
+)
+
+// thriftReader decodes Thrift compact protocol structs into maps of field
+// ID to value, enough to check the files parquetWriter writes
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) byte() byte {
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) value(fieldType byte) interface{} {
+	switch fieldType {
+	case thriftI32, thriftI64:
+		v := r.uvarint()
+		return int64(v>>1) ^ -int64(v&1)
+	case thriftBinary:
+		n := int(r.uvarint())
+		r.pos += n
+		return string(r.data[r.pos-n : r.pos])
+	case thriftList:
+		header := r.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = r.value(header & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	panic(fmt.Sprintf("unexpected Thrift type %d", fieldType))
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var id int16
+	for {
+		header := r.byte()
+		if header == 0 {
+			return fields
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			v := r.uvarint()
+			id = int16(int64(v>>1) ^ -int64(v&1))
+		}
+		fields[id] = r.value(header & 0x0f)
+	}
+}
+
+// parquetFooter checks the magic numbers of a Parquet file and decodes its
+// metadata
+func parquetFooter(t *testing.T, data []byte) map[int16]interface{} {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte(parquetMagic)) || !bytes.HasSuffix(data, []byte(parquetMagic)) {
+		t.Fatalf("Expected the file to start and end with %s", parquetMagic)
+	}
+	length := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	reader := &thriftReader{data: data[len(data)-8-length : len(data)-8]}
+	footer := reader.readStruct()
+	if reader.pos != length {
+		t.Fatalf("Footer decoded %d bytes of %d", reader.pos, length)
+	}
+	return footer
+}
+
+// parquetPage decodes the header of the data page at offset and returns
+// its uncompressed contents
+func parquetPage(t *testing.T, data []byte, offset int64) []byte {
+	t.Helper()
+	reader := &thriftReader{data: data, pos: int(offset)}
+	header := reader.readStruct()
+	compressedSize := int(header[3].(int64))
+	page, err := snappy.Decode(nil, data[reader.pos:reader.pos+compressedSize])
+	if err != nil {
+		t.Fatalf("Failed to decompress page: %v", err)
+	}
+	if int64(len(page)) != header[2].(int64) {
+		t.Fatalf("Expected %d uncompressed bytes, got %d", header[2], len(page))
+	}
+	return page
+}
+
+// plainStrings decodes PLAIN byte array values
+func plainStrings(data []byte) []string {
+	var values []string
+	for len(data) > 0 {
+		n := binary.LittleEndian.Uint32(data)
+		values = append(values, string(data[4:4+n]))
+		data = data[4+n:]
+	}
+	return values
+}
+
+func TestParquet(t *testing.T) {
+	logs := exportLogs()
+	data := writeAll(t, Parquet, []string{"region"}, logs)
+	footer := parquetFooter(t, data)
+
+	if footer[3] != int64(3) {
+		t.Errorf("Expected 3 rows, got %v", footer[3])
+	}
+
+	// The schema is the root followed by a column per Columns
+	var names []string
+	schema := footer[2].([]interface{})
+	for _, element := range schema[1:] {
+		names = append(names, element.(map[int16]interface{})[4].(string))
+	}
+	if !reflect.DeepEqual(names, Columns([]string{"region"})) {
+		t.Errorf("Unexpected columns %v", names)
+	}
+	timestamp := schema[2].(map[int16]interface{})
+	if timestamp[1] != int64(parquetInt64) || timestamp[6] != int64(parquetTimestampMicros) || timestamp[3] != int64(parquetRequired) {
+		t.Errorf("Unexpected timestamp column %v", timestamp)
+	}
+	if region := schema[9].(map[int16]interface{}); region[3] != int64(parquetOptional) {
+		t.Errorf("Expected metadata columns to be optional, got %v", region)
+	}
+
+	rowGroups := footer[4].([]interface{})
+	if len(rowGroups) != 1 {
+		t.Fatalf("Expected 1 row group, got %d", len(rowGroups))
+	}
+	chunks := rowGroups[0].(map[int16]interface{})[1].([]interface{})
+	pageOffset := func(column int) int64 {
+		return chunks[column].(map[int16]interface{})[3].(map[int16]interface{})[9].(int64)
+	}
+
+	// Required columns hold their values only
+	if levels := plainStrings(parquetPage(t, data, pageOffset(2))); !reflect.DeepEqual(levels, []string{"error", "info", "debug"}) {
+		t.Errorf("Unexpected levels %v", levels)
+	}
+	timestamps := parquetPage(t, data, pageOffset(1))
+	if micros := int64(binary.LittleEndian.Uint64(timestamps)); micros != logs[0].Timestamp.UnixMicro() {
+		t.Errorf("Expected timestamp %d, got %d", logs[0].Timestamp.UnixMicro(), micros)
+	}
+
+	// Optional columns start with their definition levels
+	region := parquetPage(t, data, pageOffset(8))
+	n := binary.LittleEndian.Uint32(region)
+	if levels := region[4 : 4+n]; !bytes.Equal(levels, rleLevels([]byte{1, 0, 0})) {
+		t.Errorf("Unexpected definition levels %v", levels)
+	}
+	if values := plainStrings(region[4+n:]); !reflect.DeepEqual(values, []string{"eu"}) {
+		t.Errorf("Unexpected regions %v", values)
+	}
+	other := parquetPage(t, data, pageOffset(9))
+	n = binary.LittleEndian.Uint32(other)
+	if values := plainStrings(other[4+n:]); !reflect.DeepEqual(values, []string{`{"userId":"42"}`, `{"requestId":"r-1"}`}) {
+		t.Errorf("Unexpected other metadata %v", values)
+	}
+}
+
+func TestParquetRowGroups(t *testing.T) {
+	logs := make([]*models.Log, parquetRowGroupSize+1)
+	for i := range logs {
+		logs[i] = &models.Log{ID: fmt.Sprint(i), Level: "info"}
+	}
+	footer := parquetFooter(t, writeAll(t, Parquet, nil, logs))
+
+	rowGroups := footer[4].([]interface{})
+	if footer[3] != int64(len(logs)) || len(rowGroups) != 2 {
+		t.Fatalf("Expected %d rows in 2 row groups, got %v rows in %d", len(logs), footer[3], len(rowGroups))
+	}
+	if rows := rowGroups[1].(map[int16]interface{})[3]; rows != int64(1) {
+		t.Errorf("Expected 1 row in the last row group, got %v", rows)
+	}
+}
+
+func TestParquetWithoutLogs(t *testing.T) {
+	footer := parquetFooter(t, writeAll(t, Parquet, nil, nil))
+	if footer[3] != int64(0) || len(footer[4].([]interface{})) != 0 {
+		t.Errorf("Expected no rows, got %v", footer)
+	}
+}
+
+func TestRLELevels(t *testing.T) {
+	tests := []struct {
+		levels   []byte
+		expected []byte
+	}{
+		{nil, nil},
+		{[]byte{1, 1, 1}, []byte{3 << 1, 1}},
+		{[]byte{1, 0, 0, 1}, []byte{1 << 1, 1, 2 << 1, 0, 1 << 1, 1}},
+		{bytes.Repeat([]byte{0}, 100), []byte{0xc8, 0x01, 0}},
+	}
+	for _, tt := range tests {
+		if encoded := rleLevels(tt.levels); !bytes.Equal(encoded, tt.expected) {
+			t.Errorf("rleLevels(%v) = %v, expected %v", tt.levels, encoded, tt.expected)
+		}
+	}
+}
+
+func TestThriftWriterFieldIDs(t *testing.T) {
+	// Field IDs more than 15 apart are written in full
+	w := &thriftWriter{}
+	w.begin()
+	w.i32(1, -2)
+	w.binary(20, "x")
+	w.i64(21, 1<<40)
+	w.end()
+
+	fields := (&thriftReader{data: w.buf.Bytes()}).readStruct()
+	if fields[1] != int64(-2) || fields[20] != "x" || fields[21] != int64(1<<40) {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+}
@@ -0,0 +1,423 @@
+package ingestor
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"log-ingestor/internal/database"
+	"log-ingestor/internal/models"
+)
+
+// maxFlushBackoff caps the delay between flush retries
+const maxFlushBackoff = 30 * time.Second
+
+var (
+	// ErrQueueFull is returned when the buffer cannot accept more entries
+	ErrQueueFull = errors.New("ingestion queue is full")
+
+	// ErrBufferClosed is returned when entries are enqueued after Close
+	ErrBufferClosed = errors.New("ingestion buffer is closed")
+)
+
+// BufferConfig configures the write-behind buffer
+type BufferConfig struct {
+	// QueueSize is the maximum number of entries waiting to be flushed
+	QueueSize int
+
+	// BatchSize is the number of entries that triggers a flush
+	BatchSize int
+
+	// FlushInterval is the longest an entry waits before being flushed
+	FlushInterval time.Duration
+
+	// Workers is the number of goroutines flushing to the database
+	Workers int
+
+	// FlushTimeout bounds a single flush attempt
+	FlushTimeout time.Duration
+
+	// MaxRetries is the number of times a failed flush is retried. Buffers
+	// backed by a write-ahead log retry until the flush succeeds instead,
+	// unless the database rejected the batch.
+	MaxRetries int
+}
+
+// DefaultBufferConfig returns the default buffer configuration
+func DefaultBufferConfig() BufferConfig {
+	return BufferConfig{
+		QueueSize:     10000,
+		BatchSize:     500,
+		FlushInterval: time.Second,
+		Workers:       4,
+		FlushTimeout:  10 * time.Second,
+		MaxRetries:    3,
+	}
+}
+
+// BufferStats is a snapshot of the buffer's counters
+type BufferStats struct {
+	QueueDepth         int64   `json:"queueDepth"`
+	QueueCapacity      int     `json:"queueCapacity"`
+	Enqueued           int64   `json:"enqueued"`
+	Flushed            int64   `json:"flushed"`
+	Rejected           int64   `json:"rejected"`
+	Dropped            int64   `json:"dropped"`
+	Flushes            int64   `json:"flushes"`
+	FailedFlushes      int64   `json:"failedFlushes"`
+	LastFlushLatencyMs float64 `json:"lastFlushLatencyMs"`
+	AvgFlushLatencyMs  float64 `json:"avgFlushLatencyMs"`
+}
+
+// queuedLog is an entry waiting in the buffer along with its tenant and the
+// WAL segment it was appended to, if any
+type queuedLog struct {
+	entry   *models.Log
+	tenant  string
+	segment uint64
+}
+
+// Buffer accepts log entries and writes them to the database in batches
+// from a pool of worker goroutines
+type Buffer struct {
+	db     database.DB
+	config BufferConfig
+	queue  chan queuedLog
+	wal    *WAL
+
+	// stop is closed when Close gives up waiting, abandoning retries;
+	// stopOnce lets Close give up more than once
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// pending counts entries accepted but not yet written, so a batch is
+	// accepted or rejected as a whole and memory stays bounded by QueueSize
+	pending atomic.Int64
+
+	mutex  sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+
+	enqueued         atomic.Int64
+	flushed          atomic.Int64
+	rejected         atomic.Int64
+	dropped          atomic.Int64
+	flushes          atomic.Int64
+	failedFlushes    atomic.Int64
+	lastFlushLatency atomic.Int64
+	totalFlushTime   atomic.Int64
+}
+
+// NewBuffer creates a buffer and starts its flush workers
+func NewBuffer(db database.DB, config BufferConfig) *Buffer {
+	return newBuffer(db, config, nil)
+}
+
+// NewDurableBuffer creates a buffer that appends entries to a write-ahead log
+// before acknowledging them. Entries left in the log by a previous run are
+// replayed before the buffer is returned.
+func NewDurableBuffer(db database.DB, config BufferConfig, walConfig WALConfig) (*Buffer, error) {
+	wal, replay, err := OpenWAL(walConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	b := newBuffer(db, config, wal)
+
+	// Replayed entries bypass the capacity check; workers drain them as usual
+	for _, batch := range replay {
+		log.Printf("Replaying %d logs from WAL segment %d", len(batch.Entries), batch.Segment)
+		b.pending.Add(int64(len(batch.Entries)))
+		for _, entry := range batch.Entries {
+			b.queue <- queuedLog{entry: entry, tenant: batch.Tenant, segment: batch.Segment}
+		}
+	}
+
+	return b, nil
+}
+
+// newBuffer creates a buffer with an optional write-ahead log
+func newBuffer(db database.DB, config BufferConfig, wal *WAL) *Buffer {
+	defaults := DefaultBufferConfig()
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaults.FlushInterval
+	}
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.FlushTimeout <= 0 {
+		config.FlushTimeout = defaults.FlushTimeout
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+
+	b := &Buffer{
+		db:     db,
+		config: config,
+		queue:  make(chan queuedLog, config.QueueSize),
+		wal:    wal,
+		stop:   make(chan struct{}),
+	}
+
+	for i := 0; i < config.Workers; i++ {
+		b.wg.Add(1)
+		go b.worker()
+	}
+
+	return b
+}
+
+// Enqueue adds a tenant's entries to the buffer, giving those without an
+// ID a new one. Either all entries are accepted or, when the queue lacks
+// room for them, none are and ErrQueueFull is returned.
+func (b *Buffer) Enqueue(tenant string, entries ...*models.Log) error {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if b.closed {
+		return ErrBufferClosed
+	}
+
+	// Reserve queue slots so the sends below never block
+	n := int64(len(entries))
+	for {
+		pending := b.pending.Load()
+		if pending+n > int64(b.config.QueueSize) {
+			b.rejected.Add(n)
+			return ErrQueueFull
+		}
+		if b.pending.CompareAndSwap(pending, pending+n) {
+			break
+		}
+	}
+
+	// IDs are assigned before the entries reach the write-ahead log, so a
+	// replayed batch the database already stored is skipped or replaced
+	// rather than stored again under new IDs
+	assignIDs(entries)
+
+	// Persist entries to the write-ahead log before acknowledging them
+	var segment uint64
+	if b.wal != nil {
+		var err error
+		if segment, err = b.wal.Append(tenant, entries); err != nil {
+			b.pending.Add(-n)
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		b.queue <- queuedLog{entry: entry, tenant: tenant, segment: segment}
+	}
+	b.enqueued.Add(n)
+	return nil
+}
+
+// assignIDs gives entries without an ID a new one
+func assignIDs(entries []*models.Log) {
+	for _, entry := range entries {
+		if entry.ID == "" {
+			entry.ID = database.NewLogID()
+		}
+	}
+}
+
+// Close stops accepting entries and waits for queued entries to be flushed.
+// If ctx expires first, pending retries are abandoned; entries that were
+// written to the WAL are replayed on the next start.
+func (b *Buffer) Close(ctx context.Context) error {
+	b.mutex.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		b.stopOnce.Do(func() { close(b.stop) })
+		err = ctx.Err()
+	}
+
+	if b.wal != nil {
+		if walErr := b.wal.Close(); walErr != nil && err == nil {
+			err = walErr
+		}
+	}
+	return err
+}
+
+// Stats returns a snapshot of the buffer's counters
+func (b *Buffer) Stats() BufferStats {
+	stats := BufferStats{
+		QueueDepth:         b.pending.Load(),
+		QueueCapacity:      b.config.QueueSize,
+		Enqueued:           b.enqueued.Load(),
+		Flushed:            b.flushed.Load(),
+		Rejected:           b.rejected.Load(),
+		Dropped:            b.dropped.Load(),
+		Flushes:            b.flushes.Load(),
+		FailedFlushes:      b.failedFlushes.Load(),
+		LastFlushLatencyMs: durationMs(time.Duration(b.lastFlushLatency.Load())),
+	}
+	if stats.Flushes > 0 {
+		stats.AvgFlushLatencyMs = durationMs(time.Duration(b.totalFlushTime.Load() / stats.Flushes))
+	}
+	return stats
+}
+
+// worker collects entries into batches and flushes them on size or time
+func (b *Buffer) worker() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]queuedLog, 0, b.config.BatchSize)
+	for {
+		select {
+		case item, ok := <-b.queue:
+			if !ok {
+				b.flush(batch)
+				return
+			}
+			batch = append(batch, item)
+			if len(batch) >= b.config.BatchSize {
+				b.flush(batch)
+				batch = make([]queuedLog, 0, b.config.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				b.flush(batch)
+				batch = make([]queuedLog, 0, b.config.BatchSize)
+			}
+		}
+	}
+}
+
+// flush writes a batch to the database, one insert per tenant
+func (b *Buffer) flush(batch []queuedLog) {
+	if len(batch) == 0 {
+		return
+	}
+	defer b.pending.Add(-int64(len(batch)))
+
+	// Batches rarely mix tenants, so split them only when they do
+	tenants := make(map[string][]queuedLog)
+	var order []string
+	for _, item := range batch {
+		if _, ok := tenants[item.tenant]; !ok {
+			order = append(order, item.tenant)
+		}
+		tenants[item.tenant] = append(tenants[item.tenant], item)
+	}
+	for _, tenant := range order {
+		b.flushTenant(tenant, tenants[tenant])
+	}
+}
+
+// flushTenant writes a tenant's entries to the database, retrying with
+// backoff on failure. Entries over the tenant's quota are dropped, since
+// retrying cannot succeed until its logs expire, as are entries the
+// database refuses to store. Batches the database rejects outright are
+// retried MaxRetries times even with a WAL, which otherwise retries until
+// the flush succeeds.
+func (b *Buffer) flushTenant(tenant string, batch []queuedLog) {
+	entries := make([]*models.Log, len(batch))
+	for i, item := range batch {
+		entries[i] = item.entry
+	}
+
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(database.WithTenant(context.Background(), tenant), b.config.FlushTimeout)
+		err := b.db.InsertLogs(ctx, entries)
+		cancel()
+
+		latency := time.Since(start)
+		b.flushes.Add(1)
+		b.lastFlushLatency.Store(int64(latency))
+		b.totalFlushTime.Add(int64(latency))
+
+		if err == nil {
+			b.flushed.Add(int64(len(batch)))
+			b.ack(batch)
+			return
+		}
+
+		b.failedFlushes.Add(1)
+
+		// The rest of the batch is stored; retrying the refused entries
+		// would only fail again
+		var insertErr *database.InsertError
+		if errors.As(err, &insertErr) {
+			log.Printf("Dropping %d of %d logs refused by the database: %v", len(insertErr.Failed), len(batch), err)
+			b.flushed.Add(int64(len(batch) - len(insertErr.Failed)))
+			b.dropped.Add(int64(len(insertErr.Failed)))
+			b.ack(batch)
+			return
+		}
+		if errors.Is(err, database.ErrQuotaExceeded) {
+			log.Printf("Dropping %d logs: %v", len(batch), err)
+			b.dropped.Add(int64(len(batch)))
+			b.ack(batch)
+			return
+		}
+		if attempt >= b.config.MaxRetries && (b.wal == nil || errors.Is(err, database.ErrRejected)) {
+			log.Printf("Dropping %d logs after %d failed flush attempts: %v", len(batch), attempt+1, err)
+			b.dropped.Add(int64(len(batch)))
+			b.ack(batch)
+			return
+		}
+
+		log.Printf("Error flushing %d logs, retrying in %v: %v", len(batch), backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-b.stop:
+			log.Printf("Abandoning flush of %d logs; they remain in the WAL", len(batch))
+			return
+		}
+		if backoff < maxFlushBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+// ack acknowledges flushed entries in the write-ahead log
+func (b *Buffer) ack(batch []queuedLog) {
+	if b.wal == nil {
+		return
+	}
+
+	counts := make(map[uint64]int)
+	for _, item := range batch {
+		counts[item.segment]++
+	}
+	for segment, n := range counts {
+		b.wal.Ack(segment, n)
+	}
+}
+
+// durationMs converts a duration to fractional milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
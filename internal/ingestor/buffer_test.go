@@ -0,0 +1,332 @@
+package ingestor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log-ingestor/internal/database"
+	"log-ingestor/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// waitFor polls cond until it returns true or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Condition not met before timeout")
+}
+
+// countLogs returns the number of logs stored in the mock database
+func countLogs(mockDB *database.MockDB) int {
+	logs, _ := mockDB.QueryLogs(context.Background(), &models.LogQuery{Limit: 1000})
+	return len(logs)
+}
+
+func sampleLog(message string) *models.Log {
+	return &models.Log{Level: "info", Message: message, Timestamp: time.Now().UTC()}
+}
+
+func TestBufferFlushesOnBatchSize(t *testing.T) {
+	mockDB := database.NewMockDB()
+	buffer := NewBuffer(mockDB, BufferConfig{QueueSize: 10, BatchSize: 2, FlushInterval: time.Hour, Workers: 1})
+	defer buffer.Close(context.Background())
+
+	if err := buffer.Enqueue(database.DefaultTenant, sampleLog("one"), sampleLog("two")); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+
+	waitFor(t, time.Second, func() bool { return countLogs(mockDB) == 2 })
+}
+
+func TestBufferFlushesOnInterval(t *testing.T) {
+	mockDB := database.NewMockDB()
+	buffer := NewBuffer(mockDB, BufferConfig{QueueSize: 10, BatchSize: 100, FlushInterval: 10 * time.Millisecond, Workers: 1})
+	defer buffer.Close(context.Background())
+
+	if err := buffer.Enqueue(database.DefaultTenant, sampleLog("one")); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+
+	waitFor(t, time.Second, func() bool { return countLogs(mockDB) == 1 })
+
+	stats := buffer.Stats()
+	if stats.Flushed != 1 || stats.Flushes != 1 {
+		t.Errorf("Expected 1 flushed entry in 1 flush, got %+v", stats)
+	}
+}
+
+func TestBufferRejectsWhenFull(t *testing.T) {
+	mockDB := database.NewMockDB()
+	buffer := NewBuffer(mockDB, BufferConfig{QueueSize: 2, BatchSize: 100, FlushInterval: time.Hour, Workers: 1})
+	defer buffer.Close(context.Background())
+
+	err := buffer.Enqueue(database.DefaultTenant, sampleLog("one"), sampleLog("two"), sampleLog("three"))
+	if err != ErrQueueFull {
+		t.Fatalf("Expected ErrQueueFull, got %v", err)
+	}
+
+	stats := buffer.Stats()
+	if stats.Rejected != 3 || stats.Enqueued != 0 {
+		t.Errorf("Expected 3 rejected and 0 enqueued, got %+v", stats)
+	}
+}
+
+func TestBufferCloseDrainsQueue(t *testing.T) {
+	mockDB := database.NewMockDB()
+	buffer := NewBuffer(mockDB, BufferConfig{QueueSize: 100, BatchSize: 100, FlushInterval: time.Hour, Workers: 2})
+
+	for i := 0; i < 5; i++ {
+		if err := buffer.Enqueue(database.DefaultTenant, sampleLog("entry")); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+
+	if err := buffer.Close(context.Background()); err != nil {
+		t.Fatalf("Failed to close buffer: %v", err)
+	}
+
+	if n := countLogs(mockDB); n != 5 {
+		t.Errorf("Expected 5 logs after drain, got %d", n)
+	}
+
+	if err := buffer.Enqueue(database.DefaultTenant, sampleLog("late")); err != ErrBufferClosed {
+		t.Errorf("Expected ErrBufferClosed after Close, got %v", err)
+	}
+}
+
+func TestBufferDropsAfterRetries(t *testing.T) {
+	mockDB := database.NewMockDB()
+	mockDB.SimulateError = true
+	buffer := NewBuffer(mockDB, BufferConfig{QueueSize: 10, BatchSize: 1, FlushInterval: time.Hour, Workers: 1, MaxRetries: 0})
+
+	if err := buffer.Enqueue(database.DefaultTenant, sampleLog("one")); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+	buffer.Close(context.Background())
+
+	stats := buffer.Stats()
+	if stats.Dropped != 1 || stats.FailedFlushes != 1 {
+		t.Errorf("Expected 1 dropped entry after 1 failed flush, got %+v", stats)
+	}
+}
+
+// refusingDB is a MockDB that stores every log but those with the message
+// "bad", which it refuses as MongoDB does documents it cannot store
+type refusingDB struct {
+	*database.MockDB
+	inserts int
+}
+
+func (r *refusingDB) InsertLogs(ctx context.Context, logEntries []*models.Log) error {
+	r.inserts++
+	failed := make(map[int]error)
+	var stored []*models.Log
+	for i, logEntry := range logEntries {
+		if logEntry.Message == "bad" {
+			failed[i] = errors.New("document is invalid")
+			continue
+		}
+		stored = append(stored, logEntry)
+	}
+	if err := r.MockDB.InsertLogs(ctx, stored); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &database.InsertError{Failed: failed}
+	}
+	return nil
+}
+
+func TestBufferDropsRefusedEntries(t *testing.T) {
+	dir := t.TempDir()
+	db := &refusingDB{MockDB: database.NewMockDB()}
+	buffer, err := NewDurableBuffer(db, BufferConfig{QueueSize: 10, BatchSize: 3, FlushInterval: time.Hour, Workers: 1}, WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create durable buffer: %v", err)
+	}
+
+	if err := buffer.Enqueue(database.DefaultTenant, sampleLog("one"), sampleLog("bad"), sampleLog("two")); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+
+	// The refused entry is not retried, so the buffer drains at once
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := buffer.Close(ctx); err != nil {
+		t.Fatalf("Failed to close buffer: %v", err)
+	}
+
+	stats := buffer.Stats()
+	if db.inserts != 1 || stats.Flushed != 2 || stats.Dropped != 1 || countLogs(db.MockDB) != 2 {
+		t.Errorf("Expected 2 flushed and 1 dropped log after 1 insert, got %d inserts and %+v", db.inserts, stats)
+	}
+
+	// The whole batch was acknowledged, leaving nothing to replay
+	wal, replay, err := OpenWAL(WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer wal.Close()
+	if len(replay) != 0 {
+		t.Errorf("Expected nothing to replay, got %d batches", len(replay))
+	}
+}
+
+// rejectingDB is a MockDB that rejects every batch as Postgres does text
+// it cannot encode
+type rejectingDB struct {
+	*database.MockDB
+	inserts int
+}
+
+func (r *rejectingDB) InsertLogs(ctx context.Context, logEntries []*models.Log) error {
+	r.inserts++
+	return fmt.Errorf("%w: invalid byte sequence for encoding \"UTF8\": 0x00", database.ErrRejected)
+}
+
+func TestBufferDropsRejectedBatchesWithWAL(t *testing.T) {
+	dir := t.TempDir()
+	db := &rejectingDB{MockDB: database.NewMockDB()}
+	buffer, err := NewDurableBuffer(db, BufferConfig{QueueSize: 10, BatchSize: 1, FlushInterval: time.Hour, Workers: 1, MaxRetries: 1}, WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create durable buffer: %v", err)
+	}
+
+	if err := buffer.Enqueue(database.DefaultTenant, sampleLog("one")); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+
+	// A rejected batch is retried MaxRetries times, not until Close gives up
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := buffer.Close(ctx); err != nil {
+		t.Fatalf("Failed to close buffer: %v", err)
+	}
+
+	stats := buffer.Stats()
+	if db.inserts != 2 || stats.Dropped != 1 || stats.FailedFlushes != 2 {
+		t.Errorf("Expected 1 dropped log after 2 inserts, got %d inserts and %+v", db.inserts, stats)
+	}
+
+	wal, replay, err := OpenWAL(WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer wal.Close()
+	if len(replay) != 0 {
+		t.Errorf("Expected nothing to replay, got %d batches", len(replay))
+	}
+}
+
+// blockedDB is a MockDB whose inserts hang until release is closed
+type blockedDB struct {
+	*database.MockDB
+	release chan struct{}
+}
+
+func (b *blockedDB) InsertLogs(ctx context.Context, logEntries []*models.Log) error {
+	<-b.release
+	return nil
+}
+
+func TestBufferCloseTimesOutTwice(t *testing.T) {
+	db := &blockedDB{MockDB: database.NewMockDB(), release: make(chan struct{})}
+	defer close(db.release)
+	buffer := NewBuffer(db, BufferConfig{QueueSize: 10, BatchSize: 1, FlushInterval: time.Hour, Workers: 1})
+
+	if err := buffer.Enqueue(database.DefaultTenant, sampleLog("one")); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+
+	// Each Close gives up on the hung flush without panicking
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		if err := buffer.Close(ctx); err != context.DeadlineExceeded {
+			t.Errorf("Close %d: expected %v, got %v", i+1, context.DeadlineExceeded, err)
+		}
+		cancel()
+	}
+}
+
+func TestHandleLogIngestionBuffered(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := database.NewMockDB()
+	buffer := NewBuffer(mockDB, BufferConfig{QueueSize: 1, BatchSize: 100, FlushInterval: time.Hour, Workers: 1})
+	defer buffer.Close(context.Background())
+
+	logIngestor := NewLogIngestor(mockDB)
+	logIngestor.UseBuffer(buffer)
+
+	router := gin.Default()
+	router.POST("/", logIngestor.HandleLogIngestion)
+
+	body := []byte(`{"level": "error", "message": "Failed to connect to DB"}`)
+
+	// First request fits in the queue
+	req, _ := http.NewRequest("POST", "/", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected status code %d, got %d", http.StatusAccepted, w.Code)
+	}
+
+	// Second request exceeds the queue and is pushed back
+	req, _ = http.NewRequest("POST", "/", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header on backpressure response")
+	}
+}
+
+func TestBufferFlushesPerTenant(t *testing.T) {
+	mockDB := database.NewMockDB()
+	buffer := NewBuffer(mockDB, BufferConfig{QueueSize: 10, BatchSize: 100, FlushInterval: time.Hour, Workers: 1})
+
+	buffer.Enqueue("acme", sampleLog("acme one"), sampleLog("acme two"))
+	buffer.Enqueue("globex", sampleLog("globex one"))
+	if err := buffer.Close(context.Background()); err != nil {
+		t.Fatalf("Failed to close buffer: %v", err)
+	}
+
+	// A mixed batch lands in each tenant's own logs
+	for tenant, expected := range map[string]int64{"acme": 2, "globex": 1, database.DefaultTenant: 0} {
+		count, _ := mockDB.CountLogs(database.WithTenant(context.Background(), tenant), &models.LogQuery{}, 0)
+		if count != expected {
+			t.Errorf("Expected %d logs for tenant %q, got %d", expected, tenant, count)
+		}
+	}
+}
+
+func TestBufferDropsLogsOverQuota(t *testing.T) {
+	mockDB := database.NewMockDB()
+	mockDB.TenantConfig = &database.TenantConfig{Default: database.TenantLimits{MaxLogs: 1}}
+	buffer := NewBuffer(mockDB, BufferConfig{QueueSize: 10, BatchSize: 2, FlushInterval: time.Hour, Workers: 1, MaxRetries: 5})
+
+	buffer.Enqueue(database.DefaultTenant, sampleLog("one"), sampleLog("two"))
+	if err := buffer.Close(context.Background()); err != nil {
+		t.Fatalf("Failed to close buffer: %v", err)
+	}
+
+	// Quota errors are not retried
+	stats := buffer.Stats()
+	if stats.Dropped != 2 || stats.FailedFlushes != 1 {
+		t.Errorf("Expected 2 dropped logs after 1 failed flush, got %+v", stats)
+	}
+}
@@ -0,0 +1,233 @@
+package ingestor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"log-ingestor/internal/auth"
+	"log-ingestor/internal/database"
+	"log-ingestor/internal/models"
+)
+
+const (
+	// maxBulkBodySize caps the size of a single bulk request body
+	maxBulkBodySize = 32 << 20
+
+	// maxBulkLineSize caps the size of a single NDJSON line
+	maxBulkLineSize = 1 << 20
+)
+
+// BulkResult reports the outcome of a single entry in a bulk request
+type BulkResult struct {
+	Line   int    `json:"line"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// bulkEntry is a raw entry read from a bulk request body
+type bulkEntry struct {
+	line int
+	raw  []byte
+}
+
+// HandleBulkIngestion handles ingestion of a JSON array or NDJSON stream of logs
+func (li *LogIngestor) HandleBulkIngestion(c *gin.Context) {
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxBulkBodySize)
+
+	entries, err := readBulkEntries(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(entries) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "request body contains no log entries"})
+		return
+	}
+
+	// Validate each entry independently
+	results := make([]BulkResult, len(entries))
+	accepted := make([]*models.Log, 0, len(entries))
+	acceptedResults := make([]int, 0, len(entries))
+	for i, entry := range entries {
+		results[i].Line = entry.line
+
+		logEntry, err := decodeBulkEntry(entry.raw)
+		if err != nil {
+			results[i].Status = "rejected"
+			results[i].Error = err.Error()
+			continue
+		}
+
+		results[i].Status = "accepted"
+		accepted = append(accepted, logEntry)
+		acceptedResults = append(acceptedResults, i)
+	}
+
+	rejected := len(entries) - len(accepted)
+	if len(accepted) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"accepted": 0,
+			"rejected": rejected,
+			"results":  results,
+		})
+		return
+	}
+
+	tenant := auth.TenantOf(c)
+	ctx, cancel := tenantContext(c, 30*time.Second)
+	defer cancel()
+
+	status := http.StatusOK
+	failed := 0
+	if li.buffer != nil {
+		// A batch larger than the whole queue could never be accepted, so
+		// it is refused outright rather than as a retryable 503
+		if len(accepted) > li.buffer.config.QueueSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("batch of %d logs exceeds the limit of %d logs per request; split it into smaller requests", len(accepted), li.buffer.config.QueueSize)})
+			return
+		}
+
+		// Hand the batch to the write-behind buffer as a whole
+		if err := li.checkQuota(ctx, len(accepted)); err != nil {
+			respondBufferError(c, err)
+			return
+		}
+
+		// Tail subscribers see the IDs the logs are stored under
+		assignIDs(accepted)
+		snapshots := li.hub.Snapshot(accepted)
+		if err := li.buffer.Enqueue(tenant, accepted...); err != nil {
+			respondBufferError(c, err)
+			return
+		}
+		li.hub.Publish(tenant, snapshots...)
+		status = http.StatusAccepted
+	} else if err := li.db.InsertLogs(ctx, accepted); err != nil {
+		if respondQuotaError(c, err) {
+			return
+		}
+
+		// Report the entries the database refused so only they are retried
+		var insertErr *database.InsertError
+		if !errors.As(err, &insertErr) || len(insertErr.Failed) == len(accepted) {
+			log.Printf("Error inserting log batch: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert logs: " + err.Error()})
+			return
+		}
+		log.Printf("Error inserting %d logs of a batch: %v", len(insertErr.Failed), err)
+		for i, failure := range insertErr.Failed {
+			results[acceptedResults[i]].Status = "failed"
+			results[acceptedResults[i]].Error = failure.Error()
+		}
+		failed = len(insertErr.Failed)
+		accepted = insertErr.Stored(accepted)
+		li.hub.Publish(tenant, accepted...)
+	} else {
+		// Push the batch to live tail subscribers
+		li.hub.Publish(tenant, accepted...)
+	}
+
+	c.JSON(status, gin.H{
+		"accepted": len(accepted),
+		"rejected": rejected,
+		"failed":   failed,
+		"errors":   rejected > 0 || failed > 0,
+		"results":  results,
+	})
+}
+
+// readBulkEntries splits a request body into raw entries, accepting either
+// a JSON array or newline-delimited JSON
+func readBulkEntries(body io.Reader) ([]bulkEntry, error) {
+	reader := bufio.NewReaderSize(body, 64*1024)
+
+	// Peek past leading whitespace to detect the body format
+	for {
+		b, err := reader.Peek(1)
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !isJSONSpace(b[0]) {
+			if b[0] == '[' {
+				return readJSONArray(reader)
+			}
+			return readNDJSON(reader)
+		}
+		if _, err := reader.ReadByte(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readJSONArray reads each element of a JSON array as a raw entry
+func readJSONArray(r io.Reader) ([]bulkEntry, error) {
+	var raws []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raws); err != nil {
+		return nil, errors.New("invalid JSON array: " + err.Error())
+	}
+
+	entries := make([]bulkEntry, len(raws))
+	for i, raw := range raws {
+		entries[i] = bulkEntry{line: i + 1, raw: raw}
+	}
+	return entries, nil
+}
+
+// readNDJSON reads each non-blank line of an NDJSON body as a raw entry
+func readNDJSON(r io.Reader) ([]bulkEntry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxBulkLineSize)
+
+	var entries []bulkEntry
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		entries = append(entries, bulkEntry{line: line, raw: append([]byte(nil), raw...)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("invalid NDJSON body: " + err.Error())
+	}
+	return entries, nil
+}
+
+// decodeBulkEntry decodes and validates a single raw log entry
+func decodeBulkEntry(raw []byte) (*models.Log, error) {
+	var logEntry models.Log
+	if err := json.Unmarshal(raw, &logEntry); err != nil {
+		return nil, err
+	}
+
+	if err := logEntry.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Ensure timestamp is valid
+	if logEntry.Timestamp.IsZero() {
+		logEntry.Timestamp = time.Now().UTC()
+	}
+
+	sanitizeIncoming(&logEntry)
+	return &logEntry, nil
+}
+
+// isJSONSpace reports whether b is insignificant JSON whitespace
+func isJSONSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
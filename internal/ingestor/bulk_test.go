@@ -0,0 +1,235 @@
+package ingestor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log-ingestor/internal/database"
+	"log-ingestor/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandleBulkIngestion(t *testing.T) {
+	testCases := []struct {
+		name             string
+		contentType      string
+		body             string
+		expectedStatus   int
+		expectedAccepted int
+		expectedRejected int
+	}{
+		{
+			name:        "JSON array",
+			contentType: "application/json",
+			body: `[
+				{"level": "error", "message": "Failed to connect to DB", "resourceId": "server-1234"},
+				{"level": "info", "message": "User authentication successful", "resourceId": "server-5678"}
+			]`,
+			expectedStatus:   http.StatusOK,
+			expectedAccepted: 2,
+			expectedRejected: 0,
+		},
+		{
+			name:        "NDJSON with invalid lines",
+			contentType: "application/x-ndjson",
+			body: `{"level": "error", "message": "Failed to connect to DB"}
+
+{"level": "info"}
+not json
+{"level": "debug", "message": "Cache miss for key"}
+`,
+			expectedStatus:   http.StatusOK,
+			expectedAccepted: 2,
+			expectedRejected: 2,
+		},
+		{
+			name:             "All entries rejected",
+			contentType:      "application/x-ndjson",
+			body:             `{"message": "missing level"}`,
+			expectedStatus:   http.StatusBadRequest,
+			expectedAccepted: 0,
+			expectedRejected: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router, mockDB := setupTestRouter()
+
+			req, _ := http.NewRequest("POST", "/bulk", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.expectedStatus {
+				t.Fatalf("Expected status code %d, got %d: %s", tc.expectedStatus, w.Code, w.Body.String())
+			}
+
+			var response struct {
+				Accepted int          `json:"accepted"`
+				Rejected int          `json:"rejected"`
+				Results  []BulkResult `json:"results"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+
+			if response.Accepted != tc.expectedAccepted {
+				t.Errorf("Expected %d accepted, got %d", tc.expectedAccepted, response.Accepted)
+			}
+			if response.Rejected != tc.expectedRejected {
+				t.Errorf("Expected %d rejected, got %d", tc.expectedRejected, response.Rejected)
+			}
+			if len(response.Results) != tc.expectedAccepted+tc.expectedRejected {
+				t.Errorf("Expected %d results, got %d", tc.expectedAccepted+tc.expectedRejected, len(response.Results))
+			}
+
+			logs, _ := mockDB.QueryLogs(context.Background(), &models.LogQuery{Limit: 100})
+			if len(logs) != tc.expectedAccepted {
+				t.Errorf("Expected %d logs in the database, got %d", tc.expectedAccepted, len(logs))
+			}
+		})
+	}
+}
+
+func TestHandleBulkIngestionReportsLineNumbers(t *testing.T) {
+	router, _ := setupTestRouter()
+
+	body := "{\"level\": \"error\", \"message\": \"ok\"}\n\n{\"level\": \"info\"}\n"
+	req, _ := http.NewRequest("POST", "/bulk", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response struct {
+		Results []BulkResult `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if len(response.Results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(response.Results))
+	}
+	if response.Results[1].Line != 3 || response.Results[1].Status != "rejected" {
+		t.Errorf("Expected line 3 to be rejected, got %+v", response.Results[1])
+	}
+	if response.Results[1].Error != "message is required" {
+		t.Errorf("Expected 'message is required' error, got '%s'", response.Results[1].Error)
+	}
+}
+
+func TestHandleBulkIngestionWithDBError(t *testing.T) {
+	router, mockDB := setupTestRouter()
+	mockDB.SimulateError = true
+
+	req, _ := http.NewRequest("POST", "/bulk", strings.NewReader(`[{"level": "error", "message": "Failed"}]`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status code %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+}
+
+func TestHandleBulkIngestionLargerThanQueue(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := database.NewMockDB()
+	buffer := NewBuffer(mockDB, BufferConfig{QueueSize: 2, BatchSize: 100, FlushInterval: time.Hour, Workers: 1})
+	defer buffer.Close(context.Background())
+
+	logIngestor := NewLogIngestor(mockDB)
+	logIngestor.UseBuffer(buffer)
+
+	router := gin.New()
+	router.POST("/bulk", logIngestor.HandleBulkIngestion)
+
+	// A batch that can never fit in the queue is not worth retrying
+	body := `{"level": "info", "message": "one"}
+{"level": "info", "message": "two"}
+{"level": "info", "message": "three"}`
+	req, _ := http.NewRequest("POST", "/bulk", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge || w.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected status code %d without Retry-After, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "limit of 2 logs") {
+		t.Errorf("Expected the limit in the error, got %s", w.Body.String())
+	}
+	if stats := buffer.Stats(); stats.Rejected != 0 {
+		t.Errorf("Expected the batch to be refused before the queue, got %+v", stats)
+	}
+}
+
+// partialDB is a MockDB that refuses the logs with a message of "bad", as
+// MongoDB reports documents it refuses in an unordered insert
+type partialDB struct {
+	*database.MockDB
+}
+
+func (p *partialDB) InsertLogs(ctx context.Context, logEntries []*models.Log) error {
+	insertErr := &database.InsertError{Failed: make(map[int]error)}
+	for i, logEntry := range logEntries {
+		if logEntry.Message == "bad" {
+			insertErr.Failed[i] = errors.New("document refused")
+		}
+	}
+	if err := p.MockDB.InsertLogs(ctx, insertErr.Stored(logEntries)); err != nil {
+		return err
+	}
+	if len(insertErr.Failed) > 0 {
+		return insertErr
+	}
+	return nil
+}
+
+func TestHandleBulkIngestionPartialFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := &partialDB{MockDB: database.NewMockDB()}
+	router := gin.New()
+	router.POST("/bulk", NewLogIngestor(db).HandleBulkIngestion)
+
+	body := `{"level": "info", "message": "good"}
+{"message": "missing level"}
+{"level": "info", "message": "bad"}
+{"level": "info", "message": "also good"}`
+	req, _ := http.NewRequest("POST", "/bulk", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Accepted int          `json:"accepted"`
+		Rejected int          `json:"rejected"`
+		Failed   int          `json:"failed"`
+		Errors   bool         `json:"errors"`
+		Results  []BulkResult `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response.Accepted != 2 || response.Rejected != 1 || response.Failed != 1 || !response.Errors {
+		t.Errorf("Unexpected counts: %+v", response)
+	}
+
+	// Each entry reports whether it was stored, so only failures are retried
+	statuses := []string{"accepted", "rejected", "failed", "accepted"}
+	for i, result := range response.Results {
+		if result.Status != statuses[i] {
+			t.Errorf("Line %d: expected status %q, got %+v", result.Line, statuses[i], result)
+		}
+	}
+	if response.Results[2].Error != "document refused" {
+		t.Errorf("Expected the database's reason, got %q", response.Results[2].Error)
+	}
+}
@@ -0,0 +1,269 @@
+package ingestor
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"log-ingestor/internal/auth"
+	"log-ingestor/internal/database"
+	"log-ingestor/internal/export"
+	"log-ingestor/internal/models"
+)
+
+const (
+	// DefaultExportRowLimit caps the rows of an export by a role without a
+	// limit of its own
+	DefaultExportRowLimit = 1000000
+
+	// defaultExportTimeout is the time budget of an export
+	defaultExportTimeout = 5 * time.Minute
+
+	// exportSampleSize is how many logs of a CSV or Parquet export are held
+	// back to find the metadata keys given columns of their own
+	exportSampleSize = 1000
+)
+
+// Trailers sent after an export's rows, since they are only known at the end
+const (
+	exportRowsTrailer      = "X-Export-Rows"
+	exportTruncatedTrailer = "X-Export-Truncated"
+	exportErrorTrailer     = "X-Export-Error"
+)
+
+// errExportLimit stops an export stream at its row cap
+var errExportLimit = errors.New("export row limit reached")
+
+// SetExportLimits sets the row cap of exports: roleLimits by role name
+// (a role definition's name, or else the built-in role), and defaultLimit
+// for the other roles. Zero means no cap.
+func (li *LogIngestor) SetExportLimits(defaultLimit int64, roleLimits map[string]int64) {
+	li.exportLimit = defaultLimit
+	li.exportRoleLimits = roleLimits
+}
+
+// SetExportTimeout sets the time budget of an export, after which it is
+// cut short
+func (li *LogIngestor) SetExportTimeout(timeout time.Duration) {
+	li.exportTimeout = timeout
+}
+
+// ParseRoleLimits parses row caps by role name, written as
+// "reader=100000,analyst=5000000"
+func ParseRoleLimits(value string) (map[string]int64, error) {
+	limits := make(map[string]int64)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		role, limit, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(role) == "" {
+			return nil, fmt.Errorf("invalid role limit %q: expected role=rows", entry)
+		}
+		rows, err := strconv.ParseInt(strings.TrimSpace(limit), 10, 64)
+		if err != nil || rows < 0 {
+			return nil, fmt.Errorf("invalid role limit %q: rows must be a non-negative integer", entry)
+		}
+		limits[strings.TrimSpace(role)] = rows
+	}
+	return limits, nil
+}
+
+// rowLimit returns the export row cap of a principal, zero for none
+func (li *LogIngestor) rowLimit(principal *auth.Principal) int64 {
+	if principal != nil {
+		name := principal.RoleName
+		if name == "" {
+			name = string(principal.Role)
+		}
+		if limit, ok := li.exportRoleLimits[name]; ok {
+			return limit
+		}
+	}
+	return li.exportLimit
+}
+
+// HandleExport streams every log matching the query, newest first, as an
+// NDJSON, CSV or Parquet file. Exports stop at the caller's row cap; the
+// X-Export-Rows, X-Export-Truncated and X-Export-Error trailers report how
+// the export ended.
+func (li *LogIngestor) HandleExport(c *gin.Context) {
+	format := c.DefaultQuery("format", export.NDJSON)
+	if !slices.Contains(export.Formats, format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown format %q: expected ndjson, csv or parquet", format)})
+		return
+	}
+
+	var query models.LogQuery
+	if !bindLogQuery(c, &query) {
+		return
+	}
+	if query.Sort != "" && query.Sort != models.SortTime {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exports are sorted by time; sort is not supported"})
+		return
+	}
+	if query.Fields != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exports have every field; fields is not supported, use metadataKeys to choose metadata columns"})
+		return
+	}
+
+	// One row past the cap is read to tell whether the export was truncated
+	rowLimit := li.rowLimit(auth.FromContext(c))
+	query.Page = 1
+	query.Limit = 0
+	if rowLimit > 0 {
+		query.Limit = int(rowLimit) + 1
+	}
+
+	stream := &exportStream{format: format, limit: rowLimit, scope: scopeOf(c)}
+	if keys := c.Query("metadataKeys"); keys != "" {
+		stream.keys = strings.Split(keys, ",")
+	} else {
+		stream.discover = export.Flat(format)
+	}
+
+	// Parquet pages are already compressed
+	var compressor *gzip.Writer
+	stream.out = c.Writer
+	if format != export.Parquet && acceptsGzip(c.Request) {
+		compressor = gzip.NewWriter(c.Writer)
+		stream.out = compressor
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", export.ContentType(format))
+	header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "logs."+format))
+	header.Set("Vary", "Accept-Encoding")
+	header.Set("X-Export-Row-Limit", strconv.FormatInt(rowLimit, 10))
+	header.Set("Trailer", strings.Join([]string{exportRowsTrailer, exportTruncatedTrailer, exportErrorTrailer}, ", "))
+	if compressor != nil {
+		header.Set("Content-Encoding", "gzip")
+	}
+
+	ctx, cancel := tenantContext(c, li.exportTimeout)
+	defer cancel()
+
+	err := database.StreamLogs(ctx, li.db, &query, stream.write)
+	if errors.Is(err, errExportLimit) {
+		err = nil
+	}
+	if err == nil {
+		err = stream.close()
+	}
+	if err == nil && compressor != nil {
+		err = compressor.Close()
+	}
+
+	if err != nil {
+		// Until the first byte is sent the export can still fail as a whole
+		if !c.Writer.Written() {
+			for _, key := range []string{"Content-Type", "Content-Disposition", "Content-Encoding", "Trailer", "X-Export-Row-Limit"} {
+				header.Del(key)
+			}
+			respondQueryFailure(c, "Failed to export logs", err)
+			return
+		}
+
+		// Otherwise the file is left incomplete and the trailer says why
+		log.Printf("Export failed after %d rows: %v", stream.rows, err)
+		message := "export failed"
+		if database.IsQueryTimeout(err) {
+			message = database.ErrQueryTimeout.Error()
+		}
+		header.Set(exportErrorTrailer, message)
+	}
+
+	header.Set(exportRowsTrailer, strconv.FormatInt(stream.rows, 10))
+	header.Set(exportTruncatedTrailer, strconv.FormatBool(stream.truncated))
+}
+
+// acceptsGzip reports whether a request's Accept-Encoding allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// exportStream writes streamed logs to an export file. Flat formats
+// without explicit metadata keys hold back the first exportSampleSize logs
+// to find them; keys first seen later go to export.MetadataColumn.
+type exportStream struct {
+	format   string
+	out      io.Writer
+	keys     []string
+	discover bool
+	scope    *auth.Scope
+
+	writer  export.Writer
+	pending []*models.Log
+
+	limit     int64
+	rows      int64
+	truncated bool
+}
+
+// write adds a log to the export, stopping it at the row cap
+func (s *exportStream) write(entry *models.Log) error {
+	if s.limit > 0 && s.rows == s.limit {
+		s.truncated = true
+		return errExportLimit
+	}
+	s.rows++
+
+	entry = s.scope.Redact(entry)
+	if s.writer != nil {
+		return s.writer.Write(entry)
+	}
+
+	s.pending = append(s.pending, entry)
+	if s.discover && len(s.pending) < exportSampleSize {
+		return nil
+	}
+	return s.open()
+}
+
+// open creates the export writer and writes the logs held back
+func (s *exportStream) open() error {
+	keys := s.keys
+	if s.discover {
+		keys = export.MetadataKeys(s.pending)
+	}
+
+	writer, err := export.NewWriter(s.format, s.out, keys)
+	if err != nil {
+		return err
+	}
+	s.writer = writer
+
+	for _, entry := range s.pending {
+		if err := writer.Write(entry); err != nil {
+			return err
+		}
+	}
+	s.pending = nil
+	return nil
+}
+
+// close completes the export file
+func (s *exportStream) close() error {
+	if s.writer == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	return s.writer.Close()
+}
@@ -0,0 +1,263 @@
+package ingestor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log-ingestor/internal/auth"
+	"log-ingestor/internal/database"
+	"log-ingestor/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupExportRouter(t *testing.T) (*gin.Engine, *LogIngestor, *database.MockDB) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := database.NewMockDB()
+	logIngestor := NewLogIngestor(mockDB)
+
+	router := gin.New()
+	router.GET("/logs/export", logIngestor.HandleExport)
+
+	base, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	for i := 0; i < 5; i++ {
+		mockDB.InsertLog(context.TODO(), &models.Log{
+			Level:      "error",
+			Message:    fmt.Sprintf("Failed to connect %d", i),
+			ResourceID: "server-1234",
+			Timestamp:  base.Add(time.Duration(i) * time.Minute),
+			Metadata:   map[string]string{"region": []string{"eu", "us"}[i%2]},
+		})
+	}
+	mockDB.InsertLog(context.TODO(), &models.Log{Level: "info", Message: "ok", Timestamp: base, Metadata: map[string]string{"userId": "u-1"}})
+
+	return router, logIngestor, mockDB
+}
+
+// requestExport requests an export and returns the response with its trailers
+func requestExport(router *gin.Engine, url string, header http.Header) (*http.Response, []byte) {
+	req, _ := http.NewRequest("GET", url, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	response := w.Result()
+	body, _ := io.ReadAll(response.Body)
+	return response, body
+}
+
+func TestHandleExportNDJSON(t *testing.T) {
+	router, _, _ := setupExportRouter(t)
+
+	response, body := requestExport(router, "/logs/export?level=error", nil)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, response.StatusCode, body)
+	}
+	if response.Header.Get("Content-Type") != "application/x-ndjson" || response.Header.Get("Content-Disposition") != `attachment; filename="logs.ndjson"` {
+		t.Errorf("Unexpected headers %v", response.Header)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if len(lines) != 5 || !strings.Contains(lines[0], "Failed to connect 4") || !strings.Contains(lines[4], "Failed to connect 0") {
+		t.Errorf("Expected 5 logs newest first, got %s", body)
+	}
+	if response.Trailer.Get("X-Export-Rows") != "5" || response.Trailer.Get("X-Export-Truncated") != "false" || response.Trailer.Get("X-Export-Error") != "" {
+		t.Errorf("Unexpected trailers %v", response.Trailer)
+	}
+}
+
+func TestHandleExportCSV(t *testing.T) {
+	router, _, _ := setupExportRouter(t)
+
+	// Metadata keys are found in the logs
+	response, body := requestExport(router, "/logs/export?format=csv", nil)
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("Unexpected response %d %v: %s", response.StatusCode, response.Header, body)
+	}
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+	if len(records) != 7 || !reflect.DeepEqual(records[0][8:], []string{"metadata.region", "metadata.userId", "metadata"}) {
+		t.Errorf("Unexpected records %q", records)
+	}
+
+	// Or given, leaving the rest to the metadata column
+	_, body = requestExport(router, "/logs/export?format=csv&level=info&metadataKeys=region", nil)
+	records, _ = csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if len(records) != 2 || !reflect.DeepEqual(records[1][8:], []string{"", `{"userId":"u-1"}`}) {
+		t.Errorf("Unexpected records %q", records)
+	}
+}
+
+func TestHandleExportParquet(t *testing.T) {
+	router, _, _ := setupExportRouter(t)
+
+	// Parquet is compressed already, so it is not gzipped
+	response, body := requestExport(router, "/logs/export?format=parquet", http.Header{"Accept-Encoding": {"gzip"}})
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("Unexpected response %d %v", response.StatusCode, response.Header)
+	}
+	if !bytes.HasPrefix(body, []byte("PAR1")) || !bytes.HasSuffix(body, []byte("PAR1")) {
+		t.Errorf("Expected a Parquet file, got %q", body)
+	}
+	if response.Trailer.Get("X-Export-Rows") != "6" {
+		t.Errorf("Unexpected trailers %v", response.Trailer)
+	}
+}
+
+func TestHandleExportGzip(t *testing.T) {
+	router, _, _ := setupExportRouter(t)
+
+	response, body := requestExport(router, "/logs/export", http.Header{"Accept-Encoding": {"br, gzip;q=0.8"}})
+	if response.Header.Get("Content-Encoding") != "gzip" || response.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("Expected a gzipped response, got %v", response.Header)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to read gzip: %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read gzip: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 6 {
+		t.Errorf("Expected 6 logs, got %d", lines)
+	}
+
+	// gzip can be refused
+	if response, _ := requestExport(router, "/logs/export", http.Header{"Accept-Encoding": {"gzip;q=0"}}); response.Header.Get("Content-Encoding") != "" {
+		t.Errorf("Expected no compression, got %v", response.Header)
+	}
+}
+
+func TestHandleExportRowLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	keys := `{
+		"roles": [{"name": "payments-reader", "base": "reader", "scope": {"redactMetadata": ["region"]}}],
+		"keys": [
+			{"name": "payments", "keyHash": "` + auth.HashKey("payments-key") + `", "role": "payments-reader"},
+			{"name": "oncall", "keyHash": "` + auth.HashKey("oncall-key") + `", "role": "reader"},
+			{"name": "ops", "keyHash": "` + auth.HashKey("ops-key") + `", "role": "admin"}
+		]
+	}`
+	if err := os.WriteFile(path, []byte(keys), 0o600); err != nil {
+		t.Fatalf("Failed to write keys file: %v", err)
+	}
+	store, err := auth.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+
+	_, logIngestor, _ := setupExportRouter(t)
+	limits, err := ParseRoleLimits("payments-reader=2, reader=0")
+	if err != nil {
+		t.Fatalf("Failed to parse role limits: %v", err)
+	}
+	logIngestor.SetExportLimits(3, limits)
+
+	router := gin.New()
+	router.GET("/logs/export", auth.NewAuthenticator(store).Require(auth.RoleReader), logIngestor.HandleExport)
+
+	tests := []struct {
+		key       string
+		rows      string
+		truncated string
+	}{
+		{"payments-key", "2", "true"},
+		{"oncall-key", "6", "false"},
+		{"ops-key", "3", "true"},
+	}
+	for _, tt := range tests {
+		response, body := requestExport(router, "/logs/export", http.Header{"Authorization": {"Bearer " + tt.key}})
+		if response.Trailer.Get("X-Export-Rows") != tt.rows || response.Trailer.Get("X-Export-Truncated") != tt.truncated {
+			t.Errorf("%s: expected %s rows, truncated %s, got trailers %v", tt.key, tt.rows, tt.truncated, response.Trailer)
+		}
+		if lines := strings.Count(string(body), "\n"); fmt.Sprint(lines) != tt.rows {
+			t.Errorf("%s: expected %s rows, got %d", tt.key, tt.rows, lines)
+		}
+		if tt.key == "payments-key" && strings.Contains(string(body), "region") {
+			t.Errorf("Expected region to be redacted, got %s", body)
+		}
+	}
+
+	// An export exactly at the limit is not truncated
+	response, _ := requestExport(router, "/logs/export?level=error&startTime=2023-09-15T08:02:00Z", http.Header{"Authorization": {"Bearer ops-key"}})
+	if response.Header.Get("X-Export-Row-Limit") != "3" || response.Trailer.Get("X-Export-Rows") != "3" || response.Trailer.Get("X-Export-Truncated") != "false" {
+		t.Errorf("Unexpected headers %v and trailers %v", response.Header, response.Trailer)
+	}
+}
+
+// failingStreamDB streams a log and then fails
+type failingStreamDB struct {
+	*database.MockDB
+}
+
+func (f failingStreamDB) StreamLogs(ctx context.Context, query *models.LogQuery, fn func(*models.Log) error) error {
+	if err := fn(&models.Log{Level: "error", Message: "first"}); err != nil {
+		return err
+	}
+	return errors.New("connection lost")
+}
+
+func TestHandleExportErrors(t *testing.T) {
+	router, _, mockDB := setupExportRouter(t)
+
+	// Invalid requests are rejected up front
+	for _, url := range []string{"/logs/export?format=xml", "/logs/export?search=error&sort=relevance", "/logs/export?regex=(a%2B)%2B"} {
+		if response, body := requestExport(router, url, nil); response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, got %d: %s", http.StatusBadRequest, url, response.StatusCode, body)
+		}
+	}
+
+	// Failures before the first row are reported as errors
+	mockDB.SimulateError = true
+	response, body := requestExport(router, "/logs/export?format=csv", nil)
+	if response.StatusCode != http.StatusInternalServerError || response.Header.Get("Content-Disposition") != "" || !strings.Contains(string(body), "Failed to export logs") {
+		t.Errorf("Expected an error response, got %d %v: %s", response.StatusCode, response.Header, body)
+	}
+
+	// Later failures leave the file incomplete and are reported in a trailer
+	logIngestor := NewLogIngestor(failingStreamDB{database.NewMockDB()})
+	router = gin.New()
+	router.GET("/logs/export", logIngestor.HandleExport)
+	response, body = requestExport(router, "/logs/export", nil)
+	if response.StatusCode != http.StatusOK || !strings.Contains(string(body), "first") {
+		t.Errorf("Expected the rows before the failure, got %d: %s", response.StatusCode, body)
+	}
+	if response.Trailer.Get("X-Export-Error") != "export failed" || response.Trailer.Get("X-Export-Rows") != "1" {
+		t.Errorf("Unexpected trailers %v", response.Trailer)
+	}
+}
+
+func TestParseRoleLimits(t *testing.T) {
+	limits, err := ParseRoleLimits(" reader=100000 ,analyst=0,")
+	if err != nil || !reflect.DeepEqual(limits, map[string]int64{"reader": 100000, "analyst": 0}) {
+		t.Errorf("Unexpected limits %v, %v", limits, err)
+	}
+	if limits, err := ParseRoleLimits(""); err != nil || len(limits) != 0 {
+		t.Errorf("Expected no limits, got %v, %v", limits, err)
+	}
+	for _, value := range []string{"reader", "=5", "reader=-1", "reader=lots"} {
+		if _, err := ParseRoleLimits(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package ingestor
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"log-ingestor/internal/database"
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+)
+
+// DefaultTailBuffer is how many logs a tail subscriber may fall behind by
+// before further logs are dropped for it
+const DefaultTailBuffer = 256
+
+// Hub fans newly ingested logs out to live tail subscribers. Publishing
+// never blocks: a subscriber whose buffer is full misses the log, and the
+// miss is counted so the client can be told.
+type Hub struct {
+	mutex       sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	bufferSize  int
+	closed      bool
+	dropped     atomic.Uint64
+}
+
+// Subscription receives the published logs of its tenant matching its query
+type Subscription struct {
+	C <-chan *models.Log
+
+	ch      chan *models.Log
+	tenant  string
+	query   *models.LogQuery
+	expr    querylang.Expr
+	dropped atomic.Uint64
+}
+
+// HubStats is a snapshot of the hub's state
+type HubStats struct {
+	Subscribers int    `json:"subscribers"`
+	Dropped     uint64 `json:"dropped"`
+}
+
+// NewHub creates a hub whose subscribers each buffer up to bufferSize logs
+func NewHub(bufferSize int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = DefaultTailBuffer
+	}
+
+	return &Hub{
+		subscribers: make(map[*Subscription]struct{}),
+		bufferSize:  bufferSize,
+	}
+}
+
+// Subscribe registers a subscriber for a tenant's logs matching query. The
+// query's expression must already be valid.
+func (h *Hub) Subscribe(tenant string, query *models.LogQuery) (*Subscription, error) {
+	expr, err := querylang.Parse(query.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan *models.Log, h.bufferSize)
+	sub := &Subscription{C: ch, ch: ch, tenant: tenant, query: query, expr: expr}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// A closed hub hands out subscriptions that are already finished
+	if h.closed {
+		close(ch)
+		return sub, nil
+	}
+
+	h.subscribers[sub] = struct{}{}
+	return sub, nil
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// Publish delivers a tenant's logs to every subscriber of the tenant whose
+// query they match. The logs are shared with subscribers, so they must not
+// be modified afterwards.
+func (h *Hub) Publish(tenant string, entries ...*models.Log) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	if len(h.subscribers) == 0 {
+		return
+	}
+
+	for _, entry := range entries {
+		for sub := range h.subscribers {
+			if sub.tenant != tenant || !database.MatchesQuery(entry, sub.query, sub.expr) {
+				continue
+			}
+
+			select {
+			case sub.ch <- entry:
+			default:
+				// Never block ingestion on a slow subscriber
+				sub.dropped.Add(1)
+				h.dropped.Add(1)
+			}
+		}
+	}
+}
+
+// Snapshot copies logs for publishing once they have been handed to the
+// write-behind buffer, whose workers assign IDs concurrently. It returns nil
+// when nobody is subscribed.
+func (h *Hub) Snapshot(entries []*models.Log) []*models.Log {
+	h.mutex.RLock()
+	active := len(h.subscribers) > 0
+	h.mutex.RUnlock()
+	if !active {
+		return nil
+	}
+
+	snapshots := make([]*models.Log, len(entries))
+	for i, entry := range entries {
+		snapshot := *entry
+		snapshots[i] = &snapshot
+	}
+	return snapshots
+}
+
+// Close ends every subscription and rejects new ones
+func (h *Hub) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// Stats returns a snapshot of the hub's state
+func (h *Hub) Stats() HubStats {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return HubStats{
+		Subscribers: len(h.subscribers),
+		Dropped:     h.dropped.Load(),
+	}
+}
+
+// TakeDropped returns how many logs the subscriber has missed since the
+// last call, and resets the count
+func (s *Subscription) TakeDropped() uint64 {
+	return s.dropped.Swap(0)
+}
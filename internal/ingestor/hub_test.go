@@ -0,0 +1,122 @@
+package ingestor
+
+import (
+	"log-ingestor/internal/database"
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+	"testing"
+)
+
+func TestHubPublishesMatchingLogs(t *testing.T) {
+	hub := NewHub(10)
+
+	sub, err := hub.Subscribe(database.DefaultTenant, &models.LogQuery{Query: "level:error"})
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	info := sampleLog("started")
+	failure := &models.Log{Level: "error", Message: "failed"}
+	hub.Publish(database.DefaultTenant, info, failure)
+
+	select {
+	case entry := <-sub.C:
+		if entry != failure {
+			t.Errorf("Expected the error log, got %+v", entry)
+		}
+	default:
+		t.Fatal("Expected a log to be delivered")
+	}
+
+	if len(sub.C) != 0 {
+		t.Errorf("Expected non-matching logs to be skipped, %d queued", len(sub.C))
+	}
+}
+
+func TestHubDropsForSlowSubscribers(t *testing.T) {
+	hub := NewHub(2)
+
+	sub, _ := hub.Subscribe(database.DefaultTenant, &models.LogQuery{})
+	for i := 0; i < 5; i++ {
+		hub.Publish(database.DefaultTenant, sampleLog("entry"))
+	}
+
+	if len(sub.C) != 2 {
+		t.Errorf("Expected 2 buffered logs, got %d", len(sub.C))
+	}
+	if dropped := sub.TakeDropped(); dropped != 3 {
+		t.Errorf("Expected 3 dropped logs, got %d", dropped)
+	}
+	if dropped := sub.TakeDropped(); dropped != 0 {
+		t.Errorf("Expected the dropped count to reset, got %d", dropped)
+	}
+	if stats := hub.Stats(); stats.Subscribers != 1 || stats.Dropped != 3 {
+		t.Errorf("Unexpected hub stats %+v", stats)
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	hub := NewHub(1)
+
+	sub, _ := hub.Subscribe(database.DefaultTenant, &models.LogQuery{})
+	hub.Close()
+
+	if _, ok := <-sub.C; ok {
+		t.Error("Expected the subscription to be closed")
+	}
+
+	// Unsubscribing after close and subscribing to a closed hub are harmless
+	hub.Unsubscribe(sub)
+	late, _ := hub.Subscribe(database.DefaultTenant, &models.LogQuery{})
+	if _, ok := <-late.C; ok {
+		t.Error("Expected subscriptions to a closed hub to be closed")
+	}
+	hub.Publish(database.DefaultTenant, sampleLog("after close"))
+}
+
+func TestHubSnapshot(t *testing.T) {
+	hub := NewHub(1)
+
+	if snapshots := hub.Snapshot([]*models.Log{sampleLog("a")}); snapshots != nil {
+		t.Errorf("Expected no snapshot without subscribers, got %v", snapshots)
+	}
+
+	hub.Subscribe(database.DefaultTenant, &models.LogQuery{})
+	entry := sampleLog("a")
+	snapshots := hub.Snapshot([]*models.Log{entry})
+	if len(snapshots) != 1 || snapshots[0] == entry || snapshots[0].Message != "a" {
+		t.Errorf("Expected a copy of the log, got %v", snapshots)
+	}
+}
+
+func TestHubAppliesQueryScope(t *testing.T) {
+	hub := NewHub(10)
+
+	query := &models.LogQuery{Scope: &querylang.TermExpr{Field: "resourceId", Value: "payments-*", Wildcard: true}}
+	sub, _ := hub.Subscribe(database.DefaultTenant, query)
+
+	hub.Publish(database.DefaultTenant, &models.Log{Level: "info", ResourceID: "search-api"}, &models.Log{Level: "info", ResourceID: "payments-api"})
+
+	if len(sub.C) != 1 {
+		t.Fatalf("Expected 1 in-scope log, got %d", len(sub.C))
+	}
+	if entry := <-sub.C; entry.ResourceID != "payments-api" {
+		t.Errorf("Unexpected log %+v", entry)
+	}
+}
+
+func TestHubSeparatesTenants(t *testing.T) {
+	hub := NewHub(10)
+	acme, _ := hub.Subscribe("acme", &models.LogQuery{})
+	defaults, _ := hub.Subscribe(database.DefaultTenant, &models.LogQuery{})
+
+	hub.Publish("acme", sampleLog("for acme"))
+	hub.Publish("globex", sampleLog("for globex"))
+
+	if len(acme.C) != 1 || (<-acme.C).Message != "for acme" {
+		t.Error("Expected the acme subscriber to receive only acme's log")
+	}
+	if len(defaults.C) != 0 {
+		t.Errorf("Expected the default tenant's subscriber to receive nothing, got %d logs", len(defaults.C))
+	}
+}
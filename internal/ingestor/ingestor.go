@@ -2,28 +2,92 @@ package ingestor
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"log-ingestor/internal/auth"
 	"log-ingestor/internal/database"
 	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+	"log-ingestor/internal/retention"
+)
+
+const (
+	// defaultQueryLimit is the page size used when none is requested
+	defaultQueryLimit = 10
+
+	// maxQueryLimit caps the page size a client may request
+	maxQueryLimit = 1000
+
+	// defaultCountLimit caps how far totals are counted before being reported
+	// as a lower bound
+	defaultCountLimit = 100000
+
+	// defaultQueryTimeout is the time budget of a log query, after which the
+	// database stops it
+	defaultQueryTimeout = 10 * time.Second
 )
 
 // LogIngestor represents the log ingestor service
 type LogIngestor struct {
-	db database.DB
+	db           database.DB
+	buffer       *Buffer
+	hub          *Hub
+	janitor      *retention.Janitor
+	countLimit   int64
+	queryTimeout time.Duration
+
+	exportLimit      int64
+	exportRoleLimits map[string]int64
+	exportTimeout    time.Duration
 }
 
 // NewLogIngestor creates a new log ingestor service
 func NewLogIngestor(db database.DB) *LogIngestor {
 	return &LogIngestor{
-		db: db,
+		db:           db,
+		hub:          NewHub(DefaultTailBuffer),
+		countLimit:   defaultCountLimit,
+		queryTimeout: defaultQueryTimeout,
+
+		exportLimit:   DefaultExportRowLimit,
+		exportTimeout: defaultExportTimeout,
 	}
 }
 
+// SetCountLimit sets how many matches are counted before the total is
+// reported as a lower bound; zero counts every match
+func (li *LogIngestor) SetCountLimit(limit int64) {
+	li.countLimit = limit
+}
+
+// SetQueryTimeout sets the time budget of a log query, after which the
+// database stops it and the request fails with 504
+func (li *LogIngestor) SetQueryTimeout(timeout time.Duration) {
+	li.queryTimeout = timeout
+}
+
+// UseBuffer routes ingested logs through a write-behind buffer instead of
+// inserting them synchronously
+func (li *LogIngestor) UseBuffer(buffer *Buffer) {
+	li.buffer = buffer
+}
+
+// UseHub replaces the hub that live tail subscribers are served from
+func (li *LogIngestor) UseHub(hub *Hub) {
+	li.hub = hub
+}
+
+// UseJanitor reports the retention janitor's progress in the ingest stats
+func (li *LogIngestor) UseJanitor(janitor *retention.Janitor) {
+	li.janitor = janitor
+}
+
 // HandleLogIngestion handles the log ingestion HTTP request
 func (li *LogIngestor) HandleLogIngestion(c *gin.Context) {
 	var logEntry models.Log
@@ -34,44 +98,385 @@ func (li *LogIngestor) HandleLogIngestion(c *gin.Context) {
 		return
 	}
 
+	// Apply the same checks as each line of a bulk request
+	if err := logEntry.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Ensure timestamp is valid
 	if logEntry.Timestamp.IsZero() {
 		logEntry.Timestamp = time.Now().UTC()
 	}
 
-	// Insert log into database
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	sanitizeIncoming(&logEntry)
+
+	tenant := auth.TenantOf(c)
+	ctx, cancel := tenantContext(c, 5*time.Second)
 	defer cancel()
 
+	// Hand the log to the write-behind buffer when one is configured
+	if li.buffer != nil {
+		if err := li.checkQuota(ctx, 1); err != nil {
+			respondBufferError(c, err)
+			return
+		}
+
+		// Tail subscribers see the ID the log is stored under
+		assignIDs([]*models.Log{&logEntry})
+		snapshots := li.hub.Snapshot([]*models.Log{&logEntry})
+		if err := li.buffer.Enqueue(tenant, &logEntry); err != nil {
+			respondBufferError(c, err)
+			return
+		}
+		li.hub.Publish(tenant, snapshots...)
+		c.JSON(http.StatusAccepted, gin.H{"status": "Log accepted"})
+		return
+	}
+
+	// Insert log into database
 	if err := li.db.InsertLog(ctx, &logEntry); err != nil {
+		if respondQuotaError(c, err) {
+			return
+		}
 		log.Printf("Error inserting log: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert log: " + err.Error()})
 		return
 	}
 
+	// Push the log to live tail subscribers
+	li.hub.Publish(tenant, &logEntry)
+
 	c.JSON(http.StatusOK, gin.H{"status": "Log ingested successfully"})
 }
 
+// sanitizeIncoming clears the fields of a received log that only the server
+// sets. Scores are computed by relevance queries, never stored. IDs are
+// assigned by the server; a client's could collide with stored logs or
+// reorder cursor pages.
+func sanitizeIncoming(logEntry *models.Log) {
+	logEntry.Score = 0
+	logEntry.ID = ""
+}
+
+// HandleIngestStats reports the state of the write-behind buffer, the live
+// tail hub and the retention janitor
+func (li *LogIngestor) HandleIngestStats(c *gin.Context) {
+	response := gin.H{"buffered": li.buffer != nil, "tail": li.hub.Stats()}
+	if li.buffer != nil {
+		response["buffer"] = li.buffer.Stats()
+	}
+	if li.janitor != nil {
+		response["retention"] = li.janitor.Stats()
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// tenantContext returns a context for database operations on the request's
+// tenant, bounded by timeout
+func tenantContext(c *gin.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(database.WithTenant(context.Background(), auth.TenantOf(c)), timeout)
+}
+
+// checkQuota refuses logs the tenant has no room for before they are
+// buffered, since the buffer can only drop them once they are accepted
+func (li *LogIngestor) checkQuota(ctx context.Context, n int) error {
+	checker, ok := li.db.(database.QuotaChecker)
+	if !ok {
+		return nil
+	}
+	return checker.CheckQuota(ctx, n)
+}
+
+// bindLogQuery binds and validates the log query filters in the request's
+// query string, responding with an error and returning false if they are
+// invalid
+func bindLogQuery(c *gin.Context, query *models.LogQuery) bool {
+	// Bind query parameters to log query
+	if err := c.ShouldBindQuery(query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	// Metadata filters use dynamic parameter names, so they are parsed separately
+	metadataFilters, err := models.ParseMetadataFilters(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	query.MetadataFilters = metadataFilters
+
+	// Repeated and negated field values, e.g. level=error&level=warning&-resourceId=x
+	query.Filters = models.ParseFieldFilters(c.Request.URL.Query())
+
+	// Reject malformed queries before they reach the database
+	if err := validateQuery(query); err != nil {
+		respondQueryError(c, err)
+		return false
+	}
+
+	// Restrict the query to the logs the caller may see
+	if err := scopeOf(c).Apply(query); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return false
+	}
+
+	return true
+}
+
+// scopeOf returns the scope of the request's principal, or nil when it is
+// unrestricted
+func scopeOf(c *gin.Context) *auth.Scope {
+	if principal := auth.FromContext(c); principal != nil {
+		return principal.Scope
+	}
+	return nil
+}
+
+// redactLogs removes metadata the caller may not see from query results
+func redactLogs(scope *auth.Scope, logs []*models.Log) []*models.Log {
+	for i, entry := range logs {
+		logs[i] = scope.Redact(entry)
+	}
+	return logs
+}
+
+// highlightedLog is a log in query results with the parts of its message
+// that matched the query
+type highlightedLog struct {
+	*models.Log
+	Highlights []models.Highlight `json:"highlights"`
+}
+
+// highlightLogs finds the parts of each log's message matching the query
+func highlightLogs(logs []*models.Log, query *models.LogQuery) []highlightedLog {
+	results := make([]highlightedLog, len(logs))
+	for i, entry := range logs {
+		results[i] = highlightedLog{Log: entry, Highlights: database.Highlights(entry.Message, query)}
+	}
+	return results
+}
+
+// projectedLogs renders query results with only the projected fields,
+// besides each log's ID, timestamp and score. Highlights are only included
+// with the message they point into.
+func projectedLogs(logs []*models.Log, fields []string, query *models.LogQuery) []gin.H {
+	results := make([]gin.H, len(logs))
+	for i, entry := range logs {
+		result := gin.H{"id": entry.ID, "timestamp": entry.Timestamp}
+		for _, field := range fields {
+			if _, ok := querylang.MetadataKey(field); ok || field == models.MetadataField {
+				metadata := entry.Metadata
+				if metadata == nil {
+					metadata = map[string]string{}
+				}
+				result[models.MetadataField] = metadata
+				continue
+			}
+			result[field], _ = entry.Field(field)
+		}
+
+		if entry.Score != 0 {
+			result["score"] = entry.Score
+		}
+		if query.Highlight && slices.Contains(fields, "message") {
+			result["highlights"] = database.Highlights(entry.Message, query)
+		}
+		results[i] = result
+	}
+	return results
+}
+
+// validateQuery checks client-supplied query parameters
+func validateQuery(query *models.LogQuery) error {
+	if query.Cursor != "" {
+		if _, err := models.DecodeCursor(query.Cursor); err != nil {
+			return err
+		}
+	}
+
+	if _, err := querylang.Parse(query.Query); err != nil {
+		return err
+	}
+
+	if _, err := query.Regex(); err != nil {
+		return err
+	}
+
+	switch query.Sort {
+	case "", models.SortTime:
+	case models.SortRelevance:
+		if query.FullTextSearch == "" {
+			return errors.New("sort=relevance requires a full-text search")
+		}
+		if query.Cursor != "" {
+			return errors.New("sort=relevance pages by page number, not cursor")
+		}
+	default:
+		if _, err := query.SortKeys(); err != nil {
+			return err
+		}
+	}
+
+	fields, err := query.Projection()
+	if err != nil {
+		return err
+	}
+	if query.Highlight && fields != nil && !slices.Contains(fields, "message") {
+		return errors.New("highlight=true requires message in fields")
+	}
+
+	return nil
+}
+
+// respondQueryError reports an invalid query, including the position of
+// query language syntax errors
+func respondQueryError(c *gin.Context, err error) {
+	var parseErr *querylang.ParseError
+	if errors.As(err, &parseErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": parseErr.Error(), "position": parseErr.Pos})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// respondQueryFailure reports a query the database could not complete,
+// with 504 when it ran past its time budget
+func respondQueryFailure(c *gin.Context, message string, err error) {
+	if database.IsQueryTimeout(err) {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": message + ": " + database.ErrQueryTimeout.Error() + "; narrow the time range or filters"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// respondQuotaError reports an insert refused because the tenant is over
+// its quota, returning false for any other error
+func respondQuotaError(c *gin.Context, err error) bool {
+	if !errors.Is(err, database.ErrQuotaExceeded) {
+		return false
+	}
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	return true
+}
+
+// respondBufferError applies backpressure when the buffer cannot take more logs
+func respondBufferError(c *gin.Context, err error) {
+	if respondQuotaError(c, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, ErrQueueFull):
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrBufferClosed):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error buffering logs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept logs: " + err.Error()})
+	}
+}
+
 // QueryLogs handles the log query HTTP request
 func (li *LogIngestor) QueryLogs(c *gin.Context) {
 	var query models.LogQuery
+	if !bindLogQuery(c, &query) {
+		return
+	}
 
-	// Bind query parameters to log query
-	if err := c.ShouldBindQuery(&query); err != nil {
+	// Sorting by a field the backend does not index would scan every log
+	keys, _ := query.SortKeys()
+	if err := models.CheckSortable(keys, database.IndexedMetadataKeys(li.db)); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Query logs from database
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Apply pagination defaults here so the response can report them
+	if query.Page <= 0 {
+		query.Page = 1
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultQueryLimit
+	}
+	if query.Limit > maxQueryLimit {
+		query.Limit = maxQueryLimit
+	}
+	limit := query.Limit
+
+	// Query logs from database within the time budget
+	ctx, cancel := tenantContext(c, li.queryTimeout)
 	defer cancel()
 
-	logs, err := li.db.QueryLogs(ctx, &query)
+	start := time.Now()
+
+	// With a cursor there is no page offset, so fetching one extra log tells
+	// whether another page follows
+	fetchQuery := query
+	if query.Cursor != "" {
+		fetchQuery.Limit = limit + 1
+	}
+
+	logs, err := li.db.QueryLogs(ctx, &fetchQuery)
 	if err != nil {
 		log.Printf("Error querying logs: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query logs"})
+		respondQueryFailure(c, "Failed to query logs", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"logs": logs, "count": len(logs)})
+	total, err := li.db.CountLogs(ctx, &query, li.countLimit)
+	if err != nil {
+		log.Printf("Error counting logs: %v", err)
+		respondQueryFailure(c, "Failed to query logs", err)
+		return
+	}
+
+	// Counts that hit the limit are only a lower bound
+	totalRelation := "eq"
+	if li.countLimit > 0 && total >= li.countLimit {
+		totalRelation = "gte"
+	}
+
+	var hasMore bool
+	switch {
+	case query.Cursor != "":
+		hasMore = len(logs) > limit
+		if hasMore {
+			logs = logs[:limit]
+		}
+	case int64((query.Page-1)*limit+len(logs)) < total:
+		hasMore = true
+	case totalRelation == "gte":
+		// Past the counted range a full page is the best evidence available
+		hasMore = len(logs) == limit
+	}
+
+	logs = redactLogs(scopeOf(c), logs)
+	var results interface{} = logs
+	if query.Highlight {
+		results = highlightLogs(logs, &query)
+	}
+	if fields, _ := query.Projection(); fields != nil {
+		results = projectedLogs(logs, fields, &query)
+	}
+
+	response := gin.H{
+		"logs":          results,
+		"count":         len(logs),
+		"total":         total,
+		"totalRelation": totalRelation,
+		"hasMore":       hasMore,
+		"page":          query.Page,
+		"limit":         limit,
+		"tookMs":        durationMs(time.Since(start)),
+	}
+
+	// Cursors continue in time order, so pages in other orders are
+	// numbered only
+	if hasMore && len(logs) > 0 && !query.ByRelevance() && !query.ByFields() {
+		response["nextCursor"] = models.EncodeCursor(logs[len(logs)-1])
+	}
+
+	c.JSON(http.StatusOK, response)
 }
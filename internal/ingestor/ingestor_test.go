@@ -4,10 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"log-ingestor/internal/auth"
 	"log-ingestor/internal/database"
 	"log-ingestor/internal/models"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -29,6 +35,7 @@ func setupTestRouter() (*gin.Engine, *database.MockDB) {
 
 	// Define routes
 	router.POST("/", logIngestor.HandleLogIngestion)
+	router.POST("/bulk", logIngestor.HandleBulkIngestion)
 	router.GET("/logs", logIngestor.QueryLogs)
 
 	return router, mockDB
@@ -89,6 +96,52 @@ func TestHandleLogIngestion(t *testing.T) {
 	}
 }
 
+func TestHandleLogIngestionAssignsID(t *testing.T) {
+	router, mockDB := setupTestRouter()
+
+	const clientID = "650400000000000000000001"
+	for _, request := range []struct{ path, body string }{
+		{"/", `{"id": "` + clientID + `", "level": "info", "message": "single"}`},
+		{"/bulk", `{"id": "` + clientID + `", "level": "info", "message": "bulk"}`},
+	} {
+		req, _ := http.NewRequest("POST", request.path, strings.NewReader(request.body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status code %d, got %d: %s", request.path, http.StatusOK, w.Code, w.Body.String())
+		}
+	}
+
+	// Client IDs are replaced, so the second log does not collide with the first
+	logs, _ := mockDB.QueryLogs(context.Background(), &models.LogQuery{})
+	if len(logs) != 2 {
+		t.Fatalf("Expected 2 logs, got %d", len(logs))
+	}
+	for _, log := range logs {
+		if log.ID == "" || log.ID == clientID {
+			t.Errorf("Expected a new ID for %q, got %q", log.Message, log.ID)
+		}
+	}
+}
+
+func TestHandleLogIngestionValidates(t *testing.T) {
+	router, mockDB := setupTestRouter()
+
+	// Logs /bulk rejects per line are rejected by POST / too
+	for _, body := range []string{`{"message": "no level"}`, `{"level": "info"}`} {
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %d, got %d", body, http.StatusBadRequest, w.Code)
+		}
+	}
+
+	if logs, _ := mockDB.QueryLogs(context.Background(), &models.LogQuery{}); len(logs) != 0 {
+		t.Errorf("Expected no logs in the database, got %d", len(logs))
+	}
+}
+
 func TestQueryLogs(t *testing.T) {
 	router, mockDB := setupTestRouter()
 
@@ -319,3 +372,621 @@ func TestHandleLogIngestionWithDBError(t *testing.T) {
 		t.Errorf("Expected error message 'Failed to insert log: simulated error', got '%s'", response["error"])
 	}
 }
+
+func TestQueryLogsWithCursor(t *testing.T) {
+	router, mockDB := setupTestRouter()
+
+	base, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	for i := 0; i < 3; i++ {
+		if err := mockDB.InsertLog(context.TODO(), &models.Log{Level: "info", Message: "entry", Timestamp: base.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatalf("Failed to insert log: %v", err)
+		}
+	}
+
+	var response struct {
+		Logs       []models.Log `json:"logs"`
+		NextCursor string       `json:"nextCursor"`
+	}
+
+	// First page returns a continuation token
+	req, _ := http.NewRequest("GET", "/logs?limit=2", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(response.Logs) != 2 || response.NextCursor == "" {
+		t.Fatalf("Expected 2 logs and a cursor, got %d logs and cursor %q", len(response.Logs), response.NextCursor)
+	}
+
+	// Second page continues where the first ended
+	req, _ = http.NewRequest("GET", "/logs?limit=2&cursor="+response.NextCursor, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	response.NextCursor = ""
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(response.Logs) != 1 || !response.Logs[0].Timestamp.Equal(base) {
+		t.Errorf("Expected the oldest log on the second page, got %+v", response.Logs)
+	}
+	if response.NextCursor != "" {
+		t.Errorf("Expected no cursor on the last page, got %q", response.NextCursor)
+	}
+
+	// Malformed cursors are rejected
+	req, _ = http.NewRequest("GET", "/logs?cursor=garbage", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestQueryLogsPaginationMetadata(t *testing.T) {
+	router, mockDB := setupTestRouter()
+
+	base, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	for i := 0; i < 5; i++ {
+		level := "info"
+		if i%2 == 0 {
+			level = "error"
+		}
+		if err := mockDB.InsertLog(context.TODO(), &models.Log{Level: level, Message: "entry", Timestamp: base.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatalf("Failed to insert log: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name            string
+		url             string
+		expectedCount   int
+		expectedTotal   int64
+		expectedHasMore bool
+		expectedPage    int
+		expectedLimit   int
+	}{
+		{"First page", "/logs?limit=2", 2, 5, true, 1, 2},
+		{"Last page", "/logs?limit=2&page=3", 1, 5, false, 3, 2},
+		{"Filtered", "/logs?level=error&limit=3", 3, 3, false, 1, 3},
+		{"Defaults", "/logs", 5, 5, false, 1, 10},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tc.url, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			var response struct {
+				Count         int     `json:"count"`
+				Total         int64   `json:"total"`
+				TotalRelation string  `json:"totalRelation"`
+				HasMore       bool    `json:"hasMore"`
+				Page          int     `json:"page"`
+				Limit         int     `json:"limit"`
+				TookMs        float64 `json:"tookMs"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+
+			if response.Count != tc.expectedCount {
+				t.Errorf("Expected count %d, got %d", tc.expectedCount, response.Count)
+			}
+			if response.Total != tc.expectedTotal || response.TotalRelation != "eq" {
+				t.Errorf("Expected exact total %d, got %d (%s)", tc.expectedTotal, response.Total, response.TotalRelation)
+			}
+			if response.HasMore != tc.expectedHasMore {
+				t.Errorf("Expected hasMore %v, got %v", tc.expectedHasMore, response.HasMore)
+			}
+			if response.Page != tc.expectedPage || response.Limit != tc.expectedLimit {
+				t.Errorf("Expected page %d limit %d, got page %d limit %d", tc.expectedPage, tc.expectedLimit, response.Page, response.Limit)
+			}
+		})
+	}
+}
+
+func TestQueryLogsCappedTotal(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := database.NewMockDB()
+	for i := 0; i < 5; i++ {
+		mockDB.InsertLog(context.TODO(), &models.Log{Level: "info", Message: "entry", Timestamp: time.Now()})
+	}
+
+	logIngestor := NewLogIngestor(mockDB)
+	logIngestor.SetCountLimit(3)
+
+	router := gin.Default()
+	router.GET("/logs", logIngestor.QueryLogs)
+
+	req, _ := http.NewRequest("GET", "/logs?limit=2", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if response["total"] != float64(3) || response["totalRelation"] != "gte" {
+		t.Errorf("Expected total to be capped at 3 (gte), got %v (%v)", response["total"], response["totalRelation"])
+	}
+}
+
+func TestQueryLogsWithQueryLanguage(t *testing.T) {
+	router, mockDB := setupTestRouter()
+
+	ctx := context.TODO()
+	mockDB.InsertLog(ctx, &models.Log{Level: "error", Message: "API request timed out", ResourceID: "server-1234", Timestamp: time.Now()})
+	mockDB.InsertLog(ctx, &models.Log{Level: "info", Message: "Cache miss for key", ResourceID: "server-1234", Timestamp: time.Now()})
+
+	req, _ := http.NewRequest("GET", "/logs?q="+url.QueryEscape(`resourceId:server-* AND message:"timed out"`), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response["total"] != float64(1) {
+		t.Errorf("Expected 1 matching log, got %v", response["total"])
+	}
+
+	// Syntax errors are reported with their position
+	req, _ = http.NewRequest("GET", "/logs?q="+url.QueryEscape(`level:error AND (resourceId:x`), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response["position"] != float64(16) {
+		t.Errorf("Expected error at position 16, got %v", response["position"])
+	}
+}
+
+func TestQueryLogsByRelevance(t *testing.T) {
+	router, mockDB := setupTestRouter()
+
+	base, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	for i, message := range []string{"Disk cache full", "Disk usage high", "Cache miss", "Disk cache flushed, disk idle"} {
+		mockDB.InsertLog(context.TODO(), &models.Log{Level: "info", Message: message, Timestamp: base.Add(time.Duration(i) * time.Minute)})
+	}
+
+	var response struct {
+		Logs       []models.Log `json:"logs"`
+		HasMore    bool         `json:"hasMore"`
+		NextCursor string       `json:"nextCursor"`
+	}
+	req, _ := http.NewRequest("GET", "/logs?search="+url.QueryEscape("disk cache")+"&sort=relevance&limit=3", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	// Logs with both terms rank first, and repeats rank higher
+	if len(response.Logs) != 3 || response.Logs[0].Message != "Disk cache flushed, disk idle" || response.Logs[1].Message != "Disk cache full" {
+		t.Fatalf("Unexpected order: %+v", response.Logs)
+	}
+	for i := 1; i < len(response.Logs); i++ {
+		if response.Logs[i].Score <= 0 || response.Logs[i].Score > response.Logs[i-1].Score {
+			t.Errorf("Expected descending scores, got %+v", response.Logs)
+		}
+	}
+
+	// Relevance pages are numbered, never continued by cursor
+	if !response.HasMore || response.NextCursor != "" {
+		t.Errorf("Expected more logs without a cursor, got hasMore %v and cursor %q", response.HasMore, response.NextCursor)
+	}
+
+	for _, query := range []string{"sort=relevance", "sort=relevance&search=disk&cursor=" + models.EncodeCursor(&response.Logs[0]), "sort=size"} {
+		req, _ := http.NewRequest("GET", "/logs?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestQueryLogsSortAndFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB := database.NewMockDB()
+	logIngestor := NewLogIngestor(mockDB)
+	router := gin.New()
+	router.GET("/logs", logIngestor.QueryLogs)
+
+	base, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	for i, level := range []string{"warning", "error", "info", "error"} {
+		mockDB.InsertLog(context.TODO(), &models.Log{Level: level, Message: "Event", ResourceID: "server-1", Timestamp: base.Add(time.Duration(i) * time.Minute), Metadata: map[string]string{"region": "eu"}})
+	}
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Ties on level are ordered newest first
+	w := get("/logs?sort=level:asc&fields=level,metadata.region&limit=3")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response struct {
+		Logs       []map[string]any `json:"logs"`
+		HasMore    bool             `json:"hasMore"`
+		NextCursor string           `json:"nextCursor"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	var levels []any
+	for _, log := range response.Logs {
+		levels = append(levels, log["level"])
+		for _, key := range []string{"message", "resourceId"} {
+			if _, ok := log[key]; ok {
+				t.Errorf("Expected %s to be left out, got %v", key, log)
+			}
+		}
+		if log["id"] == nil || log["timestamp"] == nil || !reflect.DeepEqual(log["metadata"], map[string]any{"region": "eu"}) {
+			t.Errorf("Expected the id, timestamp and region, got %v", log)
+		}
+	}
+	if !reflect.DeepEqual(levels, []any{"error", "error", "info"}) || response.Logs[0]["timestamp"] != "2023-09-15T08:03:00Z" {
+		t.Errorf("Unexpected order: %v", response.Logs)
+	}
+
+	// Sorted results are paged by page number only
+	if !response.HasMore || response.NextCursor != "" {
+		t.Errorf("Expected more logs without a cursor, got hasMore %v and cursor %q", response.HasMore, response.NextCursor)
+	}
+
+	for _, query := range []string{
+		"sort=spanId",
+		"sort=metadata.region",
+		"sort=level:up",
+		"sort=level&cursor=" + models.EncodeCursor(&models.Log{ID: "1", Timestamp: base}),
+		"fields=size",
+		"fields=level&highlight=true&search=event",
+	} {
+		if w := get("/logs?" + query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %d, got %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
+
+	// Metadata keys the backend indexes are sortable
+	mockDB.MetadataIndexKeys = []string{"region"}
+	if w := get("/logs?sort=metadata.region:desc,timestamp:asc"); w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// Highlights are left out with the message they point into
+	query := &models.LogQuery{Highlight: true, FullTextSearch: "event"}
+	logs := []*models.Log{{ID: "1", Level: "info", Message: "Event", Timestamp: base}}
+	if result := projectedLogs(logs, []string{"level"}, query)[0]; result["highlights"] != nil {
+		t.Errorf("Expected no highlights without the message, got %v", result)
+	}
+	if result := projectedLogs(logs, []string{"message"}, query)[0]; result["highlights"] == nil {
+		t.Errorf("Expected highlights with the message, got %v", result)
+	}
+}
+
+func TestQueryLogsWithHighlights(t *testing.T) {
+	router, mockDB := setupTestRouter()
+	mockDB.InsertLog(context.TODO(), &models.Log{Level: "error", Message: "Cache miss for user profile", Timestamp: time.Now()})
+
+	var response struct {
+		Logs []struct {
+			Message    string             `json:"message"`
+			Highlights []models.Highlight `json:"highlights"`
+		} `json:"logs"`
+	}
+	req, _ := http.NewRequest("GET", "/logs?highlight=true&search="+url.QueryEscape(`"user profile" cache`)+"&regex=miss", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	expected := []models.Highlight{{Start: 0, End: 5}, {Start: 6, End: 10}, {Start: 15, End: 27}}
+	if len(response.Logs) != 1 || !reflect.DeepEqual(response.Logs[0].Highlights, expected) {
+		t.Errorf("Expected highlights %v, got %+v", expected, response.Logs)
+	}
+
+	// Highlights are only returned on request
+	req, _ = http.NewRequest("GET", "/logs?search=cache", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if strings.Contains(w.Body.String(), "highlights") {
+		t.Errorf("Expected no highlights, got %s", w.Body.String())
+	}
+}
+
+func TestQueryLogsWithRegex(t *testing.T) {
+	router, mockDB := setupTestRouter()
+	mockDB.InsertLog(context.TODO(), &models.Log{Level: "error", Message: "Parse error near a.b(", Timestamp: time.Now()})
+	mockDB.InsertLog(context.TODO(), &models.Log{Level: "error", Message: "Parse error near axb(", Timestamp: time.Now()})
+
+	// Message filters match literally
+	var response map[string]interface{}
+	req, _ := http.NewRequest("GET", "/logs?message="+url.QueryEscape("a.b("), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response["total"] != float64(1) {
+		t.Errorf("Expected 1 matching log, got %v", response["total"])
+	}
+
+	req, _ = http.NewRequest("GET", "/logs?regexFlags=ca&regex="+url.QueryEscape(`Parse error near a.b\(`), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response["total"] != float64(2) {
+		t.Errorf("Expected 2 matching logs, got %v", response["total"])
+	}
+
+	// Invalid patterns are rejected with the compile error
+	for _, params := range []string{"regex=" + url.QueryEscape("a.b("), "regex=" + url.QueryEscape("(a+)+"), "regex=a&regexFlags=z"} {
+		req, _ = http.NewRequest("GET", "/logs?"+params, nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %d, got %d", params, http.StatusBadRequest, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "invalid regex") {
+			t.Errorf("%s: expected an invalid regex error, got %s", params, w.Body.String())
+		}
+	}
+}
+
+func TestQueryLogsTimeBudget(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logIngestor := NewLogIngestor(database.NewMockDB())
+	logIngestor.SetQueryTimeout(time.Nanosecond)
+
+	router := gin.New()
+	router.GET("/logs", logIngestor.QueryLogs)
+
+	req, _ := http.NewRequest("GET", "/logs?regex=error", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status code %d, got %d", http.StatusGatewayTimeout, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), database.ErrQueryTimeout.Error()) {
+		t.Errorf("Expected a time budget error, got %s", w.Body.String())
+	}
+}
+
+func TestQueryLogsWithMetadataFilters(t *testing.T) {
+	router, mockDB := setupTestRouter()
+
+	ctx := context.TODO()
+	mockDB.InsertLog(ctx, &models.Log{Level: "info", Message: "a", Timestamp: time.Now(), Metadata: map[string]string{"region": "us-east-1"}})
+	mockDB.InsertLog(ctx, &models.Log{Level: "info", Message: "b", Timestamp: time.Now(), Metadata: map[string]string{"region": "eu-west-1"}})
+
+	req, _ := http.NewRequest("GET", "/logs?metadata.region:prefix=us-", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response["total"] != float64(1) {
+		t.Errorf("Expected 1 matching log, got %v", response["total"])
+	}
+
+	req, _ = http.NewRequest("GET", "/logs?metadata.$where=1", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for invalid key, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestQueryLogsWithMultiValueFilters(t *testing.T) {
+	router, mockDB := setupTestRouter()
+
+	ctx := context.TODO()
+	mockDB.InsertLog(ctx, &models.Log{Level: "error", Message: "a", ResourceID: "server-1", Timestamp: time.Now()})
+	mockDB.InsertLog(ctx, &models.Log{Level: "warning", Message: "b", ResourceID: "health-check", Timestamp: time.Now()})
+	mockDB.InsertLog(ctx, &models.Log{Level: "info", Message: "c", ResourceID: "server-2", Timestamp: time.Now()})
+	mockDB.InsertLog(ctx, &models.Log{Level: "warning", Message: "d", ResourceID: "server-3", Timestamp: time.Now(), Metadata: map[string]string{"region": "eu"}})
+
+	testCases := []struct {
+		url           string
+		expectedTotal float64
+	}{
+		{"/logs?level=error&level=warning", 3},
+		{"/logs?-resourceId=health-check", 3},
+		{"/logs?level=error&level=warning&resourceId!=health-check", 2},
+		{"/logs?level!=info&level!=error", 2},
+		{"/logs?-metadata.region=eu", 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.url, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tc.url, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			var response map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if response["total"] != tc.expectedTotal {
+				t.Errorf("Expected %v matching logs, got %v", tc.expectedTotal, response["total"])
+			}
+		})
+	}
+}
+
+func TestQueryLogsScopedReader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// A reader limited to payments resources that may not see user IDs
+	path := filepath.Join(t.TempDir(), "keys.json")
+	keys := `{
+		"roles": [{"name": "payments-reader", "base": "reader", "scope": {"resourceIdPrefixes": ["payments-"], "redactMetadata": ["userId"]}}],
+		"keys": [{"name": "payments", "keyHash": "` + auth.HashKey("payments-key") + `", "role": "payments-reader"}]
+	}`
+	if err := os.WriteFile(path, []byte(keys), 0o600); err != nil {
+		t.Fatalf("Failed to write keys file: %v", err)
+	}
+	store, err := auth.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	reader := auth.NewAuthenticator(store).Require(auth.RoleReader)
+
+	mockDB := database.NewMockDB()
+	logIngestor := NewLogIngestor(mockDB)
+
+	router := gin.New()
+	router.GET("/logs", reader, logIngestor.QueryLogs)
+	router.GET("/logs/stats", reader, logIngestor.HandleStats)
+
+	ctx := context.TODO()
+	mockDB.InsertLog(ctx, &models.Log{Level: "error", Message: "a", ResourceID: "payments-api", Timestamp: time.Now(), Metadata: map[string]string{"userId": "u-1", "region": "us"}})
+	mockDB.InsertLog(ctx, &models.Log{Level: "error", Message: "b", ResourceID: "search-api", Timestamp: time.Now()})
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer payments-key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Only in-scope logs are returned, without redacted metadata
+	w := get("/logs?level=error")
+	var response struct {
+		Logs  []models.Log `json:"logs"`
+		Total int64        `json:"total"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response.Total != 1 || len(response.Logs) != 1 || response.Logs[0].ResourceID != "payments-api" {
+		t.Fatalf("Expected only the payments log, got %+v", response)
+	}
+	if _, ok := response.Logs[0].Metadata["userId"]; ok || response.Logs[0].Metadata["region"] != "us" {
+		t.Errorf("Expected userId to be redacted, got %v", response.Logs[0].Metadata)
+	}
+
+	// Explicit filters cannot widen the scope
+	if w := get("/logs?resourceId=search-api"); !bytes.Contains(w.Body.Bytes(), []byte(`"total":0`)) {
+		t.Errorf("Expected no logs outside the scope, got %s", w.Body.String())
+	}
+	if w := get("/logs?q=resourceId:search-api+OR+level:error"); !bytes.Contains(w.Body.Bytes(), []byte(`"total":1`)) {
+		t.Errorf("Expected the scope to be ANDed with the query, got %s", w.Body.String())
+	}
+
+	// Redacted keys cannot be filtered, sorted or grouped on
+	for _, url := range []string{"/logs?metadata.userId=u-1", "/logs?q=metadata.userId:u*", "/logs?sort=metadata.userId", "/logs/stats?groupBy=metadata.userId"} {
+		if w := get(url); w.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d for %s, got %d", http.StatusForbidden, url, w.Code)
+		}
+	}
+
+	// Aggregations are scoped too
+	if w := get("/logs/stats?groupBy=resourceId"); !bytes.Contains(w.Body.Bytes(), []byte(`"total":1`)) {
+		t.Errorf("Expected stats over in-scope logs only, got %s", w.Body.String())
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Authentication is disabled, so the tenant comes from the header
+	mockDB := database.NewMockDB()
+	mockDB.TenantConfig = &database.TenantConfig{
+		Tenants: map[string]database.TenantLimits{"globex": {MaxLogs: 1}},
+	}
+	logIngestor := NewLogIngestor(mockDB)
+	authenticator := auth.NewAuthenticator(nil)
+
+	router := gin.New()
+	router.POST("/", authenticator.Require(auth.RoleIngest), logIngestor.HandleLogIngestion)
+	router.POST("/bulk", authenticator.Require(auth.RoleIngest), logIngestor.HandleBulkIngestion)
+	router.GET("/logs", authenticator.Require(auth.RoleReader), logIngestor.QueryLogs)
+
+	send := func(method, url, tenant, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if tenant != "" {
+			req.Header.Set(auth.TenantHeader, tenant)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send("POST", "/", "acme", `{"level": "error", "message": "acme failure"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if w := send("POST", "/bulk", "globex", `{"level": "error", "message": "globex failure"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	// Each tenant reads only its own logs
+	for tenant, expected := range map[string]string{"acme": "acme failure", "globex": "globex failure"} {
+		w := send("GET", "/logs", tenant, "")
+		var response struct {
+			Logs []models.Log `json:"logs"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if len(response.Logs) != 1 || response.Logs[0].Message != expected {
+			t.Errorf("Expected only %q for tenant %s, got %+v", expected, tenant, response.Logs)
+		}
+	}
+	if count, _ := mockDB.CountLogs(context.Background(), &models.LogQuery{}, 0); count != 0 {
+		t.Errorf("Expected no logs for the default tenant, got %d", count)
+	}
+
+	// globex is at its quota
+	if w := send("POST", "/", "globex", `{"level": "info", "message": "one too many"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status code %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+}
+
+func TestBufferedIngestionRejectsOverQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := database.NewMockDB()
+	mockDB.TenantConfig = &database.TenantConfig{Default: database.TenantLimits{MaxLogs: 1}}
+	buffer := NewBuffer(mockDB, BufferConfig{QueueSize: 10, BatchSize: 100, FlushInterval: time.Hour, Workers: 1})
+	defer buffer.Close(context.Background())
+
+	logIngestor := NewLogIngestor(mockDB)
+	logIngestor.UseBuffer(buffer)
+
+	router := gin.New()
+	router.POST("/bulk", logIngestor.HandleBulkIngestion)
+
+	// The quota is checked before the batch is accepted
+	req, _ := http.NewRequest("POST", "/bulk", bytes.NewBufferString(`[{"level": "info", "message": "one"}, {"level": "info", "message": "two"}]`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status code %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if stats := buffer.Stats(); stats.Enqueued != 0 {
+		t.Errorf("Expected nothing to be enqueued, got %d", stats.Enqueued)
+	}
+}
@@ -0,0 +1,56 @@
+package ingestor
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"log-ingestor/internal/auth"
+	"log-ingestor/internal/models"
+)
+
+// HandleStats handles the log stats HTTP request, returning a histogram of
+// the logs matching the query's filters and optionally their top values
+// for a field
+func (li *LogIngestor) HandleStats(c *gin.Context) {
+	var query models.LogQuery
+	if !bindLogQuery(c, &query) {
+		return
+	}
+
+	request, err := models.ParseStatsRequest(c.Request.URL.Query(), &query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Group values would reveal redacted metadata
+	if scopeOf(c).Redacts(request.GroupBy) {
+		c.JSON(http.StatusForbidden, gin.H{"error": auth.ErrRedactedField.Error() + ": " + request.GroupBy})
+		return
+	}
+
+	// Aggregate logs in the database
+	ctx, cancel := tenantContext(c, 30*time.Second)
+	defer cancel()
+
+	start := time.Now()
+
+	stats, err := li.db.AggregateLogs(ctx, &query, request)
+	if err != nil {
+		log.Printf("Error aggregating logs: %v", err)
+		respondQueryFailure(c, "Failed to aggregate logs", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":    stats.Total,
+		"interval": stats.Interval,
+		"buckets":  stats.Buckets,
+		"groupBy":  stats.GroupBy,
+		"groups":   stats.Groups,
+		"tookMs":   durationMs(time.Since(start)),
+	})
+}
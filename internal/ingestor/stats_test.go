@@ -0,0 +1,88 @@
+package ingestor
+
+import (
+	"context"
+	"encoding/json"
+	"log-ingestor/internal/database"
+	"log-ingestor/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupStatsRouter() (*gin.Engine, *database.MockDB) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := database.NewMockDB()
+	logIngestor := NewLogIngestor(mockDB)
+
+	router := gin.New()
+	router.GET("/logs/stats", logIngestor.HandleStats)
+
+	return router, mockDB
+}
+
+func TestHandleStats(t *testing.T) {
+	router, mockDB := setupStatsRouter()
+
+	base, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	mockDB.InsertLogs(context.TODO(), []*models.Log{
+		{Level: "error", Message: "a", ResourceID: "server-1234", Timestamp: base},
+		{Level: "error", Message: "b", ResourceID: "server-1234", Timestamp: base.Add(2 * time.Minute)},
+		{Level: "error", Message: "c", ResourceID: "server-5678", Timestamp: base.Add(2 * time.Minute)},
+		{Level: "info", Message: "d", ResourceID: "server-1234", Timestamp: base.Add(time.Minute)},
+	})
+
+	req, _ := http.NewRequest("GET", "/logs/stats?level=error&interval=1m&groupBy=resourceId&top=1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Total    int64                `json:"total"`
+		Interval string               `json:"interval"`
+		Buckets  []models.StatsBucket `json:"buckets"`
+		GroupBy  string               `json:"groupBy"`
+		Groups   []models.TermCount   `json:"groups"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if response.Total != 3 || response.Interval != "1m0s" {
+		t.Errorf("Unexpected total %d or interval %s", response.Total, response.Interval)
+	}
+	if len(response.Buckets) != 3 || response.Buckets[0].Count != 1 || response.Buckets[1].Count != 0 || response.Buckets[2].Count != 2 {
+		t.Errorf("Unexpected buckets %+v", response.Buckets)
+	}
+	if response.GroupBy != "resourceId" || len(response.Groups) != 1 || response.Groups[0] != (models.TermCount{Value: "server-1234", Count: 2}) {
+		t.Errorf("Unexpected groups %+v", response.Groups)
+	}
+}
+
+func TestHandleStatsErrors(t *testing.T) {
+	router, mockDB := setupStatsRouter()
+
+	for _, url := range []string{"/logs/stats?interval=never", "/logs/stats?groupBy=message", "/logs/stats?q=level:"} {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, got %d", http.StatusBadRequest, url, w.Code)
+		}
+	}
+
+	mockDB.SimulateError = true
+	req, _ := http.NewRequest("GET", "/logs/stats", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status code %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+}
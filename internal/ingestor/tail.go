@@ -0,0 +1,144 @@
+package ingestor
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+
+	"log-ingestor/internal/auth"
+	"log-ingestor/internal/models"
+)
+
+// tailHeartbeat is how often an idle tail stream is sent a keep-alive, so
+// proxies do not close it and dropped-log notices are not delayed forever
+var tailHeartbeat = 15 * time.Second
+
+// tailMessage is a WebSocket frame sent to tail clients
+type tailMessage struct {
+	Type    string      `json:"type"`
+	Log     *models.Log `json:"log,omitempty"`
+	Dropped uint64      `json:"dropped,omitempty"`
+}
+
+// HandleTail streams newly ingested logs matching the request's filters.
+// WebSocket upgrade requests are served over WebSocket; everything else
+// receives Server-Sent Events.
+func (li *LogIngestor) HandleTail(c *gin.Context) {
+	var query models.LogQuery
+	if !bindLogQuery(c, &query) {
+		return
+	}
+
+	// Live logs are matched in memory, which has no equivalent of MongoDB's
+	// regular expression dialect or text index
+	if query.RegexPattern != "" || query.FullTextSearch != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "regex and search are not supported when tailing; use q or message instead"})
+		return
+	}
+
+	sub, err := li.hub.Subscribe(auth.TenantOf(c), &query)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer li.hub.Unsubscribe(sub)
+
+	scope := scopeOf(c)
+	if strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+		// Tail is read-only, so connections are accepted from any origin
+		server := websocket.Server{Handler: func(ws *websocket.Conn) {
+			streamWebSocket(ws, sub, scope)
+		}}
+		server.ServeHTTP(c.Writer, c.Request)
+		return
+	}
+
+	streamSSE(c, sub, scope)
+}
+
+// streamSSE writes subscription logs as "log" events and missed logs as
+// "dropped" events until the client disconnects or the hub closes
+func streamSSE(c *gin.Context, sub *Subscription, scope *auth.Scope) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// Tell the client the stream is open before the first log arrives
+	c.SSEvent("ready", gin.H{"bufferSize": cap(sub.ch)})
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(tailHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case entry, ok := <-sub.C:
+			if !ok {
+				return false
+			}
+			if dropped := sub.TakeDropped(); dropped > 0 {
+				c.SSEvent("dropped", gin.H{"count": dropped})
+			}
+			c.SSEvent("log", scope.Redact(entry))
+		case <-heartbeat.C:
+			if dropped := sub.TakeDropped(); dropped > 0 {
+				c.SSEvent("dropped", gin.H{"count": dropped})
+			} else if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// streamWebSocket writes subscription logs as JSON frames until the client
+// disconnects or the hub closes
+func streamWebSocket(ws *websocket.Conn, sub *Subscription, scope *auth.Scope) {
+	defer ws.Close()
+
+	// The client sends nothing, so a failed read means it has gone away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		io.Copy(io.Discard, ws)
+	}()
+
+	heartbeat := time.NewTicker(tailHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var messages []tailMessage
+		select {
+		case <-closed:
+			return
+		case entry, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if dropped := sub.TakeDropped(); dropped > 0 {
+				messages = append(messages, tailMessage{Type: "dropped", Dropped: dropped})
+			}
+			messages = append(messages, tailMessage{Type: "log", Log: scope.Redact(entry)})
+		case <-heartbeat.C:
+			if dropped := sub.TakeDropped(); dropped > 0 {
+				messages = append(messages, tailMessage{Type: "dropped", Dropped: dropped})
+			} else {
+				messages = append(messages, tailMessage{Type: "ping"})
+			}
+		}
+
+		for _, message := range messages {
+			if err := websocket.JSON.Send(ws, message); err != nil {
+				return
+			}
+		}
+	}
+}
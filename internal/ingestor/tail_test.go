@@ -0,0 +1,192 @@
+package ingestor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log-ingestor/internal/database"
+	"log-ingestor/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// setupTailServer starts a server with ingestion and tail routes
+func setupTailServer() (*httptest.Server, *LogIngestor) {
+	gin.SetMode(gin.TestMode)
+
+	logIngestor := NewLogIngestor(database.NewMockDB())
+
+	router := gin.New()
+	router.POST("/", logIngestor.HandleLogIngestion)
+	router.POST("/bulk", logIngestor.HandleBulkIngestion)
+	router.GET("/logs/tail", logIngestor.HandleTail)
+
+	return httptest.NewServer(router), logIngestor
+}
+
+// readEvent reads the next Server-Sent Event, skipping comments
+func readEvent(t *testing.T, reader *bufio.Reader) (string, string) {
+	t.Helper()
+
+	var event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+
+		switch {
+		case line == "" && event != "":
+			return event, data
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(line[len("event:"):])
+		case strings.HasPrefix(line, "data:"):
+			data = strings.TrimSpace(line[len("data:"):])
+		}
+	}
+}
+
+func TestHandleTailSSE(t *testing.T) {
+	server, logIngestor := setupTailServer()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/logs/tail?level=error&level=warning")
+	if err != nil {
+		t.Fatalf("Failed to open tail: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %s", contentType)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	if event, _ := readEvent(t, reader); event != "ready" {
+		t.Fatalf("Expected ready event, got %s", event)
+	}
+
+	// Only the warning matches the tail's filters
+	http.Post(server.URL+"/", "application/json", bytes.NewBufferString(`{"level":"info","message":"ignored"}`))
+	http.Post(server.URL+"/bulk", "application/x-ndjson", bytes.NewBufferString(`{"level":"warning","message":"disk almost full"}`))
+
+	event, data := readEvent(t, reader)
+	if event != "log" || !strings.Contains(data, "disk almost full") {
+		t.Errorf("Expected the warning log, got %s %s", event, data)
+	}
+
+	// Shutting the hub down ends the stream
+	logIngestor.hub.Close()
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, reader)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Expected the stream to end when the hub closes")
+	}
+}
+
+func TestHandleTailBufferedIDs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := database.NewMockDB()
+	logIngestor := NewLogIngestor(mockDB)
+	buffer := NewBuffer(mockDB, BufferConfig{QueueSize: 10, BatchSize: 1, FlushInterval: time.Hour, Workers: 1})
+	defer buffer.Close(context.Background())
+	logIngestor.UseBuffer(buffer)
+
+	router := gin.New()
+	router.POST("/", logIngestor.HandleLogIngestion)
+	router.POST("/bulk", logIngestor.HandleBulkIngestion)
+	router.GET("/logs/tail", logIngestor.HandleTail)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/logs/tail")
+	if err != nil {
+		t.Fatalf("Failed to open tail: %v", err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	if event, _ := readEvent(t, reader); event != "ready" {
+		t.Fatalf("Expected ready event, got %s", event)
+	}
+
+	http.Post(server.URL+"/", "application/json", bytes.NewBufferString(`{"level":"info","message":"single"}`))
+	http.Post(server.URL+"/bulk", "application/x-ndjson", bytes.NewBufferString(`{"level":"info","message":"bulk"}`))
+
+	// Buffered logs reach subscribers under the IDs they are stored under
+	tailed := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		_, data := readEvent(t, reader)
+		var entry models.Log
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			t.Fatalf("Failed to unmarshal log: %v", err)
+		}
+		tailed[entry.Message] = entry.ID
+	}
+
+	waitFor(t, time.Second, func() bool { return countLogs(mockDB) == 2 })
+	logs, _ := mockDB.QueryLogs(context.Background(), &models.LogQuery{})
+	for _, log := range logs {
+		if log.ID == "" || tailed[log.Message] != log.ID {
+			t.Errorf("Expected %q to be tailed with ID %q, got %q", log.Message, log.ID, tailed[log.Message])
+		}
+	}
+}
+
+func TestHandleTailInvalidQuery(t *testing.T) {
+	server, _ := setupTailServer()
+	defer server.Close()
+
+	for _, query := range []string{"q=level:error+AND", "regex=fail.*", "search=timeout"} {
+		resp, err := http.Get(server.URL + "/logs/tail?" + query)
+		if err != nil {
+			t.Fatalf("Failed to open tail: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status code %d for %s, got %d", http.StatusBadRequest, query, resp.StatusCode)
+		}
+	}
+}
+
+func TestHandleTailWebSocket(t *testing.T) {
+	server, logIngestor := setupTailServer()
+	defer server.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/logs/tail?q=timeout", "", server.URL)
+	if err != nil {
+		t.Fatalf("Failed to dial tail: %v", err)
+	}
+	defer ws.Close()
+
+	// Wait for the subscription before ingesting
+	deadline := time.Now().Add(time.Second)
+	for logIngestor.hub.Stats().Subscribers == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	http.Post(server.URL+"/", "application/json", bytes.NewBufferString(`{"level":"error","message":"request timeout"}`))
+
+	var message tailMessage
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	if err := websocket.JSON.Receive(ws, &message); err != nil {
+		t.Fatalf("Failed to receive message: %v", err)
+	}
+	if message.Type != "log" || message.Log == nil || message.Log.Message != "request timeout" {
+		t.Errorf("Unexpected message %+v", message)
+	}
+}
@@ -0,0 +1,66 @@
+package ingestor
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"log-ingestor/internal/database"
+	"log-ingestor/internal/models"
+)
+
+// maxTraceLogs caps the logs read for a trace; the newest are kept
+const maxTraceLogs = 10000
+
+// HandleTrace handles the trace HTTP request, returning every log with
+// the trace ID grouped by span, with the resources involved arranged by
+// their parentResourceId metadata
+func (li *LogIngestor) HandleTrace(c *gin.Context) {
+	query := models.LogQuery{TraceID: c.Param("traceId"), Limit: maxTraceLogs + 1}
+
+	// Restrict the trace to the logs the caller may see
+	scope := scopeOf(c)
+	if err := scope.Apply(&query); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := tenantContext(c, 30*time.Second)
+	defer cancel()
+
+	start := time.Now()
+
+	var logs []*models.Log
+	err := database.StreamLogs(ctx, li.db, &query, func(entry *models.Log) error {
+		logs = append(logs, scope.Redact(entry))
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error reading trace: %v", err)
+		respondQueryFailure(c, "Failed to read trace", err)
+		return
+	}
+	if len(logs) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "trace not found"})
+		return
+	}
+
+	// One log past the cap is read to tell whether the trace was truncated
+	truncated := len(logs) > maxTraceLogs
+	if truncated {
+		logs = logs[:maxTraceLogs]
+	}
+
+	trace := models.BuildTrace(query.TraceID, logs)
+	trace.Truncated = truncated
+
+	c.JSON(http.StatusOK, traceResponse{Trace: trace, TookMs: durationMs(time.Since(start))})
+}
+
+// traceResponse is a trace with the time taken to read it
+type traceResponse struct {
+	*models.Trace
+	TookMs float64 `json:"tookMs"`
+}
@@ -0,0 +1,148 @@
+package ingestor
+
+import (
+	"context"
+	"encoding/json"
+	"log-ingestor/internal/auth"
+	"log-ingestor/internal/database"
+	"log-ingestor/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupTraceRouter() (*gin.Engine, *database.MockDB) {
+	gin.SetMode(gin.TestMode)
+
+	mockDB := database.NewMockDB()
+	logIngestor := NewLogIngestor(mockDB)
+
+	router := gin.New()
+	router.GET("/traces/:traceId", logIngestor.HandleTrace)
+
+	return router, mockDB
+}
+
+func TestHandleTrace(t *testing.T) {
+	router, mockDB := setupTraceRouter()
+
+	base, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	mockDB.InsertLogs(context.TODO(), []*models.Log{
+		{Level: "info", Message: "Request received", ResourceID: "gateway", TraceID: "abc", SpanID: "span-1", Timestamp: base},
+		{Level: "error", Message: "Query failed", ResourceID: "server-1", TraceID: "abc", SpanID: "span-2", Timestamp: base.Add(200 * time.Millisecond), Metadata: map[string]string{"parentResourceId": "gateway"}},
+		{Level: "info", Message: "Response sent", ResourceID: "gateway", TraceID: "abc", SpanID: "span-1", Timestamp: base.Add(1500 * time.Millisecond)},
+		{Level: "info", Message: "Other trace", ResourceID: "gateway", TraceID: "abcd", SpanID: "span-1", Timestamp: base},
+	})
+
+	req, _ := http.NewRequest("GET", "/traces/abc", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var trace models.Trace
+	if err := json.Unmarshal(w.Body.Bytes(), &trace); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if trace.TraceID != "abc" || trace.LogCount != 3 || trace.ErrorCount != 1 || trace.DurationMs != 1500 || trace.Truncated {
+		t.Errorf("Unexpected trace: %+v", trace)
+	}
+	if len(trace.Spans) != 2 || trace.Spans[0].SpanID != "span-1" || trace.Spans[0].DurationMs != 1500 || trace.Spans[0].Logs[0].Message != "Request received" {
+		t.Fatalf("Unexpected spans: %+v", trace.Spans)
+	}
+	if trace.Spans[1].ErrorCount != 1 || trace.Spans[1].ResourceIDs[0] != "server-1" {
+		t.Errorf("Unexpected span: %+v", trace.Spans[1])
+	}
+	if len(trace.Resources) != 1 || trace.Resources[0].ResourceID != "gateway" || len(trace.Resources[0].Children) != 1 || trace.Resources[0].Children[0].ResourceID != "server-1" {
+		t.Errorf("Unexpected resource hierarchy: %+v", trace.Resources)
+	}
+
+	req, _ = http.NewRequest("GET", "/traces/missing", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestHandleTraceTruncated(t *testing.T) {
+	router, mockDB := setupTraceRouter()
+
+	base, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	logs := make([]*models.Log, maxTraceLogs+1)
+	for i := range logs {
+		logs[i] = &models.Log{Level: "info", Message: "Step", TraceID: "long", Timestamp: base.Add(time.Duration(i) * time.Millisecond)}
+	}
+	mockDB.InsertLogs(context.TODO(), logs)
+
+	req, _ := http.NewRequest("GET", "/traces/long", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var trace models.Trace
+	if err := json.Unmarshal(w.Body.Bytes(), &trace); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	// The newest logs are kept
+	if !trace.Truncated || trace.LogCount != maxTraceLogs || !trace.Start.Equal(base.Add(time.Millisecond)) {
+		t.Errorf("Expected the newest %d logs, got %d from %v (truncated %v)", maxTraceLogs, trace.LogCount, trace.Start, trace.Truncated)
+	}
+}
+
+func TestHandleTraceScopedReader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	keys := `{
+		"roles": [{"name": "payments-reader", "base": "reader", "scope": {"resourceIdPrefixes": ["payments-"], "redactMetadata": ["userId"]}}],
+		"keys": [{"name": "payments", "keyHash": "` + auth.HashKey("payments-key") + `", "role": "payments-reader"}]
+	}`
+	if err := os.WriteFile(path, []byte(keys), 0o600); err != nil {
+		t.Fatalf("Failed to write keys file: %v", err)
+	}
+	store, err := auth.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+
+	mockDB := database.NewMockDB()
+	logIngestor := NewLogIngestor(mockDB)
+	router := gin.New()
+	router.GET("/traces/:traceId", auth.NewAuthenticator(store).Require(auth.RoleReader), logIngestor.HandleTrace)
+
+	ctx := context.TODO()
+	mockDB.InsertLog(ctx, &models.Log{Level: "info", Message: "a", ResourceID: "payments-api", TraceID: "abc", Timestamp: time.Now(), Metadata: map[string]string{"userId": "u-1"}})
+	mockDB.InsertLog(ctx, &models.Log{Level: "info", Message: "b", ResourceID: "search-api", TraceID: "abc", Timestamp: time.Now()})
+	mockDB.InsertLog(ctx, &models.Log{Level: "info", Message: "c", ResourceID: "search-api", TraceID: "def", Timestamp: time.Now()})
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer payments-key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Only in-scope logs are part of the trace, without redacted metadata
+	var trace models.Trace
+	if err := json.Unmarshal(get("/traces/abc").Body.Bytes(), &trace); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if trace.LogCount != 1 || len(trace.Spans) != 1 || trace.Spans[0].Logs[0].ResourceID != "payments-api" {
+		t.Fatalf("Expected only the payments log, got %+v", trace)
+	}
+	if _, ok := trace.Spans[0].Logs[0].Metadata["userId"]; ok {
+		t.Errorf("Expected userId to be redacted, got %v", trace.Spans[0].Logs[0].Metadata)
+	}
+
+	if w := get("/traces/def"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for a trace outside the scope, got %d", http.StatusNotFound, w.Code)
+	}
+}
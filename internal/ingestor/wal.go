@@ -0,0 +1,322 @@
+package ingestor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"log-ingestor/internal/models"
+)
+
+const (
+	// walSegmentExt is the file extension of WAL segments
+	walSegmentExt = ".wal"
+
+	// walHeaderSize is the size of a record header: payload length and CRC32
+	walHeaderSize = 8
+
+	// walMaxRecordSize guards replay against corrupt length prefixes
+	walMaxRecordSize = 16 << 20
+)
+
+// walChecksumTable is the CRC32 polynomial used for record checksums
+var walChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WALConfig configures the write-ahead log
+type WALConfig struct {
+	// Dir is the directory holding WAL segments
+	Dir string
+
+	// SegmentSize is the size in bytes after which a new segment is started
+	SegmentSize int64
+
+	// Sync forces an fsync after every append
+	Sync bool
+}
+
+// WALBatch is a group of replayed entries belonging to one segment and tenant
+type WALBatch struct {
+	Segment uint64
+	Tenant  string
+	Entries []*models.Log
+}
+
+// walRecord is the stored form of an entry. The tenant sits alongside the
+// log's own fields, so records written before tenants existed still decode,
+// as belonging to the default tenant.
+type walRecord struct {
+	Tenant string `json:"_tenant,omitempty"`
+	models.Log
+}
+
+// WAL is a segmented, checksummed on-disk log of accepted entries. A segment
+// is deleted once every entry appended to it has been acknowledged.
+type WAL struct {
+	config WALConfig
+
+	mutex       sync.Mutex
+	active      *os.File
+	activeID    uint64
+	activeSize  int64
+	outstanding map[uint64]int
+}
+
+// OpenWAL opens the WAL in config.Dir and returns the entries of segments
+// left unacknowledged by a previous run. Replayed segments stay on disk
+// until their entries are acknowledged.
+func OpenWAL(config WALConfig) (*WAL, []WALBatch, error) {
+	if config.SegmentSize <= 0 {
+		config.SegmentSize = 64 << 20
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+
+	segments, err := listSegments(config.Dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w := &WAL{
+		config:      config,
+		outstanding: make(map[uint64]int),
+	}
+
+	// Replay existing segments; new entries always go to a fresh segment
+	var batches []WALBatch
+	for _, id := range segments {
+		records, err := readSegment(w.segmentPath(id))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(records) == 0 {
+			os.Remove(w.segmentPath(id))
+			continue
+		}
+
+		w.outstanding[id] = len(records)
+		batches = append(batches, groupRecords(id, records)...)
+		w.activeID = id
+	}
+
+	if err := w.rotate(); err != nil {
+		return nil, nil, err
+	}
+
+	return w, batches, nil
+}
+
+// Append writes a tenant's entries to the active segment and returns its id.
+// The entries stay on disk until Ack is called for the segment.
+func (w *WAL) Append(tenant string, entries []*models.Log) (uint64, error) {
+	var buf []byte
+	for _, entry := range entries {
+		payload, err := json.Marshal(walRecord{Tenant: tenant, Log: *entry})
+		if err != nil {
+			return 0, err
+		}
+
+		var header [walHeaderSize]byte
+		binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+		binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(payload, walChecksumTable))
+		buf = append(buf, header[:]...)
+		buf = append(buf, payload...)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.active == nil {
+		return 0, errors.New("write-ahead log is closed")
+	}
+
+	// Start a new segment once the active one is full
+	if w.activeSize > 0 && w.activeSize+int64(len(buf)) > w.config.SegmentSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := w.active.Write(buf); err != nil {
+		return 0, err
+	}
+	if w.config.Sync {
+		if err := w.active.Sync(); err != nil {
+			return 0, err
+		}
+	}
+
+	w.activeSize += int64(len(buf))
+	w.outstanding[w.activeID] += len(entries)
+	return w.activeID, nil
+}
+
+// Ack marks n entries of a segment as persisted, deleting the segment once
+// it is no longer being written and all of its entries are acknowledged
+func (w *WAL) Ack(segment uint64, n int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.outstanding[segment] -= n
+	if w.outstanding[segment] > 0 || (segment == w.activeID && w.active != nil) {
+		return
+	}
+
+	delete(w.outstanding, segment)
+	if err := os.Remove(w.segmentPath(segment)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing WAL segment %d: %v", segment, err)
+	}
+}
+
+// Close closes the active segment, deleting it if all of its entries were
+// acknowledged
+func (w *WAL) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.active == nil {
+		return nil
+	}
+
+	err := w.active.Close()
+	w.active = nil
+
+	if w.outstanding[w.activeID] <= 0 {
+		delete(w.outstanding, w.activeID)
+		os.Remove(w.segmentPath(w.activeID))
+	}
+	return err
+}
+
+// rotate closes the active segment and starts the next one
+func (w *WAL) rotate() error {
+	if w.active != nil {
+		if err := w.active.Close(); err != nil {
+			return err
+		}
+
+		// The previous segment may already be fully acknowledged
+		if w.outstanding[w.activeID] <= 0 {
+			delete(w.outstanding, w.activeID)
+			os.Remove(w.segmentPath(w.activeID))
+		}
+	}
+
+	w.activeID++
+	file, err := os.OpenFile(w.segmentPath(w.activeID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	w.active = file
+	w.activeSize = 0
+	return nil
+}
+
+// segmentPath returns the file path of a segment
+func (w *WAL) segmentPath(id uint64) string {
+	return filepath.Join(w.config.Dir, fmt.Sprintf("%020d%s", id, walSegmentExt))
+}
+
+// listSegments returns the ids of the segments in dir in ascending order
+func listSegments(dir string) ([]uint64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, walSegmentExt) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, walSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// groupRecords splits a segment's records into one batch per tenant,
+// keeping each tenant's entries in the order they were appended
+func groupRecords(segment uint64, records []*walRecord) []WALBatch {
+	var batches []WALBatch
+	index := make(map[string]int)
+	for _, record := range records {
+		i, ok := index[record.Tenant]
+		if !ok {
+			i = len(batches)
+			index[record.Tenant] = i
+			batches = append(batches, WALBatch{Segment: segment, Tenant: record.Tenant})
+		}
+		entry := record.Log
+		batches[i].Entries = append(batches[i].Entries, &entry)
+	}
+	return batches
+}
+
+// readSegment decodes the records of a segment. Reading stops at the first
+// truncated or corrupt record, which is expected after a crash mid-write.
+func readSegment(path string) ([]*walRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var entries []*walRecord
+	for {
+		var header [walHeaderSize]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			if err != io.EOF {
+				log.Printf("Ignoring truncated record header in WAL segment %s", path)
+			}
+			return entries, nil
+		}
+
+		length := binary.LittleEndian.Uint32(header[0:4])
+		checksum := binary.LittleEndian.Uint32(header[4:8])
+		if length > walMaxRecordSize {
+			log.Printf("Ignoring oversized record in WAL segment %s", path)
+			return entries, nil
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			log.Printf("Ignoring truncated record in WAL segment %s", path)
+			return entries, nil
+		}
+
+		if crc32.Checksum(payload, walChecksumTable) != checksum {
+			log.Printf("Ignoring record with bad checksum in WAL segment %s", path)
+			return entries, nil
+		}
+
+		var entry walRecord
+		if err := json.Unmarshal(payload, &entry); err != nil {
+			log.Printf("Ignoring undecodable record in WAL segment %s: %v", path, err)
+			return entries, nil
+		}
+		entries = append(entries, &entry)
+	}
+}
@@ -0,0 +1,223 @@
+package ingestor
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"log-ingestor/internal/database"
+	"log-ingestor/internal/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// segmentCount returns the number of WAL segments in dir
+func segmentCount(t *testing.T, dir string) int {
+	t.Helper()
+	ids, err := listSegments(dir)
+	if err != nil {
+		t.Fatalf("Failed to list segments: %v", err)
+	}
+	return len(ids)
+}
+
+func TestWALAppendAndAck(t *testing.T) {
+	dir := t.TempDir()
+	wal, replay, err := OpenWAL(WALConfig{Dir: dir, SegmentSize: 1 << 20})
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	if len(replay) != 0 {
+		t.Fatalf("Expected nothing to replay in an empty directory, got %d batches", len(replay))
+	}
+
+	segment, err := wal.Append(database.DefaultTenant, []*models.Log{sampleLog("one"), sampleLog("two")})
+	if err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+
+	// The active segment is kept even when fully acknowledged
+	wal.Ack(segment, 2)
+	if n := segmentCount(t, dir); n != 1 {
+		t.Errorf("Expected the active segment to remain, got %d segments", n)
+	}
+
+	// Closing removes the fully acknowledged active segment
+	if err := wal.Close(); err != nil {
+		t.Fatalf("Failed to close WAL: %v", err)
+	}
+	if n := segmentCount(t, dir); n != 0 {
+		t.Errorf("Expected no segments after close, got %d", n)
+	}
+}
+
+func TestWALRotatesAndDeletesAcknowledgedSegments(t *testing.T) {
+	dir := t.TempDir()
+	wal, _, err := OpenWAL(WALConfig{Dir: dir, SegmentSize: 1})
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	defer wal.Close()
+
+	first, _ := wal.Append(database.DefaultTenant, []*models.Log{sampleLog("one")})
+	second, _ := wal.Append(database.DefaultTenant, []*models.Log{sampleLog("two")})
+	if first == second {
+		t.Fatalf("Expected appends to rotate into a new segment")
+	}
+
+	wal.Ack(first, 1)
+	if _, err := os.Stat(wal.segmentPath(first)); !os.IsNotExist(err) {
+		t.Errorf("Expected acknowledged segment %d to be removed", first)
+	}
+	if _, err := os.Stat(wal.segmentPath(second)); err != nil {
+		t.Errorf("Expected active segment %d to remain: %v", second, err)
+	}
+}
+
+func TestWALReplaysUnacknowledgedEntries(t *testing.T) {
+	dir := t.TempDir()
+	wal, _, err := OpenWAL(WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+
+	if _, err := wal.Append(database.DefaultTenant, []*models.Log{sampleLog("one"), sampleLog("two")}); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	wal.Close()
+
+	// Simulate a crash mid-write by appending a truncated record
+	path := filepath.Join(dir, "00000000000000000001.wal")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %v", err)
+	}
+	file.Write([]byte{0xff, 0x00, 0x00})
+	file.Close()
+
+	wal, replay, err := OpenWAL(WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer wal.Close()
+
+	if len(replay) != 1 || len(replay[0].Entries) != 2 {
+		t.Fatalf("Expected 1 batch of 2 replayed entries, got %+v", replay)
+	}
+	if replay[0].Entries[0].Message != "one" || replay[0].Entries[1].Message != "two" {
+		t.Errorf("Replayed entries out of order: %q, %q", replay[0].Entries[0].Message, replay[0].Entries[1].Message)
+	}
+}
+
+func TestDurableBufferReplaysOnStartup(t *testing.T) {
+	dir := t.TempDir()
+
+	// Leave unacknowledged entries behind, as if the process died
+	wal, _, err := OpenWAL(WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	wal.Append(database.DefaultTenant, []*models.Log{sampleLog("one"), sampleLog("two"), sampleLog("three")})
+	wal.Close()
+
+	mockDB := database.NewMockDB()
+	buffer, err := NewDurableBuffer(mockDB, BufferConfig{QueueSize: 10, BatchSize: 100, FlushInterval: time.Hour, Workers: 1}, WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create durable buffer: %v", err)
+	}
+
+	if err := buffer.Close(context.Background()); err != nil {
+		t.Fatalf("Failed to close buffer: %v", err)
+	}
+
+	if n := countLogs(mockDB); n != 3 {
+		t.Errorf("Expected 3 replayed logs in the database, got %d", n)
+	}
+	if n := segmentCount(t, dir); n != 0 {
+		t.Errorf("Expected all segments to be removed after flushing, got %d", n)
+	}
+}
+
+func TestDurableBufferKeepsEntriesDuringOutage(t *testing.T) {
+	dir := t.TempDir()
+
+	mockDB := database.NewMockDB()
+	mockDB.SimulateError = true
+	buffer, err := NewDurableBuffer(mockDB, BufferConfig{QueueSize: 10, BatchSize: 1, FlushInterval: time.Hour, Workers: 1}, WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create durable buffer: %v", err)
+	}
+
+	entry := sampleLog("one")
+	if err := buffer.Enqueue(database.DefaultTenant, entry); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+
+	// The database never recovers, so Close gives up and leaves the WAL behind
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := buffer.Close(ctx); err == nil {
+		t.Fatal("Expected Close to time out while the database is down")
+	}
+
+	if stats := buffer.Stats(); stats.Dropped != 0 {
+		t.Errorf("Expected no dropped logs with a WAL, got %d", stats.Dropped)
+	}
+
+	_, replay, err := OpenWAL(WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	if len(replay) != 1 || len(replay[0].Entries) != 1 {
+		t.Fatalf("Expected the unflushed entry to be replayed, got %+v", replay)
+	}
+
+	// The entry is replayed under the ID it was given before the crash
+	if entry.ID == "" || replay[0].Entries[0].ID != entry.ID {
+		t.Errorf("Expected the replayed entry to keep ID %q, got %q", entry.ID, replay[0].Entries[0].ID)
+	}
+}
+
+func TestWALReplaysTenants(t *testing.T) {
+	dir := t.TempDir()
+	wal, _, err := OpenWAL(WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to open WAL: %v", err)
+	}
+	wal.Append("acme", []*models.Log{sampleLog("acme one")})
+	wal.Append(database.DefaultTenant, []*models.Log{sampleLog("default one")})
+	wal.Append("acme", []*models.Log{sampleLog("acme two")})
+	wal.Close()
+
+	// Records written before tenants existed belong to the default tenant
+	payload := []byte(`{"level":"info","message":"legacy","timestamp":"2023-09-15T08:00:00Z"}`)
+	record := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, walChecksumTable))
+	record = append(record, payload...)
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000002.wal"), record, 0o644); err != nil {
+		t.Fatalf("Failed to write legacy segment: %v", err)
+	}
+
+	wal, replay, err := OpenWAL(WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer wal.Close()
+
+	if len(replay) != 3 {
+		t.Fatalf("Expected 3 replayed batches, got %+v", replay)
+	}
+
+	acme, others, legacy := replay[0], replay[1], replay[2]
+	if acme.Tenant != "acme" || len(acme.Entries) != 2 || acme.Entries[0].Message != "acme one" || acme.Entries[1].Message != "acme two" {
+		t.Errorf("Unexpected acme batch %+v", acme)
+	}
+	if others.Tenant != database.DefaultTenant || len(others.Entries) != 1 || others.Entries[0].Message != "default one" {
+		t.Errorf("Unexpected default tenant batch %+v", others)
+	}
+	if legacy.Segment != 2 || legacy.Tenant != database.DefaultTenant || len(legacy.Entries) != 1 || legacy.Entries[0].Message != "legacy" {
+		t.Errorf("Unexpected legacy batch %+v", legacy)
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrInvalidCursor is returned when a continuation token cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks the position of the last log returned by a query. Results are
+// ordered by timestamp and then ID, both descending.
+type Cursor struct {
+	Timestamp time.Time `json:"t"`
+	ID        string    `json:"id"`
+}
+
+// EncodeCursor returns an opaque continuation token for the given log
+func EncodeCursor(l *Log) string {
+	data, _ := json.Marshal(Cursor{Timestamp: l.Timestamp, ID: l.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a continuation token produced by EncodeCursor
+func DecodeCursor(token string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" || cursor.Timestamp.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// After reports whether l sorts after the cursor position
+func (c *Cursor) After(l *Log) bool {
+	if !l.Timestamp.Equal(c.Timestamp) {
+		return l.Timestamp.Before(c.Timestamp)
+	}
+	return l.ID < c.ID
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	timestamp, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	log := &Log{ID: "650412d0c8a1f2b3c4d5e6f7", Timestamp: timestamp}
+
+	cursor, err := DecodeCursor(EncodeCursor(log))
+	if err != nil {
+		t.Fatalf("Failed to decode cursor: %v", err)
+	}
+
+	if cursor.ID != log.ID {
+		t.Errorf("ID mismatch: expected %s, got %s", log.ID, cursor.ID)
+	}
+	if !cursor.Timestamp.Equal(log.Timestamp) {
+		t.Errorf("Timestamp mismatch: expected %v, got %v", log.Timestamp, cursor.Timestamp)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	for _, token := range []string{"not-base64!", "bm90IGpzb24", "e30"} {
+		if _, err := DecodeCursor(token); err != ErrInvalidCursor {
+			t.Errorf("DecodeCursor(%q) = %v, expected ErrInvalidCursor", token, err)
+		}
+	}
+}
+
+func TestCursorAfter(t *testing.T) {
+	timestamp, _ := time.Parse(time.RFC3339, "2023-09-15T08:00:00Z")
+	cursor := &Cursor{Timestamp: timestamp, ID: "b"}
+
+	testCases := []struct {
+		name     string
+		log      *Log
+		expected bool
+	}{
+		{"Older timestamp", &Log{ID: "z", Timestamp: timestamp.Add(-time.Second)}, true},
+		{"Newer timestamp", &Log{ID: "a", Timestamp: timestamp.Add(time.Second)}, false},
+		{"Same timestamp, lower ID", &Log{ID: "a", Timestamp: timestamp}, true},
+		{"Same timestamp, same ID", &Log{ID: "b", Timestamp: timestamp}, false},
+		{"Same timestamp, higher ID", &Log{ID: "c", Timestamp: timestamp}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := cursor.After(tc.log); result != tc.expected {
+				t.Errorf("After() = %v, expected %v", result, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"net/url"
+	"strings"
+)
+
+// FilterFields lists the query fields that accept repeated and negated values
+var FilterFields = []string{"level", "message", "resourceId", "traceId", "spanId", "commit", "parentResourceId"}
+
+// FieldFilter holds the values a field must match one of (Include) and the
+// values it must match none of (Exclude)
+type FieldFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// IsEmpty reports whether the filter places no constraint on the field
+func (f FieldFilter) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// negatedParam returns the parameter name without its negation marker, and
+// whether it had one. Both -name and name! (as in name!=value) negate.
+func negatedParam(param string) (string, bool) {
+	if strings.HasPrefix(param, "-") {
+		return param[1:], true
+	}
+	if strings.HasSuffix(param, "!") {
+		return param[:len(param)-1], true
+	}
+	return param, false
+}
+
+// ParseFieldFilters extracts repeated and negated values for FilterFields
+// from query parameters, e.g. level=error&level=warning&-resourceId=health
+func ParseFieldFilters(params url.Values) map[string]FieldFilter {
+	filters := make(map[string]FieldFilter)
+	for param, values := range params {
+		field, negated := negatedParam(param)
+		if !isFilterField(field) {
+			continue
+		}
+
+		filter := filters[field]
+		for _, value := range values {
+			if value == "" {
+				continue
+			}
+			if negated {
+				filter.Exclude = appendUnique(filter.Exclude, value)
+			} else {
+				filter.Include = appendUnique(filter.Include, value)
+			}
+		}
+
+		if !filter.IsEmpty() {
+			filters[field] = filter
+		}
+	}
+	return filters
+}
+
+// FieldFilter returns the combined filter for a field, merging the
+// single-value form field with any repeated or negated values
+func (q *LogQuery) FieldFilter(field string) FieldFilter {
+	filter := q.Filters[field]
+	if value := q.fieldValue(field); value != "" && !containsString(filter.Include, value) {
+		filter.Include = append([]string{value}, filter.Include...)
+	}
+	return filter
+}
+
+// fieldValue returns the single-value form field for a filter field
+func (q *LogQuery) fieldValue(field string) string {
+	switch field {
+	case "level":
+		return q.Level
+	case "message":
+		return q.Message
+	case "resourceId":
+		return q.ResourceID
+	case "traceId":
+		return q.TraceID
+	case "spanId":
+		return q.SpanID
+	case "commit":
+		return q.Commit
+	case "parentResourceId":
+		return q.ParentResourceID
+	default:
+		return ""
+	}
+}
+
+// isFilterField reports whether field is one of FilterFields
+func isFilterField(field string) bool {
+	return containsString(FilterFields, field)
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// appendUnique appends value unless it is already present
+func appendUnique(values []string, value string) []string {
+	if containsString(values, value) {
+		return values
+	}
+	return append(values, value)
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseFieldFilters(t *testing.T) {
+	params, _ := url.ParseQuery("level=error&level=warning&-resourceId=health-check&commit!=abc&commit!=abc&message=&page=2&metadata.region=us")
+
+	filters := ParseFieldFilters(params)
+
+	expected := map[string]FieldFilter{
+		"level":      {Include: []string{"error", "warning"}},
+		"resourceId": {Exclude: []string{"health-check"}},
+		"commit":     {Exclude: []string{"abc"}},
+	}
+	if !reflect.DeepEqual(filters, expected) {
+		t.Errorf("ParseFieldFilters() = %+v, expected %+v", filters, expected)
+	}
+}
+
+func TestLogQueryFieldFilter(t *testing.T) {
+	query := &LogQuery{
+		Level:      "error",
+		ResourceID: "server-1",
+		Filters: map[string]FieldFilter{
+			"level":      {Include: []string{"error", "warning"}, Exclude: []string{"debug"}},
+			"resourceId": {Exclude: []string{"health-check"}},
+		},
+	}
+
+	testCases := []struct {
+		field    string
+		expected FieldFilter
+	}{
+		{"level", FieldFilter{Include: []string{"error", "warning"}, Exclude: []string{"debug"}}},
+		{"resourceId", FieldFilter{Include: []string{"server-1"}, Exclude: []string{"health-check"}}},
+		{"traceId", FieldFilter{}},
+	}
+
+	for _, tc := range testCases {
+		if result := query.FieldFilter(tc.field); !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("FieldFilter(%q) = %+v, expected %+v", tc.field, result, tc.expected)
+		}
+	}
+
+	if !query.FieldFilter("spanId").IsEmpty() {
+		t.Error("Expected an empty filter for spanId")
+	}
+}
@@ -1,11 +1,16 @@
 package models
 
 import (
+	"errors"
+	"strings"
 	"time"
+
+	"log-ingestor/internal/querylang"
 )
 
 // Log represents the structure of a log entry
 type Log struct {
+	ID         string            `json:"id,omitempty" bson:"-"`
 	Level      string            `json:"level" bson:"level"`
 	Message    string            `json:"message" bson:"message"`
 	ResourceID string            `json:"resourceId" bson:"resourceId"`
@@ -14,6 +19,56 @@ type Log struct {
 	SpanID     string            `json:"spanId" bson:"spanId"`
 	Commit     string            `json:"commit" bson:"commit"`
 	Metadata   map[string]string `json:"metadata" bson:"metadata"`
+
+	// Score is the search relevance of the log in results ordered by
+	// relevance. It is never stored; its scale depends on the backend.
+	Score float64 `json:"score,omitempty" bson:"score,omitempty"`
+}
+
+// Highlight marks a part of a log's message that matched a query, as the
+// UTF-8 byte offsets of its start and end
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Validate checks that a log entry carries the fields required for storage
+func (l *Log) Validate() error {
+	if l.Level == "" {
+		return errors.New("level is required")
+	}
+	if l.Message == "" {
+		return errors.New("message is required")
+	}
+	return nil
+}
+
+// Field returns the value of a named field, addressing metadata keys as
+// metadata.<key>, and whether the field is set
+func (l *Log) Field(name string) (string, bool) {
+	switch name {
+	case "level":
+		return l.Level, true
+	case "message":
+		return l.Message, true
+	case "resourceId":
+		return l.ResourceID, true
+	case "traceId":
+		return l.TraceID, true
+	case "spanId":
+		return l.SpanID, true
+	case "commit":
+		return l.Commit, true
+	case "parentResourceId":
+		value, ok := l.Metadata["parentResourceId"]
+		return value, ok
+	}
+
+	if key := strings.TrimPrefix(name, "metadata."); key != name {
+		value, ok := l.Metadata[key]
+		return value, ok
+	}
+	return "", false
 }
 
 // LogQuery represents the query parameters for filtering logs
@@ -28,7 +83,53 @@ type LogQuery struct {
 	StartTime        time.Time `form:"startTime" time_format:"2006-01-02T15:04:05Z"`
 	EndTime          time.Time `form:"endTime" time_format:"2006-01-02T15:04:05Z"`
 	RegexPattern     string    `form:"regex"`
+	RegexFlags       string    `form:"regexFlags"`
 	FullTextSearch   string    `form:"search"`
 	Page             int       `form:"page"`
 	Limit            int       `form:"limit"`
+
+	// Filters holds repeated and negated values for FilterFields; they are
+	// parsed by ParseFieldFilters and merged with the single-value fields
+	// above by FieldFilter
+	Filters map[string]FieldFilter `form:"-"`
+
+	// Scope is an expression every result must match regardless of the
+	// other filters, set from the caller's permissions rather than the request
+	Scope querylang.Expr `form:"-"`
+
+	// MetadataFilters filter on arbitrary metadata keys; they are parsed
+	// from metadata.<key> parameters by ParseMetadataFilters
+	MetadataFilters []MetadataFilter `form:"-"`
+
+	// Query is an expression in the structured query language, ANDed with
+	// the other filters
+	Query string `form:"q"`
+
+	// Cursor is a continuation token from a previous page; when set it
+	// takes precedence over Page
+	Cursor string `form:"cursor"`
+
+	// Sort is the order of results: SortTime, the default, SortRelevance
+	// for full-text searches, or sort keys parsed by SortKeys
+	Sort string `form:"sort"`
+
+	// Fields is the comma-separated fields to return, parsed by Projection;
+	// every field is returned when it is empty
+	Fields string `form:"fields"`
+
+	// Highlight requests the parts of each message matching the message,
+	// regex and full-text filters
+	Highlight bool `form:"highlight"`
+}
+
+// Result orders of log queries
+const (
+	SortTime      = "time"
+	SortRelevance = "relevance"
+)
+
+// ByRelevance reports whether results are ordered by search relevance,
+// which needs a full-text search and is not defined over cursors
+func (q *LogQuery) ByRelevance() bool {
+	return q.Sort == SortRelevance && q.FullTextSearch != "" && q.Cursor == ""
 }
@@ -90,3 +90,24 @@ func TestLogQueryValidation(t *testing.T) {
 		t.Errorf("Expected empty Limit to be 0, got %d", emptyQuery.Limit)
 	}
 }
+
+func TestLogValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		log     Log
+		wantErr bool
+	}{
+		{"Valid", Log{Level: "error", Message: "Failed to connect to DB"}, false},
+		{"Missing level", Log{Message: "Failed to connect to DB"}, true},
+		{"Missing message", Log{Level: "error"}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.log.Validate()
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
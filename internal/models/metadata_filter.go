@@ -0,0 +1,123 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"log-ingestor/internal/querylang"
+)
+
+// MetadataOp is the comparison applied by a metadata filter
+type MetadataOp string
+
+const (
+	// MetadataEquals matches metadata values equal to the filter value
+	MetadataEquals MetadataOp = "eq"
+
+	// MetadataPrefix matches metadata values starting with the filter value
+	MetadataPrefix MetadataOp = "prefix"
+
+	// MetadataExists matches logs that have the key
+	MetadataExists MetadataOp = "exists"
+
+	// MetadataMissing matches logs that lack the key
+	MetadataMissing MetadataOp = "missing"
+)
+
+// metadataParamPrefix prefixes query parameters that filter on metadata
+const metadataParamPrefix = "metadata."
+
+// MetadataFilter filters logs on a single metadata key. Equality and prefix
+// filters match when any of their values matches; negated filters match
+// when the underlying filter does not.
+type MetadataFilter struct {
+	Key    string
+	Op     MetadataOp
+	Values []string
+	Negate bool
+}
+
+// ParseMetadataFilters extracts metadata filters from query parameters of
+// the forms
+//
+//	metadata.<key>=<value>          value equals
+//	metadata.<key>:prefix=<value>   value starts with
+//	metadata.<key>:exists=true      key is present
+//	metadata.<key>:exists=false     key is absent
+//
+// Repeating a parameter matches any of its values, and prefixing it with
+// '-' or suffixing it with '!' (as in metadata.region!=eu) negates it.
+func ParseMetadataFilters(params url.Values) ([]MetadataFilter, error) {
+	var filters []MetadataFilter
+	for rawParam, values := range params {
+		param, negated := negatedParam(rawParam)
+		if !strings.HasPrefix(param, metadataParamPrefix) {
+			continue
+		}
+
+		key, op := param[len(metadataParamPrefix):], ""
+		if i := strings.IndexByte(key, ':'); i >= 0 {
+			key, op = key[:i], key[i+1:]
+		}
+
+		if _, ok := querylang.CanonicalField(metadataParamPrefix + key); !ok {
+			return nil, fmt.Errorf("invalid metadata key %q", key)
+		}
+
+		filter := MetadataFilter{Key: key, Negate: negated}
+		switch op {
+		case "":
+			filter.Op = MetadataEquals
+			filter.Values = values
+		case "prefix":
+			filter.Op = MetadataPrefix
+			filter.Values = values
+		case "exists":
+			if len(values) != 1 {
+				return nil, fmt.Errorf("%s must be given exactly once", rawParam)
+			}
+			exists, err := strconv.ParseBool(values[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for %s: expected true or false", values[0], rawParam)
+			}
+			filter.Op = MetadataExists
+			if !exists {
+				filter.Op = MetadataMissing
+			}
+		default:
+			return nil, fmt.Errorf("unknown metadata operator %q in %s", op, rawParam)
+		}
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
+// Matches reports whether a log's metadata satisfies the filter
+func (f MetadataFilter) Matches(metadata map[string]string) bool {
+	return f.matches(metadata) != f.Negate
+}
+
+// matches evaluates the filter before negation
+func (f MetadataFilter) matches(metadata map[string]string) bool {
+	value, ok := metadata[f.Key]
+	switch f.Op {
+	case MetadataEquals:
+		return ok && containsString(f.Values, value)
+	case MetadataPrefix:
+		for _, prefix := range f.Values {
+			if ok && strings.HasPrefix(value, prefix) {
+				return true
+			}
+		}
+		return false
+	case MetadataExists:
+		return ok
+	case MetadataMissing:
+		return !ok
+	default:
+		return false
+	}
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseMetadataFilters(t *testing.T) {
+	params, _ := url.ParseQuery("metadata.region=us-east-1&metadata.userId:prefix=u-&metadata.tenant:exists=false&level=error" +
+		"&metadata.env=prod&metadata.env=staging&-metadata.team=infra&metadata.zone!=a")
+
+	filters, err := ParseMetadataFilters(params)
+	if err != nil {
+		t.Fatalf("Failed to parse metadata filters: %v", err)
+	}
+
+	expected := map[string]MetadataFilter{
+		"region": {Key: "region", Op: MetadataEquals, Values: []string{"us-east-1"}},
+		"userId": {Key: "userId", Op: MetadataPrefix, Values: []string{"u-"}},
+		"tenant": {Key: "tenant", Op: MetadataMissing},
+		"env":    {Key: "env", Op: MetadataEquals, Values: []string{"prod", "staging"}},
+		"team":   {Key: "team", Op: MetadataEquals, Values: []string{"infra"}, Negate: true},
+		"zone":   {Key: "zone", Op: MetadataEquals, Values: []string{"a"}, Negate: true},
+	}
+
+	if len(filters) != len(expected) {
+		t.Fatalf("Expected %d filters, got %d: %+v", len(expected), len(filters), filters)
+	}
+	for _, filter := range filters {
+		if !reflect.DeepEqual(filter, expected[filter.Key]) {
+			t.Errorf("Unexpected filter %+v, expected %+v", filter, expected[filter.Key])
+		}
+	}
+}
+
+func TestParseMetadataFiltersInvalid(t *testing.T) {
+	for _, query := range []string{
+		"metadata.$where=1",
+		"metadata.a.b=1",
+		"metadata.region:contains=us",
+		"metadata.region:exists=maybe",
+	} {
+		params, _ := url.ParseQuery(query)
+		if _, err := ParseMetadataFilters(params); err == nil {
+			t.Errorf("Expected error for %q", query)
+		}
+	}
+}
+
+func TestMetadataFilterMatches(t *testing.T) {
+	metadata := map[string]string{"region": "us-east-1"}
+
+	testCases := []struct {
+		filter   MetadataFilter
+		expected bool
+	}{
+		{MetadataFilter{Key: "region", Op: MetadataEquals, Values: []string{"us-east-1"}}, true},
+		{MetadataFilter{Key: "region", Op: MetadataEquals, Values: []string{"us"}}, false},
+		{MetadataFilter{Key: "region", Op: MetadataPrefix, Values: []string{"us-"}}, true},
+		{MetadataFilter{Key: "tenant", Op: MetadataPrefix, Values: []string{""}}, false},
+		{MetadataFilter{Key: "region", Op: MetadataExists}, true},
+		{MetadataFilter{Key: "tenant", Op: MetadataExists}, false},
+		{MetadataFilter{Key: "tenant", Op: MetadataMissing}, true},
+		{MetadataFilter{Key: "region", Op: MetadataMissing}, false},
+		{MetadataFilter{Key: "region", Op: MetadataEquals, Values: []string{"eu-west-1", "us-east-1"}}, true},
+		{MetadataFilter{Key: "region", Op: MetadataEquals, Values: []string{"us-east-1"}, Negate: true}, false},
+		{MetadataFilter{Key: "tenant", Op: MetadataEquals, Values: []string{"acme"}, Negate: true}, true},
+		{MetadataFilter{Key: "region", Op: MetadataPrefix, Values: []string{"eu-", "us-"}}, true},
+	}
+
+	for _, tc := range testCases {
+		if result := tc.filter.Matches(metadata); result != tc.expected {
+			t.Errorf("%+v.Matches() = %v, expected %v", tc.filter, result, tc.expected)
+		}
+	}
+}
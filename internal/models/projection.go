@@ -0,0 +1,95 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"log-ingestor/internal/querylang"
+)
+
+// MetadataField requests all of a log's metadata in a projection
+const MetadataField = "metadata"
+
+// Projection parses the fields parameter, the comma-separated fields to
+// return: built-in fields, metadata for all metadata, or metadata.<key>
+// for single keys. It returns nil when every field is requested. The id
+// and timestamp are always returned, as cursors need them, and are
+// dropped from the list.
+func (q *LogQuery) Projection() ([]string, error) {
+	if q.Fields == "" {
+		return nil, nil
+	}
+
+	fields := []string{}
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(q.Fields, ",") {
+		name = strings.TrimSpace(name)
+
+		field := name
+		switch {
+		case name == "id" || name == "timestamp":
+			continue
+		case name == MetadataField:
+		default:
+			var ok bool
+			if field, ok = querylang.CanonicalField(name); !ok {
+				return nil, fmt.Errorf("unknown field %q in fields", name)
+			}
+			if field == "parentResourceId" {
+				field = "metadata.parentResourceId"
+			}
+		}
+
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// Project returns a copy of the log with only the projected fields set,
+// besides its ID, timestamp and score. A nil projection keeps every field.
+func (l *Log) Project(fields []string) *Log {
+	if fields == nil {
+		return l
+	}
+
+	projected := &Log{ID: l.ID, Timestamp: l.Timestamp, Score: l.Score}
+	for _, field := range fields {
+		switch field {
+		case "level":
+			projected.Level = l.Level
+		case "message":
+			projected.Message = l.Message
+		case "resourceId":
+			projected.ResourceID = l.ResourceID
+		case "traceId":
+			projected.TraceID = l.TraceID
+		case "spanId":
+			projected.SpanID = l.SpanID
+		case "commit":
+			projected.Commit = l.Commit
+		case MetadataField:
+			projected.Metadata = l.Metadata
+		}
+	}
+
+	// Single metadata keys, unless all metadata was requested
+	if projected.Metadata != nil {
+		return projected
+	}
+	for _, field := range fields {
+		key, ok := querylang.MetadataKey(field)
+		if !ok {
+			continue
+		}
+		if value, ok := l.Metadata[key]; ok {
+			if projected.Metadata == nil {
+				projected.Metadata = make(map[string]string)
+			}
+			projected.Metadata[key] = value
+		}
+	}
+	return projected
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogQueryProjection(t *testing.T) {
+	tests := []struct {
+		fields   string
+		expected []string
+	}{
+		{"", nil},
+		{"id,timestamp", []string{}},
+		{"level, Message,level", []string{"level", "message"}},
+		{"metadata,parentResourceId,metadata.region", []string{"metadata", "metadata.parentResourceId", "metadata.region"}},
+	}
+
+	for _, tt := range tests {
+		fields, err := (&LogQuery{Fields: tt.fields}).Projection()
+		if err != nil {
+			t.Fatalf("Projection(%q) failed: %v", tt.fields, err)
+		}
+		if !reflect.DeepEqual(fields, tt.expected) {
+			t.Errorf("Projection(%q): expected %v, got %v", tt.fields, tt.expected, fields)
+		}
+	}
+
+	for _, fields := range []string{"score", "level,", "metadata.bad key"} {
+		if _, err := (&LogQuery{Fields: fields}).Projection(); err == nil {
+			t.Errorf("Expected error for fields %q", fields)
+		}
+	}
+}
+
+func TestLogProject(t *testing.T) {
+	log := &Log{
+		ID:         "650400000000000000000001",
+		Timestamp:  time.Date(2023, 9, 15, 8, 0, 0, 0, time.UTC),
+		Level:      "error",
+		Message:    "Failed to connect",
+		ResourceID: "server-1234",
+		TraceID:    "abc-xyz-123",
+		Score:      1.5,
+		Metadata:   map[string]string{"region": "eu", "userId": "42"},
+	}
+
+	if projected := log.Project(nil); projected != log {
+		t.Error("Expected a nil projection to keep the log")
+	}
+
+	expected := &Log{ID: log.ID, Timestamp: log.Timestamp, Score: 1.5, Level: "error", Metadata: map[string]string{"region": "eu"}}
+	if projected := log.Project([]string{"level", "metadata.region", "metadata.missing"}); !reflect.DeepEqual(projected, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, projected)
+	}
+
+	expected = &Log{ID: log.ID, Timestamp: log.Timestamp, Score: 1.5, TraceID: "abc-xyz-123", Metadata: log.Metadata}
+	if projected := log.Project([]string{"traceId", "metadata.region", "metadata"}); !reflect.DeepEqual(projected, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, projected)
+	}
+}
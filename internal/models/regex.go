@@ -0,0 +1,339 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidRegex is returned for a regex the query cannot run
+var ErrInvalidRegex = errors.New("invalid regex")
+
+// Guardrails on regex patterns. Backends with backtracking engines
+// (MongoDB, PostgreSQL) can take exponential time on nested repetitions
+// and on repeated alternations whose branches overlap, so patterns are
+// kept short and simple.
+const (
+	// MaxRegexLength is the longest pattern accepted, in bytes
+	MaxRegexLength = 256
+
+	// MaxRegexRepeat is the largest count accepted in a {n,m} repetition
+	MaxRegexRepeat = 100
+)
+
+// Regex flags, combined in the regexFlags parameter
+const (
+	// RegexCaseSensitive matches case exactly; by default case is ignored
+	RegexCaseSensitive = 'c'
+
+	// RegexAnchored requires the pattern to match the whole message rather
+	// than any part of it
+	RegexAnchored = 'a'
+)
+
+// Regex is a validated regex filter on log messages
+type Regex struct {
+	Pattern       string
+	CaseSensitive bool
+	Anchored      bool
+}
+
+// Regex validates the query's regex pattern and flags, returning nil when
+// there is no pattern. Errors wrap ErrInvalidRegex.
+func (q *LogQuery) Regex() (*Regex, error) {
+	regex := &Regex{Pattern: q.RegexPattern}
+	for _, flag := range q.RegexFlags {
+		switch flag {
+		case RegexCaseSensitive:
+			regex.CaseSensitive = true
+		case RegexAnchored:
+			regex.Anchored = true
+		default:
+			return nil, fmt.Errorf("%w: unknown flag %q in regexFlags, expected %q or %q", ErrInvalidRegex, flag, RegexCaseSensitive, RegexAnchored)
+		}
+	}
+
+	if q.RegexPattern == "" {
+		if q.RegexFlags != "" {
+			return nil, fmt.Errorf("%w: regexFlags requires a regex", ErrInvalidRegex)
+		}
+		return nil, nil
+	}
+	if err := checkRegex(q.RegexPattern); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRegex, err)
+	}
+	return regex, nil
+}
+
+// Expr returns the pattern, anchored if requested, without a case flag;
+// backends pass case sensitivity in their own way
+func (r *Regex) Expr() string {
+	if r.Anchored {
+		return "^(?:" + r.Pattern + ")$"
+	}
+	return r.Pattern
+}
+
+// Compile returns the filter as a Go regular expression
+func (r *Regex) Compile() *regexp.Regexp {
+	expr := r.Expr()
+	if !r.CaseSensitive {
+		expr = "(?i)" + expr
+	}
+	return regexp.MustCompile(expr)
+}
+
+// checkRegex reports why a pattern cannot be run: a syntax error, or a
+// pattern past the guardrails
+func checkRegex(pattern string) error {
+	if len(pattern) > MaxRegexLength {
+		return fmt.Errorf("pattern is %d bytes long, the limit is %d", len(pattern), MaxRegexLength)
+	}
+	if hasInlineFlags(pattern) {
+		return errors.New("inline flags such as (?i) are not supported, use regexFlags")
+	}
+
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return err
+	}
+	if err := checkRepeats(re, false); err != nil {
+		return err
+	}
+	return checkAlternations(pattern)
+}
+
+// hasInlineFlags reports whether a pattern has a (?flags) group or a named
+// group, whose syntax differs between the backends' regex engines
+func hasInlineFlags(pattern string) bool {
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '(':
+			if !inClass && strings.HasPrefix(pattern[i:], "(?") && !strings.HasPrefix(pattern[i:], "(?:") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkRepeats rejects repetitions nested in other repetitions, such as
+// (a+)+, and repetition counts above MaxRegexRepeat
+func checkRepeats(re *syntax.Regexp, repeated bool) error {
+	switch re.Op {
+	case syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		if repeated {
+			return errors.New("nested repetitions such as (a+)+ are not supported")
+		}
+		if re.Op == syntax.OpRepeat && (re.Min > MaxRegexRepeat || re.Max > MaxRegexRepeat) {
+			return fmt.Errorf("repetition counts above %d are not supported", MaxRegexRepeat)
+		}
+		repeated = true
+	}
+
+	for _, sub := range re.Sub {
+		if err := checkRepeats(sub, repeated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repeatCount matches the {n}, {n,} and {n,m} quantifiers
+var repeatCount = regexp.MustCompile(`^\{(\d+)(,(\d*))?\}`)
+
+// checkAlternations rejects repeated groups holding an alternation whose
+// branches can start with the same character or match nothing, such as
+// (a|aa)+, which backtracking engines retry in every combination. Go's
+// parser factors such alternations away, so the pattern's text is scanned
+// for them; a valid pattern is assumed.
+func checkAlternations(pattern string) error {
+	type group struct {
+		start      int
+		separators []int
+		overlaps   bool
+	}
+
+	stack := []*group{{start: -1}}
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '[':
+			i = classEnd(pattern, i)
+		case '(':
+			stack = append(stack, &group{start: i})
+		case '|':
+			top := stack[len(stack)-1]
+			top.separators = append(top.separators, i)
+		case ')':
+			g := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(g.separators) > 0 && overlappingBranches(branches(pattern, g.start, i, g.separators)) {
+				g.overlaps = true
+			}
+			if g.overlaps && repeats(pattern[i+1:]) {
+				return errors.New("repeated alternations whose branches can match the same text, such as (a|aa)+, are not supported")
+			}
+
+			// Alternations nested in a repeated group are repeated too
+			parent := stack[len(stack)-1]
+			parent.overlaps = parent.overlaps || g.overlaps
+		}
+	}
+	return nil
+}
+
+// classEnd returns the index of the ] closing the character class opened
+// at start, where a leading ] is literal
+func classEnd(pattern string, start int) int {
+	i := start + 1
+	if i < len(pattern) && pattern[i] == '^' {
+		i++
+	}
+	if i < len(pattern) && pattern[i] == ']' {
+		i++
+	}
+	for ; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == '\\':
+			i++
+		case strings.HasPrefix(pattern[i:], "[:"):
+			if end := strings.Index(pattern[i:], ":]"); end >= 0 {
+				i += end + 1
+			}
+		case pattern[i] == ']':
+			return i
+		}
+	}
+	return i
+}
+
+// branches splits the text of the group between the parentheses at open
+// and close at its own | separators
+func branches(pattern string, open, close int, separators []int) []string {
+	start := open + 1
+	if strings.HasPrefix(pattern[start:], "?:") {
+		start += 2
+	}
+
+	var result []string
+	for _, separator := range separators {
+		result = append(result, pattern[start:separator])
+		start = separator + 1
+	}
+	return append(result, pattern[start:close])
+}
+
+// overlappingBranches reports whether alternation branches can match the
+// same first character, ignoring case, or whether one can match nothing
+func overlappingBranches(branches []string) bool {
+	firsts := make([][]rune, len(branches))
+	for i, branch := range branches {
+		re, err := syntax.Parse(branch, syntax.Perl|syntax.FoldCase)
+		if err != nil {
+			return true
+		}
+		var nullable bool
+		if firsts[i], nullable = firstRunes(re); nullable {
+			return true
+		}
+		for _, other := range firsts[:i] {
+			if rangesOverlap(firsts[i], other) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// firstRunes returns the ranges, as lo-hi pairs, of the characters a
+// match can start with, and whether the expression can match nothing
+func firstRunes(re *syntax.Regexp) (runes []rune, nullable bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		r := re.Rune[0]
+		runes = []rune{r, r}
+		if re.Flags&syntax.FoldCase != 0 {
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				runes = append(runes, f, f)
+			}
+		}
+		return runes, false
+	case syntax.OpCharClass:
+		return re.Rune, false
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return []rune{0, unicode.MaxRune}, false
+	case syntax.OpCapture, syntax.OpPlus:
+		return firstRunes(re.Sub[0])
+	case syntax.OpStar, syntax.OpQuest:
+		runes, _ = firstRunes(re.Sub[0])
+		return runes, true
+	case syntax.OpRepeat:
+		runes, nullable = firstRunes(re.Sub[0])
+		return runes, nullable || re.Min == 0
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			subRunes, subNullable := firstRunes(sub)
+			runes = append(runes, subRunes...)
+			if !subNullable {
+				return runes, false
+			}
+		}
+		return runes, true
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			subRunes, subNullable := firstRunes(sub)
+			runes = append(runes, subRunes...)
+			nullable = nullable || subNullable
+		}
+		return runes, nullable
+	}
+
+	// Empty matches and assertions such as ^ and \b consume nothing
+	return nil, true
+}
+
+// rangesOverlap reports whether two lists of lo-hi rune pairs share a rune
+func rangesOverlap(a, b []rune) bool {
+	for i := 0; i+1 < len(a); i += 2 {
+		for j := 0; j+1 < len(b); j += 2 {
+			if a[i] <= b[j+1] && b[j] <= a[i+1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// repeats reports whether rest starts with a quantifier allowing more
+// than one repetition
+func repeats(rest string) bool {
+	if strings.HasPrefix(rest, "*") || strings.HasPrefix(rest, "+") {
+		return true
+	}
+	match := repeatCount.FindStringSubmatch(rest)
+	if match == nil {
+		return false
+	}
+	if match[2] == "" {
+		n, _ := strconv.Atoi(match[1])
+		return n > 1
+	}
+	if match[3] == "" {
+		return true
+	}
+	m, _ := strconv.Atoi(match[3])
+	return m > 1
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLogQueryRegex(t *testing.T) {
+	tests := []struct {
+		pattern string
+		flags   string
+		message string
+		matches bool
+	}{
+		{`time\w+`, "", "Request TIMEOUT", true},
+		{`time\w+`, "c", "Request TIMEOUT", false},
+		{`time\w+`, "a", "Request timeout", false},
+		{`.*time\w+`, "a", "Request timeout", true},
+		{`GET|POST`, "a", "GET", true},
+		{`GET|POST`, "a", "GET /", false},
+		{`a(?:b|c){2,5}`, "ca", "abcb", true},
+	}
+
+	for _, tt := range tests {
+		query := &LogQuery{RegexPattern: tt.pattern, RegexFlags: tt.flags}
+		regex, err := query.Regex()
+		if err != nil {
+			t.Fatalf("Regex(%q, %q) failed: %v", tt.pattern, tt.flags, err)
+		}
+		if matches := regex.Compile().MatchString(tt.message); matches != tt.matches {
+			t.Errorf("Regex(%q, %q) on %q: expected %v, got %v", tt.pattern, tt.flags, tt.message, tt.matches, matches)
+		}
+	}
+
+	if regex, err := (&LogQuery{}).Regex(); regex != nil || err != nil {
+		t.Errorf("Expected no regex without a pattern, got %v, %v", regex, err)
+	}
+}
+
+func TestLogQueryRegexInvalid(t *testing.T) {
+	tests := []struct {
+		pattern string
+		flags   string
+		reason  string
+	}{
+		{"a.b(", "", "missing closing )"},
+		{"[invalid", "", "missing closing ]"},
+		{"error", "x", "unknown flag"},
+		{"", "c", "requires a regex"},
+		{strings.Repeat("a", MaxRegexLength+1), "", "the limit is"},
+		{"(a+)+b", "", "nested repetitions"},
+		{"(?:x*y)*", "", "nested repetitions"},
+		{"a{1,500}", "", "repetition counts"},
+		{"(a|aa)*", "", "repeated alternations"},
+		{"(a|a)+b", "", "repeated alternations"},
+		{"(?:x|xy){2,}", "", "repeated alternations"},
+		{"(?:A|a)+", "", "repeated alternations"},
+		{"(.|b)+", "", "repeated alternations"},
+		{"(|a)+", "", "repeated alternations"},
+		{"(?:(a|ab)c)*", "", "repeated alternations"},
+		{`(\w|_){2}`, "", "repeated alternations"},
+		{"(?i)error", "", "inline flags"},
+		{"(?P<name>a)", "", "inline flags"},
+	}
+
+	for _, tt := range tests {
+		_, err := (&LogQuery{RegexPattern: tt.pattern, RegexFlags: tt.flags}).Regex()
+		if !errors.Is(err, ErrInvalidRegex) || !strings.Contains(err.Error(), tt.reason) {
+			t.Errorf("Regex(%q, %q) = %v, expected an invalid regex error mentioning %q", tt.pattern, tt.flags, err, tt.reason)
+		}
+	}
+
+	// Escaped parentheses and brackets in classes are not groups, and
+	// alternations are fine when not repeated or when their branches start
+	// differently
+	for _, pattern := range []string{`\(?x`, `[(?]x`, `(?:a|b)+`, `(GET|POST)+`, `(a|aa)?`, `(a|aa){1}`, `\(a|a\)+`, `[]|(]+(x|y)*`, `((a|aa)|b)`} {
+		if _, err := (&LogQuery{RegexPattern: pattern}).Regex(); err != nil {
+			t.Errorf("Regex(%q) failed: %v", pattern, err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"log-ingestor/internal/querylang"
+)
+
+// ErrInvalidSort is returned for a sort the query cannot run
+var ErrInvalidSort = errors.New("invalid sort")
+
+// Sort directions, as in sort=level:asc,timestamp:desc
+const (
+	SortAscending  = "asc"
+	SortDescending = "desc"
+)
+
+// MaxSortKeys is the most fields results may be sorted by at once
+const MaxSortKeys = 3
+
+// SortFields are the fields results may be sorted by, as every backend
+// indexes them. Metadata keys are sortable when they are indexed too.
+var SortFields = []string{"timestamp", "level", "resourceId", "traceId", "parentResourceId"}
+
+// SortKey orders results by a field: timestamp, a built-in field, or
+// metadata.<key>
+type SortKey struct {
+	Field      string
+	Descending bool
+}
+
+// String returns the key as written in the sort parameter
+func (k SortKey) String() string {
+	if k.Descending {
+		return k.Field + ":" + SortDescending
+	}
+	return k.Field + ":" + SortAscending
+}
+
+// ByFields reports whether results are ordered by sort keys rather than by
+// time or relevance
+func (q *LogQuery) ByFields() bool {
+	switch q.Sort {
+	case "", SortTime, SortRelevance:
+		return false
+	}
+	return true
+}
+
+// SortKeys parses a sort by fields, written as comma-separated fields each
+// optionally followed by :asc, the default, or :desc. It returns nil for
+// the time and relevance orders. Errors wrap ErrInvalidSort; whether the
+// fields may be sorted by is left to CheckSortable.
+func (q *LogQuery) SortKeys() ([]SortKey, error) {
+	if !q.ByFields() {
+		return nil, nil
+	}
+	if q.Cursor != "" {
+		return nil, fmt.Errorf("%w: results sorted by fields are paged by page number, not cursor", ErrInvalidSort)
+	}
+
+	var keys []SortKey
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(q.Sort, ",") {
+		name, direction, _ := strings.Cut(strings.TrimSpace(part), ":")
+
+		key := SortKey{Field: name}
+		if name != "timestamp" {
+			field, ok := querylang.CanonicalField(name)
+			if !ok || field == "message" {
+				return nil, fmt.Errorf("%w: unknown sort field %q: expected time, relevance or fields such as level:asc,timestamp:desc", ErrInvalidSort, name)
+			}
+			if field == "metadata.parentResourceId" {
+				field = "parentResourceId"
+			}
+			key.Field = field
+		}
+
+		switch direction {
+		case "", SortAscending:
+		case SortDescending:
+			key.Descending = true
+		default:
+			return nil, fmt.Errorf("%w: unknown direction %q for %s, expected asc or desc", ErrInvalidSort, direction, key.Field)
+		}
+
+		if seen[key.Field] {
+			return nil, fmt.Errorf("%w: %s is sorted by twice", ErrInvalidSort, key.Field)
+		}
+		seen[key.Field] = true
+		keys = append(keys, key)
+	}
+
+	if len(keys) > MaxSortKeys {
+		return nil, fmt.Errorf("%w: at most %d sort fields are supported", ErrInvalidSort, MaxSortKeys)
+	}
+	return keys, nil
+}
+
+// CheckSortable rejects sort keys on fields outside SortFields and
+// metadataKeys, which could not use an index
+func CheckSortable(keys []SortKey, metadataKeys []string) error {
+	for _, key := range keys {
+		sortable := false
+		for _, field := range SortFields {
+			sortable = sortable || key.Field == field
+		}
+		if metadataKey, ok := querylang.MetadataKey(key.Field); ok {
+			for _, indexed := range metadataKeys {
+				sortable = sortable || metadataKey == indexed
+			}
+		}
+		if !sortable {
+			return fmt.Errorf("%w: %s is not indexed; results can be sorted by %s or indexed metadata keys", ErrInvalidSort, key.Field, strings.Join(SortFields, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLogQuerySortKeys(t *testing.T) {
+	tests := []struct {
+		sort     string
+		expected []SortKey
+	}{
+		{"", nil},
+		{SortTime, nil},
+		{SortRelevance, nil},
+		{"level", []SortKey{{Field: "level"}}},
+		{"level:asc, timestamp:desc", []SortKey{{Field: "level"}, {Field: "timestamp", Descending: true}}},
+		{"RESOURCEID:desc", []SortKey{{Field: "resourceId", Descending: true}}},
+		{"metadata.parentResourceId,metadata.region:desc", []SortKey{{Field: "parentResourceId"}, {Field: "metadata.region", Descending: true}}},
+	}
+
+	for _, tt := range tests {
+		query := &LogQuery{Sort: tt.sort}
+		keys, err := query.SortKeys()
+		if err != nil {
+			t.Fatalf("SortKeys(%q) failed: %v", tt.sort, err)
+		}
+		if !reflect.DeepEqual(keys, tt.expected) {
+			t.Errorf("SortKeys(%q): expected %v, got %v", tt.sort, tt.expected, keys)
+		}
+		if query.ByFields() != (tt.expected != nil) {
+			t.Errorf("ByFields(%q): expected %v", tt.sort, tt.expected != nil)
+		}
+	}
+}
+
+func TestLogQuerySortKeysErrors(t *testing.T) {
+	for _, query := range []*LogQuery{
+		{Sort: "score"},
+		{Sort: "message"},
+		{Sort: "metadata.bad.key"},
+		{Sort: "level:up"},
+		{Sort: "level,level:desc"},
+		{Sort: "level,resourceId,traceId,timestamp"},
+		{Sort: "level", Cursor: "eyJ0Ijo"},
+	} {
+		if _, err := query.SortKeys(); !errors.Is(err, ErrInvalidSort) {
+			t.Errorf("SortKeys(%q): expected ErrInvalidSort, got %v", query.Sort, err)
+		}
+	}
+}
+
+func TestCheckSortable(t *testing.T) {
+	keys := []SortKey{{Field: "timestamp"}, {Field: "level"}, {Field: "parentResourceId"}}
+	if err := CheckSortable(keys, nil); err != nil {
+		t.Errorf("Expected built-in sort fields to be sortable, got %v", err)
+	}
+
+	region := []SortKey{{Field: "metadata.region", Descending: true}}
+	if err := CheckSortable(region, []string{"region"}); err != nil {
+		t.Errorf("Expected an indexed metadata key to be sortable, got %v", err)
+	}
+
+	for _, keys := range [][]SortKey{region, {{Field: "spanId"}}, {{Field: "commit"}}} {
+		if err := CheckSortable(keys, []string{"userId"}); !errors.Is(err, ErrInvalidSort) {
+			t.Errorf("Expected %v not to be sortable, got %v", keys, err)
+		}
+	}
+}
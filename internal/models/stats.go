@@ -0,0 +1,143 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"log-ingestor/internal/querylang"
+)
+
+const (
+	// DefaultStatsTop is how many group values are reported when none is requested
+	DefaultStatsTop = 10
+
+	// MaxStatsTop caps how many group values a client may request
+	MaxStatsTop = 100
+
+	// MaxStatsBuckets caps how many histogram buckets a response may contain
+	MaxStatsBuckets = 1000
+
+	// targetStatsBuckets is roughly how many buckets an automatic interval aims for
+	targetStatsBuckets = 60
+)
+
+// statsIntervals are the intervals chosen from when none is requested
+var statsIntervals = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+// StatsRequest describes the aggregations computed over the logs matching
+// a query: a histogram with buckets of Interval, and when GroupBy is set,
+// the Top values of that field by count
+type StatsRequest struct {
+	Interval time.Duration
+	GroupBy  string
+	Top      int
+}
+
+// StatsBucket is the number of logs in the histogram bucket starting at Start
+type StatsBucket struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+}
+
+// TermCount is the number of logs with a given value of the grouped field
+type TermCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// StatsResult holds the aggregations computed for a StatsRequest
+type StatsResult struct {
+	Total    int64         `json:"total"`
+	Interval string        `json:"interval"`
+	Buckets  []StatsBucket `json:"buckets"`
+	GroupBy  string        `json:"groupBy,omitempty"`
+	Groups   []TermCount   `json:"groups,omitempty"`
+}
+
+// ParseStatsRequest reads the interval, groupBy and top parameters. Without
+// an interval one is chosen from the query's time range.
+func ParseStatsRequest(params url.Values, query *LogQuery) (*StatsRequest, error) {
+	request := &StatsRequest{Top: DefaultStatsTop}
+
+	// Histogram interval
+	if value := params.Get("interval"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval %q", value)
+		}
+		if interval < time.Second || interval%time.Millisecond != 0 {
+			return nil, fmt.Errorf("interval must be a whole number of milliseconds and at least 1s")
+		}
+		request.Interval = interval
+	} else {
+		request.Interval = autoInterval(query.StartTime, query.EndTime)
+	}
+
+	// Refuse histograms that would need more buckets than can be returned
+	if !query.StartTime.IsZero() {
+		end := query.EndTime
+		if end.IsZero() {
+			end = time.Now()
+		}
+		if end.Sub(query.StartTime)/request.Interval > MaxStatsBuckets {
+			return nil, fmt.Errorf("interval %v yields more than %d buckets for the time range", request.Interval, MaxStatsBuckets)
+		}
+	}
+
+	// Grouped field
+	if value := params.Get("groupBy"); value != "" {
+		field, ok := querylang.CanonicalField(value)
+		if !ok || field == querylang.DefaultField {
+			return nil, fmt.Errorf("cannot group by %q", value)
+		}
+		request.GroupBy = field
+	}
+
+	// Number of group values
+	if value := params.Get("top"); value != "" {
+		top, err := strconv.Atoi(value)
+		if err != nil || top < 1 || top > MaxStatsTop {
+			return nil, fmt.Errorf("top must be between 1 and %d", MaxStatsTop)
+		}
+		request.Top = top
+	}
+
+	return request, nil
+}
+
+// autoInterval picks the smallest interval that covers the time range in
+// about targetStatsBuckets buckets. Open-ended ranges use hourly buckets.
+func autoInterval(start, end time.Time) time.Duration {
+	if start.IsZero() {
+		return time.Hour
+	}
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	span := end.Sub(start)
+	for _, interval := range statsIntervals {
+		if span/interval <= targetStatsBuckets {
+			return interval
+		}
+	}
+	return statsIntervals[len(statsIntervals)-1]
+}
+
+// BucketStart returns the start of the histogram bucket containing t.
+// Buckets are aligned to the Unix epoch, as in the database aggregations.
+func BucketStart(t time.Time, interval time.Duration) time.Time {
+	ms := t.UnixMilli()
+	step := interval.Milliseconds()
+	offset := ms % step
+	if offset < 0 {
+		offset += step
+	}
+	return time.UnixMilli(ms - offset).UTC()
+}
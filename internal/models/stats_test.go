@@ -0,0 +1,81 @@
+package models
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseStatsRequest(t *testing.T) {
+	start, _ := time.Parse(time.RFC3339, "2023-09-15T00:00:00Z")
+
+	testCases := []struct {
+		name     string
+		params   string
+		query    LogQuery
+		expected StatsRequest
+	}{
+		{"Defaults", "", LogQuery{}, StatsRequest{Interval: time.Hour, Top: DefaultStatsTop}},
+		{"Explicit", "interval=1m&groupBy=RESOURCEID&top=5", LogQuery{}, StatsRequest{Interval: time.Minute, GroupBy: "resourceId", Top: 5}},
+		{"Metadata", "groupBy=metadata.region", LogQuery{}, StatsRequest{Interval: time.Hour, GroupBy: "metadata.region", Top: DefaultStatsTop}},
+		{"AutoInterval", "", LogQuery{StartTime: start, EndTime: start.Add(6 * time.Hour)}, StatsRequest{Interval: 10 * time.Minute, Top: DefaultStatsTop}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params, _ := url.ParseQuery(tc.params)
+			request, err := ParseStatsRequest(params, &tc.query)
+			if err != nil {
+				t.Fatalf("Failed to parse stats request: %v", err)
+			}
+			if *request != tc.expected {
+				t.Errorf("ParseStatsRequest() = %+v, expected %+v", *request, tc.expected)
+			}
+		})
+	}
+}
+
+func TestParseStatsRequestInvalid(t *testing.T) {
+	start, _ := time.Parse(time.RFC3339, "2023-09-15T00:00:00Z")
+
+	testCases := []struct {
+		params string
+		query  LogQuery
+	}{
+		{"interval=soon", LogQuery{}},
+		{"interval=10ms", LogQuery{}},
+		{"interval=1s", LogQuery{StartTime: start, EndTime: start.Add(24 * time.Hour)}},
+		{"groupBy=message", LogQuery{}},
+		{"groupBy=timestamp", LogQuery{}},
+		{"groupBy=metadata.$where", LogQuery{}},
+		{"top=0", LogQuery{}},
+		{"top=1000", LogQuery{}},
+	}
+
+	for _, tc := range testCases {
+		params, _ := url.ParseQuery(tc.params)
+		if _, err := ParseStatsRequest(params, &tc.query); err == nil {
+			t.Errorf("Expected error for %q", tc.params)
+		}
+	}
+}
+
+func TestBucketStart(t *testing.T) {
+	timestamp, _ := time.Parse(time.RFC3339, "2023-09-15T08:17:42Z")
+
+	testCases := []struct {
+		interval time.Duration
+		expected string
+	}{
+		{time.Minute, "2023-09-15T08:17:00Z"},
+		{5 * time.Minute, "2023-09-15T08:15:00Z"},
+		{time.Hour, "2023-09-15T08:00:00Z"},
+		{24 * time.Hour, "2023-09-15T00:00:00Z"},
+	}
+
+	for _, tc := range testCases {
+		if result := BucketStart(timestamp, tc.interval).Format(time.RFC3339); result != tc.expected {
+			t.Errorf("BucketStart(%v) = %s, expected %s", tc.interval, result, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrorLevel is the level of logs counted as errors in traces
+const ErrorLevel = "error"
+
+// Trace is every log of a trace grouped by span, with the resources the
+// trace went through arranged by their parentResourceId metadata
+type Trace struct {
+	TraceID    string           `json:"traceId"`
+	Start      time.Time        `json:"start"`
+	End        time.Time        `json:"end"`
+	DurationMs float64          `json:"durationMs"`
+	LogCount   int              `json:"logCount"`
+	ErrorCount int              `json:"errorCount"`
+	Spans      []*Span          `json:"spans"`
+	Resources  []*TraceResource `json:"resources"`
+
+	// Truncated is set when the trace has more logs than were read
+	Truncated bool `json:"truncated"`
+}
+
+// Span is the logs of a trace sharing a spanId, oldest first. Its
+// duration runs from its first log to its last. Logs without a spanId
+// make up a span with an empty SpanID.
+type Span struct {
+	SpanID      string    `json:"spanId"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	DurationMs  float64   `json:"durationMs"`
+	ResourceIDs []string  `json:"resourceIds"`
+	LogCount    int       `json:"logCount"`
+	ErrorCount  int       `json:"errorCount"`
+	Logs        []*Log    `json:"logs"`
+}
+
+// TraceResource is a resource in a trace's hierarchy. Parents named by
+// parentResourceId metadata are included even when they logged nothing in
+// the trace, so the hierarchy stays connected.
+type TraceResource struct {
+	ResourceID       string           `json:"resourceId"`
+	ParentResourceID string           `json:"parentResourceId,omitempty"`
+	LogCount         int              `json:"logCount"`
+	ErrorCount       int              `json:"errorCount"`
+	Children         []*TraceResource `json:"children,omitempty"`
+}
+
+// IsError reports whether the log is counted as an error
+func (l *Log) IsError() bool {
+	return strings.EqualFold(l.Level, ErrorLevel)
+}
+
+// BuildTrace groups the logs of a trace, in any order, by span. Spans are
+// ordered by their first log, ties broken by span ID.
+func BuildTrace(traceID string, logs []*Log) *Trace {
+	sorted := slices.Clone(logs)
+	slices.SortStableFunc(sorted, func(a, b *Log) int {
+		if c := a.Timestamp.Compare(b.Timestamp); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	trace := &Trace{TraceID: traceID, LogCount: len(sorted), Spans: []*Span{}, Resources: []*TraceResource{}}
+	if len(sorted) > 0 {
+		trace.Start = sorted[0].Timestamp
+		trace.End = sorted[len(sorted)-1].Timestamp
+		trace.DurationMs = durationMs(trace.End.Sub(trace.Start))
+	}
+
+	spans := make(map[string]*Span)
+	for _, log := range sorted {
+		span, ok := spans[log.SpanID]
+		if !ok {
+			span = &Span{SpanID: log.SpanID, Start: log.Timestamp, ResourceIDs: []string{}}
+			spans[log.SpanID] = span
+			trace.Spans = append(trace.Spans, span)
+		}
+		span.End = log.Timestamp
+		span.Logs = append(span.Logs, log)
+		span.LogCount++
+		if log.IsError() {
+			span.ErrorCount++
+			trace.ErrorCount++
+		}
+		if log.ResourceID != "" && !slices.Contains(span.ResourceIDs, log.ResourceID) {
+			span.ResourceIDs = append(span.ResourceIDs, log.ResourceID)
+		}
+	}
+	for _, span := range trace.Spans {
+		span.DurationMs = durationMs(span.End.Sub(span.Start))
+	}
+	slices.SortStableFunc(trace.Spans, func(a, b *Span) int {
+		if c := a.Start.Compare(b.Start); c != 0 {
+			return c
+		}
+		return strings.Compare(a.SpanID, b.SpanID)
+	})
+
+	trace.Resources = resourceHierarchy(sorted)
+	return trace
+}
+
+// resourceHierarchy arranges the resources of logs sorted oldest first
+// into trees by parentResourceId, in the order they first appear. A
+// resource keeps the first parent it reports.
+func resourceHierarchy(logs []*Log) []*TraceResource {
+	var order []*TraceResource
+	resources := make(map[string]*TraceResource)
+	resource := func(id string) *TraceResource {
+		node, ok := resources[id]
+		if !ok {
+			node = &TraceResource{ResourceID: id}
+			resources[id] = node
+			order = append(order, node)
+		}
+		return node
+	}
+
+	for _, log := range logs {
+		if log.ResourceID == "" {
+			continue
+		}
+		node := resource(log.ResourceID)
+		node.LogCount++
+		if log.IsError() {
+			node.ErrorCount++
+		}
+
+		parent := log.Metadata["parentResourceId"]
+		if node.ParentResourceID == "" && parent != "" && parent != node.ResourceID {
+			node.ParentResourceID = parent
+			resource(parent)
+		}
+	}
+
+	// A resource that is its own ancestor becomes a root, breaking the
+	// cycle for the others in it
+	cyclic := func(node *TraceResource) bool {
+		seen := make(map[string]bool)
+		for id := node.ParentResourceID; id != "" && !seen[id]; id = resources[id].ParentResourceID {
+			if id == node.ResourceID {
+				return true
+			}
+			seen[id] = true
+		}
+		return false
+	}
+
+	roots := []*TraceResource{}
+	for _, node := range order {
+		if node.ParentResourceID != "" && cyclic(node) {
+			node.ParentResourceID = ""
+		}
+		if node.ParentResourceID == "" {
+			roots = append(roots, node)
+			continue
+		}
+		parent := resources[node.ParentResourceID]
+		parent.Children = append(parent.Children, node)
+	}
+	return roots
+}
+
+// durationMs converts a duration to fractional milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildTrace(t *testing.T) {
+	base := time.Date(2023, 9, 15, 8, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return base.Add(time.Duration(ms) * time.Millisecond) }
+	logs := []*Log{
+		{ID: "4", Level: "error", ResourceID: "db-1", SpanID: "span-2", Timestamp: at(300), Metadata: map[string]string{"parentResourceId": "server-1"}},
+		{ID: "1", Level: "info", ResourceID: "gateway", SpanID: "span-1", Timestamp: at(0)},
+		{ID: "3", Level: "info", ResourceID: "server-1", SpanID: "span-2", Timestamp: at(100), Metadata: map[string]string{"parentResourceId": "gateway"}},
+		{ID: "5", Level: "ERROR", ResourceID: "server-1", SpanID: "span-2", Timestamp: at(400), Metadata: map[string]string{"parentResourceId": "lb"}},
+		{ID: "2", Level: "info", ResourceID: "gateway", SpanID: "span-1", Timestamp: at(1500)},
+		{ID: "6", Level: "debug", Timestamp: at(100)},
+	}
+
+	trace := BuildTrace("abc", logs)
+	if trace.TraceID != "abc" || trace.LogCount != 6 || trace.ErrorCount != 2 || trace.DurationMs != 1500 {
+		t.Errorf("Unexpected trace summary: %+v", trace)
+	}
+	if !trace.Start.Equal(at(0)) || !trace.End.Equal(at(1500)) {
+		t.Errorf("Expected the trace to run from 0ms to 1500ms, got %v to %v", trace.Start, trace.End)
+	}
+
+	// Spans are ordered by their first log, ties broken by span ID
+	var spanIDs []string
+	for _, span := range trace.Spans {
+		spanIDs = append(spanIDs, span.SpanID)
+	}
+	if !reflect.DeepEqual(spanIDs, []string{"span-1", "", "span-2"}) {
+		t.Fatalf("Unexpected span order: %v", spanIDs)
+	}
+
+	span := trace.Spans[2]
+	if span.DurationMs != 300 || span.LogCount != 3 || span.ErrorCount != 2 || !reflect.DeepEqual(span.ResourceIDs, []string{"server-1", "db-1"}) {
+		t.Errorf("Unexpected span: %+v", span)
+	}
+	var ids []string
+	for _, log := range span.Logs {
+		ids = append(ids, log.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"3", "4", "5"}) {
+		t.Errorf("Expected span logs oldest first, got %v", ids)
+	}
+	if trace.Spans[1].DurationMs != 0 || len(trace.Spans[1].ResourceIDs) != 0 {
+		t.Errorf("Unexpected span without ID: %+v", trace.Spans[1])
+	}
+
+	// Resources keep the first parent they report
+	expected := []*TraceResource{{
+		ResourceID: "gateway",
+		LogCount:   2,
+		Children: []*TraceResource{{
+			ResourceID:       "server-1",
+			ParentResourceID: "gateway",
+			LogCount:         2,
+			ErrorCount:       1,
+			Children:         []*TraceResource{{ResourceID: "db-1", ParentResourceID: "server-1", LogCount: 1, ErrorCount: 1}},
+		}},
+	}}
+	if !reflect.DeepEqual(trace.Resources, expected) {
+		t.Errorf("Unexpected resource hierarchy: %+v", trace.Resources)
+	}
+}
+
+func TestBuildTraceResourceHierarchy(t *testing.T) {
+	now := time.Now()
+	parent := func(resourceID, parentResourceID string) *Log {
+		return &Log{Level: "info", ResourceID: resourceID, Timestamp: now, Metadata: map[string]string{"parentResourceId": parentResourceID}}
+	}
+
+	// Parents that never logged are included, and cycles are broken at the
+	// resource seen first
+	trace := BuildTrace("abc", []*Log{parent("a", "b"), parent("b", "a"), parent("c", "a"), parent("d", "lb")})
+
+	expected := []*TraceResource{
+		{ResourceID: "a", LogCount: 1, Children: []*TraceResource{
+			{ResourceID: "b", ParentResourceID: "a", LogCount: 1},
+			{ResourceID: "c", ParentResourceID: "a", LogCount: 1},
+		}},
+		{ResourceID: "lb", Children: []*TraceResource{{ResourceID: "d", ParentResourceID: "lb", LogCount: 1}}},
+	}
+	if !reflect.DeepEqual(trace.Resources, expected) {
+		t.Errorf("Unexpected resource hierarchy: %+v", trace.Resources)
+	}
+
+	if trace := BuildTrace("empty", nil); len(trace.Spans) != 0 || len(trace.Resources) != 0 || trace.LogCount != 0 {
+		t.Errorf("Expected an empty trace, got %+v", trace)
+	}
+}
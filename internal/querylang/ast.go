@@ -0,0 +1,166 @@
+package querylang
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Expr is a node of a parsed query
+type Expr interface {
+	// String renders the expression in query language syntax
+	String() string
+
+	expr()
+}
+
+// AndExpr matches when every operand matches
+type AndExpr struct {
+	Operands []Expr
+}
+
+// OrExpr matches when any operand matches
+type OrExpr struct {
+	Operands []Expr
+}
+
+// NotExpr matches when its operand does not match
+type NotExpr struct {
+	Operand Expr
+}
+
+// TermExpr matches a single field against a value. Unquoted values
+// containing '*' are wildcard patterns.
+type TermExpr struct {
+	Field    string
+	Value    string
+	Wildcard bool
+
+	// pattern caches the compiled wildcard pattern
+	once    sync.Once
+	pattern *regexp.Regexp
+}
+
+func (*AndExpr) expr()  {}
+func (*OrExpr) expr()   {}
+func (*NotExpr) expr()  {}
+func (*TermExpr) expr() {}
+
+// String renders the expression in query language syntax
+func (e *AndExpr) String() string {
+	return joinOperands(e.Operands, " AND ")
+}
+
+// String renders the expression in query language syntax
+func (e *OrExpr) String() string {
+	return joinOperands(e.Operands, " OR ")
+}
+
+// String renders the expression in query language syntax
+func (e *NotExpr) String() string {
+	return "NOT (" + e.Operand.String() + ")"
+}
+
+// String renders the expression in query language syntax
+func (e *TermExpr) String() string {
+	value := e.Value
+	if !e.Wildcard {
+		value = Quote(value)
+	}
+	return e.Field + ":" + value
+}
+
+// joinOperands renders operands in parentheses joined by op
+func joinOperands(operands []Expr, op string) string {
+	parts := make([]string, len(operands))
+	for i, operand := range operands {
+		parts[i] = "(" + operand.String() + ")"
+	}
+	return strings.Join(parts, op)
+}
+
+// And combines expressions into one that matches when all of them match,
+// skipping nil expressions
+func And(exprs ...Expr) Expr {
+	var operands []Expr
+	for _, e := range exprs {
+		if e != nil {
+			operands = append(operands, e)
+		}
+	}
+
+	switch len(operands) {
+	case 0:
+		return nil
+	case 1:
+		return operands[0]
+	default:
+		return &AndExpr{Operands: operands}
+	}
+}
+
+// Or combines expressions into one that matches when any of them matches,
+// skipping nil expressions
+func Or(exprs ...Expr) Expr {
+	var operands []Expr
+	for _, e := range exprs {
+		if e != nil {
+			operands = append(operands, e)
+		}
+	}
+
+	switch len(operands) {
+	case 0:
+		return nil
+	case 1:
+		return operands[0]
+	default:
+		return &OrExpr{Operands: operands}
+	}
+}
+
+// Fields returns the fields referenced by an expression's terms
+func Fields(e Expr) []string {
+	var fields []string
+	var walk func(Expr)
+	walk = func(e Expr) {
+		switch e := e.(type) {
+		case *AndExpr:
+			for _, operand := range e.Operands {
+				walk(operand)
+			}
+		case *OrExpr:
+			for _, operand := range e.Operands {
+				walk(operand)
+			}
+		case *NotExpr:
+			walk(e.Operand)
+		case *TermExpr:
+			fields = append(fields, e.Field)
+		}
+	}
+	walk(e)
+	return fields
+}
+
+// Quote renders a value as a quoted string literal
+func Quote(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// WildcardPattern converts a wildcard value into a regular expression in
+// which '*' matches any run of characters. Anchored patterns must match the
+// whole value.
+func WildcardPattern(value string, anchored bool) string {
+	parts := strings.Split(value, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	pattern := strings.Join(parts, ".*")
+	if anchored {
+		pattern = "^" + pattern + "$"
+	}
+	return pattern
+}
@@ -0,0 +1,70 @@
+package querylang
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Document exposes field values to the evaluator
+type Document interface {
+	// Field returns the value of a canonical field and whether it is set
+	Field(name string) (string, bool)
+}
+
+// Match reports whether doc satisfies the expression. A nil expression
+// matches every document.
+func Match(e Expr, doc Document) bool {
+	switch e := e.(type) {
+	case nil:
+		return true
+	case *AndExpr:
+		for _, operand := range e.Operands {
+			if !Match(operand, doc) {
+				return false
+			}
+		}
+		return true
+	case *OrExpr:
+		for _, operand := range e.Operands {
+			if Match(operand, doc) {
+				return true
+			}
+		}
+		return false
+	case *NotExpr:
+		return !Match(e.Operand, doc)
+	case *TermExpr:
+		return e.matches(doc)
+	default:
+		return false
+	}
+}
+
+// matches evaluates a single term. The message field matches substrings
+// case-insensitively; every other field must match the whole value.
+func (e *TermExpr) matches(doc Document) bool {
+	value, ok := doc.Field(e.Field)
+	if !ok {
+		return false
+	}
+
+	if e.Wildcard {
+		return e.wildcardRegexp().MatchString(value)
+	}
+	if e.Field == DefaultField {
+		return strings.Contains(strings.ToLower(value), strings.ToLower(e.Value))
+	}
+	return value == e.Value
+}
+
+// wildcardRegexp compiles the term's wildcard pattern once
+func (e *TermExpr) wildcardRegexp() *regexp.Regexp {
+	e.once.Do(func() {
+		if e.Field == DefaultField {
+			e.pattern = regexp.MustCompile("(?i)" + WildcardPattern(e.Value, false))
+		} else {
+			e.pattern = regexp.MustCompile(WildcardPattern(e.Value, true))
+		}
+	})
+	return e.pattern
+}
@@ -0,0 +1,60 @@
+package querylang
+
+import (
+	"testing"
+)
+
+// mapDocument is a Document backed by a map
+type mapDocument map[string]string
+
+func (d mapDocument) Field(name string) (string, bool) {
+	value, ok := d[name]
+	return value, ok
+}
+
+func TestMatch(t *testing.T) {
+	doc := mapDocument{
+		"level":           "error",
+		"message":         "API request Timed out after 30s",
+		"resourceId":      "server-1234",
+		"commit":          "5e5342f",
+		"metadata.region": "us-east-1",
+	}
+
+	testCases := []struct {
+		query    string
+		expected bool
+	}{
+		{`level:error`, true},
+		{`level:err`, false},
+		{`level:err*`, true},
+		{`timed`, true},
+		{`message:"timed out"`, true},
+		{`message:request*after`, true},
+		{`message:"request*after"`, false},
+		{`resourceId:server-1*`, true},
+		{`resourceId:server-1`, false},
+		{`level:error AND NOT commit:5e5342f`, false},
+		{`level:info OR resourceId:server-1234`, true},
+		{`level:error AND (resourceId:server-9* OR message:"timed out")`, true},
+		{`metadata.region:us-*`, true},
+		{`metadata.tenant:acme`, false},
+		{`NOT metadata.tenant:acme`, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			expr, err := Parse(tc.query)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tc.query, err)
+			}
+			if result := Match(expr, doc); result != tc.expected {
+				t.Errorf("Match(%q) = %v, expected %v", tc.query, result, tc.expected)
+			}
+		})
+	}
+
+	if !Match(nil, doc) {
+		t.Error("Expected nil expression to match every document")
+	}
+}
@@ -0,0 +1,357 @@
+// Package querylang parses the structured log search language, for example
+//
+//	level:error AND (resourceId:server-1* OR message:"timed out") AND NOT commit:5e5342f
+//
+// Terms are field:value pairs or bare values, which match the message.
+// Adjacent terms are ANDed; AND, OR and NOT must be upper case and
+// parentheses group subexpressions. Unquoted values containing '*' are
+// wildcard patterns.
+package querylang
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultField is the field matched by terms without an explicit field
+const DefaultField = "message"
+
+// metadataPrefix prefixes fields addressing a metadata key
+const metadataPrefix = "metadata."
+
+// MaxDepth caps how deeply parentheses and NOT may nest, keeping the
+// translated query within database nesting limits and bounding recursion
+const MaxDepth = 32
+
+// fields maps lower-cased field names to their canonical form
+var fields = map[string]string{
+	"level":            "level",
+	"message":          "message",
+	"resourceid":       "resourceId",
+	"traceid":          "traceId",
+	"spanid":           "spanId",
+	"commit":           "commit",
+	"parentresourceid": "parentResourceId",
+}
+
+// metadataKeyPattern restricts metadata keys to characters that are safe in
+// database field paths
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ParseError describes a syntax error and the byte offset where it occurred
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+// Error implements the error interface
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+// CanonicalField returns the canonical name of a query field, accepting
+// any letter case for built-in fields and metadata.<key> for metadata
+func CanonicalField(name string) (string, bool) {
+	if strings.HasPrefix(strings.ToLower(name), metadataPrefix) {
+		key := name[len(metadataPrefix):]
+		if !metadataKeyPattern.MatchString(key) {
+			return "", false
+		}
+		return metadataPrefix + key, true
+	}
+
+	canonical, ok := fields[strings.ToLower(name)]
+	return canonical, ok
+}
+
+// MetadataKey returns the metadata key addressed by a canonical field
+func MetadataKey(field string) (string, bool) {
+	if !strings.HasPrefix(field, metadataPrefix) {
+		return "", false
+	}
+	return field[len(metadataPrefix):], true
+}
+
+// Parse parses a query string into an expression. An empty query yields a
+// nil expression.
+func Parse(input string) (Expr, error) {
+	p := &parser{input: input}
+	p.skipSpace()
+	if p.pos == len(p.input) {
+		return nil, nil
+	}
+
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		if p.input[p.pos] == ')' {
+			return nil, p.errorf("unexpected ')'")
+		}
+		return nil, p.errorf("unexpected %q", p.peekWord())
+	}
+	return e, nil
+}
+
+// parser is a recursive descent parser over the query string
+type parser struct {
+	input string
+	pos   int
+
+	// depth is the number of enclosing parentheses and NOTs
+	depth int
+}
+
+// enter descends into a nested expression starting at pos, failing past
+// MaxDepth. Each successful call is matched by a call to leave.
+func (p *parser) enter(pos int) error {
+	if p.depth == MaxDepth {
+		return &ParseError{Pos: pos, Msg: fmt.Sprintf("query nests deeper than %d levels", MaxDepth)}
+	}
+	p.depth++
+	return nil
+}
+
+// leave returns from a nested expression
+func (p *parser) leave() {
+	p.depth--
+}
+
+// parseOr parses operands separated by OR
+func (p *parser) parseOr() (Expr, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	operands := []Expr{first}
+	for p.acceptKeyword("OR") {
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, next)
+	}
+
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return &OrExpr{Operands: operands}, nil
+}
+
+// parseAnd parses operands separated by AND or juxtaposition
+func (p *parser) parseAnd() (Expr, error) {
+	first, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	operands := []Expr{first}
+	for {
+		if !p.acceptKeyword("AND") {
+			// Adjacent terms are implicitly ANDed
+			p.skipSpace()
+			if p.pos == len(p.input) || p.input[p.pos] == ')' || p.atKeyword("OR") {
+				break
+			}
+		}
+
+		next, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, next)
+	}
+
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return &AndExpr{Operands: operands}, nil
+}
+
+// parseNot parses an optionally negated primary expression
+func (p *parser) parseNot() (Expr, error) {
+	p.skipSpace()
+	start := p.pos
+	if p.acceptKeyword("NOT") {
+		if err := p.enter(start); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &NotExpr{Operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses a parenthesized expression or a term
+func (p *parser) parsePrimary() (Expr, error) {
+	p.skipSpace()
+	if p.pos == len(p.input) {
+		return nil, p.errorf("unexpected end of query")
+	}
+
+	switch p.input[p.pos] {
+	case '(':
+		open := p.pos
+		if err := p.enter(open); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos == len(p.input) || p.input[p.pos] != ')' {
+			return nil, &ParseError{Pos: open, Msg: "unclosed '('"}
+		}
+		p.pos++
+		return e, nil
+	case ')':
+		return nil, p.errorf("unexpected ')'")
+	}
+
+	if p.atKeyword("AND") || p.atKeyword("OR") {
+		return nil, p.errorf("unexpected %s", p.peekWord())
+	}
+	return p.parseTerm()
+}
+
+// parseTerm parses field:value, field:"value", value or "value"
+func (p *parser) parseTerm() (Expr, error) {
+	start := p.pos
+
+	if p.input[p.pos] == '"' {
+		value, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return &TermExpr{Field: DefaultField, Value: value}, nil
+	}
+
+	word := p.readWord()
+	colon := strings.IndexByte(word, ':')
+	if colon < 0 {
+		return newTerm(DefaultField, word), nil
+	}
+
+	name := word[:colon]
+	field, ok := CanonicalField(name)
+	if !ok {
+		return nil, &ParseError{Pos: start, Msg: fmt.Sprintf("unknown field %q", name)}
+	}
+
+	value := word[colon+1:]
+	if value != "" {
+		return newTerm(field, value), nil
+	}
+
+	// The value must follow the colon directly as a quoted string
+	if p.pos == len(p.input) || p.input[p.pos] != '"' {
+		return nil, p.errorf("expected value for field %q", name)
+	}
+	quoted, err := p.parseQuoted()
+	if err != nil {
+		return nil, err
+	}
+	return &TermExpr{Field: field, Value: quoted}, nil
+}
+
+// parseQuoted parses a double-quoted string with backslash escapes
+func (p *parser) parseQuoted() (string, error) {
+	open := p.pos
+	p.pos++
+
+	var b strings.Builder
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			return b.String(), nil
+		case '\\':
+			if p.pos+1 == len(p.input) {
+				return "", p.errorf("unterminated escape")
+			}
+			b.WriteByte(p.input[p.pos+1])
+			p.pos += 2
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", &ParseError{Pos: open, Msg: "unterminated string"}
+}
+
+// newTerm creates a term from an unquoted value
+func newTerm(field, value string) *TermExpr {
+	return &TermExpr{Field: field, Value: value, Wildcard: strings.Contains(value, "*")}
+}
+
+// readWord consumes characters up to whitespace, a parenthesis or a quote
+func (p *parser) readWord() string {
+	start := p.pos
+	for p.pos < len(p.input) {
+		r, size := utf8.DecodeRuneInString(p.input[p.pos:])
+		if unicode.IsSpace(r) || r == '(' || r == ')' || r == '"' {
+			break
+		}
+		p.pos += size
+	}
+	return p.input[start:p.pos]
+}
+
+// peekWord returns the word at the current position without consuming it
+func (p *parser) peekWord() string {
+	saved := p.pos
+	word := p.readWord()
+	p.pos = saved
+	if word == "" && p.pos < len(p.input) {
+		return p.input[p.pos : p.pos+1]
+	}
+	return word
+}
+
+// atKeyword reports whether the next word is the given keyword
+func (p *parser) atKeyword(keyword string) bool {
+	p.skipSpace()
+	return p.peekWord() == keyword
+}
+
+// acceptKeyword consumes the next word if it is the given keyword
+func (p *parser) acceptKeyword(keyword string) bool {
+	if !p.atKeyword(keyword) {
+		return false
+	}
+	p.pos += len(keyword)
+	return true
+}
+
+// skipSpace advances past whitespace
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) {
+		r, size := utf8.DecodeRuneInString(p.input[p.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		p.pos += size
+	}
+}
+
+// errorf returns a parse error at the current position
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &ParseError{Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,154 @@
+package querylang
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{`level:error`, `level:"error"`},
+		{`timeout`, `message:"timeout"`},
+		{`"timed out"`, `message:"timed out"`},
+		{`level:error resourceId:server-1`, `(level:"error") AND (resourceId:"server-1")`},
+		{`level:error AND resourceId:server-1*`, `(level:"error") AND (resourceId:server-1*)`},
+		{`level:error OR level:warning`, `(level:"error") OR (level:"warning")`},
+		{`a OR b AND c`, `(message:"a") OR ((message:"b") AND (message:"c"))`},
+		{`NOT commit:5e5342f`, `NOT (commit:"5e5342f")`},
+		{`RESOURCEID:x metadata.region:us-east-1`, `(resourceId:"x") AND (metadata.region:"us-east-1")`},
+		{`message:"say \"hi\""`, `message:"say \"hi\""`},
+		{`traceId:abc:123`, `traceId:"abc:123"`},
+		{
+			`level:error AND (resourceId:server-1* OR message:"timed out") AND NOT commit:5e5342f`,
+			`(level:"error") AND ((resourceId:server-1*) OR (message:"timed out")) AND (NOT (commit:"5e5342f"))`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			expr, err := Parse(tc.input)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tc.input, err)
+			}
+			if expr.String() != tc.expected {
+				t.Errorf("Parse(%q) = %s, expected %s", tc.input, expr.String(), tc.expected)
+			}
+
+			// The rendered form parses back to the same expression
+			reparsed, err := Parse(expr.String())
+			if err != nil {
+				t.Fatalf("Reparsing %q failed: %v", expr.String(), err)
+			}
+			if reparsed.String() != expr.String() {
+				t.Errorf("Round trip changed %s into %s", expr.String(), reparsed.String())
+			}
+		})
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	for _, input := range []string{"", "   "} {
+		expr, err := Parse(input)
+		if err != nil || expr != nil {
+			t.Errorf("Parse(%q) = %v, %v; expected nil, nil", input, expr, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		input       string
+		expectedPos int
+	}{
+		{`level:error AND`, 15},
+		{`(level:error`, 0},
+		{`level:error)`, 11},
+		{`bogus:value`, 0},
+		{`level:error AND OR x`, 16},
+		{`message:"unterminated`, 8},
+		{`level: error`, 6},
+		{`NOT`, 3},
+		{`metadata.$where:x`, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			_, err := Parse(tc.input)
+			parseErr, ok := err.(*ParseError)
+			if !ok {
+				t.Fatalf("Parse(%q) error = %v, expected *ParseError", tc.input, err)
+			}
+			if parseErr.Pos != tc.expectedPos {
+				t.Errorf("Parse(%q) error at %d, expected %d: %v", tc.input, parseErr.Pos, tc.expectedPos, parseErr)
+			}
+		})
+	}
+}
+
+func TestParseDepth(t *testing.T) {
+	nested := func(open, term string, depth int) string {
+		return strings.Repeat(open, depth) + term + strings.Repeat(")", strings.Count(open, "(")*depth)
+	}
+
+	// Queries at the limit parse
+	for _, input := range []string{nested("(", "level:error", MaxDepth), nested("NOT ", "level:error", MaxDepth), nested("NOT (", "a", MaxDepth/2)} {
+		if _, err := Parse(input); err != nil {
+			t.Errorf("Parse(%.20q...) failed: %v", input, err)
+		}
+	}
+
+	// One level deeper fails where that level starts, however deep it goes
+	testCases := []struct {
+		input       string
+		expectedPos int
+	}{
+		{nested("(", "level:error", MaxDepth+1), MaxDepth},
+		{nested("(", "level:error", 10000), MaxDepth},
+		{nested("NOT ", "level:error", MaxDepth+1), 4 * MaxDepth},
+		{"a OR " + nested("NOT (", "a", 1000), 5 + 5*(MaxDepth/2)},
+	}
+	for _, tc := range testCases {
+		_, err := Parse(tc.input)
+		parseErr, ok := err.(*ParseError)
+		if !ok {
+			t.Fatalf("Parse(%.20q...) error = %v, expected *ParseError", tc.input, err)
+		}
+		if parseErr.Pos != tc.expectedPos || !strings.Contains(parseErr.Msg, "nests deeper") {
+			t.Errorf("Parse(%.20q...) error at %d, expected %d: %v", tc.input, parseErr.Pos, tc.expectedPos, parseErr)
+		}
+	}
+}
+
+func TestWildcardPattern(t *testing.T) {
+	if got := WildcardPattern("server-1*", true); got != `^server-1.*$` {
+		t.Errorf("Unexpected anchored pattern %s", got)
+	}
+	if got := WildcardPattern("a.b*c", false); got != `a\.b.*c` {
+		t.Errorf("Unexpected unanchored pattern %s", got)
+	}
+}
+
+func TestOrAndFields(t *testing.T) {
+	a := &TermExpr{Field: "level", Value: "error"}
+	b := &TermExpr{Field: "metadata.tenant", Value: "acme"}
+
+	if Or(nil, a, nil) != a {
+		t.Error("Expected Or of a single expression to return it")
+	}
+	if Or() != nil {
+		t.Error("Expected Or of no expressions to be nil")
+	}
+
+	expr := And(Or(a, b), &NotExpr{Operand: &TermExpr{Field: "resourceId", Value: "x"}})
+	if expr.String() != `((level:"error") OR (metadata.tenant:"acme")) AND (NOT (resourceId:"x"))` {
+		t.Errorf("Unexpected expression %s", expr.String())
+	}
+
+	fields := Fields(expr)
+	if len(fields) != 3 || fields[0] != "level" || fields[1] != "metadata.tenant" || fields[2] != "resourceId" {
+		t.Errorf("Fields() = %v", fields)
+	}
+}
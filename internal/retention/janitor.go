@@ -0,0 +1,187 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"log-ingestor/internal/database"
+)
+
+// DefaultInterval is how often the janitor runs when none is configured
+const DefaultInterval = time.Hour
+
+// Config configures the janitor
+type Config struct {
+	// Interval is the time between runs
+	Interval time.Duration
+
+	// Timeout bounds a single run
+	Timeout time.Duration
+
+	// Now returns the current time; tests inject a fixed clock
+	Now func() time.Time
+}
+
+// Stats is a snapshot of the janitor's counters
+type Stats struct {
+	Runs              int64     `json:"runs"`
+	Deleted           int64     `json:"deleted"`
+	DroppedPartitions int64     `json:"droppedPartitions"`
+	LastRun           time.Time `json:"lastRun,omitempty"`
+	LastError         string    `json:"lastError,omitempty"`
+}
+
+// Janitor periodically deletes the logs a retention policy has expired,
+// across every tenant the database can list. When the database partitions
+// logs by time, partitions whose logs have all expired are dropped first.
+type Janitor struct {
+	db     database.DB
+	policy *Policy
+	config Config
+
+	stop    chan struct{}
+	done    chan struct{}
+	started atomic.Bool
+	once    sync.Once
+
+	mutex sync.Mutex
+	stats Stats
+}
+
+// NewJanitor creates a janitor enforcing policy on db. Call Start to run it
+// in the background, or RunOnce to enforce the policy immediately.
+func NewJanitor(db database.DB, policy *Policy, config Config) *Janitor {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Minute
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	return &Janitor{
+		db:     db,
+		policy: policy,
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start runs the janitor every interval until Close is called
+func (j *Janitor) Start() {
+	if !j.started.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer close(j.done)
+
+		ticker := time.NewTicker(j.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), j.config.Timeout)
+			if _, err := j.RunOnce(ctx); err != nil {
+				log.Printf("Error enforcing retention: %v", err)
+			}
+			cancel()
+
+			select {
+			case <-ticker.C:
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the janitor and waits for a run in progress to finish
+func (j *Janitor) Close() {
+	j.once.Do(func() {
+		close(j.stop)
+	})
+
+	if j.started.Load() {
+		<-j.done
+	}
+}
+
+// RunOnce deletes every tenant's expired logs and returns how many were
+// deleted. A failing tenant or rule does not stop the others.
+func (j *Janitor) RunOnce(ctx context.Context) (int64, error) {
+	now := j.config.Now()
+
+	tenants := []string{database.DefaultTenant}
+	var errs []error
+	if lister, ok := j.db.(database.TenantLister); ok {
+		listed, err := lister.Tenants(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			tenants = listed
+		}
+	}
+
+	partitioner, _ := j.db.(database.Partitioner)
+
+	var deleted, droppedPartitions int64
+	for _, tenant := range tenants {
+		tenantCtx := database.WithTenant(ctx, tenant)
+
+		// Dropping whole partitions is cheaper than deleting their logs
+		if partitioner != nil {
+			if age := j.policy.partitionAge(partitioner.PartitionRetention(tenantCtx)); age > 0 {
+				n, err := partitioner.DropPartitionsBefore(tenantCtx, now.Add(-age))
+				if err != nil {
+					errs = append(errs, err)
+				}
+				droppedPartitions += int64(n)
+			}
+		}
+
+		for _, query := range j.policy.expiredQueries(now) {
+			n, err := j.db.DeleteLogs(tenantCtx, query)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			deleted += n
+		}
+	}
+
+	if deleted > 0 {
+		log.Printf("Retention deleted %d expired logs", deleted)
+	}
+	if droppedPartitions > 0 {
+		log.Printf("Retention dropped %d expired partitions", droppedPartitions)
+	}
+
+	err := errors.Join(errs...)
+	j.mutex.Lock()
+	j.stats.Runs++
+	j.stats.Deleted += deleted
+	j.stats.DroppedPartitions += droppedPartitions
+	j.stats.LastRun = now
+	j.stats.LastError = ""
+	if err != nil {
+		j.stats.LastError = err.Error()
+	}
+	j.mutex.Unlock()
+
+	return deleted, err
+}
+
+// Stats returns a snapshot of the janitor's counters
+func (j *Janitor) Stats() Stats {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.stats
+}
@@ -0,0 +1,156 @@
+package retention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"log-ingestor/internal/database"
+	"log-ingestor/internal/models"
+)
+
+// testPolicy keeps payments logs for a year, debug logs for 3 days and
+// everything else for 30 days
+func testPolicy(t *testing.T) *Policy {
+	t.Helper()
+	policy := &Policy{
+		Rules: []Rule{
+			{Query: "resourceId:payments-*", MaxAge: 365 * 24 * time.Hour},
+			{Query: "level:debug", MaxAge: 3 * 24 * time.Hour},
+			{Query: "level:error", MaxAge: 90 * 24 * time.Hour},
+		},
+		Default: 30 * 24 * time.Hour,
+	}
+	if err := policy.Compile(); err != nil {
+		t.Fatalf("Failed to compile policy: %v", err)
+	}
+	return policy
+}
+
+// messages returns the messages of a tenant's remaining logs, newest first
+func messages(mockDB *database.MockDB, tenant string) []string {
+	logs, _ := mockDB.QueryLogs(database.WithTenant(context.Background(), tenant), &models.LogQuery{Limit: 100})
+	result := make([]string, len(logs))
+	for i, log := range logs {
+		result[i] = log.Message
+	}
+	return result
+}
+
+func TestJanitorRunOnce(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+
+	mockDB := database.NewMockDB()
+	for _, tenant := range []string{database.DefaultTenant, "acme"} {
+		mockDB.InsertLogs(database.WithTenant(context.Background(), tenant), []*models.Log{
+			{Level: "debug", Message: "debug 1d", Timestamp: daysAgo(1)},
+			{Level: "debug", Message: "debug 5d", Timestamp: daysAgo(5)},
+			{Level: "info", Message: "info 20d", Timestamp: daysAgo(20)},
+			{Level: "error", Message: "error 60d", Timestamp: daysAgo(60)},
+			{Level: "info", Message: "info 60d", Timestamp: daysAgo(60)},
+			{Level: "error", Message: "error 100d", Timestamp: daysAgo(100)},
+			{Level: "debug", ResourceID: "payments-api", Message: "payments debug 100d", Timestamp: daysAgo(100)},
+		})
+	}
+
+	janitor := NewJanitor(mockDB, testPolicy(t), Config{Now: func() time.Time { return now }})
+	deleted, err := janitor.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to enforce retention: %v", err)
+	}
+	if deleted != 6 {
+		t.Errorf("Expected 6 deleted logs, got %d", deleted)
+	}
+
+	// Every tenant keeps the same logs
+	expected := []string{"debug 1d", "info 20d", "error 60d", "payments debug 100d"}
+	for _, tenant := range []string{database.DefaultTenant, "acme"} {
+		remaining := messages(mockDB, tenant)
+		if len(remaining) != len(expected) {
+			t.Fatalf("Expected %q for tenant %q, got %q", expected, tenant, remaining)
+		}
+		for i := range expected {
+			if remaining[i] != expected[i] {
+				t.Errorf("Expected %q for tenant %q, got %q", expected, tenant, remaining)
+				break
+			}
+		}
+	}
+
+	// A second run at the same time finds nothing left to delete
+	if deleted, _ := janitor.RunOnce(context.Background()); deleted != 0 {
+		t.Errorf("Expected nothing deleted on the second run, got %d", deleted)
+	}
+
+	stats := janitor.Stats()
+	if stats.Runs != 2 || stats.Deleted != 6 || !stats.LastRun.Equal(now) || stats.LastError != "" {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestJanitorReportsErrors(t *testing.T) {
+	mockDB := database.NewMockDB()
+	mockDB.SimulateError = true
+
+	janitor := NewJanitor(mockDB, testPolicy(t), Config{})
+	if _, err := janitor.RunOnce(context.Background()); err == nil {
+		t.Error("Expected an error from a failing database")
+	}
+	if stats := janitor.Stats(); stats.LastError == "" {
+		t.Error("Expected the error to be recorded in the stats")
+	}
+}
+
+func TestJanitorStartAndClose(t *testing.T) {
+	mockDB := database.NewMockDB()
+	mockDB.InsertLog(context.Background(), &models.Log{Level: "debug", Message: "old", Timestamp: time.Now().AddDate(0, 0, -10)})
+
+	// The first run happens as soon as the janitor starts
+	janitor := NewJanitor(mockDB, testPolicy(t), Config{Interval: time.Hour})
+	janitor.Start()
+
+	deadline := time.Now().Add(time.Second)
+	for janitor.Stats().Runs == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	janitor.Close()
+
+	if stats := janitor.Stats(); stats.Runs != 1 || stats.Deleted != 1 {
+		t.Errorf("Expected one run deleting one log, got %+v", stats)
+	}
+}
+
+func TestJanitorDropsPartitions(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+
+	mockDB := database.NewMockDB()
+	mockDB.Partitioning = database.PartitionDaily
+	mockDB.TenantConfig = &database.TenantConfig{Default: database.TenantLimits{Retention: 10 * 24 * time.Hour}}
+	mockDB.InsertLogs(context.Background(), []*models.Log{
+		{Level: "error", Message: "error 1d", Timestamp: daysAgo(1)},
+		{Level: "debug", Message: "debug 5d", Timestamp: daysAgo(5)},
+		{Level: "error", Message: "error 20d", Timestamp: daysAgo(20)},
+		{Level: "info", Message: "info 21d", Timestamp: daysAgo(21)},
+	})
+
+	// The 10 day retention drops the partitions of 20 and 21 days ago, and
+	// the debug rule deletes within the partitions that are kept
+	janitor := NewJanitor(mockDB, testPolicy(t), Config{Now: func() time.Time { return now }})
+	deleted, err := janitor.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to enforce retention: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 deleted log, got %d", deleted)
+	}
+
+	remaining := messages(mockDB, database.DefaultTenant)
+	if len(remaining) != 1 || remaining[0] != "error 1d" {
+		t.Errorf("Expected only %q to remain, got %q", "error 1d", remaining)
+	}
+	if stats := janitor.Stats(); stats.DroppedPartitions != 2 {
+		t.Errorf("Expected 2 dropped partitions, got %+v", stats)
+	}
+}
@@ -0,0 +1,175 @@
+package retention
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"log-ingestor/internal/models"
+	"log-ingestor/internal/querylang"
+)
+
+// Rule keeps the logs matching a query language expression for MaxAge
+type Rule struct {
+	Query  string
+	MaxAge time.Duration
+
+	// expr is the parsed form of Query
+	expr querylang.Expr
+}
+
+// Policy decides how long logs are kept. Each log is governed by the first
+// rule it matches, so specific rules such as a resource override go before
+// general ones such as a level rule. Logs matching no rule are kept for
+// Default, or indefinitely when it is zero.
+type Policy struct {
+	Rules   []Rule
+	Default time.Duration
+}
+
+// ruleJSON is the configuration file form of a Rule
+type ruleJSON struct {
+	Query  string `json:"query"`
+	MaxAge string `json:"maxAge"`
+}
+
+// policyJSON is the configuration file form of a Policy
+type policyJSON struct {
+	Rules   []ruleJSON `json:"rules"`
+	Default string     `json:"default"`
+}
+
+// LoadPolicy reads a retention policy from a JSON file of the form
+//
+//	{
+//	  "rules": [
+//	    {"query": "resourceId:payments-*", "maxAge": "365d"},
+//	    {"query": "level:debug", "maxAge": "3d"},
+//	    {"query": "level:error", "maxAge": "90d"}
+//	  ],
+//	  "default": "30d"
+//	}
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file policyJSON
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	policy := &Policy{}
+	for i, raw := range file.Rules {
+		maxAge, err := ParseAge(raw.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		policy.Rules = append(policy.Rules, Rule{Query: raw.Query, MaxAge: maxAge})
+	}
+	if file.Default != "" {
+		if policy.Default, err = ParseAge(file.Default); err != nil {
+			return nil, fmt.Errorf("default: %w", err)
+		}
+	}
+
+	if err := policy.Compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// ParseAge parses a duration, additionally accepting whole days such as "90d"
+func ParseAge(value string) (time.Duration, error) {
+	var age time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q", value)
+		}
+		age = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if age, err = time.ParseDuration(value); err != nil {
+			return 0, fmt.Errorf("invalid age %q", value)
+		}
+	}
+
+	if age < time.Minute {
+		return 0, fmt.Errorf("age %q must be at least 1m", value)
+	}
+	return age, nil
+}
+
+// Compile validates the policy's rules and parses their queries
+func (p *Policy) Compile() error {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if strings.TrimSpace(rule.Query) == "" {
+			return fmt.Errorf("rule %d needs a query", i+1)
+		}
+		if rule.MaxAge <= 0 {
+			return fmt.Errorf("rule %d needs a positive maxAge", i+1)
+		}
+
+		expr, err := querylang.Parse(rule.Query)
+		if err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		rule.expr = expr
+	}
+
+	if p.Default < 0 {
+		return fmt.Errorf("default must not be negative")
+	}
+	return nil
+}
+
+// expiredQueries returns one query per rule, and one for the default when
+// set, selecting the logs that rule has expired at now. Each rule excludes
+// the logs claimed by the rules before it.
+func (p *Policy) expiredQueries(now time.Time) []*models.LogQuery {
+	var queries []*models.LogQuery
+	var earlier []querylang.Expr
+
+	for _, rule := range p.Rules {
+		queries = append(queries, &models.LogQuery{
+			EndTime: now.Add(-rule.MaxAge),
+			Scope:   querylang.And(append([]querylang.Expr{rule.expr}, earlier...)...),
+		})
+		earlier = append(earlier, &querylang.NotExpr{Operand: rule.expr})
+	}
+
+	if p.Default > 0 {
+		queries = append(queries, &models.LogQuery{
+			EndTime: now.Add(-p.Default),
+			Scope:   querylang.And(earlier...),
+		})
+	}
+
+	return queries
+}
+
+// partitionAge returns the age past which every log has expired, combining
+// the policy with a retention applied to all logs, or zero when some logs
+// are kept indefinitely. Partitions older than it can be dropped whole.
+func (p *Policy) partitionAge(retention time.Duration) time.Duration {
+	var age time.Duration
+	if p.Default > 0 {
+		age = p.Default
+		for _, rule := range p.Rules {
+			if rule.MaxAge > age {
+				age = rule.MaxAge
+			}
+		}
+	}
+
+	if retention > 0 && (age == 0 || retention < age) {
+		age = retention
+	}
+	return age
+}
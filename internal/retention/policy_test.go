@@ -0,0 +1,131 @@
+package retention
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := map[string]time.Duration{
+		"90d":   90 * 24 * time.Hour,
+		"72h":   72 * time.Hour,
+		"1h30m": 90 * time.Minute,
+	}
+	for value, expected := range tests {
+		age, err := ParseAge(value)
+		if err != nil || age != expected {
+			t.Errorf("Expected %v for %q, got %v, %v", expected, value, age, err)
+		}
+	}
+
+	for _, value := range []string{"", "d", "-1d", "ninety days", "10s"} {
+		if _, err := ParseAge(value); err == nil {
+			t.Errorf("Expected error for %q", value)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retention.json")
+	data := `{
+		"rules": [
+			{"query": "resourceId:payments-*", "maxAge": "365d"},
+			{"query": "level:debug", "maxAge": "3d"}
+		],
+		"default": "30d"
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("Failed to write policy: %v", err)
+	}
+
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("Failed to load policy: %v", err)
+	}
+	if len(policy.Rules) != 2 || policy.Rules[1].MaxAge != 72*time.Hour || policy.Default != 30*24*time.Hour {
+		t.Errorf("Unexpected policy %+v", policy)
+	}
+}
+
+func TestLoadPolicyInvalid(t *testing.T) {
+	tests := []string{
+		`{"rules": [{"query": "level:(debug", "maxAge": "3d"}]}`,
+		`{"rules": [{"query": "", "maxAge": "3d"}]}`,
+		`{"rules": [{"query": "level:debug", "maxAge": "soon"}]}`,
+		`{"default": "forever"}`,
+	}
+
+	for _, data := range tests {
+		path := filepath.Join(t.TempDir(), "retention.json")
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatalf("Failed to write policy: %v", err)
+		}
+		if _, err := LoadPolicy(path); err == nil {
+			t.Errorf("Expected error for policy %s", data)
+		}
+	}
+}
+
+func TestExpiredQueries(t *testing.T) {
+	policy := &Policy{
+		Rules: []Rule{
+			{Query: "resourceId:payments-*", MaxAge: 365 * 24 * time.Hour},
+			{Query: "level:debug", MaxAge: 3 * 24 * time.Hour},
+		},
+		Default: 30 * 24 * time.Hour,
+	}
+	if err := policy.Compile(); err != nil {
+		t.Fatalf("Failed to compile policy: %v", err)
+	}
+
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	queries := policy.expiredQueries(now)
+	if len(queries) != 3 {
+		t.Fatalf("Expected 3 queries, got %d", len(queries))
+	}
+
+	// Later rules exclude the logs claimed by earlier ones
+	expected := []struct {
+		endTime time.Time
+		scope   string
+	}{
+		{now.AddDate(0, 0, -365), "resourceId:payments-*"},
+		{now.AddDate(0, 0, -3), `(level:"debug") AND (NOT (resourceId:payments-*))`},
+		{now.AddDate(0, 0, -30), `(NOT (resourceId:payments-*)) AND (NOT (level:"debug"))`},
+	}
+	for i, query := range queries {
+		if !query.EndTime.Equal(expected[i].endTime) {
+			t.Errorf("Query %d: expected end time %v, got %v", i, expected[i].endTime, query.EndTime)
+		}
+		if query.Scope.String() != expected[i].scope {
+			t.Errorf("Query %d: expected scope %q, got %q", i, expected[i].scope, query.Scope.String())
+		}
+	}
+}
+
+func TestPartitionAge(t *testing.T) {
+	day := 24 * time.Hour
+	rules := []Rule{{Query: "level:error", MaxAge: 90 * day}, {Query: "level:debug", MaxAge: 3 * day}}
+
+	tests := []struct {
+		name      string
+		policy    Policy
+		retention time.Duration
+		expected  time.Duration
+	}{
+		{"empty", Policy{}, 0, 0},
+		{"retention only", Policy{}, 7 * day, 7 * day},
+		{"longest rule", Policy{Rules: rules, Default: 30 * day}, 0, 90 * day},
+		{"no default", Policy{Rules: rules}, 0, 0},
+		{"retention caps rules", Policy{Rules: rules, Default: 30 * day}, 14 * day, 14 * day},
+		{"rules within retention", Policy{Rules: rules, Default: 30 * day}, 365 * day, 90 * day},
+	}
+
+	for _, tt := range tests {
+		if age := tt.policy.partitionAge(tt.retention); age != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, age)
+		}
+	}
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,8 +16,10 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 
+	"log-ingestor/internal/auth"
 	"log-ingestor/internal/database"
 	"log-ingestor/internal/ingestor"
+	"log-ingestor/internal/retention"
 )
 
 func main() {
@@ -29,32 +34,137 @@ func main() {
 		port = "3000"
 	}
 
-	// Connect to MongoDB
-	db, err := database.NewMongoDB()
+	// Open the storage backend selected by STORAGE_BACKEND
+	db, err := newDatabase()
 	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+		log.Fatalf("Failed to open database: %v", err)
 	}
 	defer db.Close()
 
 	// Create log ingestor service
 	logIngestor := ingestor.NewLogIngestor(db)
+	if countLimit := getEnvInt("QUERY_COUNT_LIMIT", -1); countLimit >= 0 {
+		logIngestor.SetCountLimit(int64(countLimit))
+	}
+	if queryTimeout := getEnvDuration("QUERY_TIMEOUT", 0); queryTimeout > 0 {
+		logIngestor.SetQueryTimeout(queryTimeout)
+	}
+
+	// Cap export rows, by default and for the roles in EXPORT_ROW_LIMITS
+	roleLimits, err := ingestor.ParseRoleLimits(os.Getenv("EXPORT_ROW_LIMITS"))
+	if err != nil {
+		log.Fatalf("Invalid EXPORT_ROW_LIMITS: %v", err)
+	}
+	exportLimit := getEnvInt("EXPORT_ROW_LIMIT", ingestor.DefaultExportRowLimit)
+	logIngestor.SetExportLimits(int64(exportLimit), roleLimits)
+	if exportTimeout := getEnvDuration("EXPORT_TIMEOUT", 0); exportTimeout > 0 {
+		logIngestor.SetExportTimeout(exportTimeout)
+	}
+
+	// Set up the write-behind buffer unless it is disabled with a zero queue size
+	var buffer *ingestor.Buffer
+	bufferConfig := ingestor.DefaultBufferConfig()
+	bufferConfig.QueueSize = getEnvInt("INGEST_QUEUE_SIZE", bufferConfig.QueueSize)
+	bufferConfig.BatchSize = getEnvInt("INGEST_BATCH_SIZE", bufferConfig.BatchSize)
+	bufferConfig.Workers = getEnvInt("INGEST_WORKERS", bufferConfig.Workers)
+	bufferConfig.FlushInterval = getEnvDuration("INGEST_FLUSH_INTERVAL", bufferConfig.FlushInterval)
+	if walDir := os.Getenv("WAL_DIR"); walDir != "" && bufferConfig.QueueSize > 0 {
+		// Persist accepted logs to a write-ahead log before acknowledging them
+		walConfig := ingestor.WALConfig{
+			Dir:         walDir,
+			SegmentSize: int64(getEnvInt("WAL_SEGMENT_SIZE", 64<<20)),
+			Sync:        os.Getenv("WAL_SYNC") != "false",
+		}
+		buffer, err = ingestor.NewDurableBuffer(db, bufferConfig, walConfig)
+		if err != nil {
+			log.Fatalf("Failed to open write-ahead log: %v", err)
+		}
+		logIngestor.UseBuffer(buffer)
+	} else if bufferConfig.QueueSize > 0 {
+		buffer = ingestor.NewBuffer(db, bufferConfig)
+		logIngestor.UseBuffer(buffer)
+	}
 
-	// Set up Gin router
-	router := gin.Default()
+	// STORAGE_WRITE_MODE=all is meant for buffered ingestion, which retries
+	// a batch a secondary failed under the same IDs. Without the buffer the
+	// failure reaches the client after the primary stored the logs, and a
+	// client retrying it stores them again under new IDs.
+	if multi, ok := db.(*database.MultiDB); ok && buffer == nil && multi.Mode() == database.WriteAll {
+		log.Printf("Warning: STORAGE_WRITE_MODE=all without the write-behind buffer; retried requests may store logs twice")
+	}
+
+	// Live tail subscribers may each fall this many logs behind before
+	// further logs are dropped for them
+	hub := ingestor.NewHub(getEnvInt("TAIL_BUFFER_SIZE", ingestor.DefaultTailBuffer))
+	logIngestor.UseHub(hub)
+
+	// Delete logs expired by the retention rules in RETENTION_RULES_FILE.
+	// Partitioned logs rely on the janitor to drop expired partitions, as
+	// they carry no TTL index.
+	var janitor *retention.Janitor
+	path := os.Getenv("RETENTION_RULES_FILE")
+	if path != "" || partitioned(db) {
+		policy := &retention.Policy{}
+		if path != "" {
+			if policy, err = retention.LoadPolicy(path); err != nil {
+				log.Fatalf("Failed to load retention rules: %v", err)
+			}
+		}
+		janitor = retention.NewJanitor(db, policy, retention.Config{
+			Interval: getEnvDuration("RETENTION_INTERVAL", retention.DefaultInterval),
+		})
+		janitor.Start()
+		logIngestor.UseJanitor(janitor)
+	}
 
-	// Configure CORS
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
+	// Set up API key authentication
+	authenticator, err := newAuthenticator(db)
+	if err != nil {
+		log.Fatalf("Failed to set up authentication: %v", err)
+	}
 
-	// Define routes
-	router.POST("/", logIngestor.HandleLogIngestion)
-	router.GET("/logs", logIngestor.QueryLogs)
+	// Set up Gin router, keeping access tokens out of the request log
+	router := gin.New()
+	router.Use(gin.LoggerWithFormatter(logFormatter), gin.Recovery())
+
+	// Configure CORS. API keys travel in headers rather than cookies, so
+	// credentials are never needed cross-origin.
+	corsConfig := cors.Config{
+		AllowMethods:  []string{"GET", "POST", "OPTIONS"},
+		AllowHeaders:  []string{"Origin", "Content-Type", "Authorization", "X-API-Key", auth.TenantHeader},
+		ExposeHeaders: []string{"Content-Length", "Retry-After"},
+		MaxAge:        12 * time.Hour,
+	}
+	if origins := os.Getenv("CORS_ALLOWED_ORIGINS"); origins != "" {
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				corsConfig.AllowOrigins = append(corsConfig.AllowOrigins, origin)
+			}
+		}
+	} else {
+		corsConfig.AllowAllOrigins = true
+	}
+	router.Use(cors.New(corsConfig))
+
+	// Define routes, each requiring the role that may use it
+	ingest := authenticator.Require(auth.RoleIngest)
+	reader := authenticator.Require(auth.RoleReader)
+	admin := authenticator.Require(auth.RoleAdmin)
+
+	router.POST("/", ingest, logIngestor.HandleLogIngestion)
+	router.POST("/bulk", ingest, logIngestor.HandleBulkIngestion)
+	router.GET("/logs", reader, logIngestor.QueryLogs)
+	router.GET("/logs/export", reader, logIngestor.HandleExport)
+	router.GET("/logs/tail", reader, logIngestor.HandleTail)
+	router.GET("/logs/stats", reader, logIngestor.HandleStats)
+	router.GET("/traces/:traceId", reader, logIngestor.HandleTrace)
+	router.GET("/ingest/stats", admin, logIngestor.HandleIngestStats)
+	if multi, ok := db.(*database.MultiDB); ok {
+		router.GET("/storage/stats", admin, func(c *gin.Context) {
+			c.JSON(http.StatusOK, multi.Stats())
+		})
+	}
+	router.GET("/auth/whoami", authenticator.Require(""), authenticator.HandleWhoAmI)
 
 	// Serve static files for the UI
 	router.Static("/ui", "./ui/dist")
@@ -66,6 +176,9 @@ func main() {
 		Handler: router,
 	}
 
+	// End live tail streams so they do not hold up shutdown
+	srv.RegisterOnShutdown(hub.Close)
+
 	// Start server in a goroutine
 	go func() {
 		log.Printf("Server listening on port %s", port)
@@ -84,10 +197,205 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Attempt graceful shutdown
+	// Attempt graceful shutdown. Requests still running at the deadline,
+	// such as long exports, are cut off, but the steps below must still run.
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		log.Printf("Server forced to shutdown: %v", err)
+	}
+
+	// Let a retention run in progress finish
+	if janitor != nil {
+		janitor.Close()
+	}
+
+	// Flush logs still waiting in the write-behind buffer, with a deadline
+	// of its own as the server's may already have passed
+	if buffer != nil {
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), getEnvDuration("INGEST_DRAIN_TIMEOUT", 30*time.Second))
+		defer cancelDrain()
+		if err := buffer.Close(drainCtx); err != nil {
+			log.Printf("Failed to drain ingestion buffer: %v", err)
+		}
 	}
 
 	log.Println("Server exited")
 }
+
+// getEnvInt reads an integer environment variable, falling back to def
+func getEnvInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: invalid %s %q, using %d", key, value, def)
+		return def
+	}
+	return n
+}
+
+// getEnvDuration reads a duration environment variable, falling back to def
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Warning: invalid %s %q, using %v", key, value, def)
+		return def
+	}
+	return d
+}
+
+// newDatabase opens the storage backends listed in STORAGE_BACKEND. With
+// more than one, writes fan out to all of them and reads are served by the
+// first, as configured by the STORAGE_* variables.
+func newDatabase() (database.DB, error) {
+	var names []string
+	for _, name := range strings.Split(os.Getenv("STORAGE_BACKEND"), ",") {
+		names = append(names, strings.TrimSpace(name))
+	}
+	if len(names) == 1 {
+		return newBackend(names[0])
+	}
+
+	config := database.DefaultMultiConfig()
+	mode, err := database.ParseWriteMode(os.Getenv("STORAGE_WRITE_MODE"))
+	if err != nil {
+		return nil, err
+	}
+	config.Mode = mode
+	config.RetryQueueSize = getEnvInt("STORAGE_RETRY_QUEUE_SIZE", config.RetryQueueSize)
+	config.RetryInterval = getEnvDuration("STORAGE_RETRY_INTERVAL", config.RetryInterval)
+	config.RetryLimit = getEnvInt("STORAGE_RETRY_LIMIT", config.RetryLimit)
+	if rate := os.Getenv("STORAGE_COMPARE_RATE"); rate != "" {
+		if config.CompareRate, err = strconv.ParseFloat(rate, 64); err != nil || config.CompareRate < 0 || config.CompareRate > 1 {
+			return nil, fmt.Errorf("invalid STORAGE_COMPARE_RATE %q: expected a number between 0 and 1", rate)
+		}
+	}
+
+	var backends []database.Backend
+	for _, name := range names {
+		db, err := newBackend(name)
+		if err != nil {
+			for _, backend := range backends {
+				backend.DB.Close()
+			}
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		backends = append(backends, database.Backend{Name: name, DB: db})
+	}
+	log.Printf("Writing to storage backends %s in %s mode, reading from %s", strings.Join(names, ", "), config.Mode, names[0])
+	return database.NewMultiDB(backends[0], backends[1:], config), nil
+}
+
+// newBackend opens a storage backend: MongoDB (mongo or empty), an
+// embedded bbolt file, PostgreSQL or ClickHouse
+func newBackend(backend string) (database.DB, error) {
+	switch backend {
+	case "", "mongo":
+		db, err := database.NewMongoDB()
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case "bolt":
+		db, err := database.NewBoltDB()
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case "postgres":
+		db, err := database.NewPostgresDB()
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case "clickhouse":
+		db, err := database.NewClickHouseDB()
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q: expected mongo, bolt, postgres or clickhouse", backend)
+	}
+}
+
+// partitioned reports whether the database, or any backend it writes to,
+// splits logs into time partitions the janitor must drop
+func partitioned(db database.DB) bool {
+	if multi, ok := db.(*database.MultiDB); ok {
+		for _, backend := range multi.Backends() {
+			if partitioned(backend.DB) {
+				return true
+			}
+		}
+		return false
+	}
+	mongoDB, ok := db.(*database.MongoDB)
+	return ok && mongoDB.Partitioning().Enabled()
+}
+
+// newAuthenticator sets up the API key store selected by AUTH_MODE: none
+// (the default), file (AUTH_KEYS_FILE) or mongo (AUTH_COLLECTION and
+// AUTH_ROLES_COLLECTION)
+func newAuthenticator(db database.DB) (*auth.Authenticator, error) {
+	switch mode := os.Getenv("AUTH_MODE"); mode {
+	case "", "none":
+		log.Println("Warning: authentication is disabled; set AUTH_MODE to require API keys")
+		return auth.NewAuthenticator(nil), nil
+	case "file":
+		store, err := auth.NewFileStore(os.Getenv("AUTH_KEYS_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		return auth.NewAuthenticator(store), nil
+	case "mongo":
+		keys := os.Getenv("AUTH_COLLECTION")
+		if keys == "" {
+			keys = "api_keys"
+		}
+		// Keys live in the primary when writes fan out to several backends
+		if multi, ok := db.(*database.MultiDB); ok {
+			db = multi.Primary().DB
+		}
+		mongoDB, ok := db.(*database.MongoDB)
+		if !ok {
+			return nil, fmt.Errorf("AUTH_MODE=mongo requires mongo as the first STORAGE_BACKEND")
+		}
+		roles := os.Getenv("AUTH_ROLES_COLLECTION")
+		if roles == "" {
+			roles = "api_roles"
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		store, err := auth.NewMongoStore(ctx, mongoDB.Database().Collection(keys), mongoDB.Database().Collection(roles))
+		if err != nil {
+			return nil, err
+		}
+		return auth.NewAuthenticator(store), nil
+	default:
+		return nil, fmt.Errorf("unknown AUTH_MODE %q", mode)
+	}
+}
+
+// logFormatter formats request logs like gin's default logger, with access
+// tokens redacted from the path
+func logFormatter(param gin.LogFormatterParams) string {
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		auth.RedactPath(param.Path),
+		param.ErrorMessage,
+	)
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
 	"time"
 )
 
@@ -116,14 +117,26 @@ func main() {
 			log.Fatalf("Error marshaling log: %v", err)
 		}
 
-		// Send log to ingestor
-		resp, err := http.Post(endpoint, "application/json", bytes.NewBuffer(logJSON))
+		// Send log to ingestor, authenticating with API_KEY and naming TENANT when set
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(logJSON))
+		if err != nil {
+			log.Fatalf("Error creating request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if apiKey := os.Getenv("API_KEY"); apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		if tenant := os.Getenv("TENANT"); tenant != "" {
+			req.Header.Set("X-Tenant-ID", tenant)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
 			log.Fatalf("Error sending log: %v", err)
 		}
 
-		// Check response
-		if resp.StatusCode != http.StatusOK {
+		// Check response; buffered ingestion answers 202 Accepted
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
 			log.Fatalf("Error response: %v", resp.Status)
 		}
 
@@ -14,12 +14,39 @@ document.addEventListener('DOMContentLoaded', () => {
     const modal = document.getElementById('log-details-modal');
     const closeModal = document.querySelector('.close');
     const logJson = document.getElementById('log-json');
+    const liveToggle = document.getElementById('live-toggle');
+    const exportFormat = document.getElementById('export-format');
+    const exportButton = document.getElementById('export-button');
+    const histogram = document.getElementById('histogram');
+    const histogramInfo = document.getElementById('histogram-info');
+    const userInfo = document.getElementById('user-info');
+    const userName = document.getElementById('user-name');
+    const logoutButton = document.getElementById('logout-button');
+    const loginModal = document.getElementById('login-modal');
+    const loginForm = document.getElementById('login-form');
+    const apiKeyInput = document.getElementById('api-key');
+    const loginError = document.getElementById('login-error');
+    const traceModal = document.getElementById('trace-modal');
+    const traceClose = document.getElementById('trace-close');
+    const traceTitle = document.getElementById('trace-title');
+    const traceSummary = document.getElementById('trace-summary');
+    const traceWaterfall = document.getElementById('trace-waterfall');
+    const traceResources = document.getElementById('trace-resources');
 
     // State
     let currentPage = 1;
     let totalPages = 1;
+    let totalLogs = 0;
+    let totalRelation = 'eq';
+    let hasMore = false;
     let currentLogs = [];
     const pageSize = 10;
+    const maxLiveLogs = 200;
+    let liveSource = null;
+    let apiKey = localStorage.getItem('apiKey') || '';
+    let liveDropped = 0;
+    const utf8Encoder = new TextEncoder();
+    const utf8Decoder = new TextDecoder();
 
     // Toggle advanced filters
     advancedSearchToggle.addEventListener('click', () => {
@@ -45,21 +72,32 @@ document.addEventListener('DOMContentLoaded', () => {
     // Apply filters button click
     applyFiltersButton.addEventListener('click', searchLogs);
 
+    // Live tail toggle
+    liveToggle.addEventListener('click', () => {
+        if (liveSource) {
+            stopLive();
+            searchLogs();
+        } else {
+            startLive();
+        }
+    });
+
+    // Download every log matching the filters
+    exportButton.addEventListener('click', exportLogs);
+
     // Clear filters button click
     clearFiltersButton.addEventListener('click', clearFilters);
 
     // Pagination
     prevPageButton.addEventListener('click', () => {
         if (currentPage > 1) {
-            currentPage--;
-            displayLogs();
+            fetchLogs(currentPage - 1);
         }
     });
 
     nextPageButton.addEventListener('click', () => {
-        if (currentPage < totalPages) {
-            currentPage++;
-            displayLogs();
+        if (hasMore) {
+            fetchLogs(currentPage + 1);
         }
     });
 
@@ -68,13 +106,82 @@ document.addEventListener('DOMContentLoaded', () => {
         modal.style.display = 'none';
     });
 
+    // Close the trace view
+    traceClose.addEventListener('click', () => {
+        traceModal.style.display = 'none';
+    });
+
     // Close modal when clicking outside
     window.addEventListener('click', (e) => {
         if (e.target === modal) {
             modal.style.display = 'none';
         }
+        if (e.target === traceModal) {
+            traceModal.style.display = 'none';
+        }
+    });
+
+    // Sign in with an API key
+    loginForm.addEventListener('submit', async (e) => {
+        e.preventDefault();
+        apiKey = apiKeyInput.value.trim();
+        if (await checkLogin()) {
+            localStorage.setItem('apiKey', apiKey);
+            loginModal.style.display = 'none';
+            apiKeyInput.value = '';
+            searchLogs();
+        } else {
+            loginError.textContent = 'That API key was not accepted.';
+        }
+    });
+
+    // Sign out, forgetting the stored API key
+    logoutButton.addEventListener('click', () => {
+        stopLive();
+        apiKey = '';
+        localStorage.removeItem('apiKey');
+        showLogin();
     });
 
+    // Fetch from the API, sending the API key and asking for one when the
+    // server rejects it
+    async function apiFetch(url) {
+        const headers = apiKey ? { 'Authorization': `Bearer ${apiKey}` } : {};
+        const response = await fetch(url, { headers });
+        if (response.status === 401) {
+            showLogin();
+        }
+        return response;
+    }
+
+    // Check the stored API key and show who it belongs to
+    async function checkLogin() {
+        try {
+            const headers = apiKey ? { 'Authorization': `Bearer ${apiKey}` } : {};
+            const response = await fetch('/auth/whoami', { headers });
+            if (!response.ok) {
+                return false;
+            }
+            const user = await response.json();
+            if (user.authEnabled) {
+                userName.textContent = `${user.name} (${user.roleName || user.role})`;
+                userInfo.style.display = 'flex';
+            }
+            return true;
+        } catch (error) {
+            console.error('Error checking API key:', error);
+            return false;
+        }
+    }
+
+    // Ask for an API key
+    function showLogin() {
+        userInfo.style.display = 'none';
+        loginError.textContent = '';
+        loginModal.style.display = 'block';
+        apiKeyInput.focus();
+    }
+
     // Format timestamp
     function formatTimestamp(timestamp) {
         const date = new Date(timestamp);
@@ -92,7 +199,9 @@ document.addEventListener('DOMContentLoaded', () => {
         document.getElementById('startTime').value = '';
         document.getElementById('endTime').value = '';
         document.getElementById('regex').value = '';
+        document.getElementById('regexFlags').value = '';
         document.getElementById('message').value = '';
+        document.getElementById('sort').value = '';
         searchInput.value = '';
     }
 
@@ -105,6 +214,12 @@ document.addEventListener('DOMContentLoaded', () => {
         if (searchValue) {
             params.append('search', searchValue);
         }
+
+        // Relevance only orders full-text searches
+        const sort = document.getElementById('sort').value;
+        if (sort && (sort !== 'relevance' || searchValue)) {
+            params.append('sort', sort);
+        }
         
         // Level
         const level = document.getElementById('level').value;
@@ -158,6 +273,11 @@ document.addEventListener('DOMContentLoaded', () => {
         const regex = document.getElementById('regex').value.trim();
         if (regex) {
             params.append('regex', regex);
+
+            const regexFlags = document.getElementById('regexFlags').value;
+            if (regexFlags) {
+                params.append('regexFlags', regexFlags);
+            }
         }
         
         // Message
@@ -173,12 +293,108 @@ document.addEventListener('DOMContentLoaded', () => {
         return params;
     }
 
-    // Search logs
-    async function searchLogs() {
+    // Search logs from the first page, restarting the live stream so it
+    // picks up the new filters
+    function searchLogs() {
+        if (liveSource) {
+            startLive();
+            return;
+        }
+        fetchLogs(1);
+    }
+
+    // Download the logs matching the filters in the selected format. The
+    // browser follows a link, which cannot send headers, so the key goes in
+    // the URL as it does for the live stream.
+    function exportLogs() {
+        const params = buildQueryParams();
+        params.delete('page');
+        params.delete('limit');
+        params.delete('sort');
+        params.append('format', exportFormat.value);
+        if (apiKey) {
+            params.append('access_token', apiKey);
+        }
+
+        const link = document.createElement('a');
+        link.href = `/logs/export?${params.toString()}`;
+        link.download = `logs.${exportFormat.value}`;
+        document.body.appendChild(link);
+        link.click();
+        link.remove();
+    }
+
+    // Stream newly ingested logs matching the filters
+    function startLive() {
+        stopLive();
+
+        const params = buildQueryParams();
+        params.delete('page');
+        params.delete('limit');
+        if (params.has('search') || params.has('regex')) {
+            showError('Live mode does not support full-text search or regex patterns.');
+            return;
+        }
+
+        currentLogs = [];
+        totalLogs = 0;
+        totalRelation = 'eq';
+        hasMore = false;
+        liveDropped = 0;
+        liveToggle.classList.add('active');
+
+        // EventSource cannot send headers, so the key goes in the URL
+        if (apiKey) {
+            params.append('access_token', apiKey);
+        }
+        liveSource = new EventSource(`/logs/tail?${params.toString()}`);
+        liveSource.addEventListener('log', (e) => {
+            currentLogs.unshift(JSON.parse(e.data));
+            if (currentLogs.length > maxLiveLogs) {
+                currentLogs.length = maxLiveLogs;
+            }
+            totalLogs = currentLogs.length;
+            displayLogs();
+        });
+        liveSource.addEventListener('dropped', (e) => {
+            liveDropped += JSON.parse(e.data).count;
+            displayLogs();
+        });
+        liveSource.onerror = () => {
+            // EventSource reconnects on its own unless the request was rejected
+            if (liveSource && liveSource.readyState === EventSource.CLOSED) {
+                stopLive();
+                showError('Live stream closed by the server.');
+            }
+        };
+
+        displayLogs();
+    }
+
+    // Stop streaming logs
+    function stopLive() {
+        if (liveSource) {
+            liveSource.close();
+            liveSource = null;
+        }
+        liveToggle.classList.remove('active');
+    }
+
+    // Fetch a page of logs
+    async function fetchLogs(page) {
         try {
-            currentPage = 1;
+            currentPage = page;
             const params = buildQueryParams();
-            const response = await fetch(`/logs?${params.toString()}`);
+            if (page === 1) {
+                fetchHistogram(params);
+            }
+
+            // Ask for the parts of messages that matched to be highlighted
+            const logParams = new URLSearchParams(params);
+            if (['search', 'regex', 'message'].some(name => logParams.has(name))) {
+                logParams.set('highlight', 'true');
+            }
+            const response = await apiFetch(`/logs?${logParams.toString()}`);
             
             if (!response.ok) {
                 throw new Error(`HTTP error! Status: ${response.status}`);
@@ -187,57 +403,128 @@ document.addEventListener('DOMContentLoaded', () => {
             const data = await response.json();
             currentLogs = data.logs || [];
             
-            // Calculate total pages
-            const totalLogs = data.count || 0;
-            totalPages = Math.ceil(totalLogs / pageSize);
+            // Calculate total pages from the total number of matches
+            totalLogs = data.total || 0;
+            totalRelation = data.totalRelation || 'eq';
+            hasMore = Boolean(data.hasMore);
+            totalPages = Math.max(1, Math.ceil(totalLogs / pageSize));
             
             displayLogs();
         } catch (error) {
             console.error('Error searching logs:', error);
-            resultsContainer.innerHTML = `
-                <div class="no-results">
-                    <i class="fas fa-exclamation-circle fa-3x"></i>
-                    <p>Error fetching logs: ${error.message}</p>
-                </div>
-            `;
-            resultCount.textContent = '(0)';
-            updatePaginationControls();
+            showError(`Error fetching logs: ${error.message}`);
+        }
+    }
+
+    // Fetch and draw the histogram of logs matching the filters
+    async function fetchHistogram(queryParams) {
+        const params = new URLSearchParams(queryParams);
+        params.delete('page');
+        params.delete('limit');
+
+        try {
+            const response = await apiFetch(`/logs/stats?${params.toString()}`);
+            if (!response.ok) {
+                throw new Error(`HTTP error! Status: ${response.status}`);
+            }
+            displayHistogram(await response.json());
+        } catch (error) {
+            console.error('Error fetching stats:', error);
+            histogram.innerHTML = '';
+            histogramInfo.textContent = 'Histogram unavailable';
         }
     }
 
+    // Draw histogram buckets as bars scaled to the busiest bucket
+    function displayHistogram(stats) {
+        const buckets = stats.buckets || [];
+        const maxCount = buckets.reduce((max, bucket) => Math.max(max, bucket.count), 0);
+
+        histogram.innerHTML = '';
+        buckets.forEach(bucket => {
+            const bar = document.createElement('div');
+            bar.className = 'histogram-bar';
+            bar.style.height = maxCount > 0 ? `${(bucket.count / maxCount) * 100}%` : '0';
+            bar.title = `${formatTimestamp(bucket.start)}: ${bucket.count}`;
+            histogram.appendChild(bar);
+        });
+
+        histogramInfo.textContent = `${stats.total} logs, ${stats.interval} buckets`;
+    }
+
+    // Escape text for insertion into HTML
+    function escapeHtml(text) {
+        const entities = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' };
+        return String(text ?? '').replace(/[&<>"']/g, c => entities[c]);
+    }
+
+    // Render a message with its highlighted parts marked. Highlights are
+    // UTF-8 byte offsets, so the message is sliced as bytes.
+    function highlightMessage(message, highlights) {
+        if (!highlights || highlights.length === 0) {
+            return escapeHtml(message);
+        }
+
+        const bytes = utf8Encoder.encode(message);
+        const text = (start, end) => escapeHtml(utf8Decoder.decode(bytes.slice(start, end)));
+        let html = '';
+        let last = 0;
+        highlights.forEach(({ start, end }) => {
+            html += text(last, start) + `<mark>${text(start, end)}</mark>`;
+            last = end;
+        });
+        return html + text(last, bytes.length);
+    }
+
+    // Show an error in place of the results
+    function showError(message) {
+        resultsContainer.innerHTML = `
+            <div class="no-results">
+                <i class="fas fa-exclamation-circle fa-3x"></i>
+                <p>${escapeHtml(message)}</p>
+            </div>
+        `;
+        resultCount.textContent = '(0)';
+        hasMore = false;
+        updatePaginationControls();
+    }
+
     // Display logs
     function displayLogs() {
         if (currentLogs.length === 0) {
+            const emptyMessage = liveSource
+                ? 'Waiting for new logs matching your filters...'
+                : 'No logs found. Try adjusting your search criteria.';
             resultsContainer.innerHTML = `
                 <div class="no-results">
                     <i class="fas fa-search fa-3x"></i>
-                    <p>No logs found. Try adjusting your search criteria.</p>
+                    <p>${emptyMessage}</p>
                 </div>
             `;
             resultCount.textContent = '(0)';
         } else {
             let html = '';
             
-            currentLogs.forEach(log => {
+            currentLogs.forEach((log, index) => {
                 html += `
-                    <div class="log-item" data-log='${JSON.stringify(log)}'>
+                    <div class="log-item" data-index="${index}">
                         <div class="log-header">
-                            <span class="log-level ${log.level.toLowerCase()}">${log.level}</span>
+                            <span class="log-level ${escapeHtml(log.level.toLowerCase())}">${escapeHtml(log.level)}</span>
                             <span class="log-timestamp">${formatTimestamp(log.timestamp)}</span>
                         </div>
-                        <div class="log-message">${log.message}</div>
+                        <div class="log-message">${highlightMessage(log.message, log.highlights)}</div>
                         <div class="log-details">
                             <div class="log-detail">
                                 <i class="fas fa-server"></i>
-                                <span>${log.resourceId}</span>
+                                <span>${escapeHtml(log.resourceId)}</span>
                             </div>
                             <div class="log-detail">
                                 <i class="fas fa-fingerprint"></i>
-                                <span>${log.traceId}</span>
+                                <span class="${log.traceId ? 'trace-link' : ''}" title="${log.traceId ? 'Show the trace' : ''}">${escapeHtml(log.traceId)}</span>
                             </div>
                             <div class="log-detail">
                                 <i class="fas fa-code-branch"></i>
-                                <span>${log.commit}</span>
+                                <span>${escapeHtml(log.commit)}</span>
                             </div>
                         </div>
                     </div>
@@ -245,26 +532,43 @@ document.addEventListener('DOMContentLoaded', () => {
             });
             
             resultsContainer.innerHTML = html;
-            resultCount.textContent = `(${currentLogs.length})`;
+            resultCount.textContent = totalRelation === 'gte' ? `(${totalLogs}+)` : `(${totalLogs})`;
             
             // Add click event to log items
             document.querySelectorAll('.log-item').forEach(item => {
                 item.addEventListener('click', () => {
-                    const logData = JSON.parse(item.getAttribute('data-log'));
-                    showLogDetails(logData);
+                    showLogDetails(currentLogs[Number(item.dataset.index)]);
+                });
+            });
+
+            // Trace IDs open the trace instead of the log
+            document.querySelectorAll('.log-item .trace-link').forEach(link => {
+                link.addEventListener('click', (e) => {
+                    e.stopPropagation();
+                    const item = link.closest('.log-item');
+                    showTrace(currentLogs[Number(item.dataset.index)].traceId);
                 });
             });
         }
         
+        // Live mode shows a rolling window instead of pages
+        if (liveSource) {
+            pageInfo.textContent = liveDropped > 0 ? `Live (${liveDropped} dropped)` : 'Live';
+            prevPageButton.disabled = true;
+            nextPageButton.disabled = true;
+            return;
+        }
+
         // Update pagination
-        pageInfo.textContent = `Page ${currentPage}`;
+        const pagesLabel = totalRelation === 'gte' ? `${totalPages}+` : totalPages;
+        pageInfo.textContent = `Page ${currentPage} of ${pagesLabel}`;
         updatePaginationControls();
     }
 
     // Update pagination controls
     function updatePaginationControls() {
         prevPageButton.disabled = currentPage <= 1;
-        nextPageButton.disabled = currentPage >= totalPages;
+        nextPageButton.disabled = !hasMore;
     }
 
     // Show log details in modal
@@ -273,6 +577,98 @@ document.addEventListener('DOMContentLoaded', () => {
         modal.style.display = 'block';
     }
 
-    // Initial search on page load
-    searchLogs();
+    // Format a duration in milliseconds
+    function formatDuration(ms) {
+        return ms >= 1000 ? `${(ms / 1000).toFixed(2)}s` : `${Math.round(ms * 10) / 10}ms`;
+    }
+
+    // Fetch a trace and show it as a waterfall of spans
+    async function showTrace(traceId) {
+        traceTitle.textContent = traceId;
+        traceSummary.textContent = 'Loading...';
+        traceWaterfall.innerHTML = '';
+        traceResources.innerHTML = '';
+        traceModal.style.display = 'block';
+
+        try {
+            const response = await apiFetch(`/traces/${encodeURIComponent(traceId)}`);
+            if (!response.ok) {
+                throw new Error(response.status === 404 ? 'Trace not found' : `HTTP error! Status: ${response.status}`);
+            }
+            displayTrace(await response.json());
+        } catch (error) {
+            console.error('Error fetching trace:', error);
+            traceSummary.textContent = `Error fetching trace: ${error.message}`;
+        }
+    }
+
+    // Draw each span as a bar offset from the start of the trace, with its
+    // logs listed below when clicked, and the resource hierarchy as a tree
+    function displayTrace(trace) {
+        const spans = trace.spans || [];
+        const start = new Date(trace.start).getTime();
+        const total = trace.durationMs || 1;
+
+        traceSummary.innerHTML = `${formatTimestamp(trace.start)} &middot; ${formatDuration(trace.durationMs)} &middot; ${spans.length} spans &middot; ${trace.logCount} logs` +
+            (trace.errorCount > 0 ? ` &middot; <span class="trace-errors">${trace.errorCount} errors</span>` : '') +
+            (trace.truncated ? ' &middot; only the newest logs are shown' : '');
+
+        let html = '';
+        spans.forEach((span, index) => {
+            const offset = (new Date(span.start).getTime() - start) / total * 100;
+            const width = span.durationMs / total * 100;
+            const label = span.spanId || '(no span)';
+            const resources = span.resourceIds.join(', ');
+            const logs = (span.logs || []).map(log => `
+                <li>
+                    <span class="log-level ${escapeHtml(log.level.toLowerCase())}">${escapeHtml(log.level)}</span>
+                    +${formatDuration(new Date(log.timestamp).getTime() - start)}
+                    ${escapeHtml(log.resourceId)}: ${escapeHtml(log.message)}
+                </li>
+            `).join('');
+
+            html += `
+                <div class="trace-span" data-index="${index}" title="${escapeHtml(resources)}">
+                    <span class="trace-span-label">${escapeHtml(label)} <small>${escapeHtml(resources)}</small></span>
+                    <span class="trace-span-track">
+                        <span class="trace-span-bar ${span.errorCount > 0 ? 'error' : ''}" style="left: ${offset}%; width: ${width}%"></span>
+                    </span>
+                    <span class="trace-span-duration">${formatDuration(span.durationMs)}</span>
+                </div>
+                <ul class="trace-span-logs" data-index="${index}">${logs}</ul>
+            `;
+        });
+        traceWaterfall.innerHTML = html;
+
+        // Clicking a span shows its logs
+        traceWaterfall.querySelectorAll('.trace-span').forEach(row => {
+            row.addEventListener('click', () => {
+                traceWaterfall.querySelector(`.trace-span-logs[data-index="${row.dataset.index}"]`).classList.toggle('show');
+            });
+        });
+
+        traceResources.innerHTML = (trace.resources || []).map(resourceTree).join('');
+    }
+
+    // Render a resource and its children as nested list items
+    function resourceTree(resource) {
+        const errors = resource.errorCount > 0 ? ` <span class="trace-errors">${resource.errorCount} errors</span>` : '';
+        const children = (resource.children || []).map(resourceTree).join('');
+        return `
+            <li>
+                <i class="fas fa-server"></i> ${escapeHtml(resource.resourceId)}
+                <small>(${resource.logCount} logs)</small>${errors}
+                ${children ? `<ul>${children}</ul>` : ''}
+            </li>
+        `;
+    }
+
+    // Initial search on page load, once signed in
+    checkLogin().then(ok => {
+        if (ok) {
+            searchLogs();
+        } else {
+            showLogin();
+        }
+    });
 }); 
\ No newline at end of file